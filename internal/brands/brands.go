@@ -0,0 +1,288 @@
+// Package brands detects which brand sent a promotional email. Signals from
+// the headers, subject and body are matched against a catalog of brand
+// domains and aliases, and each signal adds to the confidence in a brand.
+package brands
+
+import (
+	_ "embed"
+	"encoding/json"
+	"io"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+//go:embed catalog.json
+var defaultCatalog []byte
+
+// Confidence each kind of signal gives a brand on its own
+const (
+	confidenceFromDomain    = 0.95
+	confidenceUnsubscribe   = 0.9
+	confidenceForwardedFrom = 0.85
+	confidenceDisplayName   = 0.85
+	confidenceSenderDomain  = 0.8
+	confidenceSubjectAlias  = 0.6
+	confidenceWeakSubject   = 0.3
+	confidenceBodyAlias     = 0.35
+)
+
+// maxBodyMentions caps how many mentions in the body add confidence
+const maxBodyMentions = 3
+
+// DefaultMinimumConfidence is the confidence a brand needs to be reported
+const DefaultMinimumConfidence = 0.5
+
+// Brand is a catalog entry. Weak aliases are common words, like "target",
+// that only count when they appear in the subject.
+type Brand struct {
+	Name        string   `json:"name"`
+	Domains     []string `json:"domains"`
+	Aliases     []string `json:"aliases"`
+	WeakAliases []string `json:"weak_aliases,omitempty"`
+}
+
+// Input is the parts of an email brands are detected from. From and
+// ListUnsubscribe are raw header values.
+type Input struct {
+	SenderEmail     string
+	From            string
+	ListUnsubscribe string
+	Subject         string
+	Body            string
+}
+
+// Match is a detected brand with the confidence in it, between 0 and 1, and
+// the signals that pointed to it
+type Match struct {
+	Brand      string
+	Confidence float64
+	Signals    []string
+}
+
+// Detector matches emails against a brand catalog
+type Detector struct {
+	brands  []Brand
+	domains map[string]int // Domain to index in brands
+	minimum float64
+}
+
+// LoadCatalog reads a JSON brand catalog
+func LoadCatalog(r io.Reader) ([]Brand, error) {
+	var catalog []Brand
+	if err := json.NewDecoder(r).Decode(&catalog); err != nil {
+		return nil, err
+	}
+	return catalog, nil
+}
+
+// DefaultCatalog returns the catalog built into the server
+func DefaultCatalog() []Brand {
+	var catalog []Brand
+	if err := json.Unmarshal(defaultCatalog, &catalog); err != nil {
+		panic("brands: invalid built-in catalog: " + err.Error())
+	}
+	return catalog
+}
+
+// NewDetector creates a Detector for catalog that reports brands with at
+// least DefaultMinimumConfidence
+func NewDetector(catalog []Brand) *Detector {
+	d := &Detector{
+		domains: make(map[string]int),
+		minimum: DefaultMinimumConfidence,
+	}
+	for _, brand := range catalog {
+		brand.Aliases = normalizeAll(brand.Aliases)
+		brand.WeakAliases = normalizeAll(brand.WeakAliases)
+		d.brands = append(d.brands, brand)
+		for _, domain := range brand.Domains {
+			d.domains[strings.ToLower(domain)] = len(d.brands) - 1
+		}
+	}
+	return d
+}
+
+// SetMinimumConfidence changes the confidence a brand needs to be reported
+func (d *Detector) SetMinimumConfidence(minimum float64) {
+	d.minimum = minimum
+}
+
+// forwardedFrom finds the From lines of forwarded messages quoted in a body
+var forwardedFrom = regexp.MustCompile(`(?im)^[\s>*]*from:\s*(.+)$`)
+
+// emailAddress finds bare addresses in text
+var emailAddress = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@([A-Za-z0-9\-]+\.)+[A-Za-z]{2,}`)
+
+// Detect returns the most likely brand of an email, or nil if no brand
+// reaches the minimum confidence
+func (d *Detector) Detect(in Input) *Match {
+	scores := map[int]*Match{}
+	add := func(i int, confidence float64, signal string) {
+		m, ok := scores[i]
+		if !ok {
+			m = &Match{Brand: d.brands[i].Name}
+			scores[i] = m
+		}
+		// Independent signals combine so that no number of them reaches 1
+		m.Confidence = 1 - (1-m.Confidence)*(1-confidence)
+		m.Signals = append(m.Signals, signal)
+	}
+
+	if from, err := mail.ParseAddress(in.From); err == nil {
+		if i, ok := d.lookupDomain(domainOf(from.Address)); ok {
+			add(i, confidenceFromDomain, "from domain")
+		}
+		for _, i := range d.matchAliases(from.Name, false) {
+			add(i, confidenceDisplayName, "from name")
+		}
+	}
+
+	for _, host := range unsubscribeHosts(in.ListUnsubscribe) {
+		if i, ok := d.lookupDomain(host); ok {
+			add(i, confidenceUnsubscribe, "list-unsubscribe")
+			break
+		}
+	}
+
+	if i, ok := d.lookupDomain(domainOf(in.SenderEmail)); ok {
+		add(i, confidenceSenderDomain, "sender domain")
+	}
+
+	// Forwarded messages quote the original headers in the body
+	for _, line := range forwardedFrom.FindAllStringSubmatch(in.Body, -1) {
+		for _, address := range emailAddress.FindAllString(line[1], -1) {
+			if i, ok := d.lookupDomain(domainOf(address)); ok {
+				add(i, confidenceForwardedFrom, "forwarded from")
+			}
+		}
+	}
+
+	for _, i := range d.matchAliases(in.Subject, false) {
+		add(i, confidenceSubjectAlias, "subject")
+	}
+	for _, i := range d.matchAliases(in.Subject, true) {
+		add(i, confidenceWeakSubject, "subject")
+	}
+
+	body := normalize(in.Body)
+	for i, brand := range d.brands {
+		mentions := 0
+		for _, alias := range brand.Aliases {
+			mentions += strings.Count(body, " "+alias+" ")
+		}
+		for n := 0; n < mentions && n < maxBodyMentions; n++ {
+			add(i, confidenceBodyAlias, "body")
+		}
+	}
+
+	var best *Match
+	for _, m := range scores {
+		if best == nil || m.Confidence > best.Confidence ||
+			(m.Confidence == best.Confidence && m.Brand < best.Brand) {
+			best = m
+		}
+	}
+	if best == nil || best.Confidence < d.minimum {
+		return nil
+	}
+	best.Signals = dedupe(best.Signals)
+	return best
+}
+
+// lookupDomain finds the brand of a domain or of any domain it is a subdomain of
+func (d *Detector) lookupDomain(domain string) (int, bool) {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	for strings.Count(domain, ".") >= 1 {
+		if i, ok := d.domains[domain]; ok {
+			return i, true
+		}
+		domain = domain[strings.IndexByte(domain, '.')+1:]
+	}
+	return 0, false
+}
+
+// matchAliases returns the brands with an alias in text, using the weak
+// aliases instead of the normal ones if weak is set
+func (d *Detector) matchAliases(text string, weak bool) []int {
+	text = normalize(text)
+	var matches []int
+	for i, brand := range d.brands {
+		aliases := brand.Aliases
+		if weak {
+			aliases = brand.WeakAliases
+		}
+		for _, alias := range aliases {
+			if strings.Contains(text, " "+alias+" ") {
+				matches = append(matches, i)
+				break
+			}
+		}
+	}
+	return matches
+}
+
+// normalize lowercases text into space separated words padded with spaces, so
+// aliases can be matched on word boundaries with strings.Contains
+func normalize(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("&'+.", r)
+	})
+	for i, word := range words {
+		words[i] = strings.Trim(word, ".'")
+	}
+	return " " + strings.Join(words, " ") + " "
+}
+
+// normalizeAll normalizes aliases the same way as the text they are matched in
+func normalizeAll(aliases []string) []string {
+	normalized := make([]string, 0, len(aliases))
+	for _, alias := range aliases {
+		if n := strings.TrimSpace(normalize(alias)); n != "" {
+			normalized = append(normalized, n)
+		}
+	}
+	return normalized
+}
+
+// unsubscribeHosts returns the hosts of the mailto and web links of a
+// List-Unsubscribe header
+func unsubscribeHosts(header string) []string {
+	var hosts []string
+	for _, part := range strings.Split(header, ",") {
+		link := strings.Trim(strings.TrimSpace(part), "<>")
+		if strings.HasPrefix(strings.ToLower(link), "mailto:") {
+			address := strings.SplitN(link[len("mailto:"):], "?", 2)[0]
+			hosts = append(hosts, domainOf(address))
+			continue
+		}
+		if u, err := url.Parse(link); err == nil && u.Hostname() != "" {
+			hosts = append(hosts, u.Hostname())
+		}
+	}
+	return hosts
+}
+
+// domainOf returns the domain of an email address
+func domainOf(address string) string {
+	at := strings.LastIndexByte(address, '@')
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(address[at+1:]))
+}
+
+// dedupe removes repeated signals, keeping them sorted
+func dedupe(signals []string) []string {
+	sort.Strings(signals)
+	out := signals[:0]
+	for i, s := range signals {
+		if i == 0 || s != signals[i-1] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
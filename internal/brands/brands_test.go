@@ -0,0 +1,228 @@
+package brands_test
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"loyaltea-server/internal/brands"
+)
+
+// testCatalog is a small catalog so confidences do not depend on the built-in one
+const testCatalog = `[
+	{"name": "Acme", "domains": ["acme.com"], "aliases": ["acme", "acme rewards"]},
+	{"name": "Bean & Leaf", "domains": ["beanandleaf.com"], "aliases": ["bean & leaf"]},
+	{"name": "Target", "domains": ["target.com"], "aliases": ["target circle"], "weak_aliases": ["target"]}
+]`
+
+func newDetector(t *testing.T) *brands.Detector {
+	t.Helper()
+	catalog, err := brands.LoadCatalog(strings.NewReader(testCatalog))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return brands.NewDetector(catalog)
+}
+
+// combined is the confidence independent signals add up to
+func combined(confidences ...float64) float64 {
+	doubt := 1.0
+	for _, c := range confidences {
+		doubt *= 1 - c
+	}
+	return 1 - doubt
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name       string
+		in         brands.Input
+		brand      string // Empty for no match
+		confidence float64
+		signals    []string
+	}{
+		{
+			name:       "from domain",
+			in:         brands.Input{From: "deals@acme.com"},
+			brand:      "Acme",
+			confidence: 0.95,
+			signals:    []string{"from domain"},
+		},
+		{
+			name:       "from subdomain",
+			in:         brands.Input{From: "news@news.acme.com"},
+			brand:      "Acme",
+			confidence: 0.95,
+			signals:    []string{"from domain"},
+		},
+		{
+			name:       "from deeper subdomain in upper case",
+			in:         brands.Input{From: "news@E.Mail.ACME.com"},
+			brand:      "Acme",
+			confidence: 0.95,
+			signals:    []string{"from domain"},
+		},
+		{
+			name: "lookalike domains",
+			in:   brands.Input{From: "deals@notacme.com", SenderEmail: "me@acme.com.example.test"},
+		},
+		{
+			name:       "display name",
+			in:         brands.Input{From: "Bean & Leaf <hello@mailer.example.test>"},
+			brand:      "Bean & Leaf",
+			confidence: 0.85,
+			signals:    []string{"from name"},
+		},
+		{
+			name:       "display name and domain",
+			in:         brands.Input{From: "Acme Deals <deals@acme.com>"},
+			brand:      "Acme",
+			confidence: combined(0.95, 0.85),
+			signals:    []string{"from domain", "from name"},
+		},
+		{
+			name:       "encoded display name",
+			in:         brands.Input{From: "=?UTF-8?Q?Bean_&_Leaf?= <hello@mailer.example.test>"},
+			brand:      "Bean & Leaf",
+			confidence: 0.85,
+			signals:    []string{"from name"},
+		},
+		{
+			name:       "list-unsubscribe counts once",
+			in:         brands.Input{ListUnsubscribe: "<mailto:unsubscribe@list.acme.com?subject=stop>, <https://acme.com/unsubscribe>"},
+			brand:      "Acme",
+			confidence: 0.9,
+			signals:    []string{"list-unsubscribe"},
+		},
+		{
+			name:       "sender domain",
+			in:         brands.Input{SenderEmail: "newsletter@acme.com"},
+			brand:      "Acme",
+			confidence: 0.8,
+			signals:    []string{"sender domain"},
+		},
+		{
+			name: "forwarded from",
+			in: brands.Input{
+				SenderEmail: "alice@example.com",
+				Body:        "---------- Forwarded message ---------\nFrom: Offers <offers@beanandleaf.com>\n\nTwo for one.",
+			},
+			brand:      "Bean & Leaf",
+			confidence: 0.85,
+			signals:    []string{"forwarded from"},
+		},
+		{
+			name:       "subject alias",
+			in:         brands.Input{Subject: "Your Acme Rewards balance"},
+			brand:      "Acme",
+			confidence: 0.6,
+			signals:    []string{"subject"},
+		},
+		{
+			name: "alias inside a word",
+			in:   brands.Input{Subject: "Acmeville sale", Body: "Welcome to acmeville"},
+		},
+		{
+			name: "weak alias alone is not enough",
+			in:   brands.Input{Subject: "Hit your target: 10% off"},
+		},
+		{
+			name:       "weak alias with a body mention",
+			in:         brands.Input{Subject: "Target run", Body: "Join Target Circle today."},
+			brand:      "Target",
+			confidence: combined(0.3, 0.35),
+			signals:    []string{"body", "subject"},
+		},
+		{
+			name:       "body mentions are capped",
+			in:         brands.Input{Body: "Acme, acme, ACME. Acme! acme?"},
+			brand:      "Acme",
+			confidence: combined(0.35, 0.35, 0.35),
+			signals:    []string{"body"},
+		},
+		{
+			name:       "strongest brand wins",
+			in:         brands.Input{From: "deals@acme.com", Subject: "Bean & Leaf and Acme team up"},
+			brand:      "Acme",
+			confidence: combined(0.95, 0.6),
+			signals:    []string{"from domain", "subject"},
+		},
+		{
+			name:       "ties go to the first name",
+			in:         brands.Input{Subject: "Bean & Leaf and Acme team up"},
+			brand:      "Acme",
+			confidence: 0.6,
+			signals:    []string{"subject"},
+		},
+		{
+			name: "unknown brand",
+			in: brands.Input{
+				SenderEmail: "alice@example.com",
+				From:        "Corner Shop <hello@cornershop.example.test>",
+				Subject:     "20% off this weekend",
+				Body:        "Everything in store is 20% off.",
+			},
+		},
+		{
+			name: "nothing to go on",
+			in:   brands.Input{From: "not an address"},
+		},
+	}
+
+	d := newDetector(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match := d.Detect(tt.in)
+			if tt.brand == "" {
+				if match != nil {
+					t.Fatalf("match = %+v, want none", match)
+				}
+				return
+			}
+			if match == nil {
+				t.Fatalf("no match, want %s", tt.brand)
+			}
+			if match.Brand != tt.brand {
+				t.Errorf("brand = %q, want %q", match.Brand, tt.brand)
+			}
+			if math.Abs(match.Confidence-tt.confidence) > 1e-9 {
+				t.Errorf("confidence = %v, want %v", match.Confidence, tt.confidence)
+			}
+			if !reflect.DeepEqual(match.Signals, tt.signals) {
+				t.Errorf("signals = %q, want %q", match.Signals, tt.signals)
+			}
+		})
+	}
+}
+
+func TestDetectMinimumConfidence(t *testing.T) {
+	d := newDetector(t)
+	in := brands.Input{Subject: "Hit your target: 10% off"}
+
+	d.SetMinimumConfidence(0.2)
+	match := d.Detect(in)
+	if match == nil || match.Brand != "Target" || math.Abs(match.Confidence-0.3) > 1e-9 {
+		t.Fatalf("match = %+v, want Target at 0.3", match)
+	}
+
+	d.SetMinimumConfidence(brands.DefaultMinimumConfidence)
+	if match := d.Detect(in); match != nil {
+		t.Fatalf("match = %+v, want none at the default minimum", match)
+	}
+}
+
+func TestDefaultCatalog(t *testing.T) {
+	d := brands.NewDetector(brands.DefaultCatalog())
+
+	match := d.Detect(brands.Input{From: "Starbucks Rewards <news@e.starbucks.com>"})
+	if match == nil || match.Brand != "Starbucks" {
+		t.Fatalf("match = %+v, want Starbucks", match)
+	}
+}
+
+func TestLoadCatalogInvalid(t *testing.T) {
+	if _, err := brands.LoadCatalog(strings.NewReader(`{"name": "Acme"}`)); err == nil {
+		t.Fatal("loaded a catalog that is not a list")
+	}
+}
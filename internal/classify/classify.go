@@ -0,0 +1,125 @@
+// Package classify infers category tags for offers from their brand,
+// discounts and the keywords in their subject and body.
+package classify
+
+import (
+	_ "embed"
+	"encoding/json"
+	"io"
+	"slices"
+	"strings"
+	"unicode"
+
+	"loyaltea-server/internal/models"
+)
+
+//go:embed rules.json
+var defaultRules []byte
+
+// Keyword scores. A tag needs minimumScore, so one keyword in the subject or
+// two different keywords in the body.
+const (
+	subjectScore = 2
+	bodyScore    = 1
+	minimumScore = 2
+)
+
+// Rule assigns Tag to offers from one of Brands, with one of the kinds of
+// Discounts, or mentioning enough of Keywords
+type Rule struct {
+	Tag       string   `json:"tag"`
+	Brands    []string `json:"brands,omitempty"`
+	Discounts []string `json:"discounts,omitempty"`
+	Keywords  []string `json:"keywords,omitempty"`
+}
+
+// Classifier assigns tags to offers by its rules
+type Classifier struct {
+	rules []Rule
+}
+
+// LoadRules reads JSON classification rules
+func LoadRules(r io.Reader) ([]Rule, error) {
+	var rules []Rule
+	if err := json.NewDecoder(r).Decode(&rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// DefaultRules returns the rules built into the server
+func DefaultRules() []Rule {
+	var rules []Rule
+	if err := json.Unmarshal(defaultRules, &rules); err != nil {
+		panic("classify: invalid built-in rules: " + err.Error())
+	}
+	return rules
+}
+
+// New creates a Classifier for rules
+func New(rules []Rule) *Classifier {
+	c := &Classifier{}
+	for _, rule := range rules {
+		keywords := make([]string, 0, len(rule.Keywords))
+		for _, keyword := range rule.Keywords {
+			if k := strings.TrimSpace(normalize(keyword)); k != "" {
+				keywords = append(keywords, k)
+			}
+		}
+		rule.Keywords = keywords
+		c.rules = append(c.rules, rule)
+	}
+	return c
+}
+
+// Classify returns the tags the rules assign to offer, sorted and without
+// duplicates. Brand and Discounts should already be filled in.
+func (c *Classifier) Classify(offer *models.Offer) []string {
+	subject := normalize(offer.Subject)
+	body := normalize(offer.Body)
+
+	var tags []string
+	for _, rule := range c.rules {
+		if c.matches(rule, offer, subject, body) {
+			tags = append(tags, rule.Tag)
+		}
+	}
+
+	slices.Sort(tags)
+	return slices.Compact(tags)
+}
+
+// matches reports whether rule applies to offer, given its normalized subject and body
+func (c *Classifier) matches(rule Rule, offer *models.Offer, subject string, body string) bool {
+	if offer.Brand != "" && slices.Contains(rule.Brands, offer.Brand) {
+		return true
+	}
+	for _, discount := range offer.Discounts {
+		if slices.Contains(rule.Discounts, discount.Kind) {
+			return true
+		}
+	}
+
+	score := 0
+	for _, keyword := range rule.Keywords {
+		switch {
+		case strings.Contains(subject, " "+keyword+" "):
+			score += subjectScore
+		case strings.Contains(body, " "+keyword+" "):
+			score += bodyScore
+		}
+		if score >= minimumScore {
+			return true
+		}
+	}
+	return false
+}
+
+// normalize lowercases text into space separated words padded with spaces, so
+// keywords can be matched on word boundaries with strings.Contains
+func normalize(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return " " + strings.Join(words, " ") + " "
+}
@@ -0,0 +1,95 @@
+package db
+
+import (
+	"context"
+	"loyaltea-server/internal/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// EarnRuleModel handles database operations for earn rules
+type EarnRuleModel struct {
+	collection *mongo.Collection
+}
+
+// NewEarnRuleModel creates a new EarnRuleModel instance
+func NewEarnRuleModel(db *mongo.Database) *EarnRuleModel {
+	return &EarnRuleModel{
+		collection: db.Collection("earn_rules"),
+	}
+}
+
+// Create stores a new rule
+func (m *EarnRuleModel) Create(ctx context.Context, rule *models.EarnRule) error {
+	if rule.ID == "" {
+		rule.ID = bson.NewObjectID().Hex()
+	}
+	now := time.Now()
+	rule.CreatedAt = now
+	rule.UpdatedAt = now
+
+	_, err := m.collection.InsertOne(ctx, rule)
+	return err
+}
+
+// Update replaces a rule, keeping when it was created, and reports whether it
+// exists
+func (m *EarnRuleModel) Update(ctx context.Context, rule *models.EarnRule) (bool, error) {
+	existing, err := m.FindByID(ctx, rule.ID)
+	if err != nil || existing == nil {
+		return false, err
+	}
+	rule.CreatedAt = existing.CreatedAt
+	rule.UpdatedAt = time.Now()
+
+	result, err := m.collection.ReplaceOne(ctx, bson.M{"_id": rule.ID}, rule)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
+// Delete removes a rule and reports whether it existed
+func (m *EarnRuleModel) Delete(ctx context.Context, id string) (bool, error) {
+	result, err := m.collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return false, err
+	}
+	return result.DeletedCount == 1, nil
+}
+
+// FindByID returns a rule, or nil if it does not exist
+func (m *EarnRuleModel) FindByID(ctx context.Context, id string) (*models.EarnRule, error) {
+	var rule models.EarnRule
+	err := m.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&rule)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// List returns the rules, or only the active ones, oldest first
+func (m *EarnRuleModel) List(ctx context.Context, activeOnly bool) ([]models.EarnRule, error) {
+	query := bson.M{}
+	if activeOnly {
+		query["active"] = true
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+
+	cursor, err := m.collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := []models.EarnRule{}
+	if err := cursor.All(ctx, &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"context"
+	"loyaltea-server/internal/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// LoginAttemptModel handles database operations for failed login tracking
+type LoginAttemptModel struct {
+	collection *mongo.Collection
+}
+
+// NewLoginAttemptModel creates a new LoginAttemptModel instance
+func NewLoginAttemptModel(db *mongo.Database) *LoginAttemptModel {
+	return &LoginAttemptModel{
+		collection: db.Collection("login_attempts"),
+	}
+}
+
+// FindByID finds the attempt record for a key
+func (m *LoginAttemptModel) FindByID(ctx context.Context, id string) (*models.LoginAttempt, error) {
+	var attempt models.LoginAttempt
+	err := m.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&attempt)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &attempt, nil
+}
+
+// IncrementFailures atomically counts one more failure for a key, creating
+// the record if needed, and returns the updated record
+func (m *LoginAttemptModel) IncrementFailures(ctx context.Context, kind string, key string, at time.Time) (*models.LoginAttempt, error) {
+	update := bson.M{
+		"$inc": bson.M{"failures": 1},
+		"$set": bson.M{"last_failure_at": at},
+		"$setOnInsert": bson.M{
+			"kind":             kind,
+			"key":              key,
+			"first_failure_at": at,
+		},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var attempt models.LoginAttempt
+	err := m.collection.FindOneAndUpdate(ctx, bson.M{"_id": kind + ":" + key}, update, opts).Decode(&attempt)
+	if err != nil {
+		return nil, err
+	}
+	return &attempt, nil
+}
+
+// SetRestrictions stores the earliest time of the next attempt and, if set,
+// the end of a lockout
+func (m *LoginAttemptModel) SetRestrictions(ctx context.Context, id string, nextAttemptAt *time.Time, lockedUntil *time.Time) error {
+	update := bson.M{
+		"$set": bson.M{
+			"next_attempt_at": nextAttemptAt,
+			"locked_until":    lockedUntil,
+		},
+	}
+	_, err := m.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+// Delete clears the record for a key
+func (m *LoginAttemptModel) Delete(ctx context.Context, id string) error {
+	_, err := m.collection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
@@ -0,0 +1,108 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/services"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var _ services.EarnRuleRepository = (*EarnRuleRepository)(nil)
+
+// EarnRuleRepository stores earn rules in memory
+type EarnRuleRepository struct {
+	mu    sync.RWMutex
+	rules map[string]*models.EarnRule
+}
+
+// NewEarnRuleRepository creates an empty EarnRuleRepository
+func NewEarnRuleRepository() *EarnRuleRepository {
+	return &EarnRuleRepository{
+		rules: make(map[string]*models.EarnRule),
+	}
+}
+
+// Create stores a new rule
+func (r *EarnRuleRepository) Create(ctx context.Context, rule *models.EarnRule) error {
+	if rule.ID == "" {
+		rule.ID = bson.NewObjectID().Hex()
+	}
+	now := time.Now()
+	rule.CreatedAt = now
+	rule.UpdatedAt = now
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rules[rule.ID] = copyEarnRule(rule)
+	return nil
+}
+
+// Update replaces a rule, keeping when it was created
+func (r *EarnRuleRepository) Update(ctx context.Context, rule *models.EarnRule) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	existing, ok := r.rules[rule.ID]
+	if !ok {
+		return false, nil
+	}
+	rule.CreatedAt = existing.CreatedAt
+	rule.UpdatedAt = time.Now()
+	r.rules[rule.ID] = copyEarnRule(rule)
+	return true, nil
+}
+
+// Delete removes a rule
+func (r *EarnRuleRepository) Delete(ctx context.Context, id string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.rules[id]; !ok {
+		return false, nil
+	}
+	delete(r.rules, id)
+	return true, nil
+}
+
+// FindByID finds a rule by ID
+func (r *EarnRuleRepository) FindByID(ctx context.Context, id string) (*models.EarnRule, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	rule, ok := r.rules[id]
+	if !ok {
+		return nil, nil
+	}
+	return copyEarnRule(rule), nil
+}
+
+// List returns the rules, or only the active ones, oldest first
+func (r *EarnRuleRepository) List(ctx context.Context, activeOnly bool) ([]models.EarnRule, error) {
+	r.mu.RLock()
+	rules := []models.EarnRule{}
+	for _, rule := range r.rules {
+		if activeOnly && !rule.Active {
+			continue
+		}
+		rules = append(rules, *copyEarnRule(rule))
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(rules, func(i, j int) bool {
+		if !rules[i].CreatedAt.Equal(rules[j].CreatedAt) {
+			return rules[i].CreatedAt.Before(rules[j].CreatedAt)
+		}
+		return rules[i].ID < rules[j].ID
+	})
+	return rules, nil
+}
+
+func copyEarnRule(rule *models.EarnRule) *models.EarnRule {
+	c := *rule
+	c.Days = append([]int(nil), rule.Days...)
+	c.ValidFrom = copyTime(rule.ValidFrom)
+	c.ValidUntil = copyTime(rule.ValidUntil)
+	return &c
+}
@@ -0,0 +1,250 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/services"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var _ services.OfferRepository = (*OfferRepository)(nil)
+
+// OfferRepository stores offers in memory
+type OfferRepository struct {
+	mu     sync.RWMutex
+	offers map[string]*models.Offer
+}
+
+// NewOfferRepository creates an empty OfferRepository
+func NewOfferRepository() *OfferRepository {
+	return &OfferRepository{
+		offers: make(map[string]*models.Offer),
+	}
+}
+
+// Create stores a new offer
+func (r *OfferRepository) Create(ctx context.Context, offer *models.Offer) error {
+	if offer.ID == "" {
+		offer.ID = bson.NewObjectID().Hex()
+	}
+	offer.CreatedAt = time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.offers[offer.ID] = copyOffer(offer)
+	return nil
+}
+
+// copyOffer returns a deep copy so callers never share memory with the store
+func copyOffer(offer *models.Offer) *models.Offer {
+	c := *offer
+	c.Tags = append([]string(nil), offer.Tags...)
+	c.InferredTags = append([]string(nil), offer.InferredTags...)
+	c.SimhashBands = append([]string(nil), offer.SimhashBands...)
+	c.Forwarders = append([]models.Forwarder(nil), offer.Forwarders...)
+	c.Codes = append([]string(nil), offer.Codes...)
+	c.Discounts = append([]models.Discount(nil), offer.Discounts...)
+	c.MinimumSpend = copyMoney(offer.MinimumSpend)
+	c.ValidFrom = copyTime(offer.ValidFrom)
+	c.ValidUntil = copyTime(offer.ValidUntil)
+	if offer.Headers != nil {
+		c.Headers = make(map[string]string, len(offer.Headers))
+		for k, v := range offer.Headers {
+			c.Headers[k] = v
+		}
+	}
+	return &c
+}
+
+func copyMoney(m *models.Money) *models.Money {
+	if m == nil {
+		return nil
+	}
+	c := *m
+	return &c
+}
+
+// FindByID finds an offer by ID
+func (r *OfferRepository) FindByID(ctx context.Context, id string) (*models.Offer, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	offer, ok := r.offers[id]
+	if !ok {
+		return nil, nil
+	}
+	return copyOffer(offer), nil
+}
+
+// List returns up to query.Limit offers matching query, starting after the
+// cursor if one is given
+func (r *OfferRepository) List(ctx context.Context, query models.OfferQuery) ([]models.Offer, error) {
+	r.mu.RLock()
+	matches := []models.Offer{}
+	for _, offer := range r.offers {
+		if matchesOfferQuery(offer, query) {
+			matches = append(matches, *copyOffer(offer))
+		}
+	}
+	r.mu.RUnlock()
+
+	// compare orders offers by (sort field, ID) in the requested direction
+	compare := func(a *models.Offer, value interface{}, id string) int {
+		c := compareOfferField(a, query.SortBy, value)
+		if c == 0 {
+			c = strings.Compare(a.ID, id)
+		}
+		if query.SortDesc {
+			return -c
+		}
+		return c
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		return compare(&matches[i], offerField(&matches[j], query.SortBy), matches[j].ID) < 0
+	})
+
+	if query.After != nil {
+		start := len(matches)
+		for i := range matches {
+			if compare(&matches[i], query.After.Value, query.After.ID) > 0 {
+				start = i
+				break
+			}
+		}
+		matches = matches[start:]
+	}
+
+	return paginate(matches, 0, query.Limit), nil
+}
+
+func matchesOfferQuery(offer *models.Offer, query models.OfferQuery) bool {
+	if query.OwnerID != "" && offer.UserID != query.OwnerID {
+		return false
+	}
+	if query.SenderEmail != "" && offer.SenderEmail != query.SenderEmail {
+		return false
+	}
+	if query.Brand != "" && offer.Brand != query.Brand {
+		return false
+	}
+	if query.Tag != "" && !slices.Contains(offer.Tags, query.Tag) && !slices.Contains(offer.InferredTags, query.Tag) {
+		return false
+	}
+	if query.Source != "" && offer.Source != query.Source {
+		return false
+	}
+	if query.ExcludeDuplicates && offer.DuplicateOf != "" {
+		return false
+	}
+	if query.CreatedAfter != nil && offer.CreatedAt.Before(*query.CreatedAfter) {
+		return false
+	}
+	if query.CreatedBefore != nil && !offer.CreatedAt.Before(*query.CreatedBefore) {
+		return false
+	}
+	return true
+}
+
+// offerField returns the value of a sortable field
+func offerField(offer *models.Offer, field string) interface{} {
+	switch field {
+	case models.OfferSortSubject:
+		return offer.Subject
+	case models.OfferSortBrand:
+		return offer.Brand
+	default:
+		return offer.CreatedAt
+	}
+}
+
+// compareOfferField compares a sortable field of offer with value
+func compareOfferField(offer *models.Offer, field string, value interface{}) int {
+	switch v := value.(type) {
+	case time.Time:
+		return offer.CreatedAt.Compare(v)
+	case string:
+		return strings.Compare(offerField(offer, field).(string), v)
+	}
+	return 0
+}
+
+// ClaimBySender links unlinked offers forwarded from email to userID
+func (r *OfferRepository) ClaimBySender(ctx context.Context, email string, userID string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var claimed int64
+	for _, offer := range r.offers {
+		if offer.SenderEmail == email && offer.UserID == "" {
+			offer.UserID = userID
+			offer.Status = models.OfferStatusClaimed
+			claimed++
+		}
+	}
+	return claimed, nil
+}
+
+// SetInferredTags replaces the tags the classifier assigned to an offer
+func (r *OfferRepository) SetInferredTags(ctx context.Context, id string, tags []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if offer, ok := r.offers[id]; ok {
+		offer.InferredTags = append([]string(nil), tags...)
+	}
+	return nil
+}
+
+// FindDuplicateCandidates returns canonical offers with the given content hash
+// or sharing a simhash band, oldest first
+func (r *OfferRepository) FindDuplicateCandidates(ctx context.Context, hash string, bands []string, limit int64) ([]models.Offer, error) {
+	r.mu.RLock()
+	candidates := []models.Offer{}
+	for _, offer := range r.offers {
+		if offer.DuplicateOf != "" {
+			continue
+		}
+		if offer.ContentHash == hash || slices.ContainsFunc(bands, func(band string) bool {
+			return slices.Contains(offer.SimhashBands, band)
+		}) {
+			candidates = append(candidates, *copyOffer(offer))
+		}
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].CreatedAt.Before(candidates[j].CreatedAt)
+	})
+	return paginate(candidates, 0, limit), nil
+}
+
+// RecordForward counts another forward of a canonical offer
+func (r *OfferRepository) RecordForward(ctx context.Context, id string, forwarder models.Forwarder) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	offer, ok := r.offers[id]
+	if !ok {
+		return nil
+	}
+
+	offer.ForwardCount++
+	for i := range offer.Forwarders {
+		f := &offer.Forwarders[i]
+		if f.SenderEmail == forwarder.SenderEmail {
+			f.Count++
+			f.LastForwardedAt = forwarder.LastForwardedAt
+			if forwarder.UserID != "" {
+				f.UserID = forwarder.UserID
+			}
+			return nil
+		}
+	}
+	forwarder.Count = 1
+	offer.Forwarders = append(offer.Forwarders, forwarder)
+	return nil
+}
@@ -0,0 +1,179 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/services"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var _ services.OutboxRepository = (*OutboxRepository)(nil)
+
+// OutboxRepository stores outbox items in memory
+type OutboxRepository struct {
+	mu    sync.Mutex
+	items map[string]*models.OutboxItem
+}
+
+// NewOutboxRepository creates an empty OutboxRepository
+func NewOutboxRepository() *OutboxRepository {
+	return &OutboxRepository{
+		items: make(map[string]*models.OutboxItem),
+	}
+}
+
+// Enqueue stores a new pending item unless one of the same kind for the same
+// address is pending
+func (r *OutboxRepository) Enqueue(ctx context.Context, item *models.OutboxItem) (bool, error) {
+	if item.ID == "" {
+		item.ID = bson.NewObjectID().Hex()
+	}
+	now := time.Now()
+	item.Status = models.OutboxPending
+	item.CreatedAt = now
+	item.UpdatedAt = now
+	if item.NextAttemptAt.IsZero() {
+		item.NextAttemptAt = now
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pending(item.Kind, item.Email) != nil {
+		return false, nil
+	}
+	r.items[item.ID] = copyOutboxItem(item)
+	return true, nil
+}
+
+// pending returns the pending item of a kind for an address, if any. The
+// caller must hold r.mu.
+func (r *OutboxRepository) pending(kind string, email string) *models.OutboxItem {
+	for _, item := range r.items {
+		if item.Status == models.OutboxPending && item.Kind == kind && item.Email == email {
+			return item
+		}
+	}
+	return nil
+}
+
+// ClaimDue locks the pending item that has been due longest for lease
+func (r *OutboxRepository) ClaimDue(ctx context.Context, now time.Time, lease time.Duration) (*models.OutboxItem, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var due *models.OutboxItem
+	for _, item := range r.items {
+		if item.Status != models.OutboxPending || item.NextAttemptAt.After(now) {
+			continue
+		}
+		if item.LockedUntil != nil && item.LockedUntil.After(now) {
+			continue
+		}
+		if due == nil || item.NextAttemptAt.Before(due.NextAttemptAt) {
+			due = item
+		}
+	}
+	if due == nil {
+		return nil, nil
+	}
+	lockedUntil := now.Add(lease)
+	due.LockedUntil = &lockedUntil
+	return copyOutboxItem(due), nil
+}
+
+// MarkDelivered records that an item was delivered
+func (r *OutboxRepository) MarkDelivered(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	item, ok := r.items[id]
+	if !ok {
+		return nil
+	}
+	now := time.Now()
+	item.Status = models.OutboxDelivered
+	item.Attempts++
+	item.DeliveredAt = &now
+	item.LockedUntil = nil
+	item.LastError = ""
+	item.UpdatedAt = now
+	return nil
+}
+
+// MarkFailed records a failed attempt
+func (r *OutboxRepository) MarkFailed(ctx context.Context, id string, lastError string, nextAttemptAt time.Time, dead bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	item, ok := r.items[id]
+	if !ok {
+		return nil
+	}
+	item.Status = models.OutboxPending
+	if dead {
+		item.Status = models.OutboxDead
+	}
+	item.Attempts++
+	item.LastError = lastError
+	item.NextAttemptAt = nextAttemptAt
+	item.LockedUntil = nil
+	item.UpdatedAt = time.Now()
+	return nil
+}
+
+// Retry makes a dead item pending again, or removes it if an item of the
+// same kind for the same address is already pending
+func (r *OutboxRepository) Retry(ctx context.Context, id string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	item, ok := r.items[id]
+	if !ok || item.Status != models.OutboxDead {
+		return false, nil
+	}
+	if r.pending(item.Kind, item.Email) != nil {
+		delete(r.items, id)
+		return true, nil
+	}
+	now := time.Now()
+	item.Status = models.OutboxPending
+	item.Attempts = 0
+	item.NextAttemptAt = now
+	item.LockedUntil = nil
+	item.UpdatedAt = now
+	return true, nil
+}
+
+// List returns a page of items, oldest first
+func (r *OutboxRepository) List(ctx context.Context, filter models.OutboxFilter, skip int64, limit int64) ([]models.OutboxItem, int64, error) {
+	r.mu.Lock()
+	matches := []models.OutboxItem{}
+	for _, item := range r.items {
+		if filter.Status != "" && item.Status != filter.Status {
+			continue
+		}
+		if filter.Status == "" && item.Status != models.OutboxDead && (item.Status != models.OutboxPending || item.Attempts == 0) {
+			continue
+		}
+		if filter.Kind != "" && item.Kind != filter.Kind {
+			continue
+		}
+		matches = append(matches, *copyOutboxItem(item))
+	}
+	r.mu.Unlock()
+
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].CreatedAt.Before(matches[j].CreatedAt)
+	})
+	return paginate(matches, skip, limit), int64(len(matches)), nil
+}
+
+// copyOutboxItem returns a copy so callers never share memory with the store
+func copyOutboxItem(item *models.OutboxItem) *models.OutboxItem {
+	c := *item
+	c.LockedUntil = copyTime(item.LockedUntil)
+	c.DeliveredAt = copyTime(item.DeliveredAt)
+	return &c
+}
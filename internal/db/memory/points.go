@@ -0,0 +1,108 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/services"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var _ services.PointsRepository = (*PointsRepository)(nil)
+
+// PointsRepository stores points ledgers in memory
+type PointsRepository struct {
+	mu      sync.RWMutex
+	entries map[string][]models.PointsEntry // By user ID, oldest first
+}
+
+// NewPointsRepository creates an empty PointsRepository
+func NewPointsRepository() *PointsRepository {
+	return &PointsRepository{
+		entries: make(map[string][]models.PointsEntry),
+	}
+}
+
+// Latest returns a user's most recent entry
+func (r *PointsRepository) Latest(ctx context.Context, userID string) (*models.PointsEntry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entries := r.entries[userID]
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	entry := entries[len(entries)-1]
+	return &entry, nil
+}
+
+// Append stores an entry unless the user already has one with its Seq, or
+// it credits a purchase already credited or repeats a redemption
+func (r *PointsRepository) Append(ctx context.Context, entry *models.PointsEntry) (bool, error) {
+	if entry.ID == "" {
+		entry.ID = bson.NewObjectID().Hex()
+	}
+	entry.CreatedAt = time.Now()
+	if entry.OccurredAt.IsZero() {
+		entry.OccurredAt = entry.CreatedAt
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entries := r.entries[entry.UserID]
+	for _, e := range entries {
+		if e.Seq == entry.Seq {
+			return false, nil
+		}
+		if (entry.Reason == models.PointsReasonPurchase || entry.Reason == models.PointsReasonRedemption) &&
+			entry.Reference != "" && e.Reason == entry.Reason && e.Reference == entry.Reference {
+			return false, nil
+		}
+	}
+	r.entries[entry.UserID] = append(entries, *entry)
+	return true, nil
+}
+
+// History returns a page of a user's entries, newest first
+func (r *PointsRepository) History(ctx context.Context, userID string, skip int64, limit int64) ([]models.PointsEntry, int64, error) {
+	r.mu.RLock()
+	entries := r.entries[userID]
+	newest := make([]models.PointsEntry, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		newest = append(newest, entries[i])
+	}
+	r.mu.RUnlock()
+
+	return paginate(newest, skip, limit), int64(len(newest)), nil
+}
+
+// FindByReference returns a user's entry for a reason and reference
+func (r *PointsRepository) FindByReference(ctx context.Context, userID string, reason string, reference string) (*models.PointsEntry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, entry := range r.entries[userID] {
+		if entry.Reason == reason && entry.Reference == reference {
+			return &entry, nil
+		}
+	}
+	return nil, nil
+}
+
+// Earned returns the points a user has been credited for a reason for what
+// occurred at or after from and, unless to is zero, before to
+func (r *PointsRepository) Earned(ctx context.Context, userID string, reason string, from time.Time, to time.Time) (int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var total int64
+	for _, entry := range r.entries[userID] {
+		if entry.Type != models.PointsCredit || entry.Reason != reason || entry.OccurredAt.Before(from) {
+			continue
+		}
+		if to.IsZero() || entry.OccurredAt.Before(to) {
+			total += entry.Points
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,121 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/services"
+)
+
+var (
+	_ services.LoginAttemptRepository  = (*LoginAttemptRepository)(nil)
+	_ services.SecurityEventRepository = (*SecurityEventRepository)(nil)
+)
+
+// LoginAttemptRepository stores failed login counters in memory
+type LoginAttemptRepository struct {
+	mu       sync.Mutex
+	attempts map[string]*models.LoginAttempt
+}
+
+// NewLoginAttemptRepository creates an empty LoginAttemptRepository
+func NewLoginAttemptRepository() *LoginAttemptRepository {
+	return &LoginAttemptRepository{
+		attempts: make(map[string]*models.LoginAttempt),
+	}
+}
+
+// FindByID finds the attempt record for a key
+func (r *LoginAttemptRepository) FindByID(ctx context.Context, id string) (*models.LoginAttempt, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	attempt, ok := r.attempts[id]
+	if !ok {
+		return nil, nil
+	}
+	return copyAttempt(attempt), nil
+}
+
+// IncrementFailures counts one more failure for a key
+func (r *LoginAttemptRepository) IncrementFailures(ctx context.Context, kind string, key string, at time.Time) (*models.LoginAttempt, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id := kind + ":" + key
+	attempt, ok := r.attempts[id]
+	if !ok {
+		attempt = &models.LoginAttempt{ID: id, Kind: kind, Key: key, FirstFailureAt: at}
+		r.attempts[id] = attempt
+	}
+	attempt.Failures++
+	attempt.LastFailureAt = at
+	return copyAttempt(attempt), nil
+}
+
+// SetRestrictions stores the next allowed attempt and lockout end for a key
+func (r *LoginAttemptRepository) SetRestrictions(ctx context.Context, id string, nextAttemptAt *time.Time, lockedUntil *time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if attempt, ok := r.attempts[id]; ok {
+		attempt.NextAttemptAt = copyTime(nextAttemptAt)
+		attempt.LockedUntil = copyTime(lockedUntil)
+	}
+	return nil
+}
+
+// Delete clears the record for a key
+func (r *LoginAttemptRepository) Delete(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.attempts, id)
+	return nil
+}
+
+func copyAttempt(attempt *models.LoginAttempt) *models.LoginAttempt {
+	c := *attempt
+	c.NextAttemptAt = copyTime(attempt.NextAttemptAt)
+	c.LockedUntil = copyTime(attempt.LockedUntil)
+	return &c
+}
+
+// SecurityEventRepository stores security events in memory
+type SecurityEventRepository struct {
+	mu     sync.Mutex
+	events []models.SecurityEvent
+}
+
+// NewSecurityEventRepository creates an empty SecurityEventRepository
+func NewSecurityEventRepository() *SecurityEventRepository {
+	return &SecurityEventRepository{}
+}
+
+// Create records a new event
+func (r *SecurityEventRepository) Create(ctx context.Context, event *models.SecurityEvent) error {
+	event.CreatedAt = time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, *event)
+	return nil
+}
+
+// List returns a page of matching events, newest first
+func (r *SecurityEventRepository) List(ctx context.Context, filter models.SecurityEventFilter, skip int64, limit int64) ([]models.SecurityEvent, int64, error) {
+	r.mu.Lock()
+	matches := []models.SecurityEvent{}
+	for _, event := range r.events {
+		if (filter.Type == "" || event.Type == filter.Type) &&
+			(filter.Email == "" || event.Email == filter.Email) &&
+			(filter.IP == "" || event.IP == filter.IP) {
+			matches = append(matches, event)
+		}
+	}
+	r.mu.Unlock()
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].CreatedAt.After(matches[j].CreatedAt)
+	})
+	return paginate(matches, skip, limit), int64(len(matches)), nil
+}
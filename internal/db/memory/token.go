@@ -0,0 +1,146 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/services"
+)
+
+var (
+	_ services.RefreshTokenRepository = (*RefreshTokenRepository)(nil)
+	_ services.OneTimeTokenRepository = (*OneTimeTokenRepository)(nil)
+)
+
+// RefreshTokenRepository stores refresh tokens in memory
+type RefreshTokenRepository struct {
+	mu     sync.Mutex
+	tokens map[string]*models.RefreshToken
+}
+
+// NewRefreshTokenRepository creates an empty RefreshTokenRepository
+func NewRefreshTokenRepository() *RefreshTokenRepository {
+	return &RefreshTokenRepository{
+		tokens: make(map[string]*models.RefreshToken),
+	}
+}
+
+// Create stores a new refresh token
+func (r *RefreshTokenRepository) Create(ctx context.Context, token *models.RefreshToken) error {
+	token.CreatedAt = time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := *token
+	r.tokens[token.ID] = &c
+	return nil
+}
+
+// FindByHash finds a refresh token by the hash of its value
+func (r *RefreshTokenRepository) FindByHash(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, token := range r.tokens {
+		if token.TokenHash == hash {
+			c := *token
+			c.UsedAt = copyTime(token.UsedAt)
+			c.RevokedAt = copyTime(token.RevokedAt)
+			return &c, nil
+		}
+	}
+	return nil, nil
+}
+
+// MarkUsed records that a still usable token has been rotated
+func (r *RefreshTokenRepository) MarkUsed(ctx context.Context, id string, replacedBy string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	token, ok := r.tokens[id]
+	if !ok || token.UsedAt != nil || token.RevokedAt != nil {
+		return false, nil
+	}
+	now := time.Now()
+	token.UsedAt = &now
+	token.ReplacedBy = replacedBy
+	return true, nil
+}
+
+// RevokeFamily revokes every token belonging to a session
+func (r *RefreshTokenRepository) RevokeFamily(ctx context.Context, familyID string) error {
+	r.revokeWhere(func(t *models.RefreshToken) bool { return t.FamilyID == familyID })
+	return nil
+}
+
+// RevokeAllForUser revokes every token belonging to a user
+func (r *RefreshTokenRepository) RevokeAllForUser(ctx context.Context, userID string) error {
+	r.revokeWhere(func(t *models.RefreshToken) bool { return t.UserID == userID })
+	return nil
+}
+
+func (r *RefreshTokenRepository) revokeWhere(match func(t *models.RefreshToken) bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for _, token := range r.tokens {
+		if token.RevokedAt == nil && match(token) {
+			revokedAt := now
+			token.RevokedAt = &revokedAt
+		}
+	}
+}
+
+// OneTimeTokenRepository stores single-use emailed tokens in memory
+type OneTimeTokenRepository struct {
+	mu     sync.Mutex
+	tokens map[string]*models.OneTimeToken
+}
+
+// NewOneTimeTokenRepository creates an empty OneTimeTokenRepository
+func NewOneTimeTokenRepository() *OneTimeTokenRepository {
+	return &OneTimeTokenRepository{
+		tokens: make(map[string]*models.OneTimeToken),
+	}
+}
+
+// Create stores a new token
+func (r *OneTimeTokenRepository) Create(ctx context.Context, token *models.OneTimeToken) error {
+	token.CreatedAt = time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := *token
+	r.tokens[token.ID] = &c
+	return nil
+}
+
+// Consume marks an unused, unexpired token as used and returns it
+func (r *OneTimeTokenRepository) Consume(ctx context.Context, purpose string, hash string) (*models.OneTimeToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for _, token := range r.tokens {
+		if token.TokenHash == hash && token.Purpose == purpose && token.UsedAt == nil && token.ExpiresAt.After(now) {
+			c := *token
+			usedAt := now
+			token.UsedAt = &usedAt
+			return &c, nil
+		}
+	}
+	return nil, nil
+}
+
+// InvalidateForUser marks every outstanding token of a purpose as used
+func (r *OneTimeTokenRepository) InvalidateForUser(ctx context.Context, userID string, purpose string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for _, token := range r.tokens {
+		if token.UserID == userID && token.Purpose == purpose && token.UsedAt == nil {
+			usedAt := now
+			token.UsedAt = &usedAt
+		}
+	}
+	return nil
+}
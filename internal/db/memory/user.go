@@ -0,0 +1,358 @@
+// Package memory is an in-process implementation of the service
+// repositories. It keeps everything in maps guarded by a mutex, so services
+// and handlers can be exercised with go test and no MongoDB.
+package memory
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/services"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var _ services.UserRepository = (*UserRepository)(nil)
+
+// UserRepository stores users in memory
+type UserRepository struct {
+	mu    sync.RWMutex
+	users map[string]*models.User
+}
+
+// NewUserRepository creates an empty UserRepository
+func NewUserRepository() *UserRepository {
+	return &UserRepository{
+		users: make(map[string]*models.User),
+	}
+}
+
+// Create creates a new user, hashing the password like the MongoDB model.
+// The minimum bcrypt cost keeps tests fast.
+func (r *UserRepository) Create(ctx context.Context, user *models.User) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.MinCost)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	user.Password = string(hashedPassword)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.users[user.ID] = copyUser(user)
+	return nil
+}
+
+// FindByEmail finds a user by email
+func (r *UserRepository) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, user := range r.users {
+		if user.Email == email {
+			return copyUser(user), nil
+		}
+	}
+	return nil, nil
+}
+
+// FindByAddress finds the user owning an address as email or alias email
+func (r *UserRepository) FindByAddress(ctx context.Context, email string) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, user := range r.users {
+		if user.Email == email || slices.Contains(user.AliasEmails, email) {
+			return copyUser(user), nil
+		}
+	}
+	return nil, nil
+}
+
+// FindByID finds a user by ID
+func (r *UserRepository) FindByID(ctx context.Context, id string) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	user, ok := r.users[id]
+	if !ok {
+		return nil, nil
+	}
+	return copyUser(user), nil
+}
+
+// Update updates the name, email and verification state of a user
+func (r *UserRepository) Update(ctx context.Context, user *models.User) error {
+	user.UpdatedAt = time.Now()
+	return r.modify(user.ID, func(u *models.User) {
+		u.Name = user.Name
+		u.Email = user.Email
+		u.EmailVerified = user.EmailVerified
+		u.EmailVerifiedAt = copyTime(user.EmailVerifiedAt)
+		u.UpdatedAt = user.UpdatedAt
+	})
+}
+
+// Delete deletes a user
+func (r *UserRepository) Delete(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.users, id)
+	return nil
+}
+
+// VerifyPassword checks if the provided password matches the user's password
+func (r *UserRepository) VerifyPassword(user *models.User, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) == nil
+}
+
+// UpdatePassword hashes and stores a new password for a user
+func (r *UserRepository) UpdatePassword(ctx context.Context, id string, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		return err
+	}
+	return r.modify(id, func(u *models.User) {
+		u.Password = string(hashedPassword)
+	})
+}
+
+// MarkEmailVerified marks a user's email as verified if it is still email
+func (r *UserRepository) MarkEmailVerified(ctx context.Context, id string, email string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[id]
+	if !ok || user.Email != email {
+		return false, nil
+	}
+	now := time.Now()
+	user.EmailVerified = true
+	user.EmailVerifiedAt = &now
+	user.UpdatedAt = now
+	return true, nil
+}
+
+// AddAliasEmail adds a verified secondary address to a user
+func (r *UserRepository) AddAliasEmail(ctx context.Context, id string, email string) error {
+	return r.modify(id, func(u *models.User) {
+		if !slices.Contains(u.AliasEmails, email) {
+			u.AliasEmails = append(u.AliasEmails, email)
+		}
+	})
+}
+
+// RemoveAliasEmail removes a secondary address from a user
+func (r *UserRepository) RemoveAliasEmail(ctx context.Context, id string, email string) error {
+	return r.modify(id, func(u *models.User) {
+		u.AliasEmails = slices.DeleteFunc(u.AliasEmails, func(a string) bool { return a == email })
+	})
+}
+
+// SetRole changes a user's role
+func (r *UserRepository) SetRole(ctx context.Context, id string, role string) error {
+	return r.modify(id, func(u *models.User) {
+		u.Role = role
+	})
+}
+
+// SetSuspended suspends or reinstates a user
+func (r *UserRepository) SetSuspended(ctx context.Context, id string, suspended bool, reason string) error {
+	return r.modify(id, func(u *models.User) {
+		u.Suspended = suspended
+		if suspended {
+			now := time.Now()
+			u.SuspendedAt = &now
+			u.SuspendedReason = reason
+		} else {
+			u.SuspendedAt = nil
+			u.SuspendedReason = ""
+		}
+	})
+}
+
+// List returns a page of users, newest first, matching query against the
+// email and name case-insensitively
+func (r *UserRepository) List(ctx context.Context, query string, skip int64, limit int64) ([]models.User, int64, error) {
+	r.mu.RLock()
+	matches := []models.User{}
+	query = strings.ToLower(query)
+	for _, user := range r.users {
+		if query == "" ||
+			strings.Contains(strings.ToLower(user.Email), query) ||
+			strings.Contains(strings.ToLower(user.Name), query) {
+			matches = append(matches, *copyUser(user))
+		}
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].CreatedAt.After(matches[j].CreatedAt)
+	})
+	return paginate(matches, skip, limit), int64(len(matches)), nil
+}
+
+// SetPendingMFASecret stores a TOTP secret awaiting confirmation
+func (r *UserRepository) SetPendingMFASecret(ctx context.Context, id string, secret string) error {
+	return r.modify(id, func(u *models.User) {
+		u.MFAPendingSecret = secret
+	})
+}
+
+// EnableMFA promotes the pending secret to the active one
+func (r *UserRepository) EnableMFA(ctx context.Context, id string, secret string, step int64, recoveryCodeHashes []string) error {
+	return r.modify(id, func(u *models.User) {
+		u.MFAEnabled = true
+		u.MFASecret = secret
+		u.MFALastStep = step
+		u.MFAPendingSecret = ""
+		u.RecoveryCodes = append([]string(nil), recoveryCodeHashes...)
+	})
+}
+
+// DisableMFA removes every second factor from a user
+func (r *UserRepository) DisableMFA(ctx context.Context, id string) error {
+	return r.modify(id, func(u *models.User) {
+		u.MFAEnabled = false
+		u.MFASecret = ""
+		u.MFAPendingSecret = ""
+		u.MFALastStep = 0
+		u.RecoveryCodes = nil
+	})
+}
+
+// UseTOTPStep records that the code for step has been used
+func (r *UserRepository) UseTOTPStep(ctx context.Context, id string, step int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[id]
+	if !ok || user.MFALastStep >= step {
+		return false, nil
+	}
+	user.MFALastStep = step
+	return true, nil
+}
+
+// ConsumeRecoveryCode removes a recovery code hash from a user
+func (r *UserRepository) ConsumeRecoveryCode(ctx context.Context, id string, hash string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[id]
+	if !ok {
+		return false, nil
+	}
+	for i, code := range user.RecoveryCodes {
+		if code == hash {
+			user.RecoveryCodes = append(user.RecoveryCodes[:i:i], user.RecoveryCodes[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// SetRecoveryCodes replaces a user's recovery code hashes
+func (r *UserRepository) SetRecoveryCodes(ctx context.Context, id string, hashes []string) error {
+	return r.modify(id, func(u *models.User) {
+		u.RecoveryCodes = append([]string(nil), hashes...)
+	})
+}
+
+// FindByInboundAlias finds the user whose forwarding address has the given local part
+func (r *UserRepository) FindByInboundAlias(ctx context.Context, alias string) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, user := range r.users {
+		if user.InboundAlias != "" && user.InboundAlias == alias {
+			return copyUser(user), nil
+		}
+	}
+	return nil, nil
+}
+
+// FindWithoutInboundAlias returns up to limit users who have no inbound alias
+func (r *UserRepository) FindWithoutInboundAlias(ctx context.Context, limit int64) ([]models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	users := []models.User{}
+	for _, user := range r.users {
+		if int64(len(users)) >= limit {
+			break
+		}
+		if user.InboundAlias == "" {
+			users = append(users, *copyUser(user))
+		}
+	}
+	return users, nil
+}
+
+// SetInboundAlias replaces the local part of a user's forwarding address
+func (r *UserRepository) SetInboundAlias(ctx context.Context, id string, alias string) error {
+	return r.modify(id, func(u *models.User) {
+		u.InboundAlias = alias
+	})
+}
+
+// SetMarketingStatus records a mailing list status unless a later one is stored
+func (r *UserRepository) SetMarketingStatus(ctx context.Context, id string, status string, reason string, at time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[id]
+	if !ok || (user.MarketingStatusChangedAt != nil && user.MarketingStatusChangedAt.After(at)) {
+		return false, nil
+	}
+	user.MarketingStatus = status
+	user.MarketingStatusReason = reason
+	user.MarketingStatusChangedAt = &at
+	user.UpdatedAt = time.Now()
+	return true, nil
+}
+
+// modify applies fn to the stored user under the write lock. Like the
+// MongoDB model, updating a missing user is not an error.
+func (r *UserRepository) modify(id string, fn func(u *models.User)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[id]
+	if !ok {
+		return nil
+	}
+	fn(user)
+	user.UpdatedAt = time.Now()
+	return nil
+}
+
+// copyUser returns a deep copy so callers never share memory with the store
+func copyUser(user *models.User) *models.User {
+	c := *user
+	c.EmailVerifiedAt = copyTime(user.EmailVerifiedAt)
+	c.SuspendedAt = copyTime(user.SuspendedAt)
+	c.MarketingStatusChangedAt = copyTime(user.MarketingStatusChangedAt)
+	c.RecoveryCodes = append([]string(nil), user.RecoveryCodes...)
+	c.AliasEmails = append([]string(nil), user.AliasEmails...)
+	return &c
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	return &c
+}
+
+// paginate returns the page of items starting at skip. A limit of zero or
+// less means no limit.
+func paginate[T any](items []T, skip int64, limit int64) []T {
+	if skip >= int64(len(items)) {
+		return []T{}
+	}
+	items = items[skip:]
+	if limit > 0 && limit < int64(len(items)) {
+		items = items[:limit]
+	}
+	return items
+}
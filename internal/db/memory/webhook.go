@@ -0,0 +1,46 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"loyaltea-server/internal/services"
+)
+
+var _ services.WebhookNonceRepository = (*WebhookNonceRepository)(nil)
+
+// WebhookNonceRepository stores webhook nonces in memory
+type WebhookNonceRepository struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time
+}
+
+// NewWebhookNonceRepository creates an empty WebhookNonceRepository
+func NewWebhookNonceRepository() *WebhookNonceRepository {
+	return &WebhookNonceRepository{
+		nonces: make(map[string]time.Time),
+	}
+}
+
+// Use records a nonce and reports whether it was new. Expired nonces are
+// forgotten, like the TTL index does in MongoDB.
+func (r *WebhookNonceRepository) Use(ctx context.Context, provider string, nonce string, expiresAt time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := provider + ":" + nonce
+	if expires, ok := r.nonces[key]; ok && time.Now().Before(expires) {
+		return false, nil
+	}
+	r.nonces[key] = expiresAt
+	return true, nil
+}
+
+// Release forgets a nonce
+func (r *WebhookNonceRepository) Release(ctx context.Context, provider string, nonce string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.nonces, provider+":"+nonce)
+	return nil
+}
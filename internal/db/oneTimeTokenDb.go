@@ -0,0 +1,63 @@
+package db
+
+import (
+	"context"
+	"loyaltea-server/internal/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// OneTimeTokenModel handles database operations for single-use email tokens
+type OneTimeTokenModel struct {
+	collection *mongo.Collection
+}
+
+// NewOneTimeTokenModel creates a new OneTimeTokenModel instance
+func NewOneTimeTokenModel(db *mongo.Database) *OneTimeTokenModel {
+	return &OneTimeTokenModel{
+		collection: db.Collection("one_time_tokens"),
+	}
+}
+
+// Create stores a new token
+func (m *OneTimeTokenModel) Create(ctx context.Context, token *models.OneTimeToken) error {
+	token.CreatedAt = time.Now()
+	_, err := m.collection.InsertOne(ctx, token)
+	return err
+}
+
+// Consume atomically marks an unused, unexpired token as used and returns it.
+// It returns nil if no such token exists.
+func (m *OneTimeTokenModel) Consume(ctx context.Context, purpose string, hash string) (*models.OneTimeToken, error) {
+	now := time.Now()
+	var token models.OneTimeToken
+	err := m.collection.FindOneAndUpdate(
+		ctx,
+		bson.M{
+			"token_hash": hash,
+			"purpose":    purpose,
+			"used_at":    nil,
+			"expires_at": bson.M{"$gt": now},
+		},
+		bson.M{"$set": bson.M{"used_at": now}},
+	).Decode(&token)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &token, nil
+}
+
+// InvalidateForUser marks every outstanding token of a purpose as used
+func (m *OneTimeTokenModel) InvalidateForUser(ctx context.Context, userID string, purpose string) error {
+	_, err := m.collection.UpdateMany(
+		ctx,
+		bson.M{"user_id": userID, "purpose": purpose, "used_at": nil},
+		bson.M{"$set": bson.M{"used_at": time.Now()}},
+	)
+	return err
+}
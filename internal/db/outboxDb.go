@@ -0,0 +1,201 @@
+package db
+
+import (
+	"context"
+	"loyaltea-server/internal/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// OutboxModel handles database operations for the outbox of background calls
+type OutboxModel struct {
+	collection *mongo.Collection
+}
+
+// NewOutboxModel creates a new OutboxModel instance
+func NewOutboxModel(db *mongo.Database) *OutboxModel {
+	return &OutboxModel{
+		collection: db.Collection("outbox"),
+	}
+}
+
+// outboxRetention is how long delivered items are kept
+const outboxRetention = 30 * 24 * time.Hour
+
+// EnsureIndexes creates the index workers find due items with, the unique
+// index that keeps one pending item of each kind per address and the TTL
+// index that removes delivered items after outboxRetention
+func (m *OutboxModel) EnsureIndexes(ctx context.Context) error {
+	_, err := m.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "kind", Value: 1}, {Key: "email", Value: 1}},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"status": models.OutboxPending}),
+		},
+		{
+			Keys:    bson.D{{Key: "delivered_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(outboxRetention.Seconds())),
+		},
+	})
+	return err
+}
+
+// Enqueue stores a new pending item and reports whether it did. Nothing is
+// stored if an item of the same kind for the same address is already pending.
+func (m *OutboxModel) Enqueue(ctx context.Context, item *models.OutboxItem) (bool, error) {
+	if item.ID == "" {
+		item.ID = bson.NewObjectID().Hex()
+	}
+	now := time.Now()
+	item.Status = models.OutboxPending
+	item.CreatedAt = now
+	item.UpdatedAt = now
+	if item.NextAttemptAt.IsZero() {
+		item.NextAttemptAt = now
+	}
+	_, err := m.collection.InsertOne(ctx, item)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// ClaimDue atomically locks the pending item that has been due longest for
+// lease, so other workers skip it. It returns nil if nothing is due.
+func (m *OutboxModel) ClaimDue(ctx context.Context, now time.Time, lease time.Duration) (*models.OutboxItem, error) {
+	filter := bson.M{
+		"status":          models.OutboxPending,
+		"next_attempt_at": bson.M{"$lte": now},
+		"$or": bson.A{
+			bson.M{"locked_until": nil},
+			bson.M{"locked_until": bson.M{"$lte": now}},
+		},
+	}
+	update := bson.M{"$set": bson.M{"locked_until": now.Add(lease)}}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var item models.OutboxItem
+	err := m.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&item)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &item, nil
+}
+
+// MarkDelivered records that an item was delivered
+func (m *OutboxModel) MarkDelivered(ctx context.Context, id string) error {
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"status":       models.OutboxDelivered,
+			"delivered_at": now,
+			"updated_at":   now,
+		},
+		"$inc":   bson.M{"attempts": 1},
+		"$unset": bson.M{"locked_until": "", "last_error": ""},
+	}
+	_, err := m.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+// MarkFailed records a failed attempt. The item is retried at nextAttemptAt,
+// or never again if dead.
+func (m *OutboxModel) MarkFailed(ctx context.Context, id string, lastError string, nextAttemptAt time.Time, dead bool) error {
+	status := models.OutboxPending
+	if dead {
+		status = models.OutboxDead
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"status":          status,
+			"last_error":      lastError,
+			"next_attempt_at": nextAttemptAt,
+			"updated_at":      time.Now(),
+		},
+		"$inc":   bson.M{"attempts": 1},
+		"$unset": bson.M{"locked_until": ""},
+	}
+	_, err := m.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+// Retry makes a dead item pending again, due now and with no attempts. It
+// reports whether there was such an item. If an item of the same kind for the
+// same address is already pending, the dead one is removed instead.
+func (m *OutboxModel) Retry(ctx context.Context, id string) (bool, error) {
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"status":          models.OutboxPending,
+			"attempts":        0,
+			"next_attempt_at": now,
+			"updated_at":      now,
+		},
+		"$unset": bson.M{"locked_until": ""},
+	}
+	filter := bson.M{"_id": id, "status": models.OutboxDead}
+	result, err := m.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			deleted, err := m.collection.DeleteOne(ctx, filter)
+			if err != nil {
+				return false, err
+			}
+			return deleted.DeletedCount == 1, nil
+		}
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
+// List returns a page of items, oldest first, along with the total number of matches
+func (m *OutboxModel) List(ctx context.Context, filter models.OutboxFilter, skip int64, limit int64) ([]models.OutboxItem, int64, error) {
+	query := bson.M{}
+	if filter.Status != "" {
+		query["status"] = filter.Status
+	} else {
+		query["$or"] = bson.A{
+			bson.M{"status": models.OutboxDead},
+			bson.M{"status": models.OutboxPending, "attempts": bson.M{"$gt": 0}},
+		}
+	}
+	if filter.Kind != "" {
+		query["kind"] = filter.Kind
+	}
+
+	total, err := m.collection.CountDocuments(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: 1}}).
+		SetSkip(skip).
+		SetLimit(limit)
+
+	cursor, err := m.collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	items := []models.OutboxItem{}
+	if err := cursor.All(ctx, &items); err != nil {
+		return nil, 0, err
+	}
+	return items, total, nil
+}
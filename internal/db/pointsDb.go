@@ -0,0 +1,163 @@
+package db
+
+import (
+	"context"
+	"loyaltea-server/internal/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// PointsModel handles database operations for the points ledger
+type PointsModel struct {
+	collection *mongo.Collection
+}
+
+// NewPointsModel creates a new PointsModel instance
+func NewPointsModel(db *mongo.Database) *PointsModel {
+	return &PointsModel{
+		collection: db.Collection("points_ledger"),
+	}
+}
+
+// EnsureIndexes creates the unique index on each user's entry numbers that
+// appending relies on, and the ones that credit each purchase and make each
+// redemption only once
+func (m *PointsModel) EnsureIndexes(ctx context.Context) error {
+	_, err := m.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "seq", Value: -1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "reason", Value: 1}, {Key: "reference", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{
+				"reason":    models.PointsReasonPurchase,
+				"reference": bson.M{"$exists": true},
+			}),
+		},
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "reference", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{
+				"reason":    models.PointsReasonRedemption,
+				"reference": bson.M{"$exists": true},
+			}),
+		},
+	})
+	return err
+}
+
+// Latest returns a user's most recent entry, or nil if there are none
+func (m *PointsModel) Latest(ctx context.Context, userID string) (*models.PointsEntry, error) {
+	opts := options.FindOne().SetSort(bson.D{{Key: "seq", Value: -1}})
+
+	var entry models.PointsEntry
+	err := m.collection.FindOne(ctx, bson.M{"user_id": userID}, opts).Decode(&entry)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// Append stores an entry unless the user already has one with its Seq, and
+// reports whether it did
+func (m *PointsModel) Append(ctx context.Context, entry *models.PointsEntry) (bool, error) {
+	if entry.ID == "" {
+		entry.ID = bson.NewObjectID().Hex()
+	}
+	entry.CreatedAt = time.Now()
+	if entry.OccurredAt.IsZero() {
+		entry.OccurredAt = entry.CreatedAt
+	}
+
+	_, err := m.collection.InsertOne(ctx, entry)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// History returns a page of a user's entries, newest first, along with the total number of entries
+func (m *PointsModel) History(ctx context.Context, userID string, skip int64, limit int64) ([]models.PointsEntry, int64, error) {
+	query := bson.M{"user_id": userID}
+
+	total, err := m.collection.CountDocuments(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "seq", Value: -1}}).
+		SetSkip(skip).
+		SetLimit(limit)
+
+	cursor, err := m.collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	entries := []models.PointsEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, 0, err
+	}
+	return entries, total, nil
+}
+
+// FindByReference returns a user's entry for a reason and reference, or nil
+// if there is none
+func (m *PointsModel) FindByReference(ctx context.Context, userID string, reason string, reference string) (*models.PointsEntry, error) {
+	var entry models.PointsEntry
+	err := m.collection.FindOne(ctx, bson.M{"user_id": userID, "reason": reason, "reference": reference}).Decode(&entry)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// Earned returns the points a user has been credited for a reason for what
+// occurred at or after from and, unless to is zero, before to. Entries
+// stored before occurred_at was recorded are placed by created_at.
+func (m *PointsModel) Earned(ctx context.Context, userID string, reason string, from time.Time, to time.Time) (int64, error) {
+	window := bson.M{"$gte": from}
+	if !to.IsZero() {
+		window["$lt"] = to
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"user_id": userID,
+			"type":    models.PointsCredit,
+			"reason":  reason,
+			"$or": bson.A{
+				bson.M{"occurred_at": window},
+				bson.M{"occurred_at": bson.M{"$exists": false}, "created_at": window},
+			},
+		}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "total": bson.M{"$sum": "$points"}}}},
+	}
+
+	cursor, err := m.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+	var results []struct {
+		Total int64 `bson:"total"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return 0, err
+	}
+	if len(results) == 0 {
+		return 0, nil
+	}
+	return results[0].Total, nil
+}
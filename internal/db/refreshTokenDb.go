@@ -0,0 +1,77 @@
+package db
+
+import (
+	"context"
+	"loyaltea-server/internal/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// RefreshTokenModel handles database operations for refresh tokens
+type RefreshTokenModel struct {
+	collection *mongo.Collection
+}
+
+// NewRefreshTokenModel creates a new RefreshTokenModel instance
+func NewRefreshTokenModel(db *mongo.Database) *RefreshTokenModel {
+	return &RefreshTokenModel{
+		collection: db.Collection("refresh_tokens"),
+	}
+}
+
+// Create stores a new refresh token
+func (m *RefreshTokenModel) Create(ctx context.Context, token *models.RefreshToken) error {
+	token.CreatedAt = time.Now()
+	_, err := m.collection.InsertOne(ctx, token)
+	return err
+}
+
+// FindByHash finds a refresh token by the hash of its value
+func (m *RefreshTokenModel) FindByHash(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	err := m.collection.FindOne(ctx, bson.M{"token_hash": hash}).Decode(&token)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &token, nil
+}
+
+// MarkUsed records that a token has been rotated into replacedBy. It only
+// succeeds for tokens that are still unused and unrevoked, so two concurrent
+// refreshes with the same token cannot both win; the loser gets false.
+func (m *RefreshTokenModel) MarkUsed(ctx context.Context, id string, replacedBy string) (bool, error) {
+	result, err := m.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": id, "used_at": nil, "revoked_at": nil},
+		bson.M{"$set": bson.M{"used_at": time.Now(), "replaced_by": replacedBy}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
+// RevokeFamily revokes every token belonging to a session
+func (m *RefreshTokenModel) RevokeFamily(ctx context.Context, familyID string) error {
+	_, err := m.collection.UpdateMany(
+		ctx,
+		bson.M{"family_id": familyID, "revoked_at": nil},
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+	)
+	return err
+}
+
+// RevokeAllForUser revokes every token belonging to a user
+func (m *RefreshTokenModel) RevokeAllForUser(ctx context.Context, userID string) error {
+	_, err := m.collection.UpdateMany(
+		ctx,
+		bson.M{"user_id": userID, "revoked_at": nil},
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+	)
+	return err
+}
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"context"
+	"loyaltea-server/internal/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// SecurityEventModel handles database operations for security events
+type SecurityEventModel struct {
+	collection *mongo.Collection
+}
+
+// NewSecurityEventModel creates a new SecurityEventModel instance
+func NewSecurityEventModel(db *mongo.Database) *SecurityEventModel {
+	return &SecurityEventModel{
+		collection: db.Collection("security_events"),
+	}
+}
+
+// Create records a new event
+func (m *SecurityEventModel) Create(ctx context.Context, event *models.SecurityEvent) error {
+	event.CreatedAt = time.Now()
+	_, err := m.collection.InsertOne(ctx, event)
+	return err
+}
+
+// List returns a page of events, newest first, along with the total number of matches
+func (m *SecurityEventModel) List(ctx context.Context, filter models.SecurityEventFilter, skip int64, limit int64) ([]models.SecurityEvent, int64, error) {
+	query := bson.M{}
+	if filter.Type != "" {
+		query["type"] = filter.Type
+	}
+	if filter.Email != "" {
+		query["email"] = filter.Email
+	}
+	if filter.IP != "" {
+		query["ip"] = filter.IP
+	}
+
+	total, err := m.collection.CountDocuments(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(skip).
+		SetLimit(limit)
+
+	cursor, err := m.collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	events := []models.SecurityEvent{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, 0, err
+	}
+	return events, total, nil
+}
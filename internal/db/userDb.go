@@ -4,10 +4,12 @@ import (
 	"context"
 	"fmt"
 	"loyaltea-server/internal/models"
+	"regexp"
 	"time"
 
-	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -23,6 +25,17 @@ func NewUserModel(db *mongo.Database) *UserModel {
 	}
 }
 
+// EnsureIndexes creates the unique index on forwarding address aliases.
+// It is sparse, as users created before aliases existed have none until
+// they are backfilled.
+func (m *UserModel) EnsureIndexes(ctx context.Context) error {
+	_, err := m.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "inbound_alias", Value: 1}},
+		Options: options.Index().SetUnique(true).SetSparse(true),
+	})
+	return err
+}
+
 // Create creates a new user
 func (m *UserModel) Create(ctx context.Context, user *models.User) error {
 	// Hash the password
@@ -60,6 +73,24 @@ func (m *UserModel) FindByEmail(ctx context.Context, email string) (*models.User
 	return &user, nil
 }
 
+// FindByAddress finds the user owning an address, either as their email or
+// as one of their alias emails
+func (m *UserModel) FindByAddress(ctx context.Context, email string) (*models.User, error) {
+	var user models.User
+	filter := bson.M{"$or": bson.A{
+		bson.M{"email": email},
+		bson.M{"alias_emails": email},
+	}}
+	err := m.collection.FindOne(ctx, filter).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
 // FindByID finds a user by ID
 func (m *UserModel) FindByID(ctx context.Context, id string) (*models.User, error) {
 	var user models.User
@@ -79,9 +110,11 @@ func (m *UserModel) Update(ctx context.Context, user *models.User) error {
 
 	update := bson.M{
 		"$set": bson.M{
-			"name":       user.Name,
-			"email":      user.Email,
-			"updated_at": user.UpdatedAt,
+			"name":              user.Name,
+			"email":             user.Email,
+			"email_verified":    user.EmailVerified,
+			"email_verified_at": user.EmailVerifiedAt,
+			"updated_at":        user.UpdatedAt,
 		},
 	}
 
@@ -93,6 +126,294 @@ func (m *UserModel) Update(ctx context.Context, user *models.User) error {
 	return err
 }
 
+// MarkEmailVerified marks a user's email as verified, provided it is still
+// the given address. It returns false if the user or address did not match.
+func (m *UserModel) MarkEmailVerified(ctx context.Context, id string, email string) (bool, error) {
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"email_verified":    true,
+			"email_verified_at": now,
+			"updated_at":        now,
+		},
+	}
+
+	result, err := m.collection.UpdateOne(ctx, bson.M{"_id": id, "email": email}, update)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
+// AddAliasEmail adds a verified secondary address to a user
+func (m *UserModel) AddAliasEmail(ctx context.Context, id string, email string) error {
+	update := bson.M{
+		"$addToSet": bson.M{"alias_emails": email},
+		"$set":      bson.M{"updated_at": time.Now()},
+	}
+
+	_, err := m.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+// RemoveAliasEmail removes a secondary address from a user
+func (m *UserModel) RemoveAliasEmail(ctx context.Context, id string, email string) error {
+	update := bson.M{
+		"$pull": bson.M{"alias_emails": email},
+		"$set":  bson.M{"updated_at": time.Now()},
+	}
+
+	_, err := m.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+// UpdatePassword hashes and stores a new password for a user
+func (m *UserModel) UpdatePassword(ctx context.Context, id string, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"password":   string(hashedPassword),
+			"updated_at": time.Now(),
+		},
+	}
+
+	_, err = m.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+// SetRole changes a user's role
+func (m *UserModel) SetRole(ctx context.Context, id string, role string) error {
+	update := bson.M{
+		"$set": bson.M{
+			"role":       role,
+			"updated_at": time.Now(),
+		},
+	}
+
+	_, err := m.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+// SetSuspended suspends or reinstates a user
+func (m *UserModel) SetSuspended(ctx context.Context, id string, suspended bool, reason string) error {
+	now := time.Now()
+	set := bson.M{
+		"suspended":  suspended,
+		"updated_at": now,
+	}
+	if suspended {
+		set["suspended_at"] = now
+		set["suspended_reason"] = reason
+	} else {
+		set["suspended_at"] = nil
+		set["suspended_reason"] = ""
+	}
+
+	_, err := m.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": set})
+	return err
+}
+
+// List returns a page of users, newest first, along with the total number of
+// matches. A non-empty query is matched case-insensitively against the email
+// and name.
+func (m *UserModel) List(ctx context.Context, query string, skip int64, limit int64) ([]models.User, int64, error) {
+	filter := bson.M{}
+	if query != "" {
+		pattern := bson.Regex{Pattern: regexp.QuoteMeta(query), Options: "i"}
+		filter["$or"] = bson.A{
+			bson.M{"email": pattern},
+			bson.M{"name": pattern},
+		}
+	}
+
+	total, err := m.collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(skip).
+		SetLimit(limit)
+
+	cursor, err := m.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	users := []models.User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+// SetPendingMFASecret stores a TOTP secret awaiting confirmation
+func (m *UserModel) SetPendingMFASecret(ctx context.Context, id string, secret string) error {
+	update := bson.M{
+		"$set": bson.M{
+			"mfa_pending_secret": secret,
+			"updated_at":         time.Now(),
+		},
+	}
+
+	_, err := m.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+// EnableMFA promotes the pending secret to the active one, records the step
+// of the confirmation code and replaces the recovery codes
+func (m *UserModel) EnableMFA(ctx context.Context, id string, secret string, step int64, recoveryCodeHashes []string) error {
+	update := bson.M{
+		"$set": bson.M{
+			"mfa_enabled":    true,
+			"mfa_secret":     secret,
+			"mfa_last_step":  step,
+			"recovery_codes": recoveryCodeHashes,
+			"updated_at":     time.Now(),
+		},
+		"$unset": bson.M{"mfa_pending_secret": ""},
+	}
+
+	_, err := m.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+// DisableMFA removes every second factor from a user
+func (m *UserModel) DisableMFA(ctx context.Context, id string) error {
+	update := bson.M{
+		"$set": bson.M{
+			"mfa_enabled": false,
+			"updated_at":  time.Now(),
+		},
+		"$unset": bson.M{
+			"mfa_secret":         "",
+			"mfa_pending_secret": "",
+			"mfa_last_step":      "",
+			"recovery_codes":     "",
+		},
+	}
+
+	_, err := m.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+// UseTOTPStep records that the code for step has been used. It returns false
+// if that step or a later one was already used.
+func (m *UserModel) UseTOTPStep(ctx context.Context, id string, step int64) (bool, error) {
+	filter := bson.M{
+		"_id": id,
+		"$or": bson.A{
+			bson.M{"mfa_last_step": bson.M{"$lt": step}},
+			bson.M{"mfa_last_step": bson.M{"$exists": false}},
+		},
+	}
+
+	result, err := m.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"mfa_last_step": step}})
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
+// ConsumeRecoveryCode removes a recovery code hash from a user. It returns
+// false if the user does not have that code.
+func (m *UserModel) ConsumeRecoveryCode(ctx context.Context, id string, hash string) (bool, error) {
+	result, err := m.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": id, "recovery_codes": hash},
+		bson.M{"$pull": bson.M{"recovery_codes": hash}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// SetRecoveryCodes replaces a user's recovery code hashes
+func (m *UserModel) SetRecoveryCodes(ctx context.Context, id string, hashes []string) error {
+	update := bson.M{
+		"$set": bson.M{
+			"recovery_codes": hashes,
+			"updated_at":     time.Now(),
+		},
+	}
+
+	_, err := m.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+// FindByInboundAlias finds the user whose forwarding address has the given local part
+func (m *UserModel) FindByInboundAlias(ctx context.Context, alias string) (*models.User, error) {
+	var user models.User
+	err := m.collection.FindOne(ctx, bson.M{"inbound_alias": alias}).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// FindWithoutInboundAlias returns up to limit users who have no inbound alias
+func (m *UserModel) FindWithoutInboundAlias(ctx context.Context, limit int64) ([]models.User, error) {
+	cursor, err := m.collection.Find(ctx, bson.M{"inbound_alias": bson.M{"$exists": false}}, options.Find().SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+
+	users := []models.User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// SetInboundAlias replaces the local part of a user's forwarding address
+func (m *UserModel) SetInboundAlias(ctx context.Context, id string, alias string) error {
+	update := bson.M{
+		"$set": bson.M{
+			"inbound_alias": alias,
+			"updated_at":    time.Now(),
+		},
+	}
+
+	_, err := m.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+// SetMarketingStatus records a mailing list status reported at the given
+// time. Reports older than the stored one are ignored, as providers do not
+// deliver webhooks in order. It returns whether the status was stored.
+func (m *UserModel) SetMarketingStatus(ctx context.Context, id string, status string, reason string, at time.Time) (bool, error) {
+	filter := bson.M{
+		"_id": id,
+		"$or": bson.A{
+			bson.M{"marketing_status_changed_at": nil},
+			bson.M{"marketing_status_changed_at": bson.M{"$lte": at}},
+		},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"marketing_status":            status,
+			"marketing_status_reason":     reason,
+			"marketing_status_changed_at": at,
+			"updated_at":                  time.Now(),
+		},
+	}
+
+	result, err := m.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
 // Delete deletes a user
 func (m *UserModel) Delete(ctx context.Context, id string) error {
 	_, err := m.collection.DeleteOne(ctx, bson.M{"_id": id})
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"context"
+	"loyaltea-server/internal/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// WebhookNonceModel handles database operations for webhook replay protection
+type WebhookNonceModel struct {
+	collection *mongo.Collection
+}
+
+// NewWebhookNonceModel creates a new WebhookNonceModel instance
+func NewWebhookNonceModel(db *mongo.Database) *WebhookNonceModel {
+	return &WebhookNonceModel{
+		collection: db.Collection("webhook_nonces"),
+	}
+}
+
+// EnsureIndexes creates the TTL index that removes nonces once they expire
+func (m *WebhookNonceModel) EnsureIndexes(ctx context.Context) error {
+	_, err := m.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+// Use records a nonce and reports whether it was new. The _id is the
+// provider and nonce, so a second insert fails with a duplicate key error.
+func (m *WebhookNonceModel) Use(ctx context.Context, provider string, nonce string, expiresAt time.Time) (bool, error) {
+	_, err := m.collection.InsertOne(ctx, models.WebhookNonce{
+		ID:        provider + ":" + nonce,
+		Provider:  provider,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Release forgets a nonce so that the request it came with is accepted again
+func (m *WebhookNonceModel) Release(ctx context.Context, provider string, nonce string) error {
+	_, err := m.collection.DeleteOne(ctx, bson.M{"_id": provider + ":" + nonce})
+	return err
+}
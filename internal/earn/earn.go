@@ -0,0 +1,243 @@
+// Package earn works out the points a purchase earns under a merchant's earn
+// rules, and explains which rules fired.
+package earn
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"loyaltea-server/internal/models"
+)
+
+// History is what is known about a user's earlier purchases
+type History struct {
+	FirstPurchase bool             // No earlier purchase has earned points
+	Earned        map[string]int64 // Points earned from purchases so far in the current day, week and month, by period
+}
+
+// Result is the points a purchase earns and the rules that contributed them
+type Result struct {
+	Points  int64                    `json:"points"`
+	Applied []models.RuleApplication `json:"applied"`
+}
+
+// Evaluate applies rules to a purchase. Inactive rules, rules for another
+// currency and rules outside their days, hours or validity are skipped.
+//
+// Per currency, per visit and category rules are added up, and the highest
+// multiplier applying scales that sum; multipliers do not stack. First
+// purchase bonuses are added after multiplying. Finally the most restrictive
+// cap limits the total to what is left of its period's allowance. Each rule's
+// points are rounded down.
+func Evaluate(rules []models.EarnRule, purchase models.Purchase, history History) Result {
+	var result Result
+	var multiplier *models.EarnRule
+	var bonuses, caps []models.EarnRule
+
+	for _, rule := range rules {
+		if !Applies(rule, purchase) {
+			continue
+		}
+
+		switch rule.Type {
+		case models.EarnRulePerCurrency:
+			points := floor(purchase.Amount * rule.Points)
+			result.add(rule, points, fmt.Sprintf("%s point(s) per unit on %s spent", number(rule.Points), money(purchase.Amount)))
+		case models.EarnRulePerVisit:
+			result.add(rule, floor(rule.Points), "points per visit")
+		case models.EarnRuleCategoryBonus:
+			spent := 0.0
+			for _, item := range purchase.Items {
+				if strings.EqualFold(item.Category, rule.Category) {
+					spent += item.Amount
+				}
+			}
+			if spent > 0 {
+				points := floor(spent * rule.Points)
+				result.add(rule, points, fmt.Sprintf("%s point(s) per unit on %s spent on %s", number(rule.Points), money(spent), rule.Category))
+			}
+		case models.EarnRuleMultiplier:
+			if multiplier == nil || rule.Multiplier > multiplier.Multiplier {
+				multiplier = &rule
+			}
+		case models.EarnRuleFirstPurchase:
+			bonuses = append(bonuses, rule)
+		case models.EarnRuleCap:
+			caps = append(caps, rule)
+		}
+	}
+
+	if multiplier != nil && result.Points > 0 {
+		extra := floor(float64(result.Points) * (multiplier.Multiplier - 1))
+		result.add(*multiplier, extra, fmt.Sprintf("%sx on %d points", number(multiplier.Multiplier), result.Points))
+	}
+
+	if history.FirstPurchase {
+		for _, rule := range bonuses {
+			result.add(rule, floor(rule.Points), "first purchase bonus")
+		}
+	}
+
+	var limit *models.EarnRule
+	remaining := int64(math.MaxInt64)
+	for _, rule := range caps {
+		left := max(rule.MaxPoints-history.Earned[rule.Period], 0)
+		if left < remaining {
+			limit, remaining = &rule, left
+		}
+	}
+	if limit != nil && result.Points > remaining {
+		detail := fmt.Sprintf("%d points per %s, %d already earned", limit.MaxPoints, limit.Period, history.Earned[limit.Period])
+		result.add(*limit, remaining-result.Points, detail)
+	}
+
+	return result
+}
+
+// add records that rule contributed points, unless it contributed nothing
+func (r *Result) add(rule models.EarnRule, points int64, detail string) {
+	if points == 0 {
+		return
+	}
+	r.Points += points
+	r.Applied = append(r.Applied, models.RuleApplication{
+		RuleID: rule.ID,
+		Name:   rule.Name,
+		Type:   rule.Type,
+		Points: points,
+		Detail: detail,
+	})
+}
+
+// Applies reports whether rule is active and applies to a purchase of its
+// currency, made when it is valid
+func Applies(rule models.EarnRule, purchase models.Purchase) bool {
+	if !rule.Active {
+		return false
+	}
+	if rule.Currency != "" && !strings.EqualFold(rule.Currency, purchase.Currency) {
+		return false
+	}
+
+	at := purchase.OccurredAt
+	if rule.ValidFrom != nil && at.Before(*rule.ValidFrom) {
+		return false
+	}
+	if rule.ValidUntil != nil && !at.Before(*rule.ValidUntil) {
+		return false
+	}
+
+	if len(rule.Days) > 0 {
+		found := false
+		for _, day := range rule.Days {
+			if time.Weekday(day) == at.Weekday() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	end := rule.EndHour
+	if end == 0 {
+		end = 24
+	}
+	return at.Hour() >= rule.StartHour && at.Hour() < end
+}
+
+// PeriodStart returns when the day, week or month containing at began, in
+// at's time zone. Weeks start on Monday.
+func PeriodStart(period string, at time.Time) time.Time {
+	day := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, at.Location())
+	switch period {
+	case models.EarnPeriodWeek:
+		return day.AddDate(0, 0, -(int(at.Weekday())+6)%7)
+	case models.EarnPeriodMonth:
+		return day.AddDate(0, 0, 1-at.Day())
+	}
+	return day
+}
+
+// PeriodEnd returns when the day, week or month containing at ends, which is
+// when the next one starts
+func PeriodEnd(period string, at time.Time) time.Time {
+	start := PeriodStart(period, at)
+	switch period {
+	case models.EarnPeriodWeek:
+		return start.AddDate(0, 0, 7)
+	case models.EarnPeriodMonth:
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// Validate checks that a rule has the fields its type needs
+func Validate(rule models.EarnRule) error {
+	if strings.TrimSpace(rule.Name) == "" {
+		return errors.New("name is required")
+	}
+
+	switch rule.Type {
+	case models.EarnRulePerCurrency, models.EarnRulePerVisit, models.EarnRuleFirstPurchase:
+		if rule.Points <= 0 {
+			return errors.New("points must be positive")
+		}
+	case models.EarnRuleCategoryBonus:
+		if rule.Points <= 0 {
+			return errors.New("points must be positive")
+		}
+		if strings.TrimSpace(rule.Category) == "" {
+			return errors.New("category is required")
+		}
+	case models.EarnRuleMultiplier:
+		if rule.Multiplier <= 1 {
+			return errors.New("multiplier must be greater than 1")
+		}
+	case models.EarnRuleCap:
+		if rule.MaxPoints <= 0 {
+			return errors.New("max_points must be positive")
+		}
+		switch rule.Period {
+		case models.EarnPeriodDay, models.EarnPeriodWeek, models.EarnPeriodMonth:
+		default:
+			return errors.New("period must be day, week or month")
+		}
+	default:
+		return fmt.Errorf("unknown rule type %q", rule.Type)
+	}
+
+	for _, day := range rule.Days {
+		if day < 0 || day > 6 {
+			return errors.New("days must be between 0 (Sunday) and 6 (Saturday)")
+		}
+	}
+	if rule.StartHour < 0 || rule.StartHour > 23 || rule.EndHour < 0 || rule.EndHour > 24 {
+		return errors.New("start_hour must be between 0 and 23 and end_hour between 0 and 24")
+	}
+	if rule.EndHour != 0 && rule.EndHour <= rule.StartHour {
+		return errors.New("end_hour must be after start_hour")
+	}
+	if rule.ValidFrom != nil && rule.ValidUntil != nil && !rule.ValidUntil.After(*rule.ValidFrom) {
+		return errors.New("valid_until must be after valid_from")
+	}
+	return nil
+}
+
+// floor rounds points down, ignoring the float error in sums such as
+// 0.1 * 30
+func floor(points float64) int64 {
+	return int64(math.Floor(points + 1e-9))
+}
+
+func number(f float64) string {
+	return fmt.Sprintf("%g", f)
+}
+
+func money(f float64) string {
+	return fmt.Sprintf("%.2f", f)
+}
@@ -0,0 +1,146 @@
+// Package fingerprint identifies emails with the same content. An exact hash
+// catches identical forwards and a simhash catches near duplicates, such as
+// the same newsletter with a different greeting or tracking links.
+package fingerprint
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"math/bits"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Bands is how many parts a simhash is split into for lookup. Two hashes
+// differing in fewer than Bands bits always share a band, and hashes a few
+// bits further apart usually do.
+const Bands = 4
+
+// MinSimilarity is how alike two emails sharing a band must be, as measured
+// by Similarity, to be near duplicates
+const MinSimilarity = 0.8
+
+// shingleSize is how many consecutive words Similarity compares at a time
+const shingleSize = 2
+
+// Fingerprint is the content identity of an email
+type Fingerprint struct {
+	Hash    string   // SHA-256 of the normalized content
+	Simhash uint64   // Locality sensitive hash of the normalized content
+	Bands   []string // Simhash split into Bands keys for lookup
+}
+
+var (
+	subjectPrefix   = regexp.MustCompile(`(?i)^\s*((re|fwd?|fw|tr|wg)\s*:\s*)+`)
+	forwardMarker   = regexp.MustCompile(`(?im)^[\s>]*(-+\s*(forwarded|original)\s+message\s*-+|begin\s+forwarded\s+message:?)\s*$`)
+	forwardedHeader = regexp.MustCompile(`(?im)^[\s>]*(from|to|cc|sent|date|subject|reply-to)\s*:.*$`)
+	quoteMarker     = regexp.MustCompile(`(?m)^[ \t]*(>[ \t]?)+`)
+	link            = regexp.MustCompile(`(?i)\bhttps?://\S+`)
+	emailAddress    = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+)
+
+// Of returns the fingerprint of an email
+func Of(subject string, body string) Fingerprint {
+	words := Normalize(subject, body)
+
+	sum := sha256.Sum256([]byte(strings.Join(words, " ")))
+	simhash := Simhash(words)
+	return Fingerprint{
+		Hash:    hex.EncodeToString(sum[:]),
+		Simhash: simhash,
+		Bands:   BandKeys(simhash),
+	}
+}
+
+// Normalize reduces an email to the words that identify its content. Forward
+// prefixes, forwarded headers, quote markers, links and addresses are removed
+// as they differ between forwards of the same email.
+func Normalize(subject string, body string) []string {
+	subject = subjectPrefix.ReplaceAllString(subject, "")
+	body = forwardMarker.ReplaceAllString(body, "")
+	body = forwardedHeader.ReplaceAllString(body, "")
+	body = quoteMarker.ReplaceAllString(body, "")
+	body = link.ReplaceAllString(body, " ")
+	body = emailAddress.ReplaceAllString(body, " ")
+
+	return strings.FieldsFunc(strings.ToLower(subject+"\n"+body), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Simhash hashes words so that similar texts get hashes differing in few
+// bits. Each word is a feature, weighted by how often it occurs.
+func Simhash(words []string) uint64 {
+	var weights [64]int
+	for _, word := range words {
+		h := fnv.New64a()
+		h.Write([]byte(word))
+		feature := h.Sum64()
+		for bit := 0; bit < 64; bit++ {
+			if feature&(1<<bit) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+
+	var simhash uint64
+	for bit, weight := range weights {
+		if weight > 0 {
+			simhash |= 1 << bit
+		}
+	}
+	return simhash
+}
+
+// Similarity returns the Jaccard similarity of the word pairs of two
+// normalized texts, from 0 for nothing in common to 1 for the same text
+func Similarity(a []string, b []string) float64 {
+	sa, sb := shingles(a), shingles(b)
+	if len(sa) == 0 && len(sb) == 0 {
+		return 1
+	}
+	shared := 0
+	for s := range sa {
+		if sb[s] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(sa)+len(sb)-shared)
+}
+
+// shingles returns the set of runs of shingleSize consecutive words
+func shingles(words []string) map[string]bool {
+	set := map[string]bool{}
+	if len(words) < shingleSize {
+		if len(words) > 0 {
+			set[strings.Join(words, " ")] = true
+		}
+		return set
+	}
+	for i := 0; i+shingleSize <= len(words); i++ {
+		set[strings.Join(words[i:i+shingleSize], " ")] = true
+	}
+	return set
+}
+
+// Distance returns how many bits two simhashes differ by
+func Distance(a uint64, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// BandKeys splits a simhash into Bands lookup keys, each naming its position
+// so equal bits in different bands do not match
+func BandKeys(simhash uint64) []string {
+	width := 64 / Bands
+	keys := make([]string, Bands)
+	for i := range keys {
+		band := (simhash >> (i * width)) & (1<<width - 1)
+		keys[i] = fmt.Sprintf("%d:%04x", i, band)
+	}
+	return keys
+}
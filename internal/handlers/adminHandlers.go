@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"loyaltea-server/internal/middleware"
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AdminHandler struct {
+	adminService *services.AdminService
+}
+
+func NewAdminHandler(adminService *services.AdminService) *AdminHandler {
+	return &AdminHandler{
+		adminService: adminService,
+	}
+}
+
+type SetRoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+type SuspendRequest struct {
+	Reason string `json:"reason" binding:"required"`
+}
+
+// ListUsers handles listing all users page by page
+func (h *AdminHandler) ListUsers(c *gin.Context) {
+	h.listUsers(c, "")
+}
+
+// SearchUsers handles searching users by email or name
+func (h *AdminHandler) SearchUsers(c *gin.Context) {
+	query := c.Query("q")
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing search query"})
+		return
+	}
+	h.listUsers(c, query)
+}
+
+func (h *AdminHandler) listUsers(c *gin.Context, query string) {
+	page := queryInt(c, "page", 1)
+	limit := queryInt(c, "limit", 20)
+
+	result, err := h.adminService.ListUsers(c.Request.Context(), query, page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	users := make([]gin.H, 0, len(result.Users))
+	for i := range result.Users {
+		users = append(users, adminUserJSON(&result.Users[i]))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users": users,
+		"total": result.Total,
+		"page":  result.Page,
+		"limit": result.Limit,
+	})
+}
+
+// SetRole handles assigning a role to a user
+func (h *AdminHandler) SetRole(c *gin.Context) {
+	claims, _ := middleware.GetClaims(c)
+
+	var req SetRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.adminService.SetRole(c.Request.Context(), claims.UserID, c.Param("id"), req.Role)
+	if err != nil {
+		writeAdminError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Role updated successfully",
+		"user":    adminUserJSON(user),
+	})
+}
+
+// SuspendUser handles suspending a user account
+func (h *AdminHandler) SuspendUser(c *gin.Context) {
+	claims, _ := middleware.GetClaims(c)
+
+	var req SuspendRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.adminService.SuspendUser(c.Request.Context(), claims.UserID, c.Param("id"), req.Reason)
+	if err != nil {
+		writeAdminError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "User suspended successfully",
+		"user":    adminUserJSON(user),
+	})
+}
+
+// UnsuspendUser handles lifting a suspension
+func (h *AdminHandler) UnsuspendUser(c *gin.Context) {
+	user, err := h.adminService.UnsuspendUser(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		writeAdminError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "User unsuspended successfully",
+		"user":    adminUserJSON(user),
+	})
+}
+
+// writeAdminError maps admin service errors to responses
+func writeAdminError(c *gin.Context, err error) {
+	switch err {
+	case services.ErrUserNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	case services.ErrInvalidRole:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+	case services.ErrCannotEditSelf:
+		c.JSON(http.StatusForbidden, gin.H{"error": "You cannot change your own account"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	}
+}
+
+// adminUserJSON is the representation of a user shown to admins
+func adminUserJSON(user *models.User) gin.H {
+	data := userJSON(user)
+	data["suspended"] = user.Suspended
+	data["suspended_at"] = user.SuspendedAt
+	data["suspended_reason"] = user.SuspendedReason
+	data["created_at"] = user.CreatedAt
+	return data
+}
+
+// queryInt reads an integer query parameter, falling back to def when it is
+// missing or malformed
+func queryInt(c *gin.Context, key string, def int64) int64 {
+	value, err := strconv.ParseInt(c.Query(key), 10, 64)
+	if err != nil {
+		return def
+	}
+	return value
+}
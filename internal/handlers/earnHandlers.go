@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type EarnHandler struct {
+	earnService *services.EarnService
+}
+
+func NewEarnHandler(earnService *services.EarnService) *EarnHandler {
+	return &EarnHandler{
+		earnService: earnService,
+	}
+}
+
+// EarnRuleRequest is an earn rule as sent by merchants. Rules are active
+// unless Active is false.
+type EarnRuleRequest struct {
+	Name       string     `json:"name" binding:"required"`
+	Type       string     `json:"type" binding:"required"`
+	Active     *bool      `json:"active"`
+	Points     float64    `json:"points"`
+	Multiplier float64    `json:"multiplier"`
+	Category   string     `json:"category"`
+	Currency   string     `json:"currency"`
+	MaxPoints  int64      `json:"max_points"`
+	Period     string     `json:"period"`
+	Days       []int      `json:"days"`
+	StartHour  int        `json:"start_hour"`
+	EndHour    int        `json:"end_hour"`
+	ValidFrom  *time.Time `json:"valid_from"`
+	ValidUntil *time.Time `json:"valid_until"`
+}
+
+func (r EarnRuleRequest) rule() *models.EarnRule {
+	return &models.EarnRule{
+		Name:       r.Name,
+		Type:       r.Type,
+		Active:     r.Active == nil || *r.Active,
+		Points:     r.Points,
+		Multiplier: r.Multiplier,
+		Category:   r.Category,
+		Currency:   r.Currency,
+		MaxPoints:  r.MaxPoints,
+		Period:     r.Period,
+		Days:       r.Days,
+		StartHour:  r.StartHour,
+		EndHour:    r.EndHour,
+		ValidFrom:  r.ValidFrom,
+		ValidUntil: r.ValidUntil,
+	}
+}
+
+// DryRunRequest is a purchase to evaluate and, optionally, unsaved rules to
+// evaluate it against instead of the stored ones
+type DryRunRequest struct {
+	Purchase models.Purchase   `json:"purchase"`
+	Rules    []EarnRuleRequest `json:"rules" binding:"omitempty,dive"`
+}
+
+// ListRules handles listing the earn rules
+func (h *EarnHandler) ListRules(c *gin.Context) {
+	rules, err := h.earnService.ListRules(c.Request.Context())
+	if err != nil {
+		writeEarnError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rules": rules})
+}
+
+// GetRule handles getting an earn rule
+func (h *EarnHandler) GetRule(c *gin.Context) {
+	rule, err := h.earnService.GetRule(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		writeEarnError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, rule)
+}
+
+// CreateRule handles creating an earn rule
+func (h *EarnHandler) CreateRule(c *gin.Context) {
+	var req EarnRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rule := req.rule()
+	if err := h.earnService.CreateRule(c.Request.Context(), rule); err != nil {
+		writeEarnError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, rule)
+}
+
+// UpdateRule handles replacing an earn rule
+func (h *EarnHandler) UpdateRule(c *gin.Context) {
+	var req EarnRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rule := req.rule()
+	rule.ID = c.Param("id")
+	if err := h.earnService.UpdateRule(c.Request.Context(), rule); err != nil {
+		writeEarnError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, rule)
+}
+
+// DeleteRule handles deleting an earn rule
+func (h *EarnHandler) DeleteRule(c *gin.Context) {
+	if err := h.earnService.DeleteRule(c.Request.Context(), c.Param("id")); err != nil {
+		writeEarnError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Earn rule deleted"})
+}
+
+// RecordPurchase handles a merchant reporting a purchase, crediting the points
+// it earns
+func (h *EarnHandler) RecordPurchase(c *gin.Context) {
+	var purchase models.Purchase
+	if err := c.ShouldBindJSON(&purchase); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.earnService.AwardPurchase(c.Request.Context(), purchase)
+	if err != nil {
+		writeEarnError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// DryRun handles evaluating rules against a purchase without crediting any
+// points, for merchants to test rules
+func (h *EarnHandler) DryRun(c *gin.Context) {
+	var req DryRunRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var rules []models.EarnRule
+	if req.Rules != nil {
+		rules = []models.EarnRule{}
+		for _, r := range req.Rules {
+			rules = append(rules, *r.rule())
+		}
+	}
+
+	result, err := h.earnService.DryRun(c.Request.Context(), req.Purchase, rules)
+	if err != nil {
+		writeEarnError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// writeEarnError maps earn service errors to responses
+func writeEarnError(c *gin.Context, err error) {
+	switch {
+	case err == services.ErrEarnRuleNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Earn rule not found"})
+	case errors.Is(err, services.ErrInvalidEarnRule):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid earn rule", "details": err.Error()})
+	case errors.Is(err, services.ErrInvalidPurchase):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid purchase", "details": err.Error()})
+	case err == services.ErrAlreadyEarned:
+		c.JSON(http.StatusConflict, gin.H{"error": "Points already earned for this purchase"})
+	default:
+		writePointsError(c, err)
+	}
+}
@@ -0,0 +1,56 @@
+package handlers_test
+
+import (
+	"net/http"
+	"testing"
+
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/services"
+)
+
+func TestMerchantRoutesRequireRole(t *testing.T) {
+	s := newTestServer(t, services.VerificationPolicy{})
+	customer := s.accessToken(t, s.createUser(t, "user@example.com", models.RoleCustomer))
+	staff := s.accessToken(t, s.createUser(t, "staff@example.com", models.RoleMerchantStaff))
+	admin := s.accessToken(t, s.createUser(t, "admin@example.com", models.RoleAdmin))
+
+	expectStatus(t, s.request(http.MethodGet, "/merchant/earn-rules", "", nil), http.StatusUnauthorized)
+	expectStatus(t, s.request(http.MethodGet, "/merchant/earn-rules", customer, nil), http.StatusForbidden)
+	expectStatus(t, s.request(http.MethodPost, "/merchant/purchases", customer, map[string]any{}), http.StatusForbidden)
+	expectStatus(t, s.request(http.MethodGet, "/merchant/earn-rules", staff, nil), http.StatusOK)
+	expectStatus(t, s.request(http.MethodGet, "/merchant/earn-rules", admin, nil), http.StatusOK)
+
+	// Staff may run the loyalty programme but not manage users
+	expectStatus(t, s.request(http.MethodGet, "/admin/users", staff, nil), http.StatusForbidden)
+}
+
+func TestRecordPurchase(t *testing.T) {
+	s := newTestServer(t, services.VerificationPolicy{})
+	user := s.createUser(t, "user@example.com", models.RoleCustomer)
+	staff := s.accessToken(t, s.createUser(t, "staff@example.com", models.RoleMerchantStaff))
+
+	w := s.request(http.MethodPost, "/merchant/earn-rules", staff, map[string]any{"name": "Broken", "type": "lottery"})
+	expectStatus(t, w, http.StatusBadRequest)
+	w = s.request(http.MethodPost, "/merchant/earn-rules", staff, map[string]any{"name": "Base", "type": models.EarnRulePerCurrency, "points": 2})
+	expectStatus(t, w, http.StatusCreated)
+	ruleID, _ := decode(t, w)["id"].(string)
+	expectStatus(t, s.request(http.MethodGet, "/merchant/earn-rules/"+ruleID, staff, nil), http.StatusOK)
+
+	purchase := map[string]any{"id": "order-1", "user_id": user.ID, "amount": 25, "currency": "usd"}
+	w = s.request(http.MethodPost, "/merchant/earn-rules/dry-run", staff, map[string]any{"purchase": purchase})
+	expectStatus(t, w, http.StatusOK)
+	if points := decode(t, w)["points"]; points != float64(50) {
+		t.Fatalf("dry run points = %v, want 50", points)
+	}
+
+	w = s.request(http.MethodPost, "/merchant/purchases", staff, purchase)
+	expectStatus(t, w, http.StatusOK)
+	if points := decode(t, w)["points"]; points != float64(50) {
+		t.Fatalf("points = %v, want 50", points)
+	}
+	w = s.request(http.MethodPost, "/merchant/purchases", staff, purchase)
+	expectStatus(t, w, http.StatusConflict)
+
+	expectStatus(t, s.request(http.MethodDelete, "/merchant/earn-rules/"+ruleID, staff, nil), http.StatusOK)
+	expectStatus(t, s.request(http.MethodGet, "/merchant/earn-rules/"+ruleID, staff, nil), http.StatusNotFound)
+}
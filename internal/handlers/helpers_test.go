@@ -0,0 +1,264 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"loyaltea-server/internal/brands"
+	"loyaltea-server/internal/classify"
+	"loyaltea-server/internal/db/memory"
+	"loyaltea-server/internal/handlers"
+	"loyaltea-server/internal/mailer"
+	"loyaltea-server/internal/marketing"
+	"loyaltea-server/internal/middleware"
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/search"
+	"loyaltea-server/internal/services"
+	"loyaltea-server/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	testBaseURL       = "https://api.loyaltea.test"
+	testResetURL      = "https://app.loyaltea.test/reset-password"
+	testInboundDomain = "in.loyaltea.test"
+	testPassword      = "correct horse"
+	testMailgunKey    = "mailgun-signing-key"
+	testRawKey        = "raw-signing-key"
+	testMailchimpKey  = "mailchimp-secret"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// testServer is the router from main wired to the in-memory repositories,
+// with mail and mailing list calls recorded instead of sent
+type testServer struct {
+	router       *gin.Engine
+	users        *memory.UserRepository
+	offers       *memory.OfferRepository
+	mail         *mailer.Recorder
+	tokenService *services.TokenService
+	inbound      *services.InboundService
+}
+
+// newTestServer builds the routes as main does, under the given verification
+// policy
+func newTestServer(t *testing.T, policy services.VerificationPolicy) *testServer {
+	t.Helper()
+	users := memory.NewUserRepository()
+	offers := memory.NewOfferRepository()
+	oneTimeTokens := memory.NewOneTimeTokenRepository()
+	points := memory.NewPointsRepository()
+	mail := &mailer.Recorder{}
+
+	verificationService := services.NewVerificationService(users, offers, mail, testBaseURL, policy)
+	lockoutService := services.NewLockoutService(memory.NewLoginAttemptRepository(), memory.NewSecurityEventRepository(), users, oneTimeTokens, mail, testBaseURL, services.DefaultLockoutPolicy())
+	offerService := services.NewOfferService(offers, users, search.NewMemoryIndex(), brands.NewDetector(brands.DefaultCatalog()), classify.New(classify.DefaultRules()), policy)
+	inboundService := services.NewInboundService(users, offerService, testInboundDomain)
+	userService := services.NewUserService(users, verificationService, lockoutService)
+	tokenService := services.NewTokenService(memory.NewRefreshTokenRepository(), users, policy)
+	mfaService := services.NewMFAService(users, lockoutService, "Loyaltea")
+	pointsService := services.NewPointsService(points, users)
+	earnService := services.NewEarnService(memory.NewEarnRuleRepository(), points, pointsService, users)
+	passwordService := services.NewPasswordService(users, oneTimeTokens, tokenService, mail, testResetURL)
+	adminService := services.NewAdminService(users, tokenService)
+	webhookService := services.NewWebhookService(memory.NewWebhookNonceRepository(), services.WebhookConfig{
+		MailgunSigningKey: testMailgunKey,
+		MailchimpSecret:   testMailchimpKey,
+		RawSigningKey:     testRawKey,
+	})
+	subscriptionService := services.NewSubscriptionService(users)
+	outboxService := services.NewOutboxService(memory.NewOutboxRepository(), &marketing.Recorder{}, subscriptionService, services.DefaultOutboxPolicy())
+
+	userHandler := handlers.NewUserHandler(userService, tokenService, verificationService, inboundService)
+	lockoutHandler := handlers.NewLockoutHandler(lockoutService)
+	mfaHandler := handlers.NewMFAHandler(mfaService, tokenService)
+	pointsHandler := handlers.NewPointsHandler(pointsService)
+	earnHandler := handlers.NewEarnHandler(earnService)
+	passwordHandler := handlers.NewPasswordHandler(passwordService)
+	adminHandler := handlers.NewAdminHandler(adminService)
+	outboxHandler := handlers.NewOutboxHandler(outboxService)
+	offerHandler := handlers.NewOfferHandler(offerService, inboundService, webhookService, subscriptionService, outboxService)
+
+	router := gin.New()
+
+	userRoutes := router.Group("/user")
+	userRoutes.POST("/register", userHandler.Register)
+	userRoutes.POST("/login", userHandler.Login)
+	userRoutes.POST("/login/mfa", mfaHandler.Login)
+	userRoutes.POST("/token/refresh", userHandler.RefreshToken)
+	userRoutes.POST("/password/forgot", passwordHandler.ForgotPassword)
+	userRoutes.POST("/password/reset", passwordHandler.ResetPassword)
+	userRoutes.GET("/verify", userHandler.VerifyEmail)
+	userRoutes.POST("/unlock/request", lockoutHandler.RequestUnlock)
+	userRoutes.GET("/unlock", lockoutHandler.UnlockAccount)
+
+	authRoutes := userRoutes.Group("", middleware.RequireAuth())
+	authRoutes.POST("/logout", userHandler.Logout)
+	authRoutes.POST("/password/change", passwordHandler.ChangePassword)
+	authRoutes.POST("/verify/resend", userHandler.ResendVerification)
+	authRoutes.POST("/2fa/setup", mfaHandler.Setup)
+	authRoutes.POST("/2fa/confirm", mfaHandler.Confirm)
+	authRoutes.POST("/2fa/disable", mfaHandler.Disable)
+	authRoutes.POST("/2fa/recovery-codes", mfaHandler.RegenerateRecoveryCodes)
+	authRoutes.GET("/:id", userHandler.GetUser)
+	authRoutes.PUT("/:id", userHandler.UpdateUser)
+	authRoutes.DELETE("/:id", userHandler.DeleteUser)
+	authRoutes.POST("/:id/emails", userHandler.AddAliasEmail)
+	authRoutes.DELETE("/:id/emails/:email", userHandler.RemoveAliasEmail)
+	authRoutes.POST("/:id/forwarding-address/rotate", userHandler.RotateForwardingAddress)
+	authRoutes.GET("/:id/points", pointsHandler.GetBalance)
+	authRoutes.GET("/:id/points/history", pointsHandler.GetHistory)
+	authRoutes.POST("/:id/points/redeem", pointsHandler.Redeem)
+
+	adminRoutes := router.Group("/admin", middleware.RequireAuth(), middleware.RequireRoles(models.RoleAdmin))
+	adminRoutes.GET("/users", adminHandler.ListUsers)
+	adminRoutes.GET("/users/search", adminHandler.SearchUsers)
+	adminRoutes.PUT("/users/:id/role", adminHandler.SetRole)
+	adminRoutes.POST("/users/:id/suspend", adminHandler.SuspendUser)
+	adminRoutes.POST("/users/:id/unsuspend", adminHandler.UnsuspendUser)
+	adminRoutes.POST("/users/:id/points/adjust", pointsHandler.AdjustPoints)
+	adminRoutes.GET("/security-events", lockoutHandler.ListSecurityEvents)
+	adminRoutes.POST("/offers/reclassify", offerHandler.ReclassifyOffers)
+	adminRoutes.GET("/outbox", outboxHandler.ListOutbox)
+	adminRoutes.POST("/outbox/:id/retry", outboxHandler.RetryOutboxItem)
+
+	merchantRoutes := router.Group("/merchant", middleware.RequireAuth(), middleware.RequireRoles(models.RoleMerchantStaff, models.RoleAdmin))
+	merchantRoutes.GET("/earn-rules", earnHandler.ListRules)
+	merchantRoutes.POST("/earn-rules", earnHandler.CreateRule)
+	merchantRoutes.POST("/earn-rules/dry-run", earnHandler.DryRun)
+	merchantRoutes.GET("/earn-rules/:id", earnHandler.GetRule)
+	merchantRoutes.PUT("/earn-rules/:id", earnHandler.UpdateRule)
+	merchantRoutes.DELETE("/earn-rules/:id", earnHandler.DeleteRule)
+	merchantRoutes.POST("/purchases", earnHandler.RecordPurchase)
+
+	router.POST("/offer/mailchimp", offerHandler.ReceiveOffer)
+	router.GET("/offer/mailchimp", offerHandler.VerifyWebhook)
+	router.HEAD("/offer/mailchimp", offerHandler.VerifyWebhook)
+	router.POST("/offer/raw", offerHandler.ReceiveRawOffer)
+	router.POST("/offer/mailgun", offerHandler.ReceiveMailgunOffer)
+
+	offerRoutes := router.Group("/offers", middleware.RequireAuth())
+	offerRoutes.GET("", offerHandler.ListOffers)
+	offerRoutes.GET("/search", offerHandler.SearchOffers)
+	offerRoutes.GET("/:id", offerHandler.GetOffer)
+
+	return &testServer{
+		router:       router,
+		users:        users,
+		offers:       offers,
+		mail:         mail,
+		tokenService: tokenService,
+		inbound:      inboundService,
+	}
+}
+
+// createUser stores a verified user with testPassword, a forwarding address
+// and the given role
+func (s *testServer) createUser(t *testing.T, email string, role string) *models.User {
+	t.Helper()
+	ctx := context.Background()
+	alias, err := utils.GenerateInboundAlias()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{
+		ID:           bson.NewObjectID().Hex(),
+		Email:        email,
+		Password:     testPassword,
+		Name:         "Test User",
+		Role:         role,
+		InboundAlias: alias,
+	}
+	if err := s.users.Create(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.users.MarkEmailVerified(ctx, user.ID, email); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := s.users.FindByID(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stored
+}
+
+// offersFor returns the stored offers owned by a user
+func (s *testServer) offersFor(t *testing.T, userID string) []models.Offer {
+	t.Helper()
+	offers, err := s.offers.List(context.Background(), models.OfferQuery{OwnerID: userID, SortBy: models.OfferSortCreatedAt, Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return offers
+}
+
+// accessToken starts a session for a user and returns its access token
+func (s *testServer) accessToken(t *testing.T, user *models.User) string {
+	t.Helper()
+	tokens, err := s.tokenService.IssueTokens(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokens.AccessToken
+}
+
+// request sends a request to the router, with a Bearer token if token is
+// set, and returns the recorded response. A body that is not a []byte is
+// sent as JSON.
+func (s *testServer) request(method string, path string, token string, body any) *httptest.ResponseRecorder {
+	var reader io.Reader
+	contentType := ""
+	switch b := body.(type) {
+	case nil:
+	case []byte:
+		reader = bytes.NewReader(b)
+	default:
+		encoded, _ := json.Marshal(b)
+		reader = bytes.NewReader(encoded)
+		contentType = "application/json"
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return s.serve(req)
+}
+
+// serve records the router's response to req
+func (s *testServer) serve(req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+// decode returns the JSON object in a response body
+func decode(t *testing.T, w *httptest.ResponseRecorder) map[string]any {
+	t.Helper()
+	var body map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("response %q is not a JSON object: %v", w.Body.String(), err)
+	}
+	return body
+}
+
+// expectStatus fails the test if a response does not have the wanted status
+func expectStatus(t *testing.T, w *httptest.ResponseRecorder, want int) {
+	t.Helper()
+	if w.Code != want {
+		t.Fatalf("status = %d, want %d: %s", w.Code, want, w.Body.String())
+	}
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"net/http"
+
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type LockoutHandler struct {
+	lockoutService *services.LockoutService
+}
+
+func NewLockoutHandler(lockoutService *services.LockoutService) *LockoutHandler {
+	return &LockoutHandler{
+		lockoutService: lockoutService,
+	}
+}
+
+type UnlockRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// RequestUnlock handles asking for a new unlock email
+func (h *LockoutHandler) RequestUnlock(c *gin.Context) {
+	var req UnlockRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.lockoutService.RequestUnlock(c.Request.Context(), req.Email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If the account is locked, an unlock link has been sent"})
+}
+
+// UnlockAccount handles the unlock link sent by email
+func (h *LockoutHandler) UnlockAccount(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing token"})
+		return
+	}
+
+	if err := h.lockoutService.UnlockAccount(c.Request.Context(), token); err != nil {
+		if err == services.ErrInvalidUnlockToken {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired unlock link"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Account unlocked successfully"})
+}
+
+// ListSecurityEvents handles listing security events for admins
+func (h *LockoutHandler) ListSecurityEvents(c *gin.Context) {
+	filter := models.SecurityEventFilter{
+		Type:  c.Query("type"),
+		Email: c.Query("email"),
+		IP:    c.Query("ip"),
+	}
+	page := queryInt(c, "page", 1)
+	limit := queryInt(c, "limit", 50)
+
+	events, total, err := h.lockoutService.ListEvents(c.Request.Context(), filter, page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"events": events,
+		"total":  total,
+	})
+}
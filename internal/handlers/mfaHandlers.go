@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"net/http"
+
+	"loyaltea-server/internal/middleware"
+	"loyaltea-server/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MFAHandler struct {
+	mfaService   *services.MFAService
+	tokenService *services.TokenService
+}
+
+func NewMFAHandler(mfaService *services.MFAService, tokenService *services.TokenService) *MFAHandler {
+	return &MFAHandler{
+		mfaService:   mfaService,
+		tokenService: tokenService,
+	}
+}
+
+type MFACodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type DisableMFARequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+type MFALoginRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	Code     string `json:"code" binding:"required"` // TOTP code or recovery code
+}
+
+// Setup handles starting TOTP enrollment for the caller
+func (h *MFAHandler) Setup(c *gin.Context) {
+	claims, _ := middleware.GetClaims(c)
+
+	enrollment, err := h.mfaService.BeginEnrollment(c.Request.Context(), claims.UserID)
+	if err != nil {
+		writeMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Scan the code with your authenticator app, then confirm with a code",
+		"secret":      enrollment.Secret,
+		"otpauth_uri": enrollment.URI,
+	})
+}
+
+// Confirm handles finishing TOTP enrollment with a first code
+func (h *MFAHandler) Confirm(c *gin.Context) {
+	claims, _ := middleware.GetClaims(c)
+
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := h.mfaService.ConfirmEnrollment(c.Request.Context(), claims.UserID, req.Code)
+	if err != nil {
+		writeMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// Disable handles turning two-factor authentication off
+func (h *MFAHandler) Disable(c *gin.Context) {
+	claims, _ := middleware.GetClaims(c)
+
+	var req DisableMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.mfaService.Disable(c.Request.Context(), claims.UserID, req.Password, req.Code); err != nil {
+		writeMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes handles replacing the caller's recovery codes
+func (h *MFAHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	claims, _ := middleware.GetClaims(c)
+
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := h.mfaService.RegenerateRecoveryCodes(c.Request.Context(), claims.UserID, req.Code)
+	if err != nil {
+		writeMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Recovery codes regenerated",
+		"recovery_codes": codes,
+	})
+}
+
+// Login handles the second step of a login for users with two-factor
+// authentication enabled
+func (h *MFAHandler) Login(c *gin.Context) {
+	var req MFALoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.mfaService.CompleteLogin(c.Request.Context(), req.MFAToken, req.Code, c.ClientIP())
+	if err != nil {
+		if writeThrottled(c, err) {
+			return
+		}
+		writeMFAError(c, err)
+		return
+	}
+
+	// Start a new session
+	tokens, err := h.tokenService.IssueTokens(c.Request.Context(), user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Login successful",
+		"user":          userJSON(user),
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    int(tokens.ExpiresIn.Seconds()),
+	})
+}
+
+// writeMFAError maps MFA service errors to responses
+func writeMFAError(c *gin.Context, err error) {
+	switch err {
+	case services.ErrUserNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	case services.ErrMFAAlreadyEnabled:
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication already enabled"})
+	case services.ErrMFANotEnabled:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication not enabled"})
+	case services.ErrMFANotPending:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor setup has not been started"})
+	case services.ErrInvalidMFACode:
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid two-factor code"})
+	case services.ErrInvalidMFAChallenge:
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired two-factor challenge"})
+	case services.ErrInvalidCredentials:
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+	case services.ErrAccountSuspended:
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account suspended"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	}
+}
@@ -2,104 +2,522 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
+	"io"
+	"log"
+	"loyaltea-server/internal/inbound"
+	"loyaltea-server/internal/middleware"
 	"loyaltea-server/internal/models"
 	"loyaltea-server/internal/services"
+	"mime/multipart"
 	"net/http"
-	"os"
-	"strings"
+	"net/url"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 type OfferHandler struct {
-	offerService *services.OfferService
+	offerService        *services.OfferService
+	inboundService      *services.InboundService
+	webhookService      *services.WebhookService
+	subscriptionService *services.SubscriptionService
+	outboxService       *services.OutboxService
 }
 
-func NewOfferHandler(offerService *services.OfferService) *OfferHandler {
+func NewOfferHandler(offerService *services.OfferService, inboundService *services.InboundService, webhookService *services.WebhookService, subscriptionService *services.SubscriptionService, outboxService *services.OutboxService) *OfferHandler {
 	return &OfferHandler{
-		offerService: offerService,
+		offerService:        offerService,
+		inboundService:      inboundService,
+		webhookService:      webhookService,
+		subscriptionService: subscriptionService,
+		outboxService:       outboxService,
 	}
 }
 
 // MailchimpOfferRequest represents the expected POST payload for Mailchimp
 // (Mailchimp expects JSON)
 type MailchimpOfferRequest struct {
-	SenderEmail string   `json:"sender_email" binding:"required,email"`
-	Subject     string   `json:"subject"`
-	Body        string   `json:"body"`
-	Brand       string   `json:"brand"`
-	Source      string   `json:"source"`
-	Tags        []string `json:"tags"`
+	SenderEmail string `json:"sender_email" binding:"required,email"`
+	// Recipient is the forwarding address the offer was sent to, if any. It
+	// decides who owns the offer instead of SenderEmail.
+	Recipient string   `json:"recipient" binding:"omitempty,email"`
+	Subject   string   `json:"subject"`
+	Body      string   `json:"body"`
+	BodyHTML  string   `json:"body_html"`
+	Brand     string   `json:"brand"`
+	Source    string   `json:"source"`
+	Tags      []string `json:"tags"`
+	// Headers of the original email. From and List-Unsubscribe are used to
+	// detect the brand when none is given.
+	Headers map[string]string `json:"headers"`
 }
 
-// subscribeToMailchimp subscribes an email to a Mailchimp list
-func subscribeToMailchimp(email string) error {
-	apiKey := os.Getenv("MAILCHIMP_API_KEY")
-	listID := os.Getenv("MAILCHIMP_LIST_ID")
-	if apiKey == "" || listID == "" {
-		return fmt.Errorf("mailchimp API key or list ID not set in environment")
+// ReceiveOffer handles POST requests to the Mailchimp webhook URL. Form
+// encoded requests are Mailchimp list webhooks or Mandrill event batches,
+// anything else is a MailchimpOfferRequest, which must carry the same key
+// query parameter as Mailchimp list webhooks.
+func (h *OfferHandler) ReceiveOffer(c *gin.Context) {
+	if c.ContentType() == "application/x-www-form-urlencoded" {
+		h.receiveWebhook(c)
+		return
+	}
+
+	if err := h.webhookService.VerifyMailchimp(c.Query("key")); err != nil {
+		writeWebhookError(c, err)
+		return
 	}
-	// Mailchimp API base URL (usX must match your API key's datacenter)
-	datacenter := apiKey[strings.LastIndex(apiKey, "-")+1:]
-	url := fmt.Sprintf("https://%s.api.mailchimp.com/3.0/lists/%s/members", datacenter, listID)
 
-	payload := map[string]interface{}{
-		"email_address": email,
-		"status":        "subscribed",
+	var req MailchimpOfferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
-	body, _ := json.Marshal(payload)
+	offer := &models.Offer{
+		SenderEmail:  req.SenderEmail,
+		Subject:      req.Subject,
+		Body:         req.Body,
+		OriginalHTML: req.BodyHTML,
+		Brand:        req.Brand,
+		Source:       req.Source,
+		Tags:         req.Tags,
+		Headers:      req.Headers,
+	}
+	var recipients []string
+	if req.Recipient != "" {
+		recipients = []string{req.Recipient}
+	}
+	h.storeOffer(c, recipients, offer)
+}
+
+// maxRawMessageSize is the largest raw email ReceiveRawOffer accepts
+const maxRawMessageSize = 10 << 20
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+// ReceiveRawOffer handles POST requests carrying a raw RFC 5322 email, such
+// as one forwarded by a mail server, and stores the offer inside it. The
+// request must be signed, see WebhookService.VerifyRaw, with the signature in
+// X-Loyaltea-Signature and the Unix time it was signed at in
+// X-Loyaltea-Timestamp.
+func (h *OfferHandler) ReceiveRawOffer(c *gin.Context) {
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxRawMessageSize))
 	if err != nil {
-		return err
+		writeInboundError(c, err)
+		return
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.SetBasicAuth("anystring", apiKey) // Mailchimp uses anystring:apikey for basic auth
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	signature := c.GetHeader("X-Loyaltea-Signature")
+	err = h.webhookService.VerifyRaw(c.Request.Context(), c.GetHeader("X-Loyaltea-Timestamp"), signature, body)
 	if err != nil {
-		return err
+		writeWebhookError(c, err)
+		return
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 && resp.StatusCode != 201 {
-		return fmt.Errorf("Mailchimp API error: %s", resp.Status)
+	email, err := inbound.Parse(bytes.NewReader(body))
+	if err != nil {
+		writeInboundError(c, err)
+		return
+	}
+
+	if !h.storeOffer(c, email.Recipients, email.Offer()) {
+		if err := h.webhookService.ReleaseRaw(c.Request.Context(), signature); err != nil {
+			log.Printf("Failed to release raw email signature: %v", err)
+		}
 	}
-	return nil
 }
 
-// ReceiveOffer handles POST requests and subscribes sender to Mailchimp
-func (h *OfferHandler) ReceiveOffer(c *gin.Context) {
-	var req MailchimpOfferRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+// maxMultipartMemory is how much of a webhook form is kept in memory, the
+// rest of its attachments spill to temporary files
+const maxMultipartMemory = 1 << 20
+
+// ReceiveMailgunOffer handles the multipart/form-data POST of a Mailgun
+// inbound route, checking its signature before storing the offer inside
+func (h *OfferHandler) ReceiveMailgunOffer(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxRawMessageSize)
+	err := c.Request.ParseMultipartForm(maxMultipartMemory)
+	if err != nil && err != http.ErrNotMultipart {
+		writeInboundError(c, err)
 		return
 	}
-	// Subscribe sender to Mailchimp
-	if err := subscribeToMailchimp(req.SenderEmail); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to subscribe to Mailchimp", "details": err.Error()})
+	form := c.Request.MultipartForm
+	if form == nil {
+		// routes without attachments may be posted URL encoded
+		form = &multipart.Form{Value: c.Request.PostForm}
+	} else {
+		defer form.RemoveAll()
+	}
+
+	token := c.PostForm("token")
+	err = h.webhookService.VerifyMailgun(c.Request.Context(), c.PostForm("timestamp"), token, c.PostForm("signature"))
+	if err != nil {
+		writeWebhookError(c, err)
 		return
 	}
-	// Store offer in DB as before
-	offer := &models.Offer{
-		SenderEmail: req.SenderEmail,
-		Subject:     req.Subject,
-		Body:        req.Body,
-		Brand:       req.Brand,
-		Source:      req.Source,
-		Tags:        req.Tags,
+
+	email, err := inbound.ParseMailgun(form)
+	if err != nil {
+		writeInboundError(c, err)
+		return
+	}
+
+	// Mailgun retries a failed webhook with the same token
+	if !h.storeOffer(c, email.Recipients, email.Offer()) {
+		if err := h.webhookService.ReleaseMailgun(c.Request.Context(), token); err != nil {
+			log.Printf("Failed to release Mailgun webhook token: %v", err)
+		}
 	}
-	if err := h.offerService.CreateOffer(c.Request.Context(), offer); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store offer"})
+}
+
+// mailchimpTimeLayout is the format of fired_at in Mailchimp list webhooks,
+// which is in UTC
+const mailchimpTimeLayout = "2006-01-02 15:04:05"
+
+// mandrillEvent is one event of a Mandrill webhook batch
+type mandrillEvent struct {
+	Event string `json:"event"`
+	ID    string `json:"_id"`
+	TS    int64  `json:"ts"`
+	Msg   struct {
+		inbound.MandrillMessage
+		BounceDescription string `json:"bounce_description"`
+	} `json:"msg"`
+}
+
+// receiveWebhook handles a form encoded webhook from Mailchimp or Mandrill
+func (h *OfferHandler) receiveWebhook(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxRawMessageSize)
+	if err := c.Request.ParseForm(); err != nil {
+		writeInboundError(c, err)
+		return
+	}
+	form := c.Request.PostForm
+
+	if form.Has("mandrill_events") {
+		h.receiveMandrillEvents(c, form)
+		return
+	}
+	h.receiveMailchimpEvent(c, form)
+}
+
+// receiveMailchimpEvent handles a Mailchimp list webhook, recording
+// unsubscribes and cleaned addresses so they are not subscribed again
+func (h *OfferHandler) receiveMailchimpEvent(c *gin.Context, form url.Values) {
+	if err := h.webhookService.VerifyMailchimp(c.Query("key")); err != nil {
+		writeWebhookError(c, err)
+		return
+	}
+
+	firedAt, _ := time.Parse(mailchimpTimeLayout, form.Get("fired_at"))
+	event := services.SubscriptionEvent{
+		Type:   form.Get("type"),
+		Email:  form.Get("data[email]"),
+		Reason: form.Get("data[reason]"),
+		At:     firedAt,
+	}
+	if event.Type == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing event type"})
+		return
+	}
+
+	updated, err := h.subscriptionService.Apply(c.Request.Context(), event)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Event received",
+		"type":    event.Type,
+		"updated": updated,
+	})
+}
+
+// receiveMandrillEvents handles a batch of Mandrill events. Inbound messages
+// are stored as offers and bounces, rejects, spam complaints and
+// unsubscribes update the subscription of the address. One event failing
+// does not fail the batch, as Mandrill would then send all of it again.
+func (h *OfferHandler) receiveMandrillEvents(c *gin.Context, form url.Values) {
+	if err := h.webhookService.VerifyMandrill(form, c.GetHeader("X-Mandrill-Signature")); err != nil {
+		writeWebhookError(c, err)
+		return
+	}
+
+	var events []mandrillEvent
+	if err := json.Unmarshal([]byte(form.Get("mandrill_events")), &events); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid mandrill_events"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "Offer stored and user subscribed to Mailchimp successfully"})
+
+	ctx := c.Request.Context()
+	results := make([]gin.H, 0, len(events))
+	for _, event := range events {
+		result := gin.H{"event": event.Event, "id": event.ID}
+		if event.Event == "inbound" {
+			email, err := inbound.ParseMandrill(event.Msg.MandrillMessage)
+			if err != nil {
+				result["error"] = err.Error()
+				results = append(results, result)
+				continue
+			}
+			offer := email.Offer()
+			if err := h.inboundService.Receive(ctx, email.Recipients, offer); err != nil {
+				result["error"] = err.Error()
+				results = append(results, result)
+				continue
+			}
+			result["offer_id"] = offer.ID
+			result["duplicate_of"] = offer.DuplicateOf
+			result["subscription_queued"] = h.queueSubscribe(ctx, offer.SenderEmail)
+		} else {
+			updated, err := h.subscriptionService.Apply(ctx, services.SubscriptionEvent{
+				Type:   event.Event,
+				Email:  event.Msg.Email,
+				Reason: event.Msg.BounceDescription,
+				At:     time.Unix(event.TS, 0),
+			})
+			if err != nil {
+				result["error"] = err.Error()
+			}
+			result["updated"] = updated
+		}
+		results = append(results, result)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"events": results})
+}
+
+// writeWebhookError writes the response for a webhook that failed its checks
+func writeWebhookError(c *gin.Context, err error) {
+	switch err {
+	case services.ErrWebhookNotConfigured:
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Webhook is not configured"})
+	case services.ErrInvalidSignature, services.ErrStaleWebhook:
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid webhook signature"})
+	case services.ErrReplayedWebhook:
+		// 406 tells Mailgun not to retry
+		c.JSON(http.StatusNotAcceptable, gin.H{"error": "Webhook already received"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	}
+}
+
+// writeInboundError writes the response for an email that could not be read
+func writeInboundError(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Message too large"})
+	case err == inbound.ErrNoSender:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Message has no valid From address"})
+	case err == inbound.ErrNoBody:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Message has no text or HTML body"})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message", "details": err.Error()})
+	}
+}
+
+// storeOffer stores a received offer for the owner of the forwarding address
+// it was sent to, or its sender, and queues subscribing that address to the
+// mailing list. The offer is stored first so senders the service rejects are
+// not subscribed. It reports whether the offer was stored.
+func (h *OfferHandler) storeOffer(c *gin.Context, recipients []string, offer *models.Offer) bool {
+	if err := h.inboundService.Receive(c.Request.Context(), recipients, offer); err != nil {
+		switch err {
+		case services.ErrUnknownRecipient:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unknown forwarding address"})
+		case services.ErrSenderNotVerified:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Sender email address not verified"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store offer"})
+		}
+		return false
+	}
+
+	queued := h.queueSubscribe(c.Request.Context(), offer.SenderEmail)
+	message := "Offer stored and user subscription queued"
+	if !queued {
+		message = "Offer stored"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":             message,
+		"offer_id":            offer.ID,
+		"duplicate_of":        offer.DuplicateOf,
+		"subscription_queued": queued,
+	})
+	return true
+}
+
+// queueSubscribe queues subscribing an address to the mailing list unless
+// its owner has unsubscribed or the address bounced, and reports whether it
+// did. The offer is already stored, so failing to queue is only logged.
+func (h *OfferHandler) queueSubscribe(ctx context.Context, email string) bool {
+	queued, err := h.outboxService.EnqueueSubscribe(ctx, email)
+	if err != nil {
+		log.Printf("Failed to queue subscription of %s: %v", email, err)
+	}
+	return queued
+}
+
+// ListOffers handles listing the offers visible to the caller
+func (h *OfferHandler) ListOffers(c *gin.Context) {
+	params := services.OfferListParams{
+		SenderEmail: c.Query("sender"),
+		Brand:       c.Query("brand"),
+		Tag:         c.Query("tag"),
+		Source:      c.Query("source"),
+		Sort:        c.Query("sort"),
+		Cursor:      c.Query("cursor"),
+		Limit:       queryInt(c, "limit", 0),
+		// Admins see every forward of an offer unless they hide duplicates
+		HideDuplicates: c.Query("hide_duplicates") == "true",
+	}
+
+	format, ok := bodyFormat(c)
+	if !ok {
+		return
+	}
+
+	var err error
+	if params.CreatedAfter, err = queryTime(c, "created_after"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "created_after must be an RFC 3339 timestamp"})
+		return
+	}
+	if params.CreatedBefore, err = queryTime(c, "created_before"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "created_before must be an RFC 3339 timestamp"})
+		return
+	}
+
+	page, err := h.offerService.ListOffers(c.Request.Context(), offerViewer(c), params)
+	if err != nil {
+		switch err {
+		case services.ErrInvalidCursor:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		case services.ErrInvalidSort:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sort, use createdAt, subject or brand with an optional - prefix"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		}
+		return
+	}
+
+	for i := range page.Offers {
+		formatBody(&page.Offers[i], format)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"offers":      page.Offers,
+		"next_cursor": page.NextCursor,
+	})
+}
+
+// GetOffer handles getting a single offer by ID
+func (h *OfferHandler) GetOffer(c *gin.Context) {
+	format, ok := bodyFormat(c)
+	if !ok {
+		return
+	}
+
+	offer, err := h.offerService.GetOffer(c.Request.Context(), offerViewer(c), c.Param("id"))
+	if err != nil {
+		if err == services.ErrOfferNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Offer not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	formatBody(offer, format)
+	c.JSON(http.StatusOK, gin.H{"offer": offer})
+}
+
+// SearchOffers handles full-text search over the offers visible to the caller
+func (h *OfferHandler) SearchOffers(c *gin.Context) {
+	format, ok := bodyFormat(c)
+	if !ok {
+		return
+	}
+
+	results, err := h.offerService.SearchOffers(c.Request.Context(), offerViewer(c), c.Query("q"), int(queryInt(c, "limit", 0)))
+	if err != nil {
+		if err == services.ErrEmptySearch {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Query parameter q is required"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	for i := range results {
+		formatBody(&results[i].Offer, format)
+	}
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+// ReclassifyOffers handles re-running tag classification over every offer
+func (h *OfferHandler) ReclassifyOffers(c *gin.Context) {
+	result, err := h.offerService.ReclassifyOffers(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// Offer body formats clients can ask for with the body query parameter
+const (
+	bodyFormatText = "text" // Plain text in body, the default
+	bodyFormatHTML = "html" // Sanitized HTML in bodyHtml, with body only for offers without HTML
+	bodyFormatBoth = "both"
+)
+
+// bodyFormat reads the body query parameter, writing a 400 response if it is invalid
+func bodyFormat(c *gin.Context) (string, bool) {
+	switch format := c.DefaultQuery("body", bodyFormatText); format {
+	case bodyFormatText, bodyFormatHTML, bodyFormatBoth:
+		return format, true
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid body format, use text, html or both"})
+	return "", false
+}
+
+// formatBody keeps the body representations of an offer the client asked for
+func formatBody(offer *models.Offer, format string) {
+	switch format {
+	case bodyFormatText:
+		offer.SafeHTML = ""
+	case bodyFormatHTML:
+		if offer.SafeHTML != "" {
+			offer.Body = ""
+		}
+	}
+}
+
+// offerViewer builds the scope of an offer request from the caller's token
+func offerViewer(c *gin.Context) services.OfferViewer {
+	claims, _ := middleware.GetClaims(c)
+	return services.OfferViewer{
+		UserID:  claims.UserID,
+		Email:   claims.Email,
+		IsAdmin: claims.IsAdmin(),
+	}
+}
+
+// queryTime reads an optional RFC 3339 timestamp query parameter
+func queryTime(c *gin.Context, key string) (*time.Time, error) {
+	value := c.Query(key)
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
 }
 
-// Add a simple GET endpoint to respond to Mailchimp webhook verification
+// VerifyWebhook answers the GET Mailchimp and the HEAD Mandrill send to check
+// a webhook URL exists before they start posting to it
 func (h *OfferHandler) VerifyWebhook(c *gin.Context) {
 	c.String(http.StatusOK, "Webhook endpoint verified")
 }
@@ -0,0 +1,122 @@
+package handlers_test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/services"
+)
+
+// hmacHex returns the hex HMAC-SHA256 of message keyed with key
+func hmacHex(key string, message string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(message))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// mailgunRequest builds a URL encoded Mailgun inbound route post signed with key
+func mailgunRequest(key string, token string, recipient string) *http.Request {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	form := url.Values{
+		"timestamp":  {timestamp},
+		"token":      {token},
+		"signature":  {hmacHex(key, timestamp+token)},
+		"recipient":  {recipient},
+		"sender":     {"user@example.com"},
+		"from":       {"Brew Co <deals@brew.example>"},
+		"subject":    {"Coffee sale"},
+		"body-plain": {"Use code BREW20 for 20% off."},
+	}
+	req := httptest.NewRequest(http.MethodPost, "/offer/mailgun", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+func TestReceiveMailchimpOfferRequiresKey(t *testing.T) {
+	s := newTestServer(t, services.VerificationPolicy{})
+	s.createUser(t, "user@example.com", models.RoleCustomer)
+	offer := map[string]string{"sender_email": "user@example.com", "subject": "Sale", "body": "Everything is 10% off."}
+
+	w := s.request(http.MethodPost, "/offer/mailchimp", "", offer)
+	expectStatus(t, w, http.StatusUnauthorized)
+	w = s.request(http.MethodPost, "/offer/mailchimp?key=guess", "", offer)
+	expectStatus(t, w, http.StatusUnauthorized)
+
+	w = s.request(http.MethodPost, "/offer/mailchimp?key="+testMailchimpKey, "", offer)
+	expectStatus(t, w, http.StatusOK)
+	if body := decode(t, w); body["offer_id"] == "" {
+		t.Fatalf("response %v has no offer ID", body)
+	}
+}
+
+func TestReceiveMailgunOffer(t *testing.T) {
+	s := newTestServer(t, services.VerificationPolicy{})
+	user := s.createUser(t, "user@example.com", models.RoleCustomer)
+	address := s.inbound.AddressFor(user)
+
+	expectStatus(t, s.serve(mailgunRequest("guess", "token-1", address)), http.StatusUnauthorized)
+
+	// A failed delivery releases the token so Mailgun's retry is handled
+	unknown := "nobody@" + testInboundDomain
+	expectStatus(t, s.serve(mailgunRequest(testMailgunKey, "token-2", unknown)), http.StatusNotFound)
+	expectStatus(t, s.serve(mailgunRequest(testMailgunKey, "token-2", unknown)), http.StatusNotFound)
+
+	expectStatus(t, s.serve(mailgunRequest(testMailgunKey, "token-1", address)), http.StatusOK)
+	expectStatus(t, s.serve(mailgunRequest(testMailgunKey, "token-1", address)), http.StatusNotAcceptable)
+
+	offers := s.offersFor(t, user.ID)
+	if len(offers) != 1 || offers[0].Subject != "Coffee sale" {
+		t.Fatalf("offers = %+v, want the one delivered", offers)
+	}
+}
+
+func TestReceiveRawOffer(t *testing.T) {
+	s := newTestServer(t, services.VerificationPolicy{})
+	user := s.createUser(t, "user@example.com", models.RoleCustomer)
+	message := []byte(strings.Join([]string{
+		"From: user@example.com",
+		"To: " + s.inbound.AddressFor(user),
+		"Subject: Garden centre sale",
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		"All plants are 30% off this weekend.",
+	}, "\r\n"))
+
+	send := func(signature string) *httptest.ResponseRecorder {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		if signature == "" {
+			signature = hmacHex(testRawKey, timestamp+"."+string(message))
+		}
+		req := httptest.NewRequest(http.MethodPost, "/offer/raw", bytes.NewReader(message))
+		req.Header.Set("X-Loyaltea-Timestamp", timestamp)
+		req.Header.Set("X-Loyaltea-Signature", signature)
+		return s.serve(req)
+	}
+
+	expectStatus(t, send(hmacHex("guess", "anything")), http.StatusUnauthorized)
+	expectStatus(t, send(""), http.StatusOK)
+	if offers := s.offersFor(t, user.ID); len(offers) != 1 {
+		t.Fatalf("%d offers stored, want 1", len(offers))
+	}
+}
+
+func TestOffersRequireAuth(t *testing.T) {
+	s := newTestServer(t, services.VerificationPolicy{})
+	token := s.accessToken(t, s.createUser(t, "user@example.com", models.RoleCustomer))
+
+	expectStatus(t, s.request(http.MethodGet, "/offers", "", nil), http.StatusUnauthorized)
+	expectStatus(t, s.request(http.MethodGet, "/offers", token, nil), http.StatusOK)
+	expectStatus(t, s.request(http.MethodGet, "/offers?sort=price", token, nil), http.StatusBadRequest)
+	expectStatus(t, s.request(http.MethodGet, "/offers/search", token, nil), http.StatusBadRequest)
+	expectStatus(t, s.request(http.MethodPost, "/admin/offers/reclassify", token, nil), http.StatusForbidden)
+}
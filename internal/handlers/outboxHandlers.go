@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"net/http"
+
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OutboxHandler struct {
+	outboxService *services.OutboxService
+}
+
+func NewOutboxHandler(outboxService *services.OutboxService) *OutboxHandler {
+	return &OutboxHandler{
+		outboxService: outboxService,
+	}
+}
+
+// ListOutbox handles listing outbox items for admins. Without a status it
+// lists the stuck ones: dead items and pending items that have failed.
+func (h *OutboxHandler) ListOutbox(c *gin.Context) {
+	filter := models.OutboxFilter{
+		Status: c.Query("status"),
+		Kind:   c.Query("kind"),
+	}
+	page := queryInt(c, "page", 1)
+	limit := queryInt(c, "limit", 50)
+
+	items, total, err := h.outboxService.ListItems(c.Request.Context(), filter, page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"items": items,
+		"total": total,
+		"page":  page,
+		"limit": limit,
+	})
+}
+
+// RetryOutboxItem handles queueing a dead outbox item again
+func (h *OutboxHandler) RetryOutboxItem(c *gin.Context) {
+	err := h.outboxService.RetryItem(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		if err == services.ErrOutboxItemNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Dead outbox item not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Outbox item queued for retry"})
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"net/http"
+
+	"loyaltea-server/internal/middleware"
+	"loyaltea-server/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PasswordHandler struct {
+	passwordService *services.PasswordService
+}
+
+func NewPasswordHandler(passwordService *services.PasswordService) *PasswordHandler {
+	return &PasswordHandler{
+		passwordService: passwordService,
+	}
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=8"`
+}
+
+type ChangePasswordRequest struct {
+	OldPassword string `json:"old_password" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required,min=8"`
+}
+
+// ForgotPassword handles requesting a password reset email
+func (h *PasswordHandler) ForgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.passwordService.ForgotPassword(c.Request.Context(), req.Email)
+
+	// Same response whether or not the email is registered, or the email could be sent
+	c.JSON(http.StatusOK, gin.H{"message": "If the email is registered, a reset link has been sent"})
+}
+
+// ResetPassword handles setting a new password with a reset token
+func (h *PasswordHandler) ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.passwordService.ResetPassword(c.Request.Context(), req.Token, req.Password)
+	if err != nil {
+		switch err {
+		case services.ErrInvalidResetToken:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset token"})
+		case services.ErrInvalidPassword:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Password must be at least 8 characters long"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully"})
+}
+
+// ChangePassword handles changing the password of the logged in user
+func (h *PasswordHandler) ChangePassword(c *gin.Context) {
+	claims, ok := middleware.GetClaims(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.passwordService.ChangePassword(c.Request.Context(), claims.UserID, req.OldPassword, req.NewPassword)
+	if err != nil {
+		switch err {
+		case services.ErrUserNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		case services.ErrInvalidCredentials:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
+		case services.ErrInvalidPassword:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Password must be at least 8 characters long"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed successfully, please log in again"})
+}
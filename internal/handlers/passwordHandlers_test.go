@@ -0,0 +1,47 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/services"
+)
+
+// resetLink matches the reset link in a password reset email
+var resetLink = regexp.MustCompile(`https?://\S+`)
+
+func TestForgotAndResetPassword(t *testing.T) {
+	s := newTestServer(t, services.VerificationPolicy{})
+	s.createUser(t, "user@example.com", models.RoleCustomer)
+
+	w := s.request(http.MethodPost, "/user/password/forgot", "", map[string]string{"email": "user@example.com"})
+	expectStatus(t, w, http.StatusOK)
+
+	// The link opens the web app's reset page with the token to post back
+	message, ok := s.mail.Last()
+	if !ok || message.To != "user@example.com" {
+		t.Fatalf("last message = %+v, want a reset email to user@example.com", message)
+	}
+	link, err := url.Parse(resetLink.FindString(message.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := link.Query().Get("token")
+	if !strings.HasPrefix(link.String(), testResetURL+"?") || token == "" {
+		t.Fatalf("link = %s, want the reset page with a token", link)
+	}
+
+	w = s.request(http.MethodPost, "/user/password/reset", "", map[string]string{"token": token, "password": "new password"})
+	expectStatus(t, w, http.StatusOK)
+	w = s.request(http.MethodPost, "/user/password/reset", "", map[string]string{"token": token, "password": "another password"})
+	expectStatus(t, w, http.StatusBadRequest)
+
+	w = s.request(http.MethodPost, "/user/login", "", map[string]string{"email": "user@example.com", "password": testPassword})
+	expectStatus(t, w, http.StatusUnauthorized)
+	w = s.request(http.MethodPost, "/user/login", "", map[string]string{"email": "user@example.com", "password": "new password"})
+	expectStatus(t, w, http.StatusOK)
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"net/http"
+
+	"loyaltea-server/internal/middleware"
+	"loyaltea-server/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PointsHandler struct {
+	pointsService *services.PointsService
+}
+
+func NewPointsHandler(pointsService *services.PointsService) *PointsHandler {
+	return &PointsHandler{
+		pointsService: pointsService,
+	}
+}
+
+type RedeemPointsRequest struct {
+	Points    int64  `json:"points" binding:"required"`
+	Reference string `json:"reference" binding:"required"` // Unique per redemption, so a retry is not redeemed twice
+}
+
+type AdjustPointsRequest struct {
+	Points    int64  `json:"points" binding:"required"` // Negative to take points away
+	Note      string `json:"note" binding:"required"`
+	Reference string `json:"reference"`
+}
+
+// GetBalance handles getting a user's points balance
+func (h *PointsHandler) GetBalance(c *gin.Context) {
+	id := c.Param("id")
+	if !authorizeUser(c, id) {
+		return
+	}
+
+	balance, err := h.pointsService.Balance(c.Request.Context(), id)
+	if err != nil {
+		writePointsError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id": id,
+		"balance": balance,
+	})
+}
+
+// GetHistory handles listing a user's points ledger, newest first
+func (h *PointsHandler) GetHistory(c *gin.Context) {
+	id := c.Param("id")
+	if !authorizeUser(c, id) {
+		return
+	}
+	page := queryInt(c, "page", 1)
+	limit := queryInt(c, "limit", 50)
+
+	entries, total, err := h.pointsService.History(c.Request.Context(), id, page, limit)
+	if err != nil {
+		writePointsError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"entries": entries,
+		"total":   total,
+		"page":    page,
+		"limit":   limit,
+	})
+}
+
+// Redeem handles spending a user's points
+func (h *PointsHandler) Redeem(c *gin.Context) {
+	id := c.Param("id")
+	if !authorizeUser(c, id) {
+		return
+	}
+
+	var req RedeemPointsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	entry, err := h.pointsService.Redeem(c.Request.Context(), id, req.Points, req.Reference)
+	if err != nil {
+		writePointsError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Points redeemed",
+		"entry":   entry,
+		"balance": entry.Balance,
+	})
+}
+
+// AdjustPoints handles an admin crediting or debiting a user's points by hand
+func (h *PointsHandler) AdjustPoints(c *gin.Context) {
+	claims, _ := middleware.GetClaims(c)
+
+	var req AdjustPointsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	entry, err := h.pointsService.Adjust(c.Request.Context(), claims.UserID, c.Param("id"), req.Points, req.Note, req.Reference)
+	if err != nil {
+		writePointsError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Points adjusted",
+		"entry":   entry,
+		"balance": entry.Balance,
+	})
+}
+
+// writePointsError maps points service errors to responses
+func writePointsError(c *gin.Context, err error) {
+	switch err {
+	case services.ErrUserNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	case services.ErrInvalidPoints:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Points must be a positive whole number"})
+	case services.ErrNoteRequired:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A note is required"})
+	case services.ErrReferenceRequired:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A reference is required"})
+	case services.ErrAlreadyRedeemed:
+		c.JSON(http.StatusConflict, gin.H{"error": "Points already redeemed for this reference"})
+	case services.ErrInsufficientPoints:
+		c.JSON(http.StatusConflict, gin.H{"error": "Insufficient points"})
+	case services.ErrPointsConflict:
+		c.JSON(http.StatusConflict, gin.H{"error": "Points balance is changing, please retry"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	}
+}
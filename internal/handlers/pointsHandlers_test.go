@@ -0,0 +1,47 @@
+package handlers_test
+
+import (
+	"net/http"
+	"testing"
+
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/services"
+)
+
+func TestRedeemPoints(t *testing.T) {
+	s := newTestServer(t, services.VerificationPolicy{})
+	user := s.createUser(t, "user@example.com", models.RoleCustomer)
+	token := s.accessToken(t, user)
+	adminToken := s.accessToken(t, s.createUser(t, "admin@example.com", models.RoleAdmin))
+	redeem := "/user/" + user.ID + "/points/redeem"
+
+	w := s.request(http.MethodPost, "/admin/users/"+user.ID+"/points/adjust", token, map[string]any{"points": 100, "note": "Welcome"})
+	expectStatus(t, w, http.StatusForbidden)
+	w = s.request(http.MethodPost, "/admin/users/"+user.ID+"/points/adjust", adminToken, map[string]any{"points": 100, "note": "Welcome"})
+	expectStatus(t, w, http.StatusOK)
+
+	w = s.request(http.MethodPost, redeem, token, map[string]any{"points": 30})
+	expectStatus(t, w, http.StatusBadRequest)
+	w = s.request(http.MethodPost, redeem, token, map[string]any{"points": 30, "reference": "order-1"})
+	expectStatus(t, w, http.StatusOK)
+	if balance := decode(t, w)["balance"]; balance != float64(70) {
+		t.Fatalf("balance = %v, want 70", balance)
+	}
+
+	// A retried request is not redeemed twice
+	w = s.request(http.MethodPost, redeem, token, map[string]any{"points": 30, "reference": "order-1"})
+	expectStatus(t, w, http.StatusConflict)
+	w = s.request(http.MethodPost, redeem, token, map[string]any{"points": 500, "reference": "order-2"})
+	expectStatus(t, w, http.StatusConflict)
+
+	w = s.request(http.MethodGet, "/user/"+user.ID+"/points", token, nil)
+	expectStatus(t, w, http.StatusOK)
+	if balance := decode(t, w)["balance"]; balance != float64(70) {
+		t.Fatalf("balance = %v, want 70", balance)
+	}
+	w = s.request(http.MethodGet, "/user/"+user.ID+"/points/history", token, nil)
+	expectStatus(t, w, http.StatusOK)
+	if total := decode(t, w)["total"]; total != float64(2) {
+		t.Fatalf("history total = %v, want 2", total)
+	}
+}
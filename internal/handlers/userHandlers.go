@@ -1,8 +1,13 @@
 package handlers
 
 import (
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
 
+	"loyaltea-server/internal/middleware"
+	"loyaltea-server/internal/models"
 	"loyaltea-server/internal/services"
 	"loyaltea-server/internal/utils"
 
@@ -10,12 +15,18 @@ import (
 )
 
 type UserHandler struct {
-	userService *services.UserService
+	userService         *services.UserService
+	tokenService        *services.TokenService
+	verificationService *services.VerificationService
+	inboundService      *services.InboundService
 }
 
-func NewUserHandler(userService *services.UserService) *UserHandler {
+func NewUserHandler(userService *services.UserService, tokenService *services.TokenService, verificationService *services.VerificationService, inboundService *services.InboundService) *UserHandler {
 	return &UserHandler{
-		userService: userService,
+		userService:         userService,
+		tokenService:        tokenService,
+		verificationService: verificationService,
+		inboundService:      inboundService,
 	}
 }
 
@@ -30,6 +41,10 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
 // Register handles user registration
 func (h *UserHandler) Register(c *gin.Context) {
 	var req RegisterRequest
@@ -53,21 +68,28 @@ func (h *UserHandler) Register(c *gin.Context) {
 		return
 	}
 
-	// Generate JWT token
-	token, err := utils.GenerateToken(user.ID, user.Email)
+	// No session until the address is verified if logging in requires it
+	if h.verificationService.Policy().RequireForLogin {
+		c.JSON(http.StatusCreated, gin.H{
+			"message": "User registered successfully, verify your email address to log in",
+			"user":    userJSON(user),
+		})
+		return
+	}
+
+	// Start a new session
+	tokens, err := h.tokenService.IssueTokens(c.Request.Context(), user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
 	c.JSON(http.StatusCreated, gin.H{
-		"message": "User registered successfully",
-		"user": gin.H{
-			"id":    user.ID,
-			"email": user.Email,
-			"name":  user.Name,
-		},
-		"token": token,
+		"message":       "User registered successfully",
+		"user":          userJSON(user),
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    int(tokens.ExpiresIn.Seconds()),
 	})
 }
 
@@ -79,37 +101,164 @@ func (h *UserHandler) Login(c *gin.Context) {
 		return
 	}
 
-	user, err := h.userService.LoginUser(req.Email, req.Password)
+	user, err := h.userService.LoginUser(req.Email, req.Password, c.ClientIP())
 	if err != nil {
-		if err == services.ErrInvalidCredentials {
+		if writeThrottled(c, err) {
+			return
+		}
+
+		switch err {
+		case services.ErrInvalidCredentials:
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		case services.ErrEmailNotVerified:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Email address not verified"})
+		case services.ErrAccountSuspended:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Account suspended"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		}
+		return
+	}
+
+	// The password was right but a second factor is still needed
+	if user.MFAEnabled {
+		challenge, err := utils.GenerateMFAChallengeToken(user.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":      "Two-factor authentication required",
+			"mfa_required": true,
+			"mfa_token":    challenge,
+			"expires_in":   int(utils.MFAChallengeTTL.Seconds()),
+		})
 		return
 	}
 
-	// Generate JWT token
-	token, err := utils.GenerateToken(user.ID, user.Email)
+	// Start a new session
+	tokens, err := h.tokenService.IssueTokens(c.Request.Context(), user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Login successful",
-		"user": gin.H{
-			"id":    user.ID,
-			"email": user.Email,
-			"name":  user.Name,
-		},
-		"token": token,
+		"message":       "Login successful",
+		"user":          userJSON(user),
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    int(tokens.ExpiresIn.Seconds()),
+	})
+}
+
+// RefreshToken handles exchanging a refresh token for a new token pair
+func (h *UserHandler) RefreshToken(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokens, err := h.tokenService.Refresh(c.Request.Context(), req.RefreshToken)
+	if err != nil {
+		switch err {
+		case services.ErrInvalidRefreshToken:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+		case services.ErrRefreshTokenReused:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token already used, session revoked"})
+		case services.ErrAccountSuspended:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Account suspended"})
+		case services.ErrEmailNotVerified:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Email address not verified"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    int(tokens.ExpiresIn.Seconds()),
+	})
+}
+
+// Logout handles revoking the caller's current session
+func (h *UserHandler) Logout(c *gin.Context) {
+	claims, ok := middleware.GetClaims(c)
+	if !ok || claims.SessionID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	if err := h.tokenService.RevokeSession(c.Request.Context(), claims.SessionID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
+
+// VerifyEmail handles the link sent to confirm an email address
+func (h *UserHandler) VerifyEmail(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing token"})
+		return
+	}
+
+	user, err := h.verificationService.VerifyEmail(c.Request.Context(), token)
+	if err != nil {
+		switch err {
+		case services.ErrInvalidVerificationToken:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification link"})
+		case services.ErrEmailExists:
+			c.JSON(http.StatusConflict, gin.H{"error": "Email already belongs to another account"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Email verified successfully",
+		"user":    userJSON(user),
 	})
 }
 
+// ResendVerification handles sending a new verification link to the caller
+func (h *UserHandler) ResendVerification(c *gin.Context) {
+	claims, ok := middleware.GetClaims(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	err := h.verificationService.ResendVerification(c.Request.Context(), claims.UserID)
+	if err != nil {
+		switch err {
+		case services.ErrUserNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		case services.ErrEmailAlreadyVerified:
+			c.JSON(http.StatusConflict, gin.H{"error": "Email already verified"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Verification email sent"})
+}
+
 // GetUser handles getting user by ID
 func (h *UserHandler) GetUser(c *gin.Context) {
 	id := c.Param("id")
+	if !authorizeUser(c, id) {
+		return
+	}
+
 	user, err := h.userService.GetUserByID(id)
 	if err != nil {
 		if err == services.ErrUserNotFound {
@@ -120,18 +269,44 @@ func (h *UserHandler) GetUser(c *gin.Context) {
 		return
 	}
 
+	response := userJSON(user)
+	response["forwarding_address"] = h.inboundService.AddressFor(user)
+	c.JSON(http.StatusOK, gin.H{
+		"user": response,
+	})
+}
+
+// RotateForwardingAddress handles replacing a user's forwarding address, for
+// when the old one has leaked
+func (h *UserHandler) RotateForwardingAddress(c *gin.Context) {
+	id := c.Param("id")
+	if !authorizeUser(c, id) {
+		return
+	}
+
+	user, err := h.inboundService.RotateAlias(c.Request.Context(), id)
+	if err != nil {
+		if err == services.ErrUserNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"user": gin.H{
-			"id":    user.ID,
-			"email": user.Email,
-			"name":  user.Name,
-		},
+		"message":            "Forwarding address rotated",
+		"forwarding_address": h.inboundService.AddressFor(user),
 	})
 }
 
 // UpdateUser handles updating user information
 func (h *UserHandler) UpdateUser(c *gin.Context) {
 	id := c.Param("id")
+	if !authorizeUser(c, id) {
+		return
+	}
+
 	var req struct {
 		Email string `json:"email" binding:"required,email"`
 		Name  string `json:"name" binding:"required"`
@@ -159,17 +334,73 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "User updated successfully",
-		"user": gin.H{
-			"id":    user.ID,
-			"email": user.Email,
-			"name":  user.Name,
-		},
+		"user":    userJSON(user),
 	})
 }
 
+// AddAliasEmail handles adding a secondary address offers can be forwarded from
+func (h *UserHandler) AddAliasEmail(c *gin.Context) {
+	id := c.Param("id")
+	if !authorizeUser(c, id) {
+		return
+	}
+
+	var req struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.verificationService.AddAliasEmail(c.Request.Context(), id, req.Email)
+	if err != nil {
+		switch err {
+		case services.ErrUserNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		case services.ErrInvalidEmail:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email format"})
+		case services.ErrEmailExists:
+			c.JSON(http.StatusConflict, gin.H{"error": "Email already exists"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Verification email sent to the new address"})
+}
+
+// RemoveAliasEmail handles removing a secondary address
+func (h *UserHandler) RemoveAliasEmail(c *gin.Context) {
+	id := c.Param("id")
+	if !authorizeUser(c, id) {
+		return
+	}
+
+	err := h.verificationService.RemoveAliasEmail(c.Request.Context(), id, c.Param("email"))
+	if err != nil {
+		switch err {
+		case services.ErrUserNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		case services.ErrAliasNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Alias email not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Alias email removed"})
+}
+
 // DeleteUser handles user deletion
 func (h *UserHandler) DeleteUser(c *gin.Context) {
 	id := c.Param("id")
+	if !authorizeUser(c, id) {
+		return
+	}
+
 	err := h.userService.DeleteUser(id)
 	if err != nil {
 		if err == services.ErrUserNotFound {
@@ -182,3 +413,49 @@ func (h *UserHandler) DeleteUser(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
 }
+
+// userJSON is the public representation of a user returned by the API
+func userJSON(user *models.User) gin.H {
+	return gin.H{
+		"id":             user.ID,
+		"email":          user.Email,
+		"name":           user.Name,
+		"role":           user.GetRole(),
+		"email_verified": user.EmailVerified,
+		"alias_emails":   user.AliasEmails,
+		"mfa_enabled":    user.MFAEnabled,
+	}
+}
+
+// writeThrottled writes a 429 response with a Retry-After header if err is a
+// *services.LoginThrottledError and reports whether it did
+func writeThrottled(c *gin.Context, err error) bool {
+	var throttled *services.LoginThrottledError
+	if !errors.As(err, &throttled) {
+		return false
+	}
+
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+	if throttled.Locked {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed login attempts, account temporarily locked"})
+	} else {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed login attempts, please wait before retrying"})
+	}
+	return true
+}
+
+// authorizeUser checks that the authenticated caller owns the account with
+// the given ID or is an admin. It writes the error response and returns false
+// when the caller is not allowed through.
+func authorizeUser(c *gin.Context, id string) bool {
+	claims, ok := middleware.GetClaims(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return false
+	}
+	if claims.UserID != id && !claims.IsAdmin() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not allowed to access this user"})
+		return false
+	}
+	return true
+}
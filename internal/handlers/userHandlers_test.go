@@ -0,0 +1,146 @@
+package handlers_test
+
+import (
+	"net/http"
+	"testing"
+
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/services"
+)
+
+func TestRegisterLoginRefreshLogout(t *testing.T) {
+	s := newTestServer(t, services.VerificationPolicy{})
+
+	w := s.request(http.MethodPost, "/user/register", "", map[string]string{"email": "user@example.com", "password": testPassword, "name": "User"})
+	expectStatus(t, w, http.StatusCreated)
+	if body := decode(t, w); body["token"] == nil || body["refresh_token"] == nil {
+		t.Fatalf("register response %v has no session", body)
+	}
+	w = s.request(http.MethodPost, "/user/register", "", map[string]string{"email": "user@example.com", "password": testPassword, "name": "User"})
+	expectStatus(t, w, http.StatusConflict)
+
+	w = s.request(http.MethodPost, "/user/login", "", map[string]string{"email": "user@example.com", "password": "wrong password"})
+	expectStatus(t, w, http.StatusUnauthorized)
+
+	w = s.request(http.MethodPost, "/user/login", "", map[string]string{"email": "user@example.com", "password": testPassword})
+	expectStatus(t, w, http.StatusOK)
+	login := decode(t, w)
+	access, _ := login["token"].(string)
+	refresh, _ := login["refresh_token"].(string)
+	if access == "" || refresh == "" {
+		t.Fatalf("login response %v has no session", login)
+	}
+
+	w = s.request(http.MethodPost, "/user/token/refresh", "", map[string]string{"refresh_token": refresh})
+	expectStatus(t, w, http.StatusOK)
+	rotated, _ := decode(t, w)["refresh_token"].(string)
+
+	// The old refresh token was rotated away
+	w = s.request(http.MethodPost, "/user/token/refresh", "", map[string]string{"refresh_token": refresh})
+	expectStatus(t, w, http.StatusUnauthorized)
+	w = s.request(http.MethodPost, "/user/token/refresh", "", map[string]string{"refresh_token": rotated})
+	expectStatus(t, w, http.StatusUnauthorized)
+
+	w = s.request(http.MethodPost, "/user/login", "", map[string]string{"email": "user@example.com", "password": testPassword})
+	expectStatus(t, w, http.StatusOK)
+	login = decode(t, w)
+	access, _ = login["token"].(string)
+	refresh, _ = login["refresh_token"].(string)
+
+	w = s.request(http.MethodPost, "/user/logout", "", nil)
+	expectStatus(t, w, http.StatusUnauthorized)
+	w = s.request(http.MethodPost, "/user/logout", access, nil)
+	expectStatus(t, w, http.StatusOK)
+	w = s.request(http.MethodPost, "/user/token/refresh", "", map[string]string{"refresh_token": refresh})
+	expectStatus(t, w, http.StatusUnauthorized)
+}
+
+func TestRegisterRequiringVerification(t *testing.T) {
+	s := newTestServer(t, services.VerificationPolicy{RequireForLogin: true})
+
+	w := s.request(http.MethodPost, "/user/register", "", map[string]string{"email": "user@example.com", "password": testPassword, "name": "User"})
+	expectStatus(t, w, http.StatusCreated)
+	if body := decode(t, w); body["token"] != nil || body["user"] == nil {
+		t.Fatalf("register response %v, want the user without a session", body)
+	}
+	if len(s.mail.Messages()) != 1 {
+		t.Fatalf("%d messages sent, want the verification email", len(s.mail.Messages()))
+	}
+
+	w = s.request(http.MethodPost, "/user/login", "", map[string]string{"email": "user@example.com", "password": testPassword})
+	expectStatus(t, w, http.StatusForbidden)
+}
+
+func TestLoginThrottled(t *testing.T) {
+	s := newTestServer(t, services.VerificationPolicy{})
+	s.createUser(t, "user@example.com", models.RoleCustomer)
+
+	// Failures past the first few are delayed
+	wrong := map[string]string{"email": "user@example.com", "password": "wrong password"}
+	for i := 0; i < 4; i++ {
+		expectStatus(t, s.request(http.MethodPost, "/user/login", "", wrong), http.StatusUnauthorized)
+	}
+	w := s.request(http.MethodPost, "/user/login", "", wrong)
+	expectStatus(t, w, http.StatusTooManyRequests)
+	if w.Header().Get("Retry-After") == "" {
+		t.Fatal("throttled response has no Retry-After header")
+	}
+}
+
+func TestUserAccess(t *testing.T) {
+	s := newTestServer(t, services.VerificationPolicy{})
+	alice := s.createUser(t, "alice@example.com", models.RoleCustomer)
+	bob := s.createUser(t, "bob@example.com", models.RoleCustomer)
+	admin := s.createUser(t, "admin@example.com", models.RoleAdmin)
+	aliceToken := s.accessToken(t, alice)
+
+	w := s.request(http.MethodGet, "/user/"+alice.ID, "", nil)
+	expectStatus(t, w, http.StatusUnauthorized)
+
+	w = s.request(http.MethodGet, "/user/"+alice.ID, aliceToken, nil)
+	expectStatus(t, w, http.StatusOK)
+	user, _ := decode(t, w)["user"].(map[string]any)
+	if user["forwarding_address"] != s.inbound.AddressFor(alice) {
+		t.Fatalf("user = %v, want the forwarding address", user)
+	}
+
+	for _, req := range []struct{ method, path string }{
+		{http.MethodGet, "/user/" + bob.ID},
+		{http.MethodPut, "/user/" + bob.ID},
+		{http.MethodDelete, "/user/" + bob.ID},
+		{http.MethodGet, "/user/" + bob.ID + "/points"},
+		{http.MethodPost, "/user/" + bob.ID + "/points/redeem"},
+		{http.MethodPost, "/user/" + bob.ID + "/forwarding-address/rotate"},
+	} {
+		w = s.request(req.method, req.path, aliceToken, map[string]any{})
+		expectStatus(t, w, http.StatusForbidden)
+	}
+
+	w = s.request(http.MethodGet, "/admin/users", aliceToken, nil)
+	expectStatus(t, w, http.StatusForbidden)
+
+	adminToken := s.accessToken(t, admin)
+	w = s.request(http.MethodGet, "/user/"+bob.ID, adminToken, nil)
+	expectStatus(t, w, http.StatusOK)
+	w = s.request(http.MethodGet, "/admin/users", adminToken, nil)
+	expectStatus(t, w, http.StatusOK)
+}
+
+func TestAdminSuspend(t *testing.T) {
+	s := newTestServer(t, services.VerificationPolicy{})
+	user := s.createUser(t, "user@example.com", models.RoleCustomer)
+	adminToken := s.accessToken(t, s.createUser(t, "admin@example.com", models.RoleAdmin))
+
+	w := s.request(http.MethodPost, "/admin/users/"+user.ID+"/suspend", adminToken, map[string]string{})
+	expectStatus(t, w, http.StatusBadRequest)
+	w = s.request(http.MethodPost, "/admin/users/"+user.ID+"/suspend", adminToken, map[string]string{"reason": "chargebacks"})
+	expectStatus(t, w, http.StatusOK)
+
+	w = s.request(http.MethodPost, "/user/login", "", map[string]string{"email": "user@example.com", "password": testPassword})
+	expectStatus(t, w, http.StatusForbidden)
+
+	w = s.request(http.MethodPost, "/admin/users/"+user.ID+"/unsuspend", adminToken, nil)
+	expectStatus(t, w, http.StatusOK)
+	w = s.request(http.MethodPost, "/user/login", "", map[string]string{"email": "user@example.com", "password": testPassword})
+	expectStatus(t, w, http.StatusOK)
+}
@@ -0,0 +1,103 @@
+package inbound
+
+import (
+	"regexp"
+	"strings"
+
+	"loyaltea-server/internal/sanitize"
+)
+
+var (
+	// forwardMarker starts an inline forwarded message in Gmail, Apple Mail
+	// and Outlook formats
+	forwardMarker = regexp.MustCompile(`(?im)^[ \t>]*(?:-{2,}[ \t]*(?:forwarded message|original message)[ \t]*-{2,}|begin forwarded message:|_{10,})[ \t]*$`)
+
+	// headerLine is one line of the header block after a forward marker. Names
+	// may be bold, as in "*From:*" or "*From*:".
+	headerLine = regexp.MustCompile(`^[ \t>]*\*?([A-Za-z-]+)\*?:\*?[ \t]*(.*)$`)
+
+	// subjectPrefix matches reply and forward prefixes in many languages
+	subjectPrefix = regexp.MustCompile(`(?i)^\s*((re|fwd?|fw|tr|wg|rv|enc|doorst)\s*:\s*)+`)
+)
+
+// unwrapInline replaces the sender, subject and body of the email with those
+// of the innermost message forwarded inline, as mail clients do when
+// forwarding without attaching the original. The HTML body is dropped when a
+// message is unwrapped, as it still holds the forwarder's note and the
+// forwarded header block.
+func (e *Email) unwrapInline() {
+	text := e.Text
+	if text == "" {
+		text = sanitize.Text(e.HTML)
+	}
+
+	for depth := 0; depth < maxDepth; depth++ {
+		loc := forwardMarker.FindStringIndex(text)
+		if loc == nil {
+			break
+		}
+		headers, rest := parseForwardedHeaders(text[loc[1]:])
+		if headers["From"] == "" && headers["Subject"] == "" {
+			break
+		}
+
+		if from := headers["From"]; from != "" {
+			e.From = strings.ReplaceAll(from, "*", "")
+			e.Headers = map[string]string{"From": e.From}
+			if date := headers["Date"]; date != "" {
+				e.Headers["Date"] = date
+			} else if sent := headers["Sent"]; sent != "" {
+				e.Headers["Date"] = sent
+			}
+		}
+		if subject := headers["Subject"]; subject != "" {
+			e.Subject = subject
+		}
+		text = unquote(rest)
+		e.Text = strings.TrimSpace(text)
+		e.HTML = ""
+	}
+
+	e.Subject = strings.TrimSpace(subjectPrefix.ReplaceAllString(e.Subject, ""))
+}
+
+// parseForwardedHeaders reads the "Key: value" lines at the start of text up
+// to the first blank line, and returns them with the text that follows
+func parseForwardedHeaders(text string) (map[string]string, string) {
+	headers := map[string]string{}
+	lines := strings.Split(text, "\n")
+	i := 0
+	// Skip blank lines between the marker and the headers
+	for i < len(lines) && strings.Trim(lines[i], " \t\r>") == "" {
+		i++
+	}
+	for ; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], "\r")
+		if strings.Trim(line, " \t>") == "" {
+			break
+		}
+		m := headerLine.FindStringSubmatch(line)
+		if m == nil {
+			break
+		}
+		key := strings.ToUpper(m[1][:1]) + strings.ToLower(m[1][1:])
+		headers[key] = strings.TrimSpace(m[2])
+	}
+	return headers, strings.Join(lines[i:], "\n")
+}
+
+// unquote removes one level of "> " quoting if every line of text is quoted
+func unquote(text string) string {
+	lines := strings.Split(text, "\n")
+	for _, line := range lines {
+		if strings.TrimSpace(line) != "" && !strings.HasPrefix(strings.TrimLeft(line, " \t"), ">") {
+			return text
+		}
+	}
+	for i, line := range lines {
+		line = strings.TrimLeft(line, " \t")
+		line = strings.TrimPrefix(line, ">")
+		lines[i] = strings.TrimPrefix(line, " ")
+	}
+	return strings.Join(lines, "\n")
+}
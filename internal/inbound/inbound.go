@@ -0,0 +1,251 @@
+// Package inbound turns raw forwarded emails into offers. It walks the MIME
+// tree of a message, decodes transfer encodings and charsets, and unwraps
+// forwarded messages to find who originally sent the offer.
+package inbound
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/sanitize"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// maxDepth bounds how deeply nested parts and forwarded messages are followed
+const maxDepth = 10
+
+var (
+	ErrNoSender       = errors.New("message has no sender")
+	ErrNoBody         = errors.New("message has no text or HTML body")
+	ErrInvalidMessage = errors.New("invalid message")
+)
+
+// Email is a parsed forwarded email
+type Email struct {
+	Forwarder  string            // Address the email was forwarded from
+	Recipients []string          // Addresses the forward was delivered to, lower cased
+	From       string            // From of the original message, as a raw header value
+	Subject    string            // Subject of the original message
+	Text       string            // Plain text body of the original message
+	HTML       string            // HTML body of the original message, if it had one
+	Headers    map[string]string // Headers of the original message that are known
+
+	forwarded bool // An attached message/rfc822 has been read, later outer parts are not part of it
+}
+
+// Parse reads a raw RFC 5322 message
+func Parse(r io.Reader) (*Email, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	forwarder, err := mail.ParseAddress(decodeHeader(msg.Header.Get("From")))
+	if err != nil {
+		return nil, ErrNoSender
+	}
+
+	email := &Email{
+		Forwarder:  strings.ToLower(forwarder.Address),
+		Recipients: recipients(msg.Header),
+	}
+	if err := email.read(msg.Header, msg.Body, 0); err != nil {
+		return nil, err
+	}
+	if email.Text == "" && email.HTML == "" {
+		return nil, ErrNoBody
+	}
+	email.unwrapInline()
+	return email, nil
+}
+
+// Offer converts the email into an offer from its forwarder
+func (e *Email) Offer() *models.Offer {
+	body := e.Text
+	if body == "" {
+		body = sanitize.Text(e.HTML)
+	}
+	return &models.Offer{
+		SenderEmail:  e.Forwarder,
+		Subject:      e.Subject,
+		Body:         strings.TrimSpace(body),
+		OriginalHTML: e.HTML,
+		Source:       "email",
+		Headers:      e.Headers,
+	}
+}
+
+// read takes the headers and bodies of a message. A message attached as
+// message/rfc822 is the one forwarded, so its headers and body replace the
+// outer ones.
+func (e *Email) read(header mail.Header, body io.Reader, depth int) error {
+	e.Subject = decodeHeader(header.Get("Subject"))
+	e.From = decodeHeader(header.Get("From"))
+	e.Headers = knownHeaders(header)
+	e.Text, e.HTML = "", ""
+	return e.readPart(textproto.MIMEHeader(header), body, depth)
+}
+
+// readPart reads one MIME part, recursing into multiparts and forwarded messages
+func (e *Email) readPart(header textproto.MIMEHeader, body io.Reader, depth int) error {
+	if depth > maxDepth {
+		return nil
+	}
+
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+	if strings.HasPrefix(header.Get("Content-Disposition"), "attachment") && mediaType != "message/rfc822" {
+		return nil
+	}
+
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/"):
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := e.readPart(part.Header, part, depth+1); err != nil {
+				return err
+			}
+		}
+
+	case mediaType == "message/rfc822":
+		// Only the first forwarded message is read
+		if e.forwarded {
+			return nil
+		}
+		inner, err := mail.ReadMessage(decodeTransfer(header.Get("Content-Transfer-Encoding"), body))
+		if err != nil {
+			return nil
+		}
+		if err := e.read(inner.Header, inner.Body, depth+1); err != nil {
+			return err
+		}
+		e.forwarded = true
+		return nil
+
+	case mediaType == "text/plain" || mediaType == "text/html":
+		data, err := io.ReadAll(decodeTransfer(header.Get("Content-Transfer-Encoding"), body))
+		if err != nil {
+			return err
+		}
+		text := strings.ReplaceAll(decodeCharset(data, params["charset"]), "\r\n", "\n")
+		// The first part of each kind is the body, later ones are usually
+		// footers. Parts after a forwarded message, such as a mailing list
+		// footer, belong to the forward rather than to that message.
+		if e.forwarded {
+			return nil
+		}
+		if mediaType == "text/plain" && e.Text == "" {
+			e.Text = text
+		}
+		if mediaType == "text/html" && e.HTML == "" {
+			e.HTML = text
+		}
+	}
+	return nil
+}
+
+// recipientHeaders are the headers of the forward that may name the
+// forwarding address, most specific first
+var recipientHeaders = []string{"Delivered-To", "X-Original-To", "X-Forwarded-To", "To", "Cc"}
+
+// recipients returns the distinct addresses the forward was delivered to
+func recipients(header mail.Header) []string {
+	seen := map[string]bool{}
+	addresses := []string{}
+	for _, key := range recipientHeaders {
+		for _, value := range header[key] {
+			list, err := mail.ParseAddressList(decodeHeader(value))
+			if err != nil {
+				// Delivered-To and friends are often a bare address
+				list = []*mail.Address{{Address: strings.TrimSpace(value)}}
+			}
+			for _, a := range list {
+				address := strings.ToLower(a.Address)
+				if address != "" && !seen[address] {
+					seen[address] = true
+					addresses = append(addresses, address)
+				}
+			}
+		}
+	}
+	return addresses
+}
+
+// knownHeaders keeps the headers of a message used to understand the offer
+func knownHeaders(header mail.Header) map[string]string {
+	headers := map[string]string{}
+	for _, key := range []string{"From", "Reply-To", "Date", "List-Unsubscribe", "List-Id", "Message-Id"} {
+		if value := header.Get(key); value != "" {
+			headers[key] = decodeHeader(value)
+		}
+	}
+	return headers
+}
+
+// decodeTransfer undoes a Content-Transfer-Encoding
+func decodeTransfer(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, body)
+	}
+	return body
+}
+
+// decodeCharset converts text in charset to UTF-8. Unknown charsets are
+// passed through as they are mostly ASCII compatible.
+func decodeCharset(data []byte, charset string) string {
+	if charset == "" || strings.EqualFold(charset, "utf-8") || strings.EqualFold(charset, "us-ascii") {
+		return string(bytes.ToValidUTF8(data, []byte("�")))
+	}
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return string(bytes.ToValidUTF8(data, []byte("�")))
+	}
+	decoded, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return string(bytes.ToValidUTF8(data, []byte("�")))
+	}
+	return string(decoded)
+}
+
+// wordDecoder decodes RFC 2047 encoded words in any charset htmlindex knows
+var wordDecoder = &mime.WordDecoder{
+	CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+		enc, err := htmlindex.Get(charset)
+		if err != nil {
+			return nil, err
+		}
+		return enc.NewDecoder().Reader(input), nil
+	},
+}
+
+// decodeHeader decodes RFC 2047 encoded words, leaving the header as it is
+// if it cannot be decoded
+func decodeHeader(value string) string {
+	decoded, err := wordDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
@@ -0,0 +1,205 @@
+package inbound_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"loyaltea-server/internal/inbound"
+)
+
+// parseFixture parses the message in testdata/name
+func parseFixture(t *testing.T, name string) (*inbound.Email, error) {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	return inbound.Parse(f)
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		file       string
+		forwarder  string
+		recipients []string
+		from       string
+		subject    string
+		text       string
+		html       string
+		headers    map[string]string
+	}{
+		{
+			file:       "alternative.eml",
+			forwarder:  "alice@example.com",
+			recipients: []string{"u-abc123@in.loyaltea.test", "offers@in.loyaltea.test"},
+			from:       "Alice Example <Alice@Example.com>",
+			subject:    "Spring sale",
+			text:       "20% off everything this weekend.",
+			html:       "<p>20% off <b>everything</b> this weekend.</p>",
+			headers: map[string]string{
+				"From": "Alice Example <Alice@Example.com>",
+				"Date": "Wed, 11 Mar 2026 10:00:00 +0000",
+			},
+		},
+		{
+			file:       "quoted_printable.eml",
+			forwarder:  "alice@example.com",
+			recipients: []string{"u-abc123@in.loyaltea.test"},
+			from:       "alice@example.com",
+			subject:    "Coffee deal",
+			text:       "Save €5 on any latte with code BREW5. This line is long enough to be wrapped by a soft line break.",
+			headers:    map[string]string{"From": "alice@example.com"},
+		},
+		{
+			file:       "base64_html.eml",
+			forwarder:  "alice@example.com",
+			recipients: []string{"u-abc123@in.loyaltea.test"},
+			from:       "alice@example.com",
+			subject:    "Flash sale",
+			html:       "<html><body><h1>Flash sale</h1><p>Half price on all jackets.</p></body></html>",
+			headers:    map[string]string{"From": "alice@example.com"},
+		},
+		{
+			file:       "latin1.eml",
+			forwarder:  "alice@example.com",
+			recipients: []string{"u-abc123@in.loyaltea.test"},
+			from:       "alice@example.com",
+			subject:    "Café offer",
+			text:       "Café crème for £3.",
+			html:       "<p>Only €5 – this week.</p>",
+			headers:    map[string]string{"From": "alice@example.com"},
+		},
+		{
+			file:       "encoded_subject.eml",
+			forwarder:  "zoe@example.com",
+			recipients: []string{"u-abc123@in.loyaltea.test"},
+			from:       "Zöe Example <zoe@example.com>",
+			subject:    "🎉 Party time: 50% réduction",
+			text:       "Everything half price.",
+			headers:    map[string]string{"From": "Zöe Example <zoe@example.com>"},
+		},
+		{
+			// The attached original replaces the forward, and the footer
+			// after it is not part of the original
+			file:       "attached_rfc822.eml",
+			forwarder:  "alice@example.com",
+			recipients: []string{"u-abc123@in.loyaltea.test"},
+			from:       "Bean & Leaf <news@beanandleaf.test>",
+			subject:    "Members weekend: free pastry",
+			text:       "A free pastry with every coffee, members only.",
+			html:       "<p>A free pastry with every coffee, <i>members only</i>.</p>",
+			headers: map[string]string{
+				"From":             "Bean & Leaf <news@beanandleaf.test>",
+				"Date":             "Tue, 10 Mar 2026 08:30:00 +0000",
+				"List-Unsubscribe": "<https://beanandleaf.test/unsubscribe>",
+			},
+		},
+		{
+			// A forward of a forward is unwrapped down to the shop's email
+			file:       "nested_forward.eml",
+			forwarder:  "alice@example.com",
+			recipients: []string{"u-abc123@in.loyaltea.test"},
+			from:       "Shoe Shop <deals@shoeshop.test>",
+			subject:    "Weekend deal",
+			text:       "Buy one pair, get the second half price.\nEnds Sunday.",
+			headers: map[string]string{
+				"From": "Shoe Shop <deals@shoeshop.test>",
+				"Date": "8 March 2026 at 18:00:00 GMT",
+			},
+		},
+		{
+			// Outlook marks up the header block and calls the date Sent
+			file:       "outlook_forward.eml",
+			forwarder:  "alice@example.com",
+			recipients: []string{"u-abc123@in.loyaltea.test"},
+			from:       "Bookshop <hello@bookshop.test>",
+			subject:    "Your exclusive code",
+			text:       "Use READ10 for 10% off your next order.",
+			headers: map[string]string{
+				"From": "Bookshop <hello@bookshop.test>",
+				"Date": "Thursday, March 5, 2026 7:15 AM",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			email, err := parseFixture(t, tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if email.Forwarder != tt.forwarder {
+				t.Errorf("forwarder = %q, want %q", email.Forwarder, tt.forwarder)
+			}
+			if !reflect.DeepEqual(email.Recipients, tt.recipients) {
+				t.Errorf("recipients = %q, want %q", email.Recipients, tt.recipients)
+			}
+			if email.From != tt.from {
+				t.Errorf("from = %q, want %q", email.From, tt.from)
+			}
+			if email.Subject != tt.subject {
+				t.Errorf("subject = %q, want %q", email.Subject, tt.subject)
+			}
+			if text := strings.TrimSpace(email.Text); text != tt.text {
+				t.Errorf("text = %q, want %q", text, tt.text)
+			}
+			if html := strings.TrimSpace(email.HTML); html != tt.html {
+				t.Errorf("html = %q, want %q", html, tt.html)
+			}
+			if !reflect.DeepEqual(email.Headers, tt.headers) {
+				t.Errorf("headers = %q, want %q", email.Headers, tt.headers)
+			}
+		})
+	}
+}
+
+func TestParseOffer(t *testing.T) {
+	email, err := parseFixture(t, "base64_html.eml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	offer := email.Offer()
+	if offer.SenderEmail != "alice@example.com" || offer.Source != "email" {
+		t.Fatalf("offer = %+v, want an email offer from alice@example.com", offer)
+	}
+	// Without a text part the body is the text of the HTML
+	if !strings.Contains(offer.Body, "Half price on all jackets.") || strings.Contains(offer.Body, "<p>") {
+		t.Fatalf("body = %q, want the text of the HTML", offer.Body)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		err     error
+	}{
+		{
+			name:    "no sender",
+			message: "To: u-abc123@in.loyaltea.test\nSubject: Sale\n\nHello\n",
+			err:     inbound.ErrNoSender,
+		},
+		{
+			name:    "unparsable sender",
+			message: "From: not an address\nSubject: Sale\n\nHello\n",
+			err:     inbound.ErrNoSender,
+		},
+		{
+			name:    "only an attachment",
+			message: "From: alice@example.com\nContent-Type: multipart/mixed; boundary=b\n\n--b\nContent-Type: application/pdf\nContent-Disposition: attachment; filename=a.pdf\n\nJVBERi0=\n--b--\n",
+			err:     inbound.ErrNoBody,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := inbound.Parse(strings.NewReader(tt.message)); !errors.Is(err, tt.err) {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package inbound
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"path"
+	"sort"
+	"strings"
+)
+
+// ParseMailgun reads the form Mailgun posts to an inbound route webhook.
+// Mailgun has already decoded the message, so the bodies are taken from the
+// body-plain and body-html fields, falling back to stripped-text and
+// stripped-html. stripped-text drops quoted text, which for a forward is
+// usually the offer itself, so it is only used when nothing else was sent.
+// A forwarded message sent as an attachment replaces the outer one, as in
+// Parse. A message-headers field that is not valid JSON is an
+// ErrInvalidMessage.
+func ParseMailgun(form *multipart.Form) (*Email, error) {
+	value := func(key string) string {
+		if values := form.Value[key]; len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+
+	header := mail.Header{}
+	if raw := value("message-headers"); raw != "" {
+		var pairs [][2]string
+		if err := json.Unmarshal([]byte(raw), &pairs); err != nil {
+			return nil, fmt.Errorf("%w: message-headers: %v", ErrInvalidMessage, err)
+		}
+		for _, pair := range pairs {
+			key := textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(pair[0]))
+			header[key] = append(header[key], pair[1])
+		}
+	}
+
+	sender := value("sender")
+	if sender == "" {
+		sender = value("from")
+	}
+	forwarder, err := mail.ParseAddress(decodeHeader(sender))
+	if err != nil {
+		return nil, ErrNoSender
+	}
+
+	email := &Email{
+		Forwarder:  strings.ToLower(forwarder.Address),
+		Recipients: mailgunRecipients(value("recipient"), header),
+		From:       decodeHeader(value("from")),
+		Subject:    decodeHeader(value("subject")),
+		Text:       strings.ReplaceAll(firstNonEmpty(value("body-plain"), value("stripped-text")), "\r\n", "\n"),
+		HTML:       firstNonEmpty(value("body-html"), value("stripped-html")),
+		Headers:    knownHeaders(header),
+	}
+
+	if err := email.readAttachments(form.File); err != nil {
+		return nil, err
+	}
+	if email.Text == "" && email.HTML == "" {
+		return nil, ErrNoBody
+	}
+	email.unwrapInline()
+	return email, nil
+}
+
+// readAttachments reads the first attachment that is a forwarded message
+func (e *Email) readAttachments(files map[string][]*multipart.FileHeader) error {
+	keys := make([]string, 0, len(files))
+	for key := range files {
+		if strings.HasPrefix(key, "attachment-") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		for _, file := range files[key] {
+			mediaType, _, _ := mime.ParseMediaType(file.Header.Get("Content-Type"))
+			if mediaType != "message/rfc822" && !strings.EqualFold(path.Ext(file.Filename), ".eml") {
+				continue
+			}
+
+			f, err := file.Open()
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			msg, err := mail.ReadMessage(f)
+			if err != nil {
+				continue
+			}
+			return e.read(msg.Header, msg.Body, 1)
+		}
+	}
+	return nil
+}
+
+// mailgunRecipients returns the envelope recipients followed by any other
+// address the forward was delivered to
+func mailgunRecipients(envelope string, header mail.Header) []string {
+	seen := map[string]bool{}
+	addresses := []string{}
+	for _, address := range strings.Split(envelope, ",") {
+		address = strings.ToLower(strings.TrimSpace(address))
+		if address != "" && !seen[address] {
+			seen[address] = true
+			addresses = append(addresses, address)
+		}
+	}
+	for _, address := range recipients(header) {
+		if !seen[address] {
+			seen[address] = true
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,261 @@
+package inbound_test
+
+import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"loyaltea-server/internal/inbound"
+)
+
+// attachment is a file in a Mailgun form
+type attachment struct {
+	field       string
+	filename    string
+	contentType string
+	content     []byte
+}
+
+// mailgunForm builds the multipart form Mailgun posts for a message
+func mailgunForm(t *testing.T, values map[string]string, files ...attachment) *multipart.Form {
+	t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for key, value := range values {
+		if err := w.WriteField(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range files {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", `form-data; name="`+file.field+`"; filename="`+file.filename+`"`)
+		header.Set("Content-Type", file.contentType)
+		part, err := w.CreatePart(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(file.content)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	form, err := multipart.NewReader(&body, w.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { form.RemoveAll() })
+	return form
+}
+
+// readFixture returns the contents of testdata/name
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestParseMailgun(t *testing.T) {
+	form := mailgunForm(t, map[string]string{
+		"recipient":  "U-ABC123@in.loyaltea.test, other@in.loyaltea.test",
+		"sender":     "alice@example.com",
+		"from":       "Alice <alice@example.com>",
+		"subject":    "Fwd: =?UTF-8?Q?Caf=C3=A9?= deals",
+		"body-plain": "Two for one on lattes.\r\nToday only.\r\n",
+		"body-html":  "<p>Two for one on lattes.</p>",
+		"message-headers": `[
+			["Delivered-To", "u-abc123@in.loyaltea.test"],
+			["To", "Offers <offers@in.loyaltea.test>"],
+			["From", "Alice <alice@example.com>"],
+			["List-Unsubscribe", "<https://cafe.test/unsubscribe>"],
+			["X-Mailgun-Spf", "Pass"]
+		]`,
+	})
+
+	email, err := inbound.ParseMailgun(form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Envelope recipients first, then those in the headers not already seen
+	recipients := []string{"u-abc123@in.loyaltea.test", "other@in.loyaltea.test", "offers@in.loyaltea.test"}
+	if !reflect.DeepEqual(email.Recipients, recipients) {
+		t.Errorf("recipients = %q, want %q", email.Recipients, recipients)
+	}
+	if email.Forwarder != "alice@example.com" || email.From != "Alice <alice@example.com>" || email.Subject != "Café deals" {
+		t.Errorf("email = %+v, want alice's forward", email)
+	}
+	if email.Text != "Two for one on lattes.\nToday only.\n" || email.HTML != "<p>Two for one on lattes.</p>" {
+		t.Errorf("bodies = %q, %q", email.Text, email.HTML)
+	}
+	headers := map[string]string{"From": "Alice <alice@example.com>", "List-Unsubscribe": "<https://cafe.test/unsubscribe>"}
+	if !reflect.DeepEqual(email.Headers, headers) {
+		t.Errorf("headers = %q, want %q", email.Headers, headers)
+	}
+}
+
+func TestParseMailgunFallbacks(t *testing.T) {
+	tests := []struct {
+		name      string
+		values    map[string]string
+		forwarder string
+		text      string
+		html      string
+	}{
+		{
+			name: "stripped text without a plain body",
+			values: map[string]string{
+				"sender":        "alice@example.com",
+				"stripped-text": "Only the reply.",
+			},
+			forwarder: "alice@example.com",
+			text:      "Only the reply.",
+		},
+		{
+			name: "plain body preferred over stripped text",
+			values: map[string]string{
+				"sender":        "alice@example.com",
+				"body-plain":    "Full body.",
+				"stripped-text": "Stripped.",
+			},
+			forwarder: "alice@example.com",
+			text:      "Full body.",
+		},
+		{
+			name: "blank plain body",
+			values: map[string]string{
+				"sender":        "alice@example.com",
+				"body-plain":    " \r\n",
+				"stripped-text": "Stripped.",
+			},
+			forwarder: "alice@example.com",
+			text:      "Stripped.",
+		},
+		{
+			name: "stripped html",
+			values: map[string]string{
+				"sender":        "alice@example.com",
+				"stripped-html": "<p>Stripped.</p>",
+			},
+			forwarder: "alice@example.com",
+			html:      "<p>Stripped.</p>",
+		},
+		{
+			name: "from when there is no sender",
+			values: map[string]string{
+				"from":       "=?UTF-8?Q?Z=C3=B6e?= <Zoe@Example.com>",
+				"body-plain": "Hello.",
+			},
+			forwarder: "zoe@example.com",
+			text:      "Hello.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email, err := inbound.ParseMailgun(mailgunForm(t, tt.values))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if email.Forwarder != tt.forwarder || email.Text != tt.text || email.HTML != tt.html {
+				t.Fatalf("email = %+v, want forwarder %q, text %q and html %q", email, tt.forwarder, tt.text, tt.html)
+			}
+		})
+	}
+}
+
+func TestParseMailgunAttachments(t *testing.T) {
+	original := readFixture(t, "alternative.eml")
+	tests := []struct {
+		name    string
+		files   []attachment
+		subject string
+	}{
+		{
+			name:    "message/rfc822",
+			files:   []attachment{{"attachment-1", "forward", "message/rfc822", original}},
+			subject: "Spring sale",
+		},
+		{
+			name:    "eml file of another type",
+			files:   []attachment{{"attachment-1", "Spring sale.EML", "application/octet-stream", original}},
+			subject: "Spring sale",
+		},
+		{
+			name:    "other attachments are ignored",
+			files:   []attachment{{"attachment-1", "terms.pdf", "application/pdf", []byte("%PDF-1.4")}},
+			subject: "Look at this",
+		},
+		{
+			name: "first forwarded message by field name",
+			files: []attachment{
+				{"attachment-2", "second.eml", "message/rfc822", []byte("From: b@example.com\nSubject: Second\n\nSecond body\n")},
+				{"attachment-1", "first.eml", "message/rfc822", original},
+			},
+			subject: "Spring sale",
+		},
+		{
+			name: "unreadable message is skipped",
+			files: []attachment{
+				{"attachment-1", "broken.eml", "message/rfc822", []byte("not a message")},
+				{"attachment-2", "good.eml", "message/rfc822", original},
+			},
+			subject: "Spring sale",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := mailgunForm(t, map[string]string{
+				"recipient":  "u-abc123@in.loyaltea.test",
+				"sender":     "bob@example.com",
+				"from":       "bob@example.com",
+				"subject":    "Look at this",
+				"body-plain": "Thought you would like this.",
+			}, tt.files...)
+
+			email, err := inbound.ParseMailgun(form)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if email.Subject != tt.subject {
+				t.Fatalf("subject = %q, want %q", email.Subject, tt.subject)
+			}
+			// The forwarder stays the one who posted the forward
+			if email.Forwarder != "bob@example.com" {
+				t.Fatalf("forwarder = %q, want bob@example.com", email.Forwarder)
+			}
+			if tt.subject == "Spring sale" {
+				if email.From != "Alice Example <Alice@Example.com>" || email.Text != "20% off everything this weekend." {
+					t.Fatalf("email = %+v, want the attached message", email)
+				}
+			}
+		})
+	}
+}
+
+func TestParseMailgunErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		values map[string]string
+		err    error
+	}{
+		{"no sender", map[string]string{"body-plain": "Hello."}, inbound.ErrNoSender},
+		{"invalid sender", map[string]string{"sender": "not an address", "body-plain": "Hello."}, inbound.ErrNoSender},
+		{"no body", map[string]string{"sender": "alice@example.com", "body-plain": "  "}, inbound.ErrNoBody},
+		{"headers not JSON", map[string]string{"sender": "alice@example.com", "body-plain": "Hello.", "message-headers": "From: alice@example.com"}, inbound.ErrInvalidMessage},
+		{"headers not pairs", map[string]string{"sender": "alice@example.com", "body-plain": "Hello.", "message-headers": `{"From": "alice@example.com"}`}, inbound.ErrInvalidMessage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := inbound.ParseMailgun(mailgunForm(t, tt.values)); !errors.Is(err, tt.err) {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
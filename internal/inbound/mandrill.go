@@ -0,0 +1,58 @@
+package inbound
+
+import (
+	"strings"
+)
+
+// MandrillMessage is the msg of a Mandrill inbound event
+type MandrillMessage struct {
+	RawMsg    string `json:"raw_msg"`
+	Email     string `json:"email"` // Inbound address the message was sent to
+	FromEmail string `json:"from_email"`
+	Subject   string `json:"subject"`
+	Text      string `json:"text"`
+	HTML      string `json:"html"`
+}
+
+// ParseMandrill reads the message of a Mandrill inbound event. The raw
+// message is parsed when Mandrill sends one, as it keeps attachments and
+// headers the other fields leave out.
+func ParseMandrill(msg MandrillMessage) (*Email, error) {
+	recipient := strings.ToLower(strings.TrimSpace(msg.Email))
+
+	if msg.RawMsg != "" {
+		email, err := Parse(strings.NewReader(msg.RawMsg))
+		if err != nil {
+			return nil, err
+		}
+		if recipient != "" {
+			recipients := []string{recipient}
+			for _, address := range email.Recipients {
+				if address != recipient {
+					recipients = append(recipients, address)
+				}
+			}
+			email.Recipients = recipients
+		}
+		return email, nil
+	}
+
+	if msg.FromEmail == "" {
+		return nil, ErrNoSender
+	}
+	email := &Email{
+		Forwarder: strings.ToLower(msg.FromEmail),
+		From:      msg.FromEmail,
+		Subject:   msg.Subject,
+		Text:      strings.ReplaceAll(msg.Text, "\r\n", "\n"),
+		HTML:      msg.HTML,
+	}
+	if recipient != "" {
+		email.Recipients = []string{recipient}
+	}
+	if email.Text == "" && email.HTML == "" {
+		return nil, ErrNoBody
+	}
+	email.unwrapInline()
+	return email, nil
+}
@@ -0,0 +1,205 @@
+package marketing
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Mailchimp manages the members of a Mailchimp audience through the
+// Marketing API
+type Mailchimp struct {
+	APIKey  string
+	ListID  string
+	BaseURL string // API root, such as https://us6.api.mailchimp.com/3.0
+	Client  *http.Client
+}
+
+// NewMailchimp creates a Mailchimp provider. The API key ends with the
+// datacenter, such as us6, which picks the API root.
+func NewMailchimp(apiKey string, listID string) (*Mailchimp, error) {
+	if apiKey == "" || listID == "" {
+		return nil, fmt.Errorf("mailchimp API key or list ID not set")
+	}
+	i := strings.LastIndex(apiKey, "-")
+	if i < 0 || i == len(apiKey)-1 {
+		return nil, fmt.Errorf("mailchimp API key has no datacenter suffix")
+	}
+	// The datacenter becomes part of the host the key is sent to
+	datacenter := apiKey[i+1:]
+	if strings.TrimFunc(datacenter, isAlphanumeric) != "" {
+		return nil, fmt.Errorf("mailchimp API key has an invalid datacenter suffix")
+	}
+
+	return &Mailchimp{
+		APIKey:  apiKey,
+		ListID:  listID,
+		BaseURL: fmt.Sprintf("https://%s.api.mailchimp.com/3.0", datacenter),
+		Client:  &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// isAlphanumeric reports whether r is an ASCII letter or digit
+func isAlphanumeric(r rune) bool {
+	return ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9')
+}
+
+// APIError is an error response from the Mailchimp API
+type APIError struct {
+	StatusCode int
+	Title      string `json:"title"`
+	Detail     string `json:"detail"`
+}
+
+func (e *APIError) Error() string {
+	if e.Title == "" {
+		return fmt.Sprintf("mailchimp API error: %d", e.StatusCode)
+	}
+	if e.Detail == "" {
+		return fmt.Sprintf("mailchimp API error: %d %s", e.StatusCode, e.Title)
+	}
+	return fmt.Sprintf("mailchimp API error: %d %s: %s", e.StatusCode, e.Title, e.Detail)
+}
+
+// Temporary reports whether the request may succeed if sent again. Other
+// errors, such as an invalid address, will fail every time.
+func (e *APIError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// subscriberHash is the ID Mailchimp gives a member, the MD5 of the lower
+// cased address
+func subscriberHash(email string) string {
+	sum := md5.Sum([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return hex.EncodeToString(sum[:])
+}
+
+// Subscribe adds an address to the list. It is a PUT on the member, so
+// subscribing an existing member succeeds, and status_if_new leaves the
+// status of members who unsubscribed alone.
+func (m *Mailchimp) Subscribe(ctx context.Context, email string) error {
+	body := map[string]interface{}{
+		"email_address": email,
+		"status_if_new": StatusSubscribed,
+	}
+	return m.do(ctx, http.MethodPut, m.memberURL(email), body, nil)
+}
+
+// Unsubscribe marks a member as unsubscribed
+func (m *Mailchimp) Unsubscribe(ctx context.Context, email string) error {
+	body := map[string]interface{}{"status": StatusUnsubscribed}
+	return m.do(ctx, http.MethodPatch, m.memberURL(email), body, nil)
+}
+
+// UpdateTags adds and removes tags on a member
+func (m *Mailchimp) UpdateTags(ctx context.Context, email string, add []string, remove []string) error {
+	tags := []map[string]string{}
+	for _, name := range add {
+		tags = append(tags, map[string]string{"name": name, "status": "active"})
+	}
+	for _, name := range remove {
+		tags = append(tags, map[string]string{"name": name, "status": "inactive"})
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	body := map[string]interface{}{"tags": tags}
+	return m.do(ctx, http.MethodPost, m.memberURL(email)+"/tags", body, nil)
+}
+
+// UpdateMergeFields sets merge fields on a member
+func (m *Mailchimp) UpdateMergeFields(ctx context.Context, email string, fields map[string]string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	body := map[string]interface{}{"merge_fields": fields}
+	return m.do(ctx, http.MethodPatch, m.memberURL(email), body, nil)
+}
+
+// Status fetches a member
+func (m *Mailchimp) Status(ctx context.Context, email string) (*Member, error) {
+	var resp struct {
+		EmailAddress string                 `json:"email_address"`
+		Status       string                 `json:"status"`
+		MergeFields  map[string]interface{} `json:"merge_fields"`
+		Tags         []struct {
+			Name string `json:"name"`
+		} `json:"tags"`
+	}
+	err := m.do(ctx, http.MethodGet, m.memberURL(email), nil, &resp)
+	if apiErr, ok := err.(*APIError); ok && apiErr.StatusCode == http.StatusNotFound {
+		return nil, ErrNotMember
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	member := &Member{
+		Email:       resp.EmailAddress,
+		Status:      resp.Status,
+		MergeFields: map[string]string{},
+	}
+	for _, tag := range resp.Tags {
+		member.Tags = append(member.Tags, tag.Name)
+	}
+	for key, value := range resp.MergeFields {
+		// merge fields may be numbers or, for addresses, objects
+		if s, ok := value.(string); ok {
+			member.MergeFields[key] = s
+		}
+	}
+	return member, nil
+}
+
+func (m *Mailchimp) membersURL() string {
+	return m.BaseURL + "/lists/" + m.ListID + "/members"
+}
+
+func (m *Mailchimp) memberURL(email string) string {
+	return m.membersURL() + "/" + subscriberHash(email)
+}
+
+// do sends a request with a JSON body, if any, and decodes the JSON response
+// into out, if given. Non-2xx responses are returned as an *APIError.
+func (m *Mailchimp) do(ctx context.Context, method string, url string, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.SetBasicAuth("anystring", m.APIKey) // Mailchimp uses anystring:apikey for basic auth
+
+	resp, err := m.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(apiErr)
+		return apiErr
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package marketing_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+
+	"loyaltea-server/internal/marketing"
+)
+
+const (
+	testAPIKey = "0123456789abcdef-us6"
+	testListID = "list123"
+
+	// memberPath is the member path of Alice@Example.com, whose subscriber
+	// hash is the MD5 of alice@example.com
+	memberPath = "/3.0/lists/list123/members/c160f8cc69a4f0bf2b0362752353d060"
+)
+
+// request is what the fake API received
+type request struct {
+	method string
+	path   string
+	body   map[string]any
+}
+
+// fakeAPI serves the Mailchimp API with handler and records the requests it
+// receives. Requests without the API key are refused.
+func fakeAPI(t *testing.T, handler http.HandlerFunc) (*marketing.Mailchimp, *[]request) {
+	t.Helper()
+	var requests []request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, key, ok := r.BasicAuth(); !ok || key != testAPIKey {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		received := request{method: r.Method, path: r.URL.Path}
+		if data, _ := io.ReadAll(r.Body); len(data) > 0 {
+			if r.Header.Get("Content-Type") != "application/json" {
+				t.Errorf("%s %s: content type = %q", r.Method, r.URL.Path, r.Header.Get("Content-Type"))
+			}
+			if err := json.Unmarshal(data, &received.body); err != nil {
+				t.Errorf("%s %s: body %s: %v", r.Method, r.URL.Path, data, err)
+			}
+		}
+		requests = append(requests, received)
+		if handler != nil {
+			handler(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	m, err := marketing.NewMailchimp(testAPIKey, testListID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.BaseURL = server.URL + "/3.0"
+	m.Client = server.Client()
+	return m, &requests
+}
+
+// respond answers with status and a JSON body
+func respond(status int, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}
+}
+
+func TestMailchimpSubscribe(t *testing.T) {
+	m, requests := fakeAPI(t, nil)
+
+	// The member is found by the hash of the lower cased address, and the
+	// status of existing members is left alone
+	if err := m.Subscribe(context.Background(), "Alice@Example.com"); err != nil {
+		t.Fatal(err)
+	}
+	want := []request{{
+		method: http.MethodPut,
+		path:   memberPath,
+		body:   map[string]any{"email_address": "Alice@Example.com", "status_if_new": "subscribed"},
+	}}
+	if !reflect.DeepEqual(*requests, want) {
+		t.Fatalf("requests = %+v, want %+v", *requests, want)
+	}
+}
+
+func TestMailchimpUnsubscribe(t *testing.T) {
+	m, requests := fakeAPI(t, nil)
+
+	if err := m.Unsubscribe(context.Background(), "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	want := []request{{method: http.MethodPatch, path: memberPath, body: map[string]any{"status": "unsubscribed"}}}
+	if !reflect.DeepEqual(*requests, want) {
+		t.Fatalf("requests = %+v, want %+v", *requests, want)
+	}
+}
+
+func TestMailchimpUpdateTags(t *testing.T) {
+	m, requests := fakeAPI(t, nil)
+	ctx := context.Background()
+
+	if err := m.UpdateTags(ctx, "alice@example.com", []string{"coffee", "vip"}, []string{"lapsed"}); err != nil {
+		t.Fatal(err)
+	}
+	want := []request{{
+		method: http.MethodPost,
+		path:   memberPath + "/tags",
+		body: map[string]any{"tags": []any{
+			map[string]any{"name": "coffee", "status": "active"},
+			map[string]any{"name": "vip", "status": "active"},
+			map[string]any{"name": "lapsed", "status": "inactive"},
+		}},
+	}}
+	if !reflect.DeepEqual(*requests, want) {
+		t.Fatalf("requests = %+v, want %+v", *requests, want)
+	}
+
+	// Nothing to change sends nothing
+	if err := m.UpdateTags(ctx, "alice@example.com", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.UpdateMergeFields(ctx, "alice@example.com", nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(*requests) != 1 {
+		t.Fatalf("%d requests, want 1", len(*requests))
+	}
+}
+
+func TestMailchimpUpdateMergeFields(t *testing.T) {
+	m, requests := fakeAPI(t, nil)
+
+	if err := m.UpdateMergeFields(context.Background(), "alice@example.com", map[string]string{"FNAME": "Alice"}); err != nil {
+		t.Fatal(err)
+	}
+	want := []request{{method: http.MethodPatch, path: memberPath, body: map[string]any{"merge_fields": map[string]any{"FNAME": "Alice"}}}}
+	if !reflect.DeepEqual(*requests, want) {
+		t.Fatalf("requests = %+v, want %+v", *requests, want)
+	}
+}
+
+func TestMailchimpStatus(t *testing.T) {
+	m, requests := fakeAPI(t, respond(http.StatusOK, `{
+		"email_address": "alice@example.com",
+		"status": "subscribed",
+		"merge_fields": {"FNAME": "Alice", "AGE": 30, "ADDRESS": {"city": "Leeds"}},
+		"tags": [{"id": 1, "name": "vip"}, {"id": 2, "name": "coffee"}]
+	}`))
+
+	member, err := m.Status(context.Background(), "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (*requests)[0].method != http.MethodGet || (*requests)[0].path != memberPath {
+		t.Fatalf("request = %+v, want a GET of the member", (*requests)[0])
+	}
+	sort.Strings(member.Tags)
+	want := &marketing.Member{
+		Email:  "alice@example.com",
+		Status: marketing.StatusSubscribed,
+		Tags:   []string{"coffee", "vip"},
+		// Only text merge fields are kept
+		MergeFields: map[string]string{"FNAME": "Alice"},
+	}
+	if !reflect.DeepEqual(member, want) {
+		t.Fatalf("member = %+v, want %+v", member, want)
+	}
+}
+
+func TestMailchimpStatusNotMember(t *testing.T) {
+	m, _ := fakeAPI(t, respond(http.StatusNotFound, `{"title": "Resource Not Found", "status": 404}`))
+
+	if _, err := m.Status(context.Background(), "nobody@example.com"); !errors.Is(err, marketing.ErrNotMember) {
+		t.Fatalf("error = %v, want ErrNotMember", err)
+	}
+}
+
+func TestMailchimpErrors(t *testing.T) {
+	tests := []struct {
+		status    int
+		body      string
+		message   string
+		temporary bool
+	}{
+		{http.StatusBadRequest, `{"title": "Invalid Resource", "detail": "Please provide a valid email address."}`, "mailchimp API error: 400 Invalid Resource: Please provide a valid email address.", false},
+		{http.StatusNotFound, `{"title": "Resource Not Found"}`, "mailchimp API error: 404 Resource Not Found", false},
+		{http.StatusTooManyRequests, `{"title": "Too Many Requests"}`, "mailchimp API error: 429 Too Many Requests", true},
+		{http.StatusInternalServerError, `not json`, "mailchimp API error: 500", true},
+		{http.StatusServiceUnavailable, ``, "mailchimp API error: 503", true},
+	}
+	for _, tt := range tests {
+		m, _ := fakeAPI(t, respond(tt.status, tt.body))
+
+		err := m.Subscribe(context.Background(), "alice@example.com")
+		var apiErr *marketing.APIError
+		if !errors.As(err, &apiErr) {
+			t.Fatalf("%d: error = %v, want an APIError", tt.status, err)
+		}
+		if apiErr.StatusCode != tt.status || apiErr.Error() != tt.message {
+			t.Errorf("%d: error = %q, want %q", tt.status, apiErr.Error(), tt.message)
+		}
+		if apiErr.Temporary() != tt.temporary {
+			t.Errorf("%d: temporary = %v, want %v", tt.status, apiErr.Temporary(), tt.temporary)
+		}
+	}
+}
+
+func TestNewMailchimp(t *testing.T) {
+	tests := []struct {
+		apiKey  string
+		listID  string
+		baseURL string // Empty if the key is refused
+	}{
+		{"0123456789abcdef-us6", "list", "https://us6.api.mailchimp.com/3.0"},
+		{"0123456789abcdef-US21", "list", "https://US21.api.mailchimp.com/3.0"},
+		{"key-with-dashes-us14", "list", "https://us14.api.mailchimp.com/3.0"},
+		{"0123456789abcdef-us6", "", ""},
+		{"", "list", ""},
+		{"0123456789abcdef", "list", ""},
+		{"0123456789abcdef-", "list", ""},
+		{"0123456789abcdef-us6.evil.test#", "list", ""},
+		{"0123456789abcdef-us6/", "list", ""},
+	}
+	for _, tt := range tests {
+		m, err := marketing.NewMailchimp(tt.apiKey, tt.listID)
+		if tt.baseURL == "" {
+			if err == nil {
+				t.Errorf("%q, %q: base URL %s, want an error", tt.apiKey, tt.listID, m.BaseURL)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q, %q: %v", tt.apiKey, tt.listID, err)
+			continue
+		}
+		if m.BaseURL != tt.baseURL || m.APIKey != tt.apiKey || m.ListID != tt.listID || m.Client == nil {
+			t.Errorf("%q: provider = %+v, want base URL %s", tt.apiKey, m, tt.baseURL)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Package marketing manages email marketing list membership behind a small
+// interface so the provider can be swapped out.
+package marketing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Member statuses, as Mailchimp names them
+const (
+	StatusSubscribed   = "subscribed"
+	StatusUnsubscribed = "unsubscribed"
+	StatusCleaned      = "cleaned"
+	StatusPending      = "pending"
+)
+
+// ErrNotMember is returned by Status for addresses that are not on the list
+var ErrNotMember = errors.New("address is not a list member")
+
+// Member is an address on the mailing list
+type Member struct {
+	Email       string
+	Status      string
+	Tags        []string
+	MergeFields map[string]string
+}
+
+// Provider manages the members of a mailing list
+type Provider interface {
+	Subscribe(ctx context.Context, email string) error
+	Unsubscribe(ctx context.Context, email string) error
+	// UpdateTags adds and removes tags on a member
+	UpdateTags(ctx context.Context, email string, add []string, remove []string) error
+	// UpdateMergeFields sets merge fields, such as FNAME, on a member
+	UpdateMergeFields(ctx context.Context, email string, fields map[string]string) error
+	Status(ctx context.Context, email string) (*Member, error)
+}
+
+// NewFromEnv returns the provider named by MARKETING_PROVIDER, "mailchimp" or
+// "noop". When it is not set, Mailchimp is used if MAILCHIMP_API_KEY and
+// MAILCHIMP_LIST_ID are, and Noop otherwise.
+func NewFromEnv() (Provider, error) {
+	apiKey := os.Getenv("MAILCHIMP_API_KEY")
+	listID := os.Getenv("MAILCHIMP_LIST_ID")
+
+	name := strings.ToLower(os.Getenv("MARKETING_PROVIDER"))
+	if name == "" {
+		name = "noop"
+		if apiKey != "" && listID != "" {
+			name = "mailchimp"
+		}
+	}
+
+	switch name {
+	case "mailchimp":
+		return NewMailchimp(apiKey, listID)
+	case "noop":
+		log.Println("No email marketing provider configured, list updates will be skipped")
+		return Noop{}, nil
+	}
+	return nil, fmt.Errorf("unknown MARKETING_PROVIDER %q", name)
+}
+
+// Noop accepts every change and keeps no members. Useful in development and
+// for deployments without a mailing list.
+type Noop struct{}
+
+// Subscribe does nothing
+func (Noop) Subscribe(ctx context.Context, email string) error { return nil }
+
+// Unsubscribe does nothing
+func (Noop) Unsubscribe(ctx context.Context, email string) error { return nil }
+
+// UpdateTags does nothing
+func (Noop) UpdateTags(ctx context.Context, email string, add []string, remove []string) error {
+	return nil
+}
+
+// UpdateMergeFields does nothing
+func (Noop) UpdateMergeFields(ctx context.Context, email string, fields map[string]string) error {
+	return nil
+}
+
+// Status reports that no address is a member
+func (Noop) Status(ctx context.Context, email string) (*Member, error) {
+	return nil, ErrNotMember
+}
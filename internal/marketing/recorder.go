@@ -0,0 +1,124 @@
+package marketing
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Call is one change made through a Recorder
+type Call struct {
+	Method      string // Name of the Provider method called
+	Email       string
+	Add         []string          // Tags added, for UpdateTags
+	Remove      []string          // Tags removed, for UpdateTags
+	MergeFields map[string]string // For UpdateMergeFields
+}
+
+// Recorder keeps every call and the resulting members in memory so tests can
+// inspect what was sent. Setting Err makes every call fail with it.
+type Recorder struct {
+	Err error
+
+	mu      sync.Mutex
+	calls   []Call
+	members map[string]*Member
+}
+
+// member returns the stored member for an address, creating it if needed.
+// The caller must hold the lock.
+func (r *Recorder) member(email string) *Member {
+	if r.members == nil {
+		r.members = make(map[string]*Member)
+	}
+	key := strings.ToLower(strings.TrimSpace(email))
+	m, ok := r.members[key]
+	if !ok {
+		m = &Member{Email: email, MergeFields: map[string]string{}}
+		r.members[key] = m
+	}
+	return m
+}
+
+// record stores a call and reports the error to return
+func (r *Recorder) record(call Call, apply func()) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, call)
+	if r.Err != nil {
+		return r.Err
+	}
+	apply()
+	return nil
+}
+
+// Subscribe records the call and marks the address subscribed
+func (r *Recorder) Subscribe(ctx context.Context, email string) error {
+	return r.record(Call{Method: "Subscribe", Email: email}, func() {
+		r.member(email).Status = StatusSubscribed
+	})
+}
+
+// Unsubscribe records the call and marks the address unsubscribed
+func (r *Recorder) Unsubscribe(ctx context.Context, email string) error {
+	return r.record(Call{Method: "Unsubscribe", Email: email}, func() {
+		r.member(email).Status = StatusUnsubscribed
+	})
+}
+
+// UpdateTags records the call and updates the member's tags
+func (r *Recorder) UpdateTags(ctx context.Context, email string, add []string, remove []string) error {
+	call := Call{Method: "UpdateTags", Email: email, Add: slices.Clone(add), Remove: slices.Clone(remove)}
+	return r.record(call, func() {
+		m := r.member(email)
+		for _, tag := range add {
+			if !slices.Contains(m.Tags, tag) {
+				m.Tags = append(m.Tags, tag)
+			}
+		}
+		m.Tags = slices.DeleteFunc(m.Tags, func(tag string) bool { return slices.Contains(remove, tag) })
+	})
+}
+
+// UpdateMergeFields records the call and updates the member's merge fields
+func (r *Recorder) UpdateMergeFields(ctx context.Context, email string, fields map[string]string) error {
+	copied := make(map[string]string, len(fields))
+	for key, value := range fields {
+		copied[key] = value
+	}
+	return r.record(Call{Method: "UpdateMergeFields", Email: email, MergeFields: copied}, func() {
+		m := r.member(email)
+		for key, value := range fields {
+			m.MergeFields[key] = value
+		}
+	})
+}
+
+// Status returns a copy of the member recorded for an address
+func (r *Recorder) Status(ctx context.Context, email string) (*Member, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	m, ok := r.members[strings.ToLower(strings.TrimSpace(email))]
+	if !ok || m.Status == "" {
+		return nil, ErrNotMember
+	}
+
+	c := *m
+	c.Tags = slices.Clone(m.Tags)
+	c.MergeFields = make(map[string]string, len(m.MergeFields))
+	for key, value := range m.MergeFields {
+		c.MergeFields[key] = value
+	}
+	return &c, nil
+}
+
+// Calls returns a copy of every call recorded so far
+func (r *Recorder) Calls() []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Call(nil), r.calls...)
+}
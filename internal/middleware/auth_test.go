@@ -0,0 +1,151 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"loyaltea-server/internal/middleware"
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testSecret = "test-secret"
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// newRouter serves /me behind RequireAuth, answering with the caller's user
+// ID, and /admin behind RequireAuth and RequireRoles(admin)
+func newRouter() *gin.Engine {
+	router := gin.New()
+	me := func(c *gin.Context) {
+		claims, _ := middleware.GetClaims(c)
+		c.JSON(http.StatusOK, gin.H{"user_id": claims.UserID})
+	}
+	router.GET("/me", middleware.RequireAuth(), me)
+	router.GET("/admin", middleware.RequireAuth(), middleware.RequireRoles(models.RoleAdmin), me)
+	router.GET("/unauthenticated", middleware.RequireRoles(models.RoleAdmin), me)
+	return router
+}
+
+// get requests path with the given Authorization header, if any
+func get(router *gin.Engine, path string, authorization string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// accessToken signs an access token for a user with role
+func accessToken(t *testing.T, role string) string {
+	t.Helper()
+	token, err := utils.GenerateToken(utils.Claims{UserID: "user-1", Email: "user@example.com", Role: role})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestRequireAuth(t *testing.T) {
+	t.Setenv("JWT_SECRET", testSecret)
+	router := newRouter()
+	token := accessToken(t, models.RoleCustomer)
+
+	for _, header := range []string{"Bearer " + token, "bearer " + token, "  Bearer   " + token + " "} {
+		w := get(router, "/me", header)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"user-1"`) {
+			t.Fatalf("%q: status = %d, body = %s", header, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestRequireAuthRejects(t *testing.T) {
+	t.Setenv("JWT_SECRET", testSecret)
+	router := newRouter()
+
+	expired := utils.Claims{
+		UserID: "user-1",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{"loyaltea-access"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+		},
+	}
+	expiredToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, expired).SignedString([]byte(testSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSecret, err := jwt.NewWithClaims(jwt.SigningMethodHS256, utils.Claims{
+		UserID:           "user-1",
+		Role:             models.RoleAdmin,
+		RegisteredClaims: jwt.RegisteredClaims{Audience: jwt.ClaimStrings{"loyaltea-access"}},
+	}).SignedString([]byte("guess"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verification, err := utils.GenerateEmailVerificationToken("user-1", "user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := accessToken(t, models.RoleCustomer)
+
+	tests := []struct {
+		name   string
+		header string
+		error  string
+	}{
+		{"no header", "", "Missing or malformed authorization header"},
+		{"no scheme", token, "Missing or malformed authorization header"},
+		{"basic scheme", "Basic dXNlcjpwYXNz", "Missing or malformed authorization header"},
+		{"no token", "Bearer ", "Missing or malformed authorization header"},
+		{"malformed token", "Bearer not-a-jwt", "Invalid token"},
+		{"expired", "Bearer " + expiredToken, "Token expired"},
+		{"wrong secret", "Bearer " + otherSecret, "Invalid token"},
+		{"email verification token", "Bearer " + verification, "Invalid token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := get(router, "/me", tt.header)
+			if w.Code != http.StatusUnauthorized {
+				t.Fatalf("status = %d, want 401", w.Code)
+			}
+			if !strings.Contains(w.Body.String(), tt.error) {
+				t.Fatalf("body = %s, want %q", w.Body.String(), tt.error)
+			}
+		})
+	}
+}
+
+func TestRequireRoles(t *testing.T) {
+	t.Setenv("JWT_SECRET", testSecret)
+	router := newRouter()
+
+	tests := []struct {
+		role   string
+		status int
+	}{
+		{models.RoleCustomer, http.StatusForbidden},
+		{models.RoleMerchantStaff, http.StatusForbidden},
+		{"", http.StatusForbidden},
+		{models.RoleAdmin, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if w := get(router, "/admin", "Bearer "+accessToken(t, tt.role)); w.Code != tt.status {
+			t.Fatalf("role %q: status = %d, want %d", tt.role, w.Code, tt.status)
+		}
+	}
+
+	// Without RequireAuth in front there are no claims to check
+	if w := get(router, "/unauthenticated", "Bearer "+accessToken(t, models.RoleAdmin)); w.Code != http.StatusUnauthorized {
+		t.Fatalf("without RequireAuth: status = %d, want 401", w.Code)
+	}
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"time"
+)
+
+// Kinds of earn rules
+const (
+	EarnRulePerCurrency   = "per_currency"   // Points for each currency unit spent
+	EarnRulePerVisit      = "per_visit"      // Fixed points for every purchase
+	EarnRuleCategoryBonus = "category_bonus" // Points for each currency unit spent on items in Category
+	EarnRuleMultiplier    = "multiplier"     // Multiplies the points of the rules above, the highest applying one wins
+	EarnRuleFirstPurchase = "first_purchase" // Fixed points for the first purchase a user earns points on
+	EarnRuleCap           = "cap"            // Limits the points earned from purchases per Period
+)
+
+// Periods a cap rule limits points over. Weeks start on Monday.
+const (
+	EarnPeriodDay   = "day"
+	EarnPeriodWeek  = "week"
+	EarnPeriodMonth = "month"
+)
+
+// EarnRule is a merchant-configured rule for awarding points on purchases.
+// Which fields matter depends on Type. Days, StartHour and EndHour restrict
+// any rule to some times of the week, such as a double points weekend.
+type EarnRule struct {
+	ID         string     `bson:"_id,omitempty" json:"id"`
+	Name       string     `bson:"name" json:"name"`
+	Type       string     `bson:"type" json:"type"`
+	Active     bool       `bson:"active" json:"active"`
+	Points     float64    `bson:"points,omitempty" json:"points,omitempty"`         // Per currency unit, or fixed for per_visit and first_purchase
+	Multiplier float64    `bson:"multiplier,omitempty" json:"multiplier,omitempty"` // For multiplier
+	Category   string     `bson:"category,omitempty" json:"category,omitempty"`     // For category_bonus
+	Currency   string     `bson:"currency,omitempty" json:"currency,omitempty"`     // Only purchases in this currency, if set
+	MaxPoints  int64      `bson:"max_points,omitempty" json:"max_points,omitempty"` // For cap
+	Period     string     `bson:"period,omitempty" json:"period,omitempty"`         // For cap
+	Days       []int      `bson:"days,omitempty" json:"days,omitempty"`             // Weekdays, 0 for Sunday, empty for every day
+	StartHour  int        `bson:"start_hour,omitempty" json:"start_hour,omitempty"` // Hours of the day, 0 to 24, applying from StartHour up to EndHour
+	EndHour    int        `bson:"end_hour,omitempty" json:"end_hour,omitempty"`     // 0 means the end of the day
+	ValidFrom  *time.Time `bson:"valid_from,omitempty" json:"valid_from,omitempty"`
+	ValidUntil *time.Time `bson:"valid_until,omitempty" json:"valid_until,omitempty"`
+	CreatedAt  time.Time  `bson:"created_at" json:"created_at"`
+	UpdatedAt  time.Time  `bson:"updated_at" json:"updated_at"`
+}
+
+// Purchase is a purchase reported by a merchant for points to be earned on.
+// Rules restricted to some days or hours are matched against the time of day
+// in OccurredAt's own time zone.
+type Purchase struct {
+	ID         string         `json:"id"` // The merchant's order ID, points are earned once per purchase
+	UserID     string         `json:"user_id"`
+	Amount     float64        `json:"amount"`
+	Currency   string         `json:"currency"`
+	Items      []PurchaseItem `json:"items,omitempty"`
+	OccurredAt time.Time      `json:"occurred_at"`
+}
+
+// PurchaseItem is a line of a Purchase
+type PurchaseItem struct {
+	Category string  `json:"category"`
+	Amount   float64 `json:"amount"`
+}
+
+// RuleApplication explains the points one earn rule contributed
+type RuleApplication struct {
+	RuleID string `bson:"rule_id" json:"rule_id"`
+	Name   string `bson:"name" json:"name"`
+	Type   string `bson:"type" json:"type"`
+	Points int64  `bson:"points" json:"points"` // Negative for a cap
+	Detail string `bson:"detail" json:"detail"`
+}
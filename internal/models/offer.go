@@ -5,18 +5,107 @@ import (
 
 	"context"
 
+	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
+// Offer statuses
+const (
+	OfferStatusClaimed   = "claimed"   // Linked to the account of the forwarder
+	OfferStatusUnclaimed = "unclaimed" // Forwarded from an address no account owns yet
+)
+
+// Kinds of discount an offer can give
+const (
+	DiscountPercent      = "percent"
+	DiscountAmount       = "amount"
+	DiscountFreeShipping = "free_shipping"
+	DiscountBOGO         = "bogo"
+)
+
+// Money is an amount in a currency such as "USD"
+type Money struct {
+	Amount   float64 `bson:"amount" json:"amount"`
+	Currency string  `bson:"currency,omitempty" json:"currency,omitempty"`
+}
+
+// Discount is one discount found in an offer. Value is a percentage for
+// percent discounts and an amount in Currency for amount discounts.
+type Discount struct {
+	Kind     string  `bson:"kind" json:"kind"`
+	Value    float64 `bson:"value,omitempty" json:"value,omitempty"`
+	Currency string  `bson:"currency,omitempty" json:"currency,omitempty"`
+	UpTo     bool    `bson:"upTo,omitempty" json:"upTo,omitempty"` // "Up to 50% off"
+}
+
+// Forwarder is an address that forwarded an offer and how often it did
+type Forwarder struct {
+	UserID           string    `bson:"userId,omitempty" json:"userId,omitempty"`
+	SenderEmail      string    `bson:"senderEmail" json:"senderEmail"`
+	Count            int       `bson:"count" json:"count"`
+	FirstForwardedAt time.Time `bson:"firstForwardedAt" json:"firstForwardedAt"`
+	LastForwardedAt  time.Time `bson:"lastForwardedAt" json:"lastForwardedAt"`
+}
+
 type Offer struct {
-	ID          string    `bson:"_id,omitempty" json:"id"`
-	SenderEmail string    `bson:"senderEmail" json:"senderEmail"`           // Email of the user who forwarded it
-	Subject     string    `bson:"subject" json:"subject"`                   // Subject line of the email
-	Body        string    `bson:"body" json:"body"`                         // Plain text body
-	Brand       string    `bson:"brand,omitempty" json:"brand,omitempty"`   // Optional: Parsed brand like "Zara", "Starbucks"
-	Source      string    `bson:"source,omitempty" json:"source,omitempty"` // e.g., "email"
-	Tags        []string  `bson:"tags,omitempty" json:"tags,omitempty"`     // Optional: e.g., ["discount", "clothing"]
-	CreatedAt   time.Time `bson:"createdAt" json:"createdAt"`               // When this offer was received
+	ID              string            `bson:"_id,omitempty" json:"id"`
+	SenderEmail     string            `bson:"senderEmail" json:"senderEmail"`           // Email of the user who forwarded it
+	UserID          string            `bson:"userId,omitempty" json:"userId,omitempty"` // Account the sender address belongs to
+	Status          string            `bson:"status,omitempty" json:"status,omitempty"`
+	Subject         string            `bson:"subject" json:"subject"`                                     // Subject line of the email
+	Body            string            `bson:"body" json:"body"`                                           // Plain text body
+	OriginalHTML    string            `bson:"originalHtml,omitempty" json:"-"`                            // HTML body as received, unsafe to render
+	SafeHTML        string            `bson:"safeHtml,omitempty" json:"bodyHtml,omitempty"`               // Sanitized HTML body, safe to render
+	Brand           string            `bson:"brand,omitempty" json:"brand,omitempty"`                     // Optional: Parsed brand like "Zara", "Starbucks"
+	BrandConfidence float64           `bson:"brandConfidence,omitempty" json:"brandConfidence,omitempty"` // Set when Brand was detected rather than given
+	Source          string            `bson:"source,omitempty" json:"source,omitempty"`                   // e.g., "email"
+	Tags            []string          `bson:"tags,omitempty" json:"tags,omitempty"`                       // Optional: e.g., ["discount", "clothing"]
+	InferredTags    []string          `bson:"inferredTags,omitempty" json:"inferredTags,omitempty"`       // Assigned by the classifier, kept apart from Tags given by the sender
+	Codes           []string          `bson:"codes,omitempty" json:"codes,omitempty"`                     // Promo codes parsed from the email
+	Discounts       []Discount        `bson:"discounts,omitempty" json:"discounts,omitempty"`
+	MinimumSpend    *Money            `bson:"minimumSpend,omitempty" json:"minimumSpend,omitempty"`
+	ValidFrom       *time.Time        `bson:"validFrom,omitempty" json:"validFrom,omitempty"`
+	ValidUntil      *time.Time        `bson:"validUntil,omitempty" json:"validUntil,omitempty"`
+	Headers         map[string]string `bson:"headers,omitempty" json:"-"`                           // Headers of the original email, such as From and List-Unsubscribe
+	ContentHash     string            `bson:"contentHash,omitempty" json:"-"`                       // SHA-256 of the normalized subject and body
+	Simhash         int64             `bson:"simhash,omitempty" json:"-"`                           // Fingerprint for near duplicates, stored signed as BSON has no uint64
+	SimhashBands    []string          `bson:"simhashBands,omitempty" json:"-"`                      // Simhash split into lookup keys
+	DuplicateOf     string            `bson:"duplicateOf,omitempty" json:"duplicateOf,omitempty"`   // Canonical offer this is a forward of
+	Forwarders      []Forwarder       `bson:"forwarders,omitempty" json:"forwarders,omitempty"`     // Set on canonical offers only
+	ForwardCount    int               `bson:"forwardCount,omitempty" json:"forwardCount,omitempty"` // Total forwards of a canonical offer
+	CreatedAt       time.Time         `bson:"createdAt" json:"createdAt"`                           // When this offer was received
+}
+
+// Fields offers can be sorted by
+const (
+	OfferSortCreatedAt = "createdAt"
+	OfferSortSubject   = "subject"
+	OfferSortBrand     = "brand"
+)
+
+// OfferCursor marks the last offer of a page. Value is the sort field of that
+// offer (time.Time for createdAt, string otherwise) and ID breaks ties.
+type OfferCursor struct {
+	Value interface{}
+	ID    string
+}
+
+// OfferQuery describes which offers to list and in what order. Empty fields
+// match everything.
+type OfferQuery struct {
+	OwnerID           string // Restricts the listing to offers linked to this user
+	SenderEmail       string
+	Brand             string
+	Tag               string
+	Source            string
+	CreatedAfter      *time.Time
+	CreatedBefore     *time.Time
+	SortBy            string
+	SortDesc          bool
+	After             *OfferCursor
+	ExcludeDuplicates bool // Leaves out offers that are forwards of another offer
+	Limit             int64
 }
 
 // OfferModel handles database operations for offers
@@ -34,11 +123,207 @@ func NewOfferModel(db *mongo.Database) *OfferModel {
 
 // Create inserts a new offer into the collection
 func (m *OfferModel) Create(ctx context.Context, offer *Offer) error {
+	// Offer IDs are hex strings like user IDs, not raw ObjectIDs
+	if offer.ID == "" {
+		offer.ID = bson.NewObjectID().Hex()
+	}
 	offer.CreatedAt = time.Now()
-	result, err := m.collection.InsertOne(ctx, offer)
+	_, err := m.collection.InsertOne(ctx, offer)
+	return err
+}
+
+// FindByID finds an offer by ID
+func (m *OfferModel) FindByID(ctx context.Context, id string) (*Offer, error) {
+	var offer Offer
+	err := m.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&offer)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &offer, nil
+}
+
+// List returns up to query.Limit offers matching query, starting after the
+// cursor if one is given
+func (m *OfferModel) List(ctx context.Context, query OfferQuery) ([]Offer, error) {
+	conditions := bson.A{}
+	if query.OwnerID != "" {
+		conditions = append(conditions, bson.M{"userId": query.OwnerID})
+	}
+	if query.SenderEmail != "" {
+		conditions = append(conditions, bson.M{"senderEmail": query.SenderEmail})
+	}
+	if query.Brand != "" {
+		conditions = append(conditions, bson.M{"brand": query.Brand})
+	}
+	if query.Tag != "" {
+		conditions = append(conditions, bson.M{"$or": bson.A{
+			bson.M{"tags": query.Tag},
+			bson.M{"inferredTags": query.Tag},
+		}})
+	}
+	if query.Source != "" {
+		conditions = append(conditions, bson.M{"source": query.Source})
+	}
+	if query.ExcludeDuplicates {
+		conditions = append(conditions, bson.M{"duplicateOf": bson.M{"$exists": false}})
+	}
+	if query.CreatedAfter != nil {
+		conditions = append(conditions, bson.M{"createdAt": bson.M{"$gte": *query.CreatedAfter}})
+	}
+	if query.CreatedBefore != nil {
+		conditions = append(conditions, bson.M{"createdAt": bson.M{"$lt": *query.CreatedBefore}})
+	}
+
+	// Keyset pagination on (sort field, _id)
+	direction := 1
+	compare := "$gt"
+	if query.SortDesc {
+		direction = -1
+		compare = "$lt"
+	}
+	if query.After != nil {
+		conditions = append(conditions, afterCursor(query.SortBy, query.After, compare))
+	}
+
+	filter := bson.M{}
+	if len(conditions) > 0 {
+		filter["$and"] = conditions
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: query.SortBy, Value: direction}, {Key: "_id", Value: direction}}).
+		SetLimit(query.Limit)
+
+	cursor, err := m.collection.Find(ctx, filter, opts)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	offers := []Offer{}
+	if err := cursor.All(ctx, &offers); err != nil {
+		return nil, err
+	}
+	return offers, nil
+}
+
+// afterCursor matches the offers after cursor in the order of field. Offers
+// without a brand have no brand field, which sorts before every string, so an
+// empty string value stands for a missing field and is matched with null.
+func afterCursor(field string, cursor *OfferCursor, compare string) bson.M {
+	value, isString := cursor.Value.(string)
+	if !isString {
+		return bson.M{"$or": bson.A{
+			bson.M{field: bson.M{compare: cursor.Value}},
+			bson.M{field: cursor.Value, "_id": bson.M{compare: cursor.ID}},
+		}}
+	}
+
+	empty := bson.M{"$in": bson.A{nil, ""}}
+	if value == "" {
+		sameValue := bson.M{field: empty, "_id": bson.M{compare: cursor.ID}}
+		if compare == "$lt" {
+			return sameValue
+		}
+		return bson.M{"$or": bson.A{
+			bson.M{field: bson.M{"$nin": bson.A{nil, ""}}},
+			sameValue,
+		}}
+	}
+
+	after := bson.A{
+		bson.M{field: bson.M{compare: value}},
+		bson.M{field: value, "_id": bson.M{compare: cursor.ID}},
+	}
+	if compare == "$lt" {
+		// a range on strings leaves out missing fields, which come last descending
+		after = append(after, bson.M{field: empty})
+	}
+	return bson.M{"$or": after}
+}
+
+// ClaimBySender links every offer forwarded from email that is not linked to
+// an account yet to userID, and returns how many were claimed
+func (m *OfferModel) ClaimBySender(ctx context.Context, email string, userID string) (int64, error) {
+	result, err := m.collection.UpdateMany(
+		ctx,
+		bson.M{"senderEmail": email, "userId": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"userId": userID, "status": OfferStatusClaimed}},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// SetInferredTags replaces the tags the classifier assigned to an offer
+func (m *OfferModel) SetInferredTags(ctx context.Context, id string, tags []string) error {
+	update := bson.M{"$set": bson.M{"inferredTags": tags}}
+	if len(tags) == 0 {
+		update = bson.M{"$unset": bson.M{"inferredTags": ""}}
+	}
+	_, err := m.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+// FindDuplicateCandidates returns canonical offers with the given content hash
+// or sharing a simhash band, which may be duplicates of a new offer
+func (m *OfferModel) FindDuplicateCandidates(ctx context.Context, hash string, bands []string, limit int64) ([]Offer, error) {
+	filter := bson.M{
+		"duplicateOf": bson.M{"$exists": false},
+		"$or": bson.A{
+			bson.M{"contentHash": hash},
+			bson.M{"simhashBands": bson.M{"$in": bands}},
+		},
+	}
+	cursor, err := m.collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}).SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	offers := []Offer{}
+	if err := cursor.All(ctx, &offers); err != nil {
+		return nil, err
+	}
+	return offers, nil
+}
+
+// RecordForward counts another forward of a canonical offer, adding the
+// forwarder if its address has not forwarded the offer before
+func (m *OfferModel) RecordForward(ctx context.Context, id string, forwarder Forwarder) error {
+	// Retried once in case another forward from the same address adds it in between
+	for attempt := 0; attempt < 2; attempt++ {
+		set := bson.M{"forwarders.$.lastForwardedAt": forwarder.LastForwardedAt}
+		if forwarder.UserID != "" {
+			set["forwarders.$.userId"] = forwarder.UserID
+		}
+		result, err := m.collection.UpdateOne(
+			ctx,
+			bson.M{"_id": id, "forwarders.senderEmail": forwarder.SenderEmail},
+			bson.M{"$inc": bson.M{"forwarders.$.count": 1, "forwardCount": 1}, "$set": set},
+		)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount > 0 {
+			return nil
+		}
+
+		forwarder.Count = 1
+		result, err = m.collection.UpdateOne(
+			ctx,
+			bson.M{"_id": id, "forwarders.senderEmail": bson.M{"$ne": forwarder.SenderEmail}},
+			bson.M{"$push": bson.M{"forwarders": forwarder}, "$inc": bson.M{"forwardCount": 1}},
+		)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount > 0 {
+			return nil
+		}
 	}
-	offer.ID = result.InsertedID.(string)
 	return nil
 }
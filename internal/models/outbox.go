@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+)
+
+// Kinds of work an OutboxItem carries
+const (
+	OutboxKindSubscribe = "subscribe" // Add Email to the mailing list
+)
+
+// Outbox item statuses
+const (
+	OutboxPending   = "pending"
+	OutboxDelivered = "delivered"
+	OutboxDead      = "dead" // Gave up after too many failures or a permanent error
+)
+
+// OutboxItem is a call to an external service that is made in the
+// background, and retried until it succeeds, so the request that caused it
+// does not depend on the service being up
+type OutboxItem struct {
+	ID            string     `bson:"_id,omitempty" json:"id"`
+	Kind          string     `bson:"kind" json:"kind"`
+	Email         string     `bson:"email" json:"email"`
+	Status        string     `bson:"status" json:"status"`
+	Attempts      int        `bson:"attempts" json:"attempts"`
+	LastError     string     `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	NextAttemptAt time.Time  `bson:"next_attempt_at" json:"next_attempt_at"`
+	LockedUntil   *time.Time `bson:"locked_until,omitempty" json:"-"` // Set while a worker delivers the item
+	DeliveredAt   *time.Time `bson:"delivered_at,omitempty" json:"delivered_at,omitempty"`
+	CreatedAt     time.Time  `bson:"created_at" json:"created_at"`
+	UpdatedAt     time.Time  `bson:"updated_at" json:"updated_at"`
+}
+
+// OutboxFilter narrows an outbox listing. With no Status it matches the
+// items that are stuck: dead ones and pending ones that have failed.
+type OutboxFilter struct {
+	Status string
+	Kind   string
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+)
+
+// Kinds of points ledger entries
+const (
+	PointsCredit = "credit"
+	PointsDebit  = "debit"
+)
+
+// Reasons points are credited or debited
+const (
+	PointsReasonPurchase   = "purchase"
+	PointsReasonRedemption = "redemption"
+	PointsReasonAdjustment = "adjustment" // Made by an admin, with a note
+)
+
+// PointsEntry is one credit or debit in a user's append-only points ledger.
+// Entries are numbered from 1 per user and carry the balance after them, so
+// the balance is that of the latest entry. Seq is unique per user, so of two
+// entries appended concurrently on the same balance only one is stored.
+type PointsEntry struct {
+	ID          string            `bson:"_id,omitempty" json:"id"`
+	UserID      string            `bson:"user_id" json:"user_id"`
+	Seq         int64             `bson:"seq" json:"seq"`
+	Type        string            `bson:"type" json:"type"`
+	Points      int64             `bson:"points" json:"points"` // Always positive, Type says which way
+	Balance     int64             `bson:"balance" json:"balance"`
+	Reason      string            `bson:"reason" json:"reason"`
+	Reference   string            `bson:"reference,omitempty" json:"reference,omitempty"` // ID of what the entry is for, such as an order
+	Note        string            `bson:"note,omitempty" json:"note,omitempty"`
+	CreatedBy   string            `bson:"created_by,omitempty" json:"created_by,omitempty"`   // Admin who made an adjustment
+	Explanation []RuleApplication `bson:"explanation,omitempty" json:"explanation,omitempty"` // Earn rules that fired, for purchases
+	OccurredAt  time.Time         `bson:"occurred_at" json:"occurred_at"`                     // When the purchase happened, or CreatedAt for other entries
+	CreatedAt   time.Time         `bson:"created_at" json:"created_at"`
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+)
+
+// Kinds of keys failed logins are tracked under
+const (
+	AttemptKindAccount = "account"
+	AttemptKindIP      = "ip"
+)
+
+// LoginAttempt tracks recent failed logins for one account or client IP
+type LoginAttempt struct {
+	ID             string     `bson:"_id" json:"id"` // "<kind>:<key>"
+	Kind           string     `bson:"kind" json:"kind"`
+	Key            string     `bson:"key" json:"key"` // Email address or IP
+	Failures       int        `bson:"failures" json:"failures"`
+	LastFailureAt  time.Time  `bson:"last_failure_at" json:"last_failure_at"`
+	NextAttemptAt  *time.Time `bson:"next_attempt_at,omitempty" json:"next_attempt_at,omitempty"` // Progressive delay
+	LockedUntil    *time.Time `bson:"locked_until,omitempty" json:"locked_until,omitempty"`
+	FirstFailureAt time.Time  `bson:"first_failure_at" json:"first_failure_at"`
+}
+
+// Security event types
+const (
+	SecurityEventLoginFailed     = "login_failed"
+	SecurityEventLoginThrottled  = "login_throttled"
+	SecurityEventAccountLocked   = "account_locked"
+	SecurityEventAccountUnlocked = "account_unlocked"
+	SecurityEventIPBlocked       = "ip_blocked"
+)
+
+// SecurityEvent is an audit record of suspicious authentication activity
+type SecurityEvent struct {
+	ID        string    `bson:"_id,omitempty" json:"id"`
+	Type      string    `bson:"type" json:"type"`
+	UserID    string    `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	Email     string    `bson:"email,omitempty" json:"email,omitempty"`
+	IP        string    `bson:"ip,omitempty" json:"ip,omitempty"`
+	Details   string    `bson:"details,omitempty" json:"details,omitempty"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
+
+// SecurityEventFilter narrows a security event listing. Empty fields match
+// everything.
+type SecurityEventFilter struct {
+	Type  string
+	Email string
+	IP    string
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+)
+
+// RefreshToken represents a stored refresh token. Tokens issued from the same
+// login share a FamilyID, which doubles as the session ID.
+type RefreshToken struct {
+	ID         string     `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID     string     `bson:"user_id" json:"user_id"`
+	FamilyID   string     `bson:"family_id" json:"family_id"`
+	TokenHash  string     `bson:"token_hash" json:"-"`
+	ExpiresAt  time.Time  `bson:"expires_at" json:"expires_at"`
+	CreatedAt  time.Time  `bson:"created_at" json:"created_at"`
+	UsedAt     *time.Time `bson:"used_at,omitempty" json:"used_at,omitempty"`         // Set once the token has been rotated
+	ReplacedBy string     `bson:"replaced_by,omitempty" json:"replaced_by,omitempty"` // ID of the token issued in exchange
+	RevokedAt  *time.Time `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+}
+
+// Purposes a OneTimeToken can be issued for
+const (
+	TokenPurposePasswordReset = "password_reset"
+	TokenPurposeAccountUnlock = "account_unlock"
+)
+
+// OneTimeToken is a single-use, expiring token sent to a user by email
+type OneTimeToken struct {
+	ID        string     `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID    string     `bson:"user_id" json:"user_id"`
+	Purpose   string     `bson:"purpose" json:"purpose"`
+	TokenHash string     `bson:"token_hash" json:"-"`
+	ExpiresAt time.Time  `bson:"expires_at" json:"expires_at"`
+	CreatedAt time.Time  `bson:"created_at" json:"created_at"`
+	UsedAt    *time.Time `bson:"used_at,omitempty" json:"used_at,omitempty"`
+}
+
+// WebhookNonce records a token from a signed webhook request so the same
+// request cannot be replayed while its signature is still accepted
+type WebhookNonce struct {
+	ID        string    `bson:"_id" json:"id"` // Provider and token, joined by a colon
+	Provider  string    `bson:"provider" json:"provider"`
+	ExpiresAt time.Time `bson:"expires_at" json:"expires_at"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
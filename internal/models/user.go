@@ -4,12 +4,61 @@ import (
 	"time"
 )
 
+// Roles a user can have
+const (
+	RoleCustomer      = "customer"
+	RoleMerchantStaff = "merchant_staff"
+	RoleAdmin         = "admin"
+)
+
+// Mailing list statuses reported by the email marketing provider. Users the
+// provider has not reported on have no status.
+const (
+	MarketingSubscribed   = "subscribed"
+	MarketingUnsubscribed = "unsubscribed"
+	MarketingCleaned      = "cleaned" // The address bounced or was rejected
+)
+
+// IsValidRole reports whether role is one of the known roles
+func IsValidRole(role string) bool {
+	switch role {
+	case RoleCustomer, RoleMerchantStaff, RoleAdmin:
+		return true
+	}
+	return false
+}
+
 // User represents the user model
 type User struct {
-	ID        string    `bson:"_id,omitempty" json:"id,omitempty"`
-	Email     string    `bson:"email" json:"email"`
-	Password  string    `bson:"password" json:"-"`
-	Name      string    `bson:"name" json:"name"`
-	CreatedAt time.Time `bson:"created_at" json:"created_at"`
-	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+	ID                       string     `bson:"_id,omitempty" json:"id,omitempty"`
+	Email                    string     `bson:"email" json:"email"`
+	Password                 string     `bson:"password" json:"-"`
+	Name                     string     `bson:"name" json:"name"`
+	AliasEmails              []string   `bson:"alias_emails,omitempty" json:"alias_emails,omitempty"` // Verified secondary addresses offers are forwarded from
+	InboundAlias             string     `bson:"inbound_alias,omitempty" json:"-"`                     // Local part of the address the user forwards offers to
+	Role                     string     `bson:"role,omitempty" json:"role,omitempty"`
+	EmailVerified            bool       `bson:"email_verified" json:"email_verified"`
+	EmailVerifiedAt          *time.Time `bson:"email_verified_at,omitempty" json:"email_verified_at,omitempty"`
+	Suspended                bool       `bson:"suspended" json:"suspended"`
+	SuspendedAt              *time.Time `bson:"suspended_at,omitempty" json:"suspended_at,omitempty"`
+	SuspendedReason          string     `bson:"suspended_reason,omitempty" json:"suspended_reason,omitempty"`
+	MFAEnabled               bool       `bson:"mfa_enabled" json:"mfa_enabled"`
+	MFASecret                string     `bson:"mfa_secret,omitempty" json:"-"`
+	MFAPendingSecret         string     `bson:"mfa_pending_secret,omitempty" json:"-"`                        // Set between setup and confirmation
+	MFALastStep              int64      `bson:"mfa_last_step,omitempty" json:"-"`                             // Last TOTP step used, to refuse replays
+	RecoveryCodes            []string   `bson:"recovery_codes,omitempty" json:"-"`                            // SHA-256 hashes of unused recovery codes
+	MarketingStatus          string     `bson:"marketing_status,omitempty" json:"marketing_status,omitempty"` // Mailing list status last reported by the provider
+	MarketingStatusReason    string     `bson:"marketing_status_reason,omitempty" json:"-"`
+	MarketingStatusChangedAt *time.Time `bson:"marketing_status_changed_at,omitempty" json:"-"`
+	CreatedAt                time.Time  `bson:"created_at" json:"created_at"`
+	UpdatedAt                time.Time  `bson:"updated_at" json:"updated_at"`
+}
+
+// GetRole returns the user's role. Accounts created before roles existed
+// are customers.
+func (u *User) GetRole() string {
+	if u.Role == "" {
+		return RoleCustomer
+	}
+	return u.Role
 }
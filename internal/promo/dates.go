@@ -0,0 +1,225 @@
+package promo
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// untilTrigger precedes the date an offer ends, as in "valid until" or
+	// "ends". The common words "by", "before" and "to" only count after a word
+	// about using the offer, as in "order by Friday" or "valid to 12/31", so
+	// prose such as "welcome to Monday deals" is not read as an end date.
+	untilTrigger = regexp.MustCompile(`(?i)\b(?:(?:valid\s+|good\s+|available\s+|redeemable\s+)?(?:until|till|thru|through|expires?(?:\s+on)?|expiring(?:\s+on)?|ends?(?:\s+on)?|ending(?:\s+on)?|last\s+day(?:\s+is)?|deadline)|(?:valid|good|available|redeemable|offer|use|redeem|order|shop|claim|book|buy|purchase|apply)\b(?:\s+[\w%$.']+){0,3}?\s+(?:by|before|to))\b\s*[:\-–]?\s*`)
+
+	// fromTrigger precedes the date an offer starts
+	fromTrigger = regexp.MustCompile(`(?i)\b(?:valid\s+from|starts?(?:\s+on)?|starting(?:\s+on)?|begins?(?:\s+on)?|beginning(?:\s+on)?|from)\b\s*[:\-–]?\s*`)
+
+	// onlyPhrase ends an offer without a trigger word, as in "today only"
+	onlyPhrase = regexp.MustCompile(`(?i)\b(today|tonight|this\s+weekend|\d+\s+days?|\d+\s+hours?|48\s+hours)\s+only\b`)
+)
+
+const (
+	monthNames   = `(jan|feb|mar|apr|may|jun|jul|aug|sep|sept|oct|nov|dec)[a-z]*\.?`
+	weekdayNames = `(?:mon|tue|tues|wed|thu|thur|thurs|fri|sat|sun)[a-z]*\.?,?\s+`
+)
+
+var (
+	isoDate        = regexp.MustCompile(`^(\d{4})-(\d{1,2})-(\d{1,2})\b`)
+	slashDate      = regexp.MustCompile(`^(\d{1,2})/(\d{1,2})(?:/(\d{4}|\d{2}))?\b`)
+	dottedDate     = regexp.MustCompile(`^(\d{1,2})\.(\d{1,2})\.(\d{4}|\d{2})\b`)
+	monthFirstDate = regexp.MustCompile(`(?i)^(?:` + weekdayNames + `)?` + monthNames + `\s+(\d{1,2})(?:st|nd|rd|th)?\b(?:,?\s+(\d{4}))?`)
+	dayFirstDate   = regexp.MustCompile(`(?i)^(?:` + weekdayNames + `)?(?:the\s+)?(\d{1,2})(?:st|nd|rd|th)?\s+(?:of\s+)?` + monthNames + `(?:,?\s+(\d{4}))?`)
+	weekdayDate    = regexp.MustCompile(`(?i)^(?:(this|next|on)\s+)?(monday|tuesday|wednesday|thursday|friday|saturday|sunday|mon|tue|tues|wed|thu|thur|thurs|fri|sat|sun)\b`)
+	inDuration     = regexp.MustCompile(`(?i)^(?:in\s+)?(\d+)\s+(day|hour)s?\b`)
+	namedDay       = regexp.MustCompile(`(?i)^(?:at\s+)?(today|tonight|midnight|tomorrow|this\s+weekend|the\s+weekend|weekend|(?:the\s+)?end\s+of\s+(?:the\s+)?(?:week|month))\b`)
+)
+
+var months = map[string]time.Month{
+	"jan": time.January, "feb": time.February, "mar": time.March, "apr": time.April,
+	"may": time.May, "jun": time.June, "jul": time.July, "aug": time.August,
+	"sep": time.September, "sept": time.September, "oct": time.October,
+	"nov": time.November, "dec": time.December,
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "tues": time.Tuesday,
+	"wed": time.Wednesday, "thu": time.Thursday, "thur": time.Thursday,
+	"thurs": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// extractValidUntil returns when the offer in text ends. Of several end dates
+// the earliest one not already past is used, as reminders such as "ends
+// tomorrow" are more precise than the general terms.
+func extractValidUntil(text string, received time.Time) *time.Time {
+	var dates []time.Time
+	for _, m := range untilTrigger.FindAllStringIndex(text, -1) {
+		if t, ok := parseDate(text[m[1]:], received, true); ok {
+			dates = append(dates, t)
+		}
+	}
+	for _, m := range onlyPhrase.FindAllStringSubmatch(text, -1) {
+		if t, ok := parseDate(m[1], received, true); ok {
+			dates = append(dates, t)
+		}
+	}
+	return pickDate(dates, startOfDay(received))
+}
+
+// extractValidFrom returns when the offer in text starts, if it says
+func extractValidFrom(text string, received time.Time) *time.Time {
+	var dates []time.Time
+	for _, m := range fromTrigger.FindAllStringIndex(text, -1) {
+		if t, ok := parseDate(text[m[1]:], received, false); ok {
+			dates = append(dates, t)
+		}
+	}
+	return pickDate(dates, time.Time{})
+}
+
+// pickDate returns the earliest date not before notBefore, or the earliest of
+// all if every date is before it
+func pickDate(dates []time.Time, notBefore time.Time) *time.Time {
+	var best, earliest *time.Time
+	for i := range dates {
+		d := &dates[i]
+		if earliest == nil || d.Before(*earliest) {
+			earliest = d
+		}
+		if !d.Before(notBefore) && (best == nil || d.Before(*best)) {
+			best = d
+		}
+	}
+	if best == nil {
+		return earliest
+	}
+	return best
+}
+
+// parseDate reads the date at the start of s. Dates are resolved in the time
+// zone of received, at the end of the day when endOfDay is set and at its
+// start otherwise.
+func parseDate(s string, received time.Time, endOfDay bool) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	day := func(year int, month time.Month, d int, yearGiven bool) (time.Time, bool) {
+		if month < time.January || month > time.December || d < 1 || d > 31 {
+			return time.Time{}, false
+		}
+		t := time.Date(year, month, d, 0, 0, 0, 0, received.Location())
+		if t.Month() != month {
+			return time.Time{}, false // Such as February 30
+		}
+		// Without a year, a date well before the email is next year's
+		if !yearGiven && t.Before(startOfDay(received).AddDate(0, 0, -7)) {
+			t = t.AddDate(1, 0, 0)
+		}
+		if endOfDay {
+			t = t.Add(24*time.Hour - time.Second)
+		}
+		return t, true
+	}
+
+	if m := isoDate.FindStringSubmatch(s); m != nil {
+		return day(atoi(m[1]), time.Month(atoi(m[2])), atoi(m[3]), true)
+	}
+
+	// Dotted dates are written day first, as in Europe
+	if m := dottedDate.FindStringSubmatch(s); m != nil {
+		return day(fullYear(m[3]), time.Month(atoi(m[2])), atoi(m[1]), true)
+	}
+
+	if m := slashDate.FindStringSubmatch(s); m != nil {
+		// Month first unless the first number cannot be a month
+		first, second := atoi(m[1]), atoi(m[2])
+		month, d := first, second
+		if first > 12 {
+			month, d = second, first
+		}
+		if m[3] == "" {
+			return day(received.Year(), time.Month(month), d, false)
+		}
+		return day(fullYear(m[3]), time.Month(month), d, true)
+	}
+
+	if m := monthFirstDate.FindStringSubmatch(s); m != nil {
+		if m[3] == "" {
+			return day(received.Year(), months[strings.ToLower(m[1])], atoi(m[2]), false)
+		}
+		return day(atoi(m[3]), months[strings.ToLower(m[1])], atoi(m[2]), true)
+	}
+
+	if m := dayFirstDate.FindStringSubmatch(s); m != nil {
+		if m[3] == "" {
+			return day(received.Year(), months[strings.ToLower(m[2])], atoi(m[1]), false)
+		}
+		return day(atoi(m[3]), months[strings.ToLower(m[2])], atoi(m[1]), true)
+	}
+
+	today := startOfDay(received)
+	relative := func(t time.Time) (time.Time, bool) {
+		if endOfDay {
+			t = t.Add(24*time.Hour - time.Second)
+		}
+		return t, true
+	}
+
+	if m := weekdayDate.FindStringSubmatch(s); m != nil {
+		name := strings.ToLower(m[2])
+		weekday, ok := weekdays[name]
+		if !ok {
+			weekday = weekdays[name[:3]]
+		}
+		// The next such day, or today if it is that day
+		days := (int(weekday) - int(today.Weekday()) + 7) % 7
+		if strings.EqualFold(m[1], "next") && days == 0 {
+			days = 7
+		}
+		return relative(today.AddDate(0, 0, days))
+	}
+
+	if m := inDuration.FindStringSubmatch(s); m != nil {
+		n := atoi(m[1])
+		if strings.EqualFold(m[2], "hour") {
+			return received.Add(time.Duration(n) * time.Hour), true
+		}
+		return relative(today.AddDate(0, 0, n))
+	}
+
+	if m := namedDay.FindStringSubmatch(s); m != nil {
+		phrase := strings.Join(strings.Fields(strings.ToLower(m[1])), " ")
+		switch {
+		case phrase == "today" || phrase == "tonight" || phrase == "midnight":
+			return relative(today)
+		case phrase == "tomorrow":
+			return relative(today.AddDate(0, 0, 1))
+		case strings.HasSuffix(phrase, "weekend") || strings.HasSuffix(phrase, "week"):
+			// Weekends and weeks end on Sunday
+			return relative(today.AddDate(0, 0, (7-int(today.Weekday()))%7))
+		case strings.HasSuffix(phrase, "month"):
+			return relative(time.Date(today.Year(), today.Month()+1, 0, 0, 0, 0, 0, today.Location()))
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// startOfDay returns midnight at the start of t's day
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// fullYear expands two digit years into this century
+func fullYear(year string) int {
+	if len(year) == 2 {
+		return 2000 + atoi(year)
+	}
+	return atoi(year)
+}
+
+// atoi parses a number the patterns have already checked is one
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
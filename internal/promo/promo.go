@@ -0,0 +1,195 @@
+// Package promo extracts the terms of an offer from the text of a
+// promotional email: coupon codes, discounts, the minimum spend and the dates
+// the offer is valid between.
+package promo
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"loyaltea-server/internal/models"
+)
+
+// Result is what was found in an email. Fields are empty when nothing was found.
+type Result struct {
+	Codes        []string
+	Discounts    []models.Discount
+	MinimumSpend *models.Money
+	ValidFrom    *time.Time
+	ValidUntil   *time.Time
+}
+
+// Extract parses text, an email subject and body, received at the given time.
+// Relative dates such as "ends Sunday" are resolved against received.
+func Extract(text string, received time.Time) Result {
+	return Result{
+		Codes:        extractCodes(text),
+		Discounts:    extractDiscounts(text),
+		MinimumSpend: extractMinimumSpend(text),
+		ValidFrom:    extractValidFrom(text, received),
+		ValidUntil:   extractValidUntil(text, received),
+	}
+}
+
+var (
+	// codeAfterLabel finds codes introduced by "code", as in "Use code SAVE20"
+	// or "Promo code: SPRING-24"
+	codeAfterLabel = regexp.MustCompile(`(?i:\bcode)(?:\s+is)?\s*[:\-–]?\s*["'“‘]?([A-Za-z0-9][A-Za-z0-9\-_]{2,23})`)
+
+	// codeBeforeAction finds unlabelled codes, as in "Enter SAVE20 at checkout"
+	codeBeforeAction = regexp.MustCompile(`(?i:\b(?:use|enter|apply|with))\s+["'“‘]?([A-Z0-9][A-Z0-9\-_]{3,23})["'”’]?\s+(?i:at\s+checkout|in\s+(?:the\s+)?(?:app|cart|basket|bag)|online|to\s+(?:save|get|redeem|claim))`)
+)
+
+// notCodes are words that follow "code" in prose but are never codes
+var notCodes = map[string]bool{
+	"BELOW": true, "ABOVE": true, "HERE": true, "NEEDED": true, "REQUIRED": true,
+	"ONLINE": true, "WHEN": true, "THAT": true, "THIS": true, "WITH": true,
+	"FROM": true, "INSTORE": true, "NONE": true,
+}
+
+// extractCodes returns the coupon codes in text, in order of appearance
+func extractCodes(text string) []string {
+	var codes []string
+	seen := map[string]bool{}
+	add := func(code string, labelled bool) {
+		code = strings.Trim(code, "-_")
+		if !looksLikeCode(code, labelled) || seen[code] {
+			return
+		}
+		seen[code] = true
+		codes = append(codes, code)
+	}
+
+	for _, m := range codeAfterLabel.FindAllStringSubmatch(text, -1) {
+		add(m[1], true)
+	}
+	for _, m := range codeBeforeAction.FindAllStringSubmatch(text, -1) {
+		add(m[1], false)
+	}
+	return codes
+}
+
+// looksLikeCode tells codes from ordinary words. Codes are written in capitals
+// and have at least one letter. A code right after a "code" label may be in
+// lower case if it also has digits.
+func looksLikeCode(code string, labelled bool) bool {
+	if len(code) < 4 || notCodes[strings.ToUpper(code)] {
+		return false
+	}
+	hasLetter := strings.ContainsAny(strings.ToUpper(code), "ABCDEFGHIJKLMNOPQRSTUVWXYZ")
+	hasDigit := strings.ContainsAny(code, "0123456789")
+	if !hasLetter {
+		return false
+	}
+	if code == strings.ToUpper(code) {
+		return true
+	}
+	return labelled && hasDigit
+}
+
+// money matches an amount with a currency symbol or code
+const money = `(?:([$£€¥])\s?(\d{1,3}(?:,\d{3})+(?:\.\d{1,2})?|\d+(?:\.\d{1,2})?)|(\d+(?:\.\d{1,2})?)\s?(USD|EUR|GBP|CAD|AUD|dollars|euros|pounds))`
+
+var (
+	percentOff    = regexp.MustCompile(`(?i)\b(up\s+to\s+)?(\d{1,2}(?:\.\d+)?|100)\s?%\s*(?:off|discount|savings?)`)
+	percentSave   = regexp.MustCompile(`(?i)\b(?:save|take|get|enjoy|extra)\s+(?:an?\s+)?(?:extra\s+|additional\s+)?(up\s+to\s+)?(\d{1,2}(?:\.\d+)?|100)\s?%`)
+	amountOff     = regexp.MustCompile(`(?i)(up\s+to\s+)?` + money + `\s*(?:off|discount)`)
+	amountSave    = regexp.MustCompile(`(?i)\b(?:save|take)\s+(?:an?\s+)?(?:extra\s+)?(up\s+to\s+)?` + money)
+	freeShipping  = regexp.MustCompile(`(?i)\bfree\s+(?:standard\s+|express\s+|next[\s-]day\s+)?(?:shipping|delivery)\b`)
+	buyOneGetOne  = regexp.MustCompile(`(?i)\b(?:bogo|buy\s+one,?\s+get\s+one(?:\s+free)?|buy\s+1,?\s+get\s+1)\b`)
+	minimumSpend  = regexp.MustCompile(`(?i)(?:\borders?\s+(?:over|above|of|totall?ing)?|\bspend(?:ing)?\s+(?:over\s+|at\s+least\s+|more\s+than\s+)?|\bminimum\s+(?:spend|purchase|order)(?:\s+of)?|\bmin\.?\s+(?:spend|purchase|order)(?:\s+of)?|\bpurchases?\s+(?:over|of|above))\s*:?\s*` + money)
+	currencyCodes = map[string]string{
+		"$": "USD", "£": "GBP", "€": "EUR", "¥": "JPY",
+		"dollars": "USD", "euros": "EUR", "pounds": "GBP",
+	}
+)
+
+// extractDiscounts returns the distinct discounts in text, in order of appearance
+func extractDiscounts(text string) []models.Discount {
+	type found struct {
+		at       int
+		discount models.Discount
+	}
+	var all []found
+
+	for _, re := range []*regexp.Regexp{percentOff, percentSave} {
+		for _, m := range re.FindAllStringSubmatchIndex(text, -1) {
+			value, _ := strconv.ParseFloat(text[m[4]:m[5]], 64)
+			all = append(all, found{m[0], models.Discount{
+				Kind:  models.DiscountPercent,
+				Value: value,
+				UpTo:  m[2] >= 0,
+			}})
+		}
+	}
+	for _, re := range []*regexp.Regexp{amountOff, amountSave} {
+		for _, m := range re.FindAllStringSubmatchIndex(text, -1) {
+			amount := parseMoney(text, m[4:])
+			all = append(all, found{m[0], models.Discount{
+				Kind:     models.DiscountAmount,
+				Value:    amount.Amount,
+				Currency: amount.Currency,
+				UpTo:     m[2] >= 0,
+			}})
+		}
+	}
+	for _, m := range freeShipping.FindAllStringIndex(text, -1) {
+		all = append(all, found{m[0], models.Discount{Kind: models.DiscountFreeShipping}})
+	}
+	for _, m := range buyOneGetOne.FindAllStringIndex(text, -1) {
+		all = append(all, found{m[0], models.Discount{Kind: models.DiscountBOGO}})
+	}
+
+	// Order by position; the patterns overlap so the same discount may be found twice
+	for i := 1; i < len(all); i++ {
+		for j := i; j > 0 && all[j].at < all[j-1].at; j-- {
+			all[j], all[j-1] = all[j-1], all[j]
+		}
+	}
+	var discounts []models.Discount
+	seen := map[models.Discount]bool{}
+	for _, f := range all {
+		if !seen[f.discount] {
+			seen[f.discount] = true
+			discounts = append(discounts, f.discount)
+		}
+	}
+	return discounts
+}
+
+// extractMinimumSpend returns the first minimum spend in text
+func extractMinimumSpend(text string) *models.Money {
+	m := minimumSpend.FindStringSubmatchIndex(text)
+	if m == nil {
+		return nil
+	}
+	amount := parseMoney(text, m[2:])
+	return &amount
+}
+
+// parseMoney reads the groups of the money pattern, given their indexes in text
+func parseMoney(text string, groups []int) models.Money {
+	group := func(n int) string {
+		if groups[2*n] < 0 {
+			return ""
+		}
+		return text[groups[2*n]:groups[2*n+1]]
+	}
+
+	symbol, number := group(0), group(1)
+	if symbol == "" {
+		number, symbol = group(2), group(3)
+		if code, ok := currencyCodes[strings.ToLower(symbol)]; ok {
+			symbol = code
+		}
+	}
+	amount, _ := strconv.ParseFloat(strings.ReplaceAll(number, ",", ""), 64)
+
+	currency := strings.ToUpper(symbol)
+	if code, ok := currencyCodes[symbol]; ok {
+		currency = code
+	}
+	return models.Money{Amount: amount, Currency: currency}
+}
@@ -0,0 +1,170 @@
+package promo_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/promo"
+)
+
+// received is when the emails in the corpus arrived, a Wednesday
+var received = time.Date(2026, time.March, 11, 10, 0, 0, 0, time.UTC)
+
+const dateLayout = "2006-01-02 15:04:05"
+
+func percent(value float64) models.Discount {
+	return models.Discount{Kind: models.DiscountPercent, Value: value}
+}
+
+func amount(value float64, currency string) models.Discount {
+	return models.Discount{Kind: models.DiscountAmount, Value: value, Currency: currency}
+}
+
+var (
+	freeShipping = models.Discount{Kind: models.DiscountFreeShipping}
+	bogo         = models.Discount{Kind: models.DiscountBOGO}
+)
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		codes     []string
+		discounts []models.Discount
+		minimum   *models.Money
+		from      string // In dateLayout, empty for none
+		until     string
+	}{
+		{
+			name:      "code and weekday end",
+			text:      "Use code SAVE20 at checkout for 20% off everything. Ends Sunday.",
+			codes:     []string{"SAVE20"},
+			discounts: []models.Discount{percent(20)},
+			until:     "2026-03-15 23:59:59",
+		},
+		{
+			name:      "lower case labelled code",
+			text:      "Promo code: spring24 - take an extra 15% off orders over $50",
+			codes:     []string{"spring24"},
+			discounts: []models.Discount{percent(15)},
+			minimum:   &models.Money{Amount: 50, Currency: "USD"},
+		},
+		{
+			name:      "unlabelled code before an action",
+			text:      "Enter BREW20 in the app to get $5 off your next coffee",
+			codes:     []string{"BREW20"},
+			discounts: []models.Discount{amount(5, "USD")},
+		},
+		{
+			name:  "prose after a code label",
+			text:  "Your code is HERE below, along with the details.",
+			codes: nil,
+		},
+		{
+			name:      "welcome to is not an end date",
+			text:      "Welcome to Monday deals: up to 50% off",
+			discounts: []models.Discount{{Kind: models.DiscountPercent, Value: 50, UpTo: true}},
+		},
+		{
+			name:      "order by is an end date",
+			text:      "Order by Friday for free shipping",
+			discounts: []models.Discount{freeShipping},
+			until:     "2026-03-13 23:59:59",
+		},
+		{
+			name:      "buy one get one with a slash date",
+			text:      "Buy one get one free on all mugs. Offer valid until 3/31",
+			discounts: []models.Discount{bogo},
+			until:     "2026-03-31 23:59:59",
+		},
+		{
+			name:      "pounds with a date range",
+			text:      "Save £10 when you spend £40 or more. Valid from 1 April to 30 April 2026.",
+			discounts: []models.Discount{amount(10, "GBP")},
+			minimum:   &models.Money{Amount: 40, Currency: "GBP"},
+			from:      "2026-04-01 00:00:00",
+			until:     "2026-04-30 23:59:59",
+		},
+		{
+			name:    "minimum spend in a currency code",
+			text:    "Free gift with a minimum spend of 25 EUR",
+			minimum: &models.Money{Amount: 25, Currency: "EUR"},
+		},
+		{
+			name:      "today only",
+			text:      "Flash sale: 30% off, today only!",
+			discounts: []models.Discount{percent(30)},
+			until:     "2026-03-11 23:59:59",
+		},
+		{
+			name:  "hours from receipt",
+			text:  "Hurry, this offer ends in 48 hours",
+			until: "2026-03-13 10:00:00",
+		},
+		{
+			name:  "two digit year",
+			text:  "Sale ends 12/31/26",
+			until: "2026-12-31 23:59:59",
+		},
+		{
+			name:  "dotted dates are day first",
+			text:  "Valid until 05.04.2026",
+			until: "2026-04-05 23:59:59",
+		},
+		{
+			name:  "month name without a year long past is next year",
+			text:  "Ends Jan 5",
+			until: "2027-01-05 23:59:59",
+		},
+		{
+			name:  "past date kept when it is the only one",
+			text:  "Expires 2026-02-01",
+			until: "2026-02-01 23:59:59",
+		},
+		{
+			name:  "earliest end date still to come",
+			text:  "Last chance, ends tomorrow! Offer valid until March 31.",
+			until: "2026-03-12 23:59:59",
+		},
+		{
+			name:  "end of the month",
+			text:  "Prices good through the end of the month",
+			until: "2026-03-31 23:59:59",
+		},
+		{
+			name: "nothing to find",
+			text: "No deals here, just our spring newsletter.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := promo.Extract(tt.text, received)
+			if !reflect.DeepEqual(got.Codes, tt.codes) {
+				t.Errorf("codes = %q, want %q", got.Codes, tt.codes)
+			}
+			if !reflect.DeepEqual(got.Discounts, tt.discounts) {
+				t.Errorf("discounts = %+v, want %+v", got.Discounts, tt.discounts)
+			}
+			if !reflect.DeepEqual(got.MinimumSpend, tt.minimum) {
+				t.Errorf("minimum spend = %+v, want %+v", got.MinimumSpend, tt.minimum)
+			}
+			if date := formatDate(got.ValidFrom); date != tt.from {
+				t.Errorf("valid from = %q, want %q", date, tt.from)
+			}
+			if date := formatDate(got.ValidUntil); date != tt.until {
+				t.Errorf("valid until = %q, want %q", date, tt.until)
+			}
+		})
+	}
+}
+
+// formatDate formats a date in dateLayout, or returns "" for none
+func formatDate(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(dateLayout)
+}
@@ -0,0 +1,276 @@
+// Package sanitize makes the HTML of marketing emails safe to show in a
+// browser and extracts its readable text.
+package sanitize
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	nethtml "golang.org/x/net/html"
+)
+
+// allowedElements are kept along with their allowed attributes
+var allowedElements = map[string]bool{
+	"a": true, "abbr": true, "b": true, "blockquote": true, "br": true,
+	"caption": true, "center": true, "code": true, "col": true, "colgroup": true,
+	"dd": true, "del": true, "div": true, "dl": true, "dt": true, "em": true,
+	"font": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true,
+	"h6": true, "hr": true, "i": true, "img": true, "ins": true, "li": true,
+	"ol": true, "p": true, "pre": true, "s": true, "small": true, "span": true,
+	"strike": true, "strong": true, "sub": true, "sup": true, "table": true,
+	"tbody": true, "td": true, "tfoot": true, "th": true, "thead": true,
+	"tr": true, "u": true, "ul": true,
+}
+
+// droppedElements are removed together with everything inside them. Other
+// elements that are not allowed are unwrapped, keeping their content.
+var droppedElements = map[string]bool{
+	"script": true, "style": true, "head": true, "title": true, "meta": true,
+	"link": true, "base": true, "iframe": true, "frame": true, "frameset": true,
+	"object": true, "embed": true, "applet": true, "form": true, "input": true,
+	"button": true, "select": true, "textarea": true, "noscript": true,
+	"template": true, "svg": true, "math": true, "audio": true, "video": true,
+	"canvas": true,
+}
+
+// voidElements have no closing tag
+var voidElements = map[string]bool{"br": true, "hr": true, "img": true, "col": true}
+
+// allowedAttributes are kept on any allowed element
+var allowedAttributes = map[string]bool{
+	"align": true, "alt": true, "bgcolor": true, "border": true,
+	"cellpadding": true, "cellspacing": true, "color": true, "colspan": true,
+	"dir": true, "face": true, "height": true, "rowspan": true, "size": true,
+	"style": true, "title": true, "valign": true, "width": true,
+}
+
+// allowedStyles are the CSS properties kept in style attributes. Anything
+// that can position content over the page, such as position and z-index, is
+// left out.
+var allowedStyles = map[string]bool{
+	"background": true, "background-color": true, "border": true,
+	"border-bottom": true, "border-collapse": true, "border-color": true,
+	"border-left": true, "border-radius": true, "border-right": true,
+	"border-spacing": true, "border-style": true, "border-top": true,
+	"border-width": true, "clear": true, "color": true, "direction": true,
+	"display": true, "float": true, "font": true, "font-family": true,
+	"font-size": true, "font-style": true, "font-variant": true,
+	"font-weight": true, "height": true, "letter-spacing": true,
+	"line-height": true, "list-style-type": true, "margin": true,
+	"margin-bottom": true, "margin-left": true, "margin-right": true,
+	"margin-top": true, "max-height": true, "max-width": true,
+	"min-height": true, "min-width": true, "padding": true,
+	"padding-bottom": true, "padding-left": true, "padding-right": true,
+	"padding-top": true, "table-layout": true, "text-align": true,
+	"text-decoration": true, "text-indent": true, "text-transform": true,
+	"vertical-align": true, "white-space": true, "width": true,
+	"word-break": true, "word-spacing": true, "word-wrap": true,
+}
+
+// allowedStyleFunctions are the only CSS functions allowed in style values,
+// so url(), image-set(), expression() and the like are never kept
+var allowedStyleFunctions = map[string]bool{"rgb": true, "rgba": true, "hsl": true, "hsla": true}
+
+// styleFunction matches the name of a CSS function being called
+var styleFunction = regexp.MustCompile(`([a-z0-9_-]*)\s*\(`)
+
+// HTML returns a version of an HTML document that is safe to render. Scripts,
+// styles, forms, embedded content, tracking pixels and event handlers are
+// removed, and links open in a new window without a referrer.
+func HTML(document string) string {
+	root, err := nethtml.Parse(strings.NewReader(document))
+	if err != nil {
+		return html.EscapeString(document)
+	}
+	var b strings.Builder
+	renderChildren(&b, root)
+	return strings.TrimSpace(b.String())
+}
+
+func renderChildren(b *strings.Builder, n *nethtml.Node) {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		render(b, child)
+	}
+}
+
+func render(b *strings.Builder, n *nethtml.Node) {
+	switch n.Type {
+	case nethtml.TextNode:
+		b.WriteString(html.EscapeString(n.Data))
+		return
+	case nethtml.DocumentNode:
+		renderChildren(b, n)
+		return
+	case nethtml.ElementNode:
+	default:
+		// Comments, including conditional comments, and doctypes
+		return
+	}
+
+	tag := strings.ToLower(n.Data)
+	if droppedElements[tag] {
+		return
+	}
+	if !allowedElements[tag] {
+		renderChildren(b, n)
+		return
+	}
+	if tag == "img" && isTrackingPixel(n) {
+		return
+	}
+
+	attrs, ok := sanitizeAttributes(tag, n.Attr)
+	if !ok {
+		// Images without a safe source show nothing; links without one keep their text
+		if tag == "img" {
+			return
+		}
+		renderChildren(b, n)
+		return
+	}
+
+	b.WriteString("<" + tag)
+	for _, attr := range attrs {
+		b.WriteString(" " + attr.Key + `="` + html.EscapeString(attr.Val) + `"`)
+	}
+	b.WriteString(">")
+	if voidElements[tag] {
+		return
+	}
+	renderChildren(b, n)
+	b.WriteString("</" + tag + ">")
+}
+
+// sanitizeAttributes keeps the safe attributes of an element. It reports
+// false if a link or image has no safe URL.
+func sanitizeAttributes(tag string, attrs []nethtml.Attribute) ([]nethtml.Attribute, bool) {
+	var kept []nethtml.Attribute
+	hasURL := false
+	for _, attr := range attrs {
+		key := strings.ToLower(attr.Key)
+		switch {
+		case tag == "a" && key == "href":
+			if safeURL(attr.Val, "http", "https", "mailto") {
+				kept = append(kept, nethtml.Attribute{Key: key, Val: attr.Val})
+				hasURL = true
+			}
+		case tag == "img" && key == "src":
+			if safeURL(attr.Val, "http", "https") {
+				kept = append(kept, nethtml.Attribute{Key: key, Val: attr.Val})
+				hasURL = true
+			}
+		case key == "style":
+			if style := sanitizeStyle(attr.Val); style != "" {
+				kept = append(kept, nethtml.Attribute{Key: key, Val: style})
+			}
+		case allowedAttributes[key]:
+			kept = append(kept, nethtml.Attribute{Key: key, Val: attr.Val})
+		}
+	}
+
+	switch tag {
+	case "a":
+		if !hasURL {
+			return nil, false
+		}
+		kept = append(kept,
+			nethtml.Attribute{Key: "rel", Val: "noopener noreferrer nofollow"},
+			nethtml.Attribute{Key: "target", Val: "_blank"},
+		)
+	case "img":
+		if !hasURL {
+			return nil, false
+		}
+	}
+	return kept, true
+}
+
+// safeURL reports whether a URL uses one of the given schemes
+func safeURL(value string, schemes ...string) bool {
+	u, err := url.Parse(strings.TrimSpace(value))
+	if err != nil {
+		return false
+	}
+	scheme := strings.ToLower(u.Scheme)
+	for _, s := range schemes {
+		if scheme == s {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitizeStyle keeps the declarations of an inline style whose property is
+// in allowedStyles and whose value is safe, returning "" if none are
+func sanitizeStyle(style string) string {
+	var kept []string
+	for _, decl := range strings.Split(style, ";") {
+		property, value, ok := strings.Cut(decl, ":")
+		if !ok {
+			continue
+		}
+		property = strings.ToLower(strings.TrimSpace(property))
+		value = strings.TrimSpace(value)
+		if allowedStyles[property] && safeStyleValue(value) {
+			kept = append(kept, property+": "+value)
+		}
+	}
+	return strings.Join(kept, "; ")
+}
+
+// safeStyleValue reports whether a CSS value calls no function other than
+// those in allowedStyleFunctions. Values with escapes or comments, which can
+// hide a function name, are refused outright.
+func safeStyleValue(value string) bool {
+	value = strings.ToLower(value)
+	if value == "" || strings.ContainsAny(value, "\\<>@{}") || strings.Contains(value, "/*") {
+		return false
+	}
+	for _, match := range styleFunction.FindAllStringSubmatch(value, -1) {
+		if !allowedStyleFunctions[match[1]] {
+			return false
+		}
+	}
+	return true
+}
+
+// isTrackingPixel reports whether an image is too small or hidden to be seen,
+// as images used to track opens are
+func isTrackingPixel(n *nethtml.Node) bool {
+	width, height := -1, -1
+	for _, attr := range n.Attr {
+		switch strings.ToLower(attr.Key) {
+		case "width":
+			width = pixels(attr.Val)
+		case "height":
+			height = pixels(attr.Val)
+		case "style":
+			style := strings.ToLower(strings.Join(strings.Fields(attr.Val), ""))
+			if strings.Contains(style, "display:none") || strings.Contains(style, "visibility:hidden") {
+				return true
+			}
+			for _, decl := range strings.Split(style, ";") {
+				if v, ok := strings.CutPrefix(decl, "width:"); ok {
+					width = pixels(v)
+				}
+				if v, ok := strings.CutPrefix(decl, "height:"); ok {
+					height = pixels(v)
+				}
+			}
+		}
+	}
+	return (width >= 0 && width <= 2) || (height >= 0 && height <= 2)
+}
+
+// pixels parses a size such as "1" or "1px", returning -1 if it is not one
+func pixels(value string) int {
+	value = strings.TrimSuffix(strings.TrimSpace(strings.ToLower(value)), "px")
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return -1
+	}
+	return n
+}
@@ -0,0 +1,203 @@
+package sanitize_test
+
+import (
+	"testing"
+
+	"loyaltea-server/internal/sanitize"
+)
+
+func TestHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		// Scripts and other active content
+		{
+			name: "script",
+			in:   `<p>Sale<script>alert(1)</script></p>`,
+			want: `<p>Sale</p>`,
+		},
+		{
+			name: "script in head",
+			in:   `<html><head><script src="https://evil.test/x.js"></script><title>Hi</title></head><body><p>Body</p></body></html>`,
+			want: `<p>Body</p>`,
+		},
+		{
+			name: "upper case script",
+			in:   `<SCRIPT>alert(1)</SCRIPT><b>Bold</b>`,
+			want: `<b>Bold</b>`,
+		},
+		{
+			name: "style element and forms",
+			in:   `<style>body{background:url(javascript:alert(1))}</style><form action="https://evil.test"><input name="card"></form><p>Left</p>`,
+			want: `<p>Left</p>`,
+		},
+		{
+			name: "iframe and svg",
+			in:   `<iframe src="https://evil.test"></iframe><svg onload="alert(1)"><circle/></svg><p>Left</p>`,
+			want: `<p>Left</p>`,
+		},
+
+		// Event handlers
+		{
+			name: "on attributes",
+			in:   `<p onclick="alert(1)" ONMOUSEOVER="alert(2)" align="center">Hi</p>`,
+			want: `<p align="center">Hi</p>`,
+		},
+		{
+			name: "on attribute on an image",
+			in:   `<img src="https://cdn.test/a.png" onerror="alert(1)" alt="Sale">`,
+			want: `<img src="https://cdn.test/a.png" alt="Sale">`,
+		},
+
+		// URLs
+		{
+			name: "javascript href",
+			in:   `<a href="javascript:alert(1)">Click</a>`,
+			want: `Click`,
+		},
+		{
+			name: "javascript href with case and spaces",
+			in:   `<a href="  JaVaScRiPt:alert(1)">Click</a>`,
+			want: `Click`,
+		},
+		{
+			name: "javascript href with an entity",
+			in:   `<a href="jav&#x61;script:alert(1)">Click</a>`,
+			want: `Click`,
+		},
+		{
+			name: "data href",
+			in:   `<a href="data:text/html;base64,PHNjcmlwdD4=">Click</a>`,
+			want: `Click`,
+		},
+		{
+			name: "safe href",
+			in:   `<a href="https://shop.test/sale?a=1&amp;b=2">Shop</a>`,
+			want: `<a href="https://shop.test/sale?a=1&amp;b=2" rel="noopener noreferrer nofollow" target="_blank">Shop</a>`,
+		},
+		{
+			name: "mailto href",
+			in:   `<a href="mailto:help@shop.test">Help</a>`,
+			want: `<a href="mailto:help@shop.test" rel="noopener noreferrer nofollow" target="_blank">Help</a>`,
+		},
+		{
+			name: "relative href",
+			in:   `<a href="/sale">Sale</a>`,
+			want: `Sale`,
+		},
+		{
+			name: "javascript src",
+			in:   `<p>A<img src="javascript:alert(1)">B</p>`,
+			want: `<p>AB</p>`,
+		},
+		{
+			name: "data src",
+			in:   `<img src="data:image/png;base64,iVBORw0KGgo=" alt="x">`,
+			want: ``,
+		},
+		{
+			name: "tracking pixel",
+			in:   `<img src="https://track.test/open.gif" width="1" height="1"><p>Hi</p>`,
+			want: `<p>Hi</p>`,
+		},
+		{
+			name: "hidden image",
+			in:   `<img src="https://track.test/open.gif" style="display: none">`,
+			want: ``,
+		},
+
+		// Inline styles
+		{
+			name: "expression",
+			in:   `<p style="width: expression(alert(1))">Hi</p>`,
+			want: `<p>Hi</p>`,
+		},
+		{
+			name: "url with javascript",
+			in:   `<p style="background: url(javascript:alert(1))">Hi</p>`,
+			want: `<p>Hi</p>`,
+		},
+		{
+			name: "url with https",
+			in:   `<p style="background-image: url(https://cdn.test/a.png)">Hi</p>`,
+			want: `<p>Hi</p>`,
+		},
+		{
+			name: "escaped function name",
+			in:   `<p style="width: expr\65 ssion(alert(1))">Hi</p>`,
+			want: `<p>Hi</p>`,
+		},
+		{
+			name: "function hidden by a comment",
+			in:   `<p style="width: expr/**/ession(alert(1))">Hi</p>`,
+			want: `<p>Hi</p>`,
+		},
+		{
+			name: "unsafe declarations dropped, safe ones kept",
+			in:   `<p style="COLOR: rgb(255, 0, 0); position: fixed; z-index: 9; font-weight: bold;">Hi</p>`,
+			want: `<p style="color: rgb(255, 0, 0); font-weight: bold">Hi</p>`,
+		},
+		{
+			name: "quotes in style are escaped",
+			in:   `<p style='font-family: "Helvetica"'>Hi</p>`,
+			want: `<p style="font-family: &#34;Helvetica&#34;">Hi</p>`,
+		},
+
+		// Nested and malformed markup
+		{
+			name: "unknown elements are unwrapped",
+			in:   `<custom-banner><section><p>Deal</p></section></custom-banner>`,
+			want: `<p>Deal</p>`,
+		},
+		{
+			name: "script nested in allowed elements",
+			in:   `<table><tr><td><div><script>alert(1)</script>Cell</div></td></tr></table>`,
+			want: `<table><tbody><tr><td><div>Cell</div></td></tr></tbody></table>`,
+		},
+		{
+			name: "unclosed tags",
+			in:   `<p><b>Bold <i>both`,
+			want: `<p><b>Bold <i>both</i></b></p>`,
+		},
+		{
+			name: "stray closing tags",
+			in:   `</div>Text</b></p>`,
+			want: `Text<p></p>`,
+		},
+		{
+			name: "attribute breaking out of its quotes",
+			in:   `<p title='x" onclick="alert(1)'>Hi</p>`,
+			want: `<p title="x&#34; onclick=&#34;alert(1)">Hi</p>`,
+		},
+		{
+			name: "tag in text",
+			in:   `<p>5 &lt; 6 &amp;&amp; <notatag</p>`,
+			want: `<p>5 &lt; 6 &amp;&amp; </p>`,
+		},
+		{
+			name: "comments and conditional comments",
+			in:   `<!--[if mso]><script>alert(1)</script><![endif]--><p>Hi<!-- note --></p>`,
+			want: `<p>Hi</p>`,
+		},
+		{
+			name: "script text split by a comment",
+			in:   `<scr<!-- -->ipt>alert(1)</script>`,
+			want: `ipt&gt;alert(1)`,
+		},
+		{
+			name: "empty",
+			in:   ``,
+			want: ``,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitize.HTML(tt.in); got != tt.want {
+				t.Errorf("HTML(%q)\n got %q\nwant %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package sanitize
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// blockElements start a new line when converting HTML to text
+var blockElements = map[string]bool{
+	"p": true, "div": true, "br": true, "tr": true, "li": true, "table": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"blockquote": true, "hr": true, "section": true, "article": true,
+	"header": true, "footer": true, "ul": true, "ol": true, "center": true,
+}
+
+// skippedElements hold no readable text
+var skippedElements = map[string]bool{
+	"script": true, "style": true, "head": true, "title": true, "noscript": true, "template": true,
+}
+
+var (
+	spaces     = regexp.MustCompile(`[ \t\r\f\v\x{00a0}]+`)
+	blankLines = regexp.MustCompile(`\n\s*\n\s*(\n\s*)+`)
+)
+
+// Text extracts the readable text of an HTML document, keeping line breaks
+// where blocks start and end
+func Text(document string) string {
+	var b strings.Builder
+	tokenizer := html.NewTokenizer(strings.NewReader(document))
+	skip := 0
+	for {
+		tokenType := tokenizer.Next()
+		switch tokenType {
+		case html.ErrorToken:
+			return tidyText(b.String())
+
+		case html.TextToken:
+			if skip == 0 {
+				b.Write(tokenizer.Text())
+			}
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := tokenizer.TagName()
+			tag := string(name)
+			if skippedElements[tag] && tokenType == html.StartTagToken {
+				skip++
+			}
+			if blockElements[tag] {
+				b.WriteString("\n")
+			}
+			if tag == "td" || tag == "th" {
+				b.WriteString(" ")
+			}
+
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			tag := string(name)
+			if skippedElements[tag] && skip > 0 {
+				skip--
+			}
+			if blockElements[tag] {
+				b.WriteString("\n")
+			}
+		}
+	}
+}
+
+// tidyText collapses runs of spaces and blank lines
+func tidyText(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(spaces.ReplaceAllString(line, " "))
+	}
+	text = strings.Join(lines, "\n")
+	return strings.TrimSpace(blankLines.ReplaceAllString(text, "\n\n"))
+}
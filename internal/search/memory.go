@@ -0,0 +1,250 @@
+package search
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"loyaltea-server/internal/models"
+)
+
+var _ Index = (*MemoryIndex)(nil)
+
+// Score multipliers for the looser and stricter clause kinds
+const (
+	prefixBoost = 0.8
+	phraseBoost = 2.0
+)
+
+// posting records where a term occurs in one document
+type posting struct {
+	positions map[string][]int // Field name to word positions
+}
+
+// indexedOffer is the copy of an offer the index keeps for scoping and highlighting
+type indexedOffer struct {
+	offer  models.Offer
+	fields map[string][]string // Field name to tokens
+}
+
+// MemoryIndex is an in-process inverted index. It ranks by TF-IDF weighted
+// per field. The owner is taken from the offer when it is indexed, so offers
+// must be indexed again after they are claimed.
+type MemoryIndex struct {
+	mu       sync.RWMutex
+	docs     map[string]*indexedOffer
+	postings map[string]map[string]*posting // Term to document ID to posting
+}
+
+// NewMemoryIndex creates an empty MemoryIndex
+func NewMemoryIndex() *MemoryIndex {
+	return &MemoryIndex{
+		docs:     make(map[string]*indexedOffer),
+		postings: make(map[string]map[string]*posting),
+	}
+}
+
+// Index adds an offer, replacing any earlier version of it
+func (idx *MemoryIndex) Index(ctx context.Context, offer *models.Offer) error {
+	doc := &indexedOffer{
+		offer: *offer,
+		fields: map[string][]string{
+			FieldSubject: Tokenize(offer.Subject),
+			FieldBody:    Tokenize(offer.Body),
+			FieldBrand:   Tokenize(offer.Brand),
+			FieldTags:    Tokenize(strings.Join(offerTags(offer), " ")),
+		},
+	}
+	doc.offer.Tags = append([]string(nil), offer.Tags...)
+	doc.offer.InferredTags = append([]string(nil), offer.InferredTags...)
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.remove(offer.ID)
+	idx.docs[offer.ID] = doc
+	for field, tokens := range doc.fields {
+		for pos, term := range tokens {
+			docs, ok := idx.postings[term]
+			if !ok {
+				docs = make(map[string]*posting)
+				idx.postings[term] = docs
+			}
+			p, ok := docs[offer.ID]
+			if !ok {
+				p = &posting{positions: make(map[string][]int)}
+				docs[offer.ID] = p
+			}
+			p.positions[field] = append(p.positions[field], pos)
+		}
+	}
+	return nil
+}
+
+// Remove drops an offer from the index
+func (idx *MemoryIndex) Remove(ctx context.Context, id string) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.remove(id)
+	return nil
+}
+
+// remove drops an offer's postings. The caller must hold the write lock.
+func (idx *MemoryIndex) remove(id string) {
+	doc, ok := idx.docs[id]
+	if !ok {
+		return
+	}
+	for _, tokens := range doc.fields {
+		for _, term := range tokens {
+			if docs, ok := idx.postings[term]; ok {
+				delete(docs, id)
+				if len(docs) == 0 {
+					delete(idx.postings, term)
+				}
+			}
+		}
+	}
+	delete(idx.docs, id)
+}
+
+// Search returns the offers matching query, best first
+func (idx *MemoryIndex) Search(ctx context.Context, query Query) ([]Hit, error) {
+	q := ParseQuery(query.Text)
+	if q.Empty() {
+		return nil, nil
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	scores := map[string]float64{}
+	matched := map[string]int{}
+	add := func(clause map[string]float64) {
+		for id, score := range clause {
+			scores[id] += score
+			matched[id]++
+		}
+	}
+
+	for _, term := range q.Terms {
+		add(idx.scoreTerm(term, 1))
+	}
+	for _, prefix := range q.Prefixes {
+		clause := map[string]float64{}
+		for term := range idx.postings {
+			if strings.HasPrefix(term, prefix) {
+				for id, score := range idx.scoreTerm(term, prefixBoost) {
+					clause[id] = math.Max(clause[id], score)
+				}
+			}
+		}
+		add(clause)
+	}
+	for _, phrase := range q.Phrases {
+		add(idx.scorePhrase(phrase))
+	}
+
+	var hits []Hit
+	for id, score := range scores {
+		doc := idx.docs[id]
+		if query.OwnerID != "" && doc.offer.UserID != query.OwnerID {
+			continue
+		}
+		// Forwards of an offer already found as their canonical offer
+		if doc.offer.DuplicateOf != "" {
+			continue
+		}
+		// Reward offers that match more of the query
+		score *= float64(matched[id]) / float64(q.clauses())
+		hits = append(hits, Hit{
+			ID:         id,
+			Score:      score,
+			Highlights: HighlightOffer(&doc.offer, q),
+		})
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID > hits[j].ID
+	})
+	if query.Limit > 0 && len(hits) > query.Limit {
+		hits = hits[:query.Limit]
+	}
+	return hits, nil
+}
+
+// idf is the inverse document frequency of a term found in df documents.
+// The caller must hold the read lock.
+func (idx *MemoryIndex) idf(df int) float64 {
+	return math.Log(1 + float64(len(idx.docs))/float64(df))
+}
+
+// scoreTerm scores every document containing term. The caller must hold the read lock.
+func (idx *MemoryIndex) scoreTerm(term string, boost float64) map[string]float64 {
+	docs := idx.postings[term]
+	if len(docs) == 0 {
+		return nil
+	}
+	idf := idx.idf(len(docs))
+
+	scores := make(map[string]float64, len(docs))
+	for id, p := range docs {
+		var score float64
+		for field, positions := range p.positions {
+			length := len(idx.docs[id].fields[field])
+			score += fieldWeights[field] * float64(len(positions)) / math.Sqrt(float64(length))
+		}
+		scores[id] = score * idf * boost
+	}
+	return scores
+}
+
+// scorePhrase scores every document containing the words of phrase in order.
+// The caller must hold the read lock.
+func (idx *MemoryIndex) scorePhrase(phrase []string) map[string]float64 {
+	first := idx.postings[phrase[0]]
+	var idf float64
+	for _, word := range phrase {
+		docs := idx.postings[word]
+		if len(docs) == 0 {
+			return nil
+		}
+		idf += idx.idf(len(docs))
+	}
+
+	scores := map[string]float64{}
+	for id := range first {
+		var score float64
+		for field, tokens := range idx.docs[id].fields {
+			count := 0
+			for i := 0; i+len(phrase) <= len(tokens); i++ {
+				if equalWords(tokens[i:i+len(phrase)], phrase) {
+					count++
+				}
+			}
+			if count > 0 {
+				score += fieldWeights[field] * float64(count) / math.Sqrt(float64(len(tokens)))
+			}
+		}
+		if score > 0 {
+			scores[id] = score * idf * phraseBoost
+		}
+	}
+	return scores
+}
+
+// equalWords reports whether two word sequences are the same
+func equalWords(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
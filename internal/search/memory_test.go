@@ -0,0 +1,195 @@
+package search_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/search"
+)
+
+// newIndex returns a MemoryIndex holding offers
+func newIndex(t *testing.T, offers ...models.Offer) *search.MemoryIndex {
+	t.Helper()
+	idx := search.NewMemoryIndex()
+	for i := range offers {
+		if err := idx.Index(context.Background(), &offers[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return idx
+}
+
+// searchIDs returns the IDs of the hits for query, best first
+func searchIDs(t *testing.T, idx *search.MemoryIndex, query search.Query) []string {
+	t.Helper()
+	hits, err := idx.Search(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for _, hit := range hits {
+		ids = append(ids, hit.ID)
+	}
+	return ids
+}
+
+func TestMemoryIndexFieldWeights(t *testing.T) {
+	// The same word, once, in fields of the same length
+	idx := newIndex(t,
+		models.Offer{ID: "body", Brand: "Acme", Subject: "Weekly news", Body: "Espresso"},
+		models.Offer{ID: "tags", Brand: "Acme", Subject: "Weekly news", Body: "Hello", Tags: []string{"espresso"}},
+		models.Offer{ID: "subject", Brand: "Acme", Subject: "Espresso", Body: "Hello"},
+		models.Offer{ID: "brand", Brand: "Espresso", Subject: "Weekly news", Body: "Hello"},
+		models.Offer{ID: "none", Brand: "Acme", Subject: "Weekly news", Body: "Hello"},
+	)
+
+	got := searchIDs(t, idx, search.Query{Text: "espresso"})
+	want := []string{"brand", "subject", "tags", "body"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ranking = %v, want %v", got, want)
+	}
+}
+
+func TestMemoryIndexRewardsMatchingMoreClauses(t *testing.T) {
+	idx := newIndex(t,
+		models.Offer{ID: "one", Subject: "Coffee beans"},
+		models.Offer{ID: "both", Subject: "Coffee and tea"},
+		models.Offer{ID: "other", Subject: "Tea towels"},
+	)
+
+	got := searchIDs(t, idx, search.Query{Text: "coffee tea"})
+	if len(got) != 3 || got[0] != "both" {
+		t.Fatalf("ranking = %v, want both first", got)
+	}
+}
+
+func TestMemoryIndexPhrasesAndPrefixes(t *testing.T) {
+	idx := newIndex(t,
+		models.Offer{ID: "phrase", Subject: "Free shipping on everything"},
+		models.Offer{ID: "scattered", Subject: "Shipping is free over $50"},
+		models.Offer{ID: "shipped", Subject: "Your order shipped"},
+		models.Offer{ID: "ship", Subject: "Model ship kits"},
+	)
+
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{`"free shipping"`, []string{"phrase"}},
+		{`"Free, Shipping!"`, []string{"phrase"}},
+		{`"shipping"`, []string{"scattered", "phrase"}},
+		{"shipp*", []string{"shipped", "scattered", "phrase"}},
+		{"kit*", []string{"ship"}},
+		{"shipp", []string{}},
+		{`"" *`, []string{}},
+	}
+	for _, tt := range tests {
+		got := searchIDs(t, idx, search.Query{Text: tt.text})
+		if !sameIDs(got, tt.want) {
+			t.Fatalf("%s: hits = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+// sameIDs reports whether two lists hold the same IDs in any order
+func sameIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := map[string]int{}
+	for _, id := range a {
+		seen[id]++
+	}
+	for _, id := range b {
+		seen[id]--
+	}
+	for _, n := range seen {
+		if n != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMemoryIndexHighlights(t *testing.T) {
+	idx := newIndex(t, models.Offer{
+		ID:           "offer",
+		Brand:        "Bean & Leaf",
+		Subject:      "Cold brew <b>deal</b>",
+		Body:         "Nothing to see here.",
+		Tags:         []string{"weekly"},
+		InferredTags: []string{"food-drink"},
+	})
+
+	hits, err := idx.Search(context.Background(), search.Query{Text: "deal food*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 1 {
+		t.Fatalf("%d hits, want 1", len(hits))
+	}
+	want := map[string][]string{
+		search.FieldSubject: {"Cold brew &lt;b&gt;<mark>deal</mark>&lt;/b&gt;"},
+		search.FieldTags:    {"weekly, <mark>food</mark>-drink"},
+	}
+	if !reflect.DeepEqual(hits[0].Highlights, want) {
+		t.Fatalf("highlights = %q, want %q", hits[0].Highlights, want)
+	}
+}
+
+func TestMemoryIndexScoping(t *testing.T) {
+	idx := newIndex(t,
+		models.Offer{ID: "alice-1", UserID: "alice", Subject: "Pizza night"},
+		models.Offer{ID: "alice-2", UserID: "alice", Subject: "Pizza party", DuplicateOf: "alice-1"},
+		models.Offer{ID: "bob-1", UserID: "bob", Subject: "Pizza lunch"},
+		models.Offer{ID: "bob-2", UserID: "bob", Subject: "Pizza pizza pizza"},
+	)
+
+	tests := []struct {
+		query search.Query
+		want  []string
+	}{
+		{search.Query{Text: "pizza"}, []string{"alice-1", "bob-1", "bob-2"}},
+		{search.Query{Text: "pizza", OwnerID: "alice"}, []string{"alice-1"}},
+		{search.Query{Text: "pizza", OwnerID: "carol"}, []string{}},
+		{search.Query{Text: "party"}, []string{}},
+		{search.Query{Text: "pizza", Limit: 1}, []string{"bob-2"}},
+	}
+	for _, tt := range tests {
+		got := searchIDs(t, idx, tt.query)
+		if !sameIDs(got, tt.want) {
+			t.Fatalf("%+v: hits = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestMemoryIndexReindexAndRemove(t *testing.T) {
+	ctx := context.Background()
+	offer := models.Offer{ID: "offer", Subject: "Summer sale"}
+	idx := newIndex(t, offer)
+
+	// Indexing again replaces the old text and owner
+	offer.Subject = "Winter sale"
+	offer.UserID = "alice"
+	if err := idx.Index(ctx, &offer); err != nil {
+		t.Fatal(err)
+	}
+	if got := searchIDs(t, idx, search.Query{Text: "summer"}); len(got) != 0 {
+		t.Fatalf("old text still found: %v", got)
+	}
+	if got := searchIDs(t, idx, search.Query{Text: "winter", OwnerID: "alice"}); !sameIDs(got, []string{"offer"}) {
+		t.Fatalf("hits = %v, want the reindexed offer", got)
+	}
+
+	if err := idx.Remove(ctx, "offer"); err != nil {
+		t.Fatal(err)
+	}
+	if got := searchIDs(t, idx, search.Query{Text: "sale"}); len(got) != 0 {
+		t.Fatalf("removed offer still found: %v", got)
+	}
+	if err := idx.Remove(ctx, "missing"); err != nil {
+		t.Fatalf("removing an unknown offer: %v", err)
+	}
+}
@@ -0,0 +1,214 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+
+	"loyaltea-server/internal/models"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+var _ Index = (*MongoIndex)(nil)
+
+// MongoIndex searches the offers collection through a MongoDB text index.
+// MongoDB keeps the text index up to date on every write. Text indexes have
+// no prefix matching, so Index stores the distinct words of each offer in a
+// searchTokens array, which prefixes are matched against with anchored,
+// indexed regular expressions, merging the results in.
+type MongoIndex struct {
+	collection *mongo.Collection
+}
+
+// textFields are the offer fields in the text index, with the search field
+// their matches count as
+var textFields = []struct {
+	name  string
+	field string
+}{
+	{"subject", FieldSubject},
+	{"body", FieldBody},
+	{"brand", FieldBrand},
+	{"tags", FieldTags},
+	{"inferredTags", FieldTags},
+}
+
+// textIndexName names the text index. It changes with the indexed fields, as
+// a collection can only have one text index and it must be replaced.
+const textIndexName = "offer_text_v2"
+
+// NewMongoIndex creates a MongoIndex, building the indexes if they are missing
+func NewMongoIndex(ctx context.Context, db *mongo.Database) (*MongoIndex, error) {
+	collection := db.Collection("offers")
+
+	weights := bson.D{}
+	keys := bson.D{}
+	for _, f := range textFields {
+		keys = append(keys, bson.E{Key: f.name, Value: "text"})
+		weights = append(weights, bson.E{Key: f.name, Value: int(fieldWeights[f.field])})
+	}
+	// Drop the text index of earlier versions so the new one can be built
+	if err := collection.Indexes().DropOne(ctx, "offer_text"); err != nil && !isIndexNotFound(err) {
+		return nil, err
+	}
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    keys,
+			Options: options.Index().SetName(textIndexName).SetWeights(weights),
+		},
+		{
+			Keys: bson.D{{Key: "searchTokens", Value: 1}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &MongoIndex{collection: collection}, nil
+}
+
+// isIndexNotFound reports whether err is MongoDB's IndexNotFound, or
+// NamespaceNotFound as there is no collection yet
+func isIndexNotFound(err error) bool {
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && (cmdErr.Code == 27 || cmdErr.Code == 26)
+}
+
+// Index stores the distinct words of an offer for prefix matching
+func (idx *MongoIndex) Index(ctx context.Context, offer *models.Offer) error {
+	_, err := idx.collection.UpdateOne(ctx, bson.M{"_id": offer.ID}, bson.M{"$set": bson.M{"searchTokens": offerTokens(offer)}})
+	return err
+}
+
+// Remove does nothing as the words are stored on the offer itself
+func (idx *MongoIndex) Remove(ctx context.Context, id string) error {
+	return nil
+}
+
+// offerTokens returns the distinct words of every searched field of offer
+func offerTokens(offer *models.Offer) []string {
+	text := strings.Join([]string{offer.Subject, offer.Body, offer.Brand, strings.Join(offerTags(offer), " ")}, " ")
+	seen := map[string]bool{}
+	tokens := []string{}
+	for _, token := range Tokenize(text) {
+		if !seen[token] {
+			seen[token] = true
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// scoredOffer is an offer with the text score MongoDB gave it
+type scoredOffer struct {
+	models.Offer `bson:",inline"`
+	Score        float64 `bson:"score"`
+}
+
+// Search returns the offers matching query, best first
+func (idx *MongoIndex) Search(ctx context.Context, query Query) ([]Hit, error) {
+	q := ParseQuery(query.Text)
+	if q.Empty() {
+		return nil, nil
+	}
+
+	scores := map[string]float64{}
+	offers := map[string]*models.Offer{}
+	collect := func(results []scoredOffer, boost float64) {
+		for i := range results {
+			r := &results[i]
+			scores[r.ID] += r.Score * boost
+			offers[r.ID] = &r.Offer
+		}
+	}
+
+	if text := textSearch(q); text != "" {
+		filter := bson.M{"$text": bson.M{"$search": text}, "duplicateOf": bson.M{"$exists": false}}
+		if query.OwnerID != "" {
+			filter["userId"] = query.OwnerID
+		}
+		opts := options.Find().
+			SetProjection(bson.M{"score": bson.M{"$meta": "textScore"}}).
+			SetSort(bson.M{"score": bson.M{"$meta": "textScore"}})
+		if query.Limit > 0 {
+			opts.SetLimit(int64(query.Limit))
+		}
+		results, err := idx.find(ctx, filter, opts)
+		if err != nil {
+			return nil, err
+		}
+		collect(results, 1)
+	}
+
+	for _, prefix := range q.Prefixes {
+		// Tokens are lower case, so a case sensitive anchored regex can use the index
+		filter := bson.M{
+			"searchTokens": bson.Regex{Pattern: "^" + regexp.QuoteMeta(prefix)},
+			"duplicateOf":  bson.M{"$exists": false},
+		}
+		if query.OwnerID != "" {
+			filter["userId"] = query.OwnerID
+		}
+		opts := options.Find()
+		if query.Limit > 0 {
+			opts.SetLimit(int64(query.Limit))
+		}
+		results, err := idx.find(ctx, filter, opts)
+		if err != nil {
+			return nil, err
+		}
+		for i := range results {
+			results[i].Score = prefixBoost
+		}
+		collect(results, 1)
+	}
+
+	hits := make([]Hit, 0, len(scores))
+	for id, score := range scores {
+		hits = append(hits, Hit{
+			ID:         id,
+			Score:      score,
+			Highlights: HighlightOffer(offers[id], q),
+		})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID > hits[j].ID
+	})
+	if query.Limit > 0 && len(hits) > query.Limit {
+		hits = hits[:query.Limit]
+	}
+	return hits, nil
+}
+
+// find runs a query and decodes the offers it returns
+func (idx *MongoIndex) find(ctx context.Context, filter bson.M, opts *options.FindOptionsBuilder) ([]scoredOffer, error) {
+	cursor, err := idx.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []scoredOffer
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// textSearch renders terms and phrases in MongoDB $search syntax, where
+// quoted phrases must match and plain terms are alternatives
+func textSearch(q ParsedQuery) string {
+	parts := append([]string(nil), q.Terms...)
+	for _, phrase := range q.Phrases {
+		parts = append(parts, `"`+strings.Join(phrase, " ")+`"`)
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,285 @@
+// Package search provides full-text search over offers. Index is implemented
+// by a MongoDB text index for production and by an embedded in-process
+// inverted index that needs no database.
+package search
+
+import (
+	"context"
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+
+	"loyaltea-server/internal/models"
+)
+
+// Fields that are searched, in the names used for highlights
+const (
+	FieldSubject = "subject"
+	FieldBody    = "body"
+	FieldBrand   = "brand"
+	FieldTags    = "tags"
+)
+
+// fieldWeights rank a match in a short, specific field above one in the body
+var fieldWeights = map[string]float64{
+	FieldBrand:   4,
+	FieldSubject: 3,
+	FieldTags:    2,
+	FieldBody:    1,
+}
+
+// Query is a search request. OwnerID restricts results to one user's offers.
+type Query struct {
+	Text    string
+	OwnerID string
+	Limit   int
+}
+
+// Hit is one matching offer with its relevance score and highlighted snippets
+// per field
+type Hit struct {
+	ID         string
+	Score      float64
+	Highlights map[string][]string
+}
+
+// Index indexes and searches offers
+type Index interface {
+	Index(ctx context.Context, offer *models.Offer) error
+	Remove(ctx context.Context, id string) error
+	Search(ctx context.Context, query Query) ([]Hit, error)
+}
+
+// ParsedQuery is the user's search text split into clauses. Quoted text is a
+// phrase, a word ending in * is a prefix, anything else a plain term.
+type ParsedQuery struct {
+	Terms    []string
+	Prefixes []string
+	Phrases  [][]string
+}
+
+// Empty reports whether the query has nothing to search for
+func (q ParsedQuery) Empty() bool {
+	return len(q.Terms) == 0 && len(q.Prefixes) == 0 && len(q.Phrases) == 0
+}
+
+// clauses returns the number of clauses used to reward hits matching many of them
+func (q ParsedQuery) clauses() int {
+	return len(q.Terms) + len(q.Prefixes) + len(q.Phrases)
+}
+
+// ParseQuery splits search text into terms, prefixes and phrases
+func ParseQuery(text string) ParsedQuery {
+	var q ParsedQuery
+	parts := strings.Split(text, `"`)
+	for i, part := range parts {
+		// Odd parts sit between quotes
+		if i%2 == 1 {
+			words := Tokenize(part)
+			switch len(words) {
+			case 0:
+			case 1:
+				q.Terms = append(q.Terms, words[0])
+			default:
+				q.Phrases = append(q.Phrases, words)
+			}
+			continue
+		}
+
+		for _, field := range strings.Fields(part) {
+			prefix := strings.HasSuffix(field, "*")
+			words := Tokenize(field)
+			for j, word := range words {
+				if prefix && j == len(words)-1 {
+					q.Prefixes = append(q.Prefixes, word)
+				} else {
+					q.Terms = append(q.Terms, word)
+				}
+			}
+		}
+	}
+	return q
+}
+
+// Tokenize lowercases text and splits it into words of letters and digits
+func Tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// span is a byte range of a matched word sequence in the original text
+type span struct {
+	start, end int
+}
+
+// token is a word of the original text with its byte offsets
+type token struct {
+	word       string
+	start, end int
+}
+
+// tokenizeWithOffsets is Tokenize that remembers where each word came from
+func tokenizeWithOffsets(text string) []token {
+	var tokens []token
+	start := -1
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isWord && start < 0 {
+			start = i
+		}
+		if !isWord && start >= 0 {
+			tokens = append(tokens, token{strings.ToLower(text[start:i]), start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{strings.ToLower(text[start:]), start, len(text)})
+	}
+	return tokens
+}
+
+// matchSpans returns where the query matches text
+func matchSpans(text string, q ParsedQuery) []span {
+	tokens := tokenizeWithOffsets(text)
+	var spans []span
+	for i, t := range tokens {
+		for _, term := range q.Terms {
+			if t.word == term {
+				spans = append(spans, span{t.start, t.end})
+			}
+		}
+		for _, prefix := range q.Prefixes {
+			if strings.HasPrefix(t.word, prefix) {
+				spans = append(spans, span{t.start, t.end})
+			}
+		}
+		for _, phrase := range q.Phrases {
+			if i+len(phrase) > len(tokens) {
+				continue
+			}
+			matched := true
+			for j, word := range phrase {
+				if tokens[i+j].word != word {
+					matched = false
+					break
+				}
+			}
+			if matched {
+				spans = append(spans, span{t.start, tokens[i+len(phrase)-1].end})
+			}
+		}
+	}
+
+	// Merge overlapping spans so marks never nest
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	merged := spans[:0]
+	for _, s := range spans {
+		if n := len(merged); n > 0 && s.start <= merged[n-1].end {
+			if s.end > merged[n-1].end {
+				merged[n-1].end = s.end
+			}
+			continue
+		}
+		merged = append(merged, s)
+	}
+	return merged
+}
+
+// snippetContext is how many bytes of text surround a match in a snippet
+const snippetContext = 40
+
+// maxSnippets is the most snippets returned per field
+const maxSnippets = 3
+
+// Highlight returns HTML-escaped snippets of text around the query matches,
+// with the matches wrapped in <mark> tags. It returns nil if nothing matched.
+func Highlight(text string, q ParsedQuery) []string {
+	spans := matchSpans(text, q)
+	if len(spans) == 0 {
+		return nil
+	}
+
+	var snippets []string
+	for i := 0; i < len(spans) && len(snippets) < maxSnippets; {
+		start := wordBoundary(text, spans[i].start-snippetContext, false)
+		end := wordBoundary(text, spans[i].end+snippetContext, true)
+
+		// Pull following matches into the same snippet if they fit
+		j := i
+		for j+1 < len(spans) && spans[j+1].start < end {
+			j++
+			if spans[j].end > end {
+				end = spans[j].end
+			}
+		}
+
+		var b strings.Builder
+		if start > 0 {
+			b.WriteString("…")
+		}
+		pos := start
+		for _, s := range spans[i : j+1] {
+			b.WriteString(html.EscapeString(text[pos:s.start]))
+			b.WriteString("<mark>")
+			b.WriteString(html.EscapeString(text[s.start:s.end]))
+			b.WriteString("</mark>")
+			pos = s.end
+		}
+		b.WriteString(html.EscapeString(text[pos:end]))
+		if end < len(text) {
+			b.WriteString("…")
+		}
+
+		snippets = append(snippets, strings.Join(strings.Fields(b.String()), " "))
+		i = j + 1
+	}
+	return snippets
+}
+
+// HighlightOffer returns the snippets of every field of offer that matches
+func HighlightOffer(offer *models.Offer, q ParsedQuery) map[string][]string {
+	highlights := map[string][]string{}
+	fields := map[string]string{
+		FieldSubject: offer.Subject,
+		FieldBody:    offer.Body,
+		FieldBrand:   offer.Brand,
+		FieldTags:    strings.Join(offerTags(offer), ", "),
+	}
+	for name, text := range fields {
+		if snippets := Highlight(text, q); snippets != nil {
+			highlights[name] = snippets
+		}
+	}
+	return highlights
+}
+
+// offerTags returns the tags given by the sender followed by those assigned
+// by the classifier, which are searched as one field as the tag filter of
+// offer listings matches either
+func offerTags(offer *models.Offer) []string {
+	tags := append([]string(nil), offer.Tags...)
+	return append(tags, offer.InferredTags...)
+}
+
+// wordBoundary moves pos to the nearest space so snippets do not cut words,
+// searching forwards or backwards, and clamps it to the text
+func wordBoundary(text string, pos int, forward bool) int {
+	if pos <= 0 {
+		return 0
+	}
+	if pos >= len(text) {
+		return len(text)
+	}
+	if forward {
+		if i := strings.IndexByte(text[pos:], ' '); i >= 0 {
+			return pos + i
+		}
+		return len(text)
+	}
+	if i := strings.LastIndexByte(text[:pos], ' '); i >= 0 {
+		return i + 1
+	}
+	return 0
+}
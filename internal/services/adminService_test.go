@@ -0,0 +1,96 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/services"
+)
+
+func TestSetRole(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	admin := env.createUser(t, "admin@example.com", true)
+	user := env.createUser(t, "user@example.com", true)
+	ctx := context.Background()
+
+	if _, err := env.admin.SetRole(ctx, admin.ID, user.ID, "owner"); !errors.Is(err, services.ErrInvalidRole) {
+		t.Fatalf("unknown role: error = %v, want ErrInvalidRole", err)
+	}
+	if _, err := env.admin.SetRole(ctx, admin.ID, admin.ID, models.RoleCustomer); !errors.Is(err, services.ErrCannotEditSelf) {
+		t.Fatalf("own role: error = %v, want ErrCannotEditSelf", err)
+	}
+	if _, err := env.admin.SetRole(ctx, admin.ID, "missing", models.RoleCustomer); !errors.Is(err, services.ErrUserNotFound) {
+		t.Fatalf("unknown user: error = %v, want ErrUserNotFound", err)
+	}
+
+	updated, err := env.admin.SetRole(ctx, admin.ID, user.ID, models.RoleMerchantStaff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Role != models.RoleMerchantStaff || env.findUser(t, user.ID).Role != models.RoleMerchantStaff {
+		t.Fatal("role not changed")
+	}
+}
+
+func TestSuspendUser(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	admin := env.createUser(t, "admin@example.com", true)
+	user := env.createUser(t, "user@example.com", true)
+	ctx := context.Background()
+
+	session, err := env.tokenService.IssueTokens(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := env.admin.SuspendUser(ctx, admin.ID, admin.ID, "oops"); !errors.Is(err, services.ErrCannotEditSelf) {
+		t.Fatalf("self: error = %v, want ErrCannotEditSelf", err)
+	}
+	suspended, err := env.admin.SuspendUser(ctx, admin.ID, user.ID, "chargebacks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !suspended.Suspended || suspended.SuspendedReason != "chargebacks" || suspended.SuspendedAt == nil {
+		t.Fatalf("user = %+v, want suspended with the reason", suspended)
+	}
+	if _, err := env.tokenService.Refresh(ctx, session.RefreshToken); !errors.Is(err, services.ErrInvalidRefreshToken) {
+		t.Fatalf("session after suspension: error = %v, want ErrInvalidRefreshToken", err)
+	}
+
+	unsuspended, err := env.admin.UnsuspendUser(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unsuspended.Suspended || unsuspended.SuspendedReason != "" {
+		t.Fatalf("user = %+v, want unsuspended", unsuspended)
+	}
+	if _, err := env.userService.LoginUser("user@example.com", testPassword, "10.0.0.1"); err != nil {
+		t.Fatalf("login after unsuspending: %v", err)
+	}
+}
+
+func TestListUsers(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	for _, email := range []string{"ann@example.com", "bob@example.com", "anna@corp.example"} {
+		env.createUser(t, email, true)
+	}
+	ctx := context.Background()
+
+	page, err := env.admin.ListUsers(ctx, "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 3 || page.Page != 1 || page.Limit != 20 {
+		t.Fatalf("page = %+v, want all 3 users on page 1 of 20", page)
+	}
+
+	page, err = env.admin.ListUsers(ctx, "ann", 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 2 || len(page.Users) != 1 {
+		t.Fatalf("page = %+v, want 1 of 2 matching users", page)
+	}
+}
@@ -0,0 +1,240 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"loyaltea-server/internal/earn"
+	"loyaltea-server/internal/models"
+)
+
+var (
+	ErrEarnRuleNotFound = errors.New("earn rule not found")
+	ErrInvalidEarnRule  = errors.New("invalid earn rule")
+	ErrInvalidPurchase  = errors.New("invalid purchase")
+)
+
+// maxPurchaseClockSkew is how far ahead of the server's clock a purchase may
+// say it occurred
+const maxPurchaseClockSkew = 5 * time.Minute
+
+// EarnService manages the rules points are earned by and awards points for
+// purchases under them
+type EarnService struct {
+	ruleModel     EarnRuleRepository
+	pointsModel   PointsRepository
+	pointsService *PointsService
+	userModel     UserRepository
+}
+
+func NewEarnService(ruleModel EarnRuleRepository, pointsModel PointsRepository, pointsService *PointsService, userModel UserRepository) *EarnService {
+	return &EarnService{
+		ruleModel:     ruleModel,
+		pointsModel:   pointsModel,
+		pointsService: pointsService,
+		userModel:     userModel,
+	}
+}
+
+// EarnResult is the outcome of evaluating the rules against a purchase. Entry
+// is the ledger entry the points were credited in, nil for a dry run or a
+// purchase that earned nothing.
+type EarnResult struct {
+	earn.Result
+	Entry *models.PointsEntry `json:"entry,omitempty"`
+}
+
+// ListRules returns every rule, oldest first
+func (s *EarnService) ListRules(ctx context.Context) ([]models.EarnRule, error) {
+	return s.ruleModel.List(ctx, false)
+}
+
+// GetRule returns a rule
+func (s *EarnService) GetRule(ctx context.Context, id string) (*models.EarnRule, error) {
+	rule, err := s.ruleModel.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if rule == nil {
+		return nil, ErrEarnRuleNotFound
+	}
+	return rule, nil
+}
+
+// CreateRule validates and stores a new rule
+func (s *EarnService) CreateRule(ctx context.Context, rule *models.EarnRule) error {
+	rule.ID = ""
+	if err := validateRule(rule); err != nil {
+		return err
+	}
+	return s.ruleModel.Create(ctx, rule)
+}
+
+// UpdateRule validates and replaces a rule
+func (s *EarnService) UpdateRule(ctx context.Context, rule *models.EarnRule) error {
+	if err := validateRule(rule); err != nil {
+		return err
+	}
+	found, err := s.ruleModel.Update(ctx, rule)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrEarnRuleNotFound
+	}
+	return nil
+}
+
+// DeleteRule removes a rule. Entries already credited keep their explanation.
+func (s *EarnService) DeleteRule(ctx context.Context, id string) error {
+	found, err := s.ruleModel.Delete(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrEarnRuleNotFound
+	}
+	return nil
+}
+
+// AwardPurchase credits a user with the points a purchase earns under the
+// active rules. The rules are evaluated as the entry is appended, so two
+// purchases awarded at once cannot both take a first purchase bonus or both
+// fill the same cap. A purchase is awarded once; awarding its ID again fails
+// with ErrAlreadyEarned.
+func (s *EarnService) AwardPurchase(ctx context.Context, purchase models.Purchase) (*EarnResult, error) {
+	if err := normalizePurchase(&purchase); err != nil {
+		return nil, err
+	}
+	if purchase.UserID == "" {
+		return nil, fmt.Errorf("%w: user_id is required", ErrInvalidPurchase)
+	}
+
+	rules, err := s.ruleModel.List(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &EarnResult{}
+	result.Entry, err = s.pointsService.Earn(ctx, purchase.UserID, purchase.ID, purchase.OccurredAt, func(ctx context.Context) (int64, []models.RuleApplication, error) {
+		history, err := s.history(ctx, rules, purchase)
+		if err != nil {
+			return 0, nil, err
+		}
+		result.Result = earn.Evaluate(rules, purchase, history)
+		return result.Points, result.Applied, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// DryRun evaluates rules against a purchase without crediting anything. It
+// uses the stored active rules unless given rules to try, which need not be
+// saved. With a user ID the user's ledger counts towards first purchase
+// bonuses and caps; without one the purchase is treated as a first purchase.
+func (s *EarnService) DryRun(ctx context.Context, purchase models.Purchase, rules []models.EarnRule) (*EarnResult, error) {
+	if err := normalizePurchase(&purchase); err != nil {
+		return nil, err
+	}
+
+	if rules == nil {
+		var err error
+		rules, err = s.ruleModel.List(ctx, true)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		for i := range rules {
+			if err := validateRule(&rules[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	history := earn.History{FirstPurchase: true}
+	if purchase.UserID != "" {
+		if err := s.pointsService.requireUser(ctx, purchase.UserID); err != nil {
+			return nil, err
+		}
+		var err error
+		history, err = s.history(ctx, rules, purchase)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &EarnResult{Result: earn.Evaluate(rules, purchase, history)}, nil
+}
+
+// history looks up whether a purchase is the user's first to earn points and
+// the points earned from purchases made in each period rules cap that
+// contains the purchase. Periods go by when purchases occurred, not when
+// they were recorded.
+func (s *EarnService) history(ctx context.Context, rules []models.EarnRule, purchase models.Purchase) (earn.History, error) {
+	earned, err := s.pointsModel.Earned(ctx, purchase.UserID, models.PointsReasonPurchase, time.Time{}, time.Time{})
+	if err != nil {
+		return earn.History{}, err
+	}
+	history := earn.History{
+		FirstPurchase: earned == 0,
+		Earned:        map[string]int64{},
+	}
+
+	for _, rule := range rules {
+		if rule.Type != models.EarnRuleCap {
+			continue
+		}
+		if _, ok := history.Earned[rule.Period]; ok {
+			continue
+		}
+		from := earn.PeriodStart(rule.Period, purchase.OccurredAt)
+		to := earn.PeriodEnd(rule.Period, purchase.OccurredAt)
+		points, err := s.pointsModel.Earned(ctx, purchase.UserID, models.PointsReasonPurchase, from, to)
+		if err != nil {
+			return earn.History{}, err
+		}
+		history.Earned[rule.Period] = points
+	}
+	return history, nil
+}
+
+// validateRule tidies a rule's text fields and checks it
+func validateRule(rule *models.EarnRule) error {
+	rule.Name = strings.TrimSpace(rule.Name)
+	rule.Category = strings.TrimSpace(rule.Category)
+	rule.Currency = strings.ToUpper(strings.TrimSpace(rule.Currency))
+	if err := earn.Validate(*rule); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidEarnRule, err)
+	}
+	return nil
+}
+
+// normalizePurchase checks a purchase, defaulting when it happened to now
+func normalizePurchase(purchase *models.Purchase) error {
+	purchase.ID = strings.TrimSpace(purchase.ID)
+	purchase.Currency = strings.ToUpper(strings.TrimSpace(purchase.Currency))
+	if purchase.ID == "" {
+		return fmt.Errorf("%w: id is required", ErrInvalidPurchase)
+	}
+	if purchase.Amount < 0 {
+		return fmt.Errorf("%w: amount cannot be negative", ErrInvalidPurchase)
+	}
+	for _, item := range purchase.Items {
+		if item.Amount < 0 {
+			return fmt.Errorf("%w: item amounts cannot be negative", ErrInvalidPurchase)
+		}
+	}
+	now := time.Now()
+	if purchase.OccurredAt.IsZero() {
+		purchase.OccurredAt = now
+	}
+	if purchase.OccurredAt.After(now.Add(maxPurchaseClockSkew)) {
+		return fmt.Errorf("%w: occurred_at cannot be in the future", ErrInvalidPurchase)
+	}
+	return nil
+}
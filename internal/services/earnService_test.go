@@ -0,0 +1,214 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/services"
+)
+
+// createRules stores active earn rules
+func (env *testEnv) createRules(t *testing.T, rules ...models.EarnRule) {
+	t.Helper()
+	for i := range rules {
+		rules[i].Active = true
+		if err := env.earnService.CreateRule(context.Background(), &rules[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestEarnRuleCRUD(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	ctx := context.Background()
+
+	if err := env.earnService.CreateRule(ctx, &models.EarnRule{Name: "Broken", Type: models.EarnRuleCap}); !errors.Is(err, services.ErrInvalidEarnRule) {
+		t.Fatalf("invalid rule: error = %v, want ErrInvalidEarnRule", err)
+	}
+
+	rule := &models.EarnRule{Name: " Points ", Type: models.EarnRulePerCurrency, Points: 1, Currency: "usd", Active: true}
+	if err := env.earnService.CreateRule(ctx, rule); err != nil {
+		t.Fatal(err)
+	}
+	got, err := env.earnService.GetRule(ctx, rule.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "Points" || got.Currency != "USD" {
+		t.Fatalf("stored rule = %+v, want trimmed name and upper case currency", got)
+	}
+
+	got.Points = 2
+	if err := env.earnService.UpdateRule(ctx, got); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.earnService.UpdateRule(ctx, &models.EarnRule{ID: "missing", Name: "x", Type: models.EarnRulePerVisit, Points: 1}); !errors.Is(err, services.ErrEarnRuleNotFound) {
+		t.Fatalf("update missing: error = %v, want ErrEarnRuleNotFound", err)
+	}
+	if err := env.earnService.DeleteRule(ctx, rule.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.earnService.GetRule(ctx, rule.ID); !errors.Is(err, services.ErrEarnRuleNotFound) {
+		t.Fatalf("deleted rule: error = %v, want ErrEarnRuleNotFound", err)
+	}
+}
+
+func TestAwardPurchase(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	user := env.createUser(t, "user@example.com", true)
+	env.createRules(t, models.EarnRule{Name: "Points per dollar", Type: models.EarnRulePerCurrency, Points: 1})
+	ctx := context.Background()
+
+	result, err := env.earnService.AwardPurchase(ctx, models.Purchase{ID: "order-1", UserID: user.ID, Amount: 25.5, Currency: "USD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Points != 25 || result.Entry == nil || result.Entry.Balance != 25 || len(result.Entry.Explanation) != 1 {
+		t.Fatalf("result = %+v, want 25 points credited with an explanation", result)
+	}
+
+	if _, err := env.earnService.AwardPurchase(ctx, models.Purchase{ID: "order-1", UserID: user.ID, Amount: 25.5}); !errors.Is(err, services.ErrAlreadyEarned) {
+		t.Fatalf("repeated purchase: error = %v, want ErrAlreadyEarned", err)
+	}
+
+	nothing, err := env.earnService.AwardPurchase(ctx, models.Purchase{ID: "order-2", UserID: user.ID, Amount: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nothing.Points != 0 || nothing.Entry != nil {
+		t.Fatalf("result = %+v, want nothing credited", nothing)
+	}
+}
+
+func TestAwardPurchaseRejects(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	user := env.createUser(t, "user@example.com", true)
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		purchase models.Purchase
+		want     error
+	}{
+		{"no id", models.Purchase{UserID: user.ID, Amount: 10}, services.ErrInvalidPurchase},
+		{"no user", models.Purchase{ID: "order-1", Amount: 10}, services.ErrInvalidPurchase},
+		{"negative amount", models.Purchase{ID: "order-1", UserID: user.ID, Amount: -1}, services.ErrInvalidPurchase},
+		{"future date", models.Purchase{ID: "order-1", UserID: user.ID, Amount: 10, OccurredAt: time.Now().Add(time.Hour)}, services.ErrInvalidPurchase},
+		{"unknown user", models.Purchase{ID: "order-1", UserID: "missing", Amount: 10}, services.ErrUserNotFound},
+	}
+	env.createRules(t, models.EarnRule{Name: "Per visit", Type: models.EarnRulePerVisit, Points: 5})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := env.earnService.AwardPurchase(ctx, tt.purchase); !errors.Is(err, tt.want) {
+				t.Fatalf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCapGoesByWhenPurchasesOccurred(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	user := env.createUser(t, "user@example.com", true)
+	env.createRules(t,
+		models.EarnRule{Name: "Points per dollar", Type: models.EarnRulePerCurrency, Points: 1},
+		models.EarnRule{Name: "Daily cap", Type: models.EarnRuleCap, MaxPoints: 50, Period: models.EarnPeriodDay},
+	)
+	ctx := context.Background()
+	yesterday := time.Now().AddDate(0, 0, -1)
+
+	award := func(id string, amount float64, at time.Time) int64 {
+		t.Helper()
+		result, err := env.earnService.AwardPurchase(ctx, models.Purchase{ID: id, UserID: user.ID, Amount: amount, OccurredAt: at})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.Points
+	}
+
+	if got := award("order-1", 40, yesterday); got != 40 {
+		t.Fatalf("yesterday's purchase earned %d, want 40", got)
+	}
+	// Recorded today, but yesterday's points do not count towards today's cap
+	if got := award("order-2", 40, time.Time{}); got != 40 {
+		t.Fatalf("today's purchase earned %d, want 40", got)
+	}
+	// Reported late, it counts towards yesterday's cap
+	if got := award("order-3", 40, yesterday); got != 10 {
+		t.Fatalf("late purchase earned %d, want 10", got)
+	}
+}
+
+func TestFirstPurchaseBonusOnce(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	user := env.createUser(t, "user@example.com", true)
+	env.createRules(t,
+		models.EarnRule{Name: "Per visit", Type: models.EarnRulePerVisit, Points: 10},
+		models.EarnRule{Name: "Welcome", Type: models.EarnRuleFirstPurchase, Points: 100},
+	)
+	ctx := context.Background()
+
+	// Two purchases awarded at once cannot both take the bonus
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = env.earnService.AwardPurchase(ctx, models.Purchase{ID: fmt.Sprintf("order-%d", i), UserID: user.ID, Amount: 10})
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	balance, err := env.pointsService.Balance(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != 120 {
+		t.Fatalf("balance = %d, want 120 with a single bonus", balance)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	user := env.createUser(t, "user@example.com", true)
+	env.createRules(t, models.EarnRule{Name: "Welcome", Type: models.EarnRuleFirstPurchase, Points: 100})
+	ctx := context.Background()
+
+	result, err := env.earnService.DryRun(ctx, models.Purchase{ID: "order-1", UserID: user.ID, Amount: 10}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Points != 100 || result.Entry != nil {
+		t.Fatalf("result = %+v, want 100 points and no entry", result)
+	}
+	if balance, _ := env.pointsService.Balance(ctx, user.ID); balance != 0 {
+		t.Fatalf("dry run credited %d points", balance)
+	}
+
+	// Unsaved rules are validated and used instead of the stored ones
+	rules := []models.EarnRule{{Name: "Double", Type: models.EarnRulePerCurrency, Points: 2, Active: true}}
+	result, err = env.earnService.DryRun(ctx, models.Purchase{ID: "order-1", Amount: 10}, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Points != 20 {
+		t.Fatalf("points = %d, want 20", result.Points)
+	}
+	invalid := []models.EarnRule{{Name: "Broken", Type: models.EarnRuleMultiplier, Multiplier: 1}}
+	if _, err := env.earnService.DryRun(ctx, models.Purchase{ID: "order-1", Amount: 10}, invalid); !errors.Is(err, services.ErrInvalidEarnRule) {
+		t.Fatalf("invalid rule: error = %v, want ErrInvalidEarnRule", err)
+	}
+	if _, err := env.earnService.DryRun(ctx, models.Purchase{ID: "order-1", Amount: 10, OccurredAt: time.Now().AddDate(0, 0, 1)}, nil); !errors.Is(err, services.ErrInvalidPurchase) {
+		t.Fatalf("future date: error = %v, want ErrInvalidPurchase", err)
+	}
+}
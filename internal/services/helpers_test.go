@@ -0,0 +1,178 @@
+package services_test
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"loyaltea-server/internal/brands"
+	"loyaltea-server/internal/classify"
+	"loyaltea-server/internal/db/memory"
+	"loyaltea-server/internal/mailer"
+	"loyaltea-server/internal/marketing"
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/search"
+	"loyaltea-server/internal/services"
+	"loyaltea-server/internal/utils"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	testBaseURL       = "https://api.loyaltea.test"
+	testResetURL      = "https://app.loyaltea.test/reset-password"
+	testInboundDomain = "in.loyaltea.test"
+	testPassword      = "correct horse"
+	testMailgunKey    = "mailgun-signing-key"
+	testRawKey        = "raw-signing-key"
+	testMailchimpKey  = "mailchimp-secret"
+	testMandrillKey   = "mandrill-key"
+	testMandrillURL   = testBaseURL + "/offer/mailchimp"
+)
+
+// testEnv is every service wired to the in-memory repositories, with mail
+// and mailing list calls recorded instead of sent
+type testEnv struct {
+	users         *memory.UserRepository
+	offers        *memory.OfferRepository
+	refreshTokens *memory.RefreshTokenRepository
+	oneTimeTokens *memory.OneTimeTokenRepository
+	attempts      *memory.LoginAttemptRepository
+	events        *memory.SecurityEventRepository
+	points        *memory.PointsRepository
+	rules         *memory.EarnRuleRepository
+	nonces        *memory.WebhookNonceRepository
+	outboxItems   *memory.OutboxRepository
+	index         *search.MemoryIndex
+	mail          *mailer.Recorder
+	provider      *marketing.Recorder
+
+	userService   *services.UserService
+	tokenService  *services.TokenService
+	verification  *services.VerificationService
+	lockout       *services.LockoutService
+	mfa           *services.MFAService
+	password      *services.PasswordService
+	offerService  *services.OfferService
+	inbound       *services.InboundService
+	pointsService *services.PointsService
+	earnService   *services.EarnService
+	webhooks      *services.WebhookService
+	subscriptions *services.SubscriptionService
+	outbox        *services.OutboxService
+	admin         *services.AdminService
+}
+
+// newTestEnv wires the services as main does, under the given verification policy
+func newTestEnv(t *testing.T, policy services.VerificationPolicy) *testEnv {
+	t.Helper()
+	env := &testEnv{
+		users:         memory.NewUserRepository(),
+		offers:        memory.NewOfferRepository(),
+		refreshTokens: memory.NewRefreshTokenRepository(),
+		oneTimeTokens: memory.NewOneTimeTokenRepository(),
+		attempts:      memory.NewLoginAttemptRepository(),
+		events:        memory.NewSecurityEventRepository(),
+		points:        memory.NewPointsRepository(),
+		rules:         memory.NewEarnRuleRepository(),
+		nonces:        memory.NewWebhookNonceRepository(),
+		outboxItems:   memory.NewOutboxRepository(),
+		index:         search.NewMemoryIndex(),
+		mail:          &mailer.Recorder{},
+		provider:      &marketing.Recorder{},
+	}
+
+	env.verification = services.NewVerificationService(env.users, env.offers, env.mail, testBaseURL, policy)
+	env.lockout = services.NewLockoutService(env.attempts, env.events, env.users, env.oneTimeTokens, env.mail, testBaseURL, services.DefaultLockoutPolicy())
+	env.offerService = services.NewOfferService(env.offers, env.users, env.index, brands.NewDetector(brands.DefaultCatalog()), classify.New(classify.DefaultRules()), policy)
+	env.inbound = services.NewInboundService(env.users, env.offerService, testInboundDomain)
+	env.userService = services.NewUserService(env.users, env.verification, env.lockout)
+	env.tokenService = services.NewTokenService(env.refreshTokens, env.users, policy)
+	env.mfa = services.NewMFAService(env.users, env.lockout, "Loyaltea")
+	env.password = services.NewPasswordService(env.users, env.oneTimeTokens, env.tokenService, env.mail, testResetURL)
+	env.pointsService = services.NewPointsService(env.points, env.users)
+	env.earnService = services.NewEarnService(env.rules, env.points, env.pointsService, env.users)
+	env.webhooks = services.NewWebhookService(env.nonces, services.WebhookConfig{
+		MailgunSigningKey: testMailgunKey,
+		MailchimpSecret:   testMailchimpKey,
+		MandrillKey:       testMandrillKey,
+		MandrillURL:       testMandrillURL,
+		RawSigningKey:     testRawKey,
+	})
+	env.subscriptions = services.NewSubscriptionService(env.users)
+	env.outbox = services.NewOutboxService(env.outboxItems, env.provider, env.subscriptions, services.DefaultOutboxPolicy())
+	env.admin = services.NewAdminService(env.users, env.tokenService)
+	return env
+}
+
+// createUser stores a customer with testPassword and a forwarding address,
+// verified or not
+func (env *testEnv) createUser(t *testing.T, email string, verified bool) *models.User {
+	t.Helper()
+	alias, err := utils.GenerateInboundAlias()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{
+		ID:           bson.NewObjectID().Hex(),
+		Email:        email,
+		Password:     testPassword,
+		Name:         "Test User",
+		Role:         models.RoleCustomer,
+		InboundAlias: alias,
+	}
+	if err := env.users.Create(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	if verified {
+		if _, err := env.users.MarkEmailVerified(context.Background(), user.ID, email); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return env.findUser(t, user.ID)
+}
+
+// findUser returns a stored user, failing the test if it is missing
+func (env *testEnv) findUser(t *testing.T, id string) *models.User {
+	t.Helper()
+	user, err := env.users.FindByID(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user == nil {
+		t.Fatalf("user %s not found", id)
+	}
+	return user
+}
+
+// linkToken matches the token in the links the services email
+var linkToken = regexp.MustCompile(`token=(\S+)`)
+
+// mailedToken returns the token in the last message sent to an address
+func (env *testEnv) mailedToken(t *testing.T, to string) string {
+	t.Helper()
+	messages := env.mail.Messages()
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].To != to {
+			continue
+		}
+		match := linkToken.FindStringSubmatch(messages[i].Body)
+		if match == nil {
+			t.Fatalf("no token in message to %s: %q", to, messages[i].Body)
+		}
+		return match[1]
+	}
+	t.Fatalf("no message sent to %s", to)
+	return ""
+}
+
+// mailCount returns how many messages were sent to an address
+func (env *testEnv) mailCount(to string) int {
+	count := 0
+	for _, msg := range env.mail.Messages() {
+		if msg.To == to {
+			count++
+		}
+	}
+	return count
+}
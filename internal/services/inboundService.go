@@ -0,0 +1,193 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"loyaltea-server/internal/inbound"
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/utils"
+)
+
+var (
+	ErrUnknownRecipient = errors.New("unknown recipient")
+	ErrInvalidMessage   = errors.New("invalid message")
+)
+
+// InboundService receives offers emailed to the forwarding addresses of users
+type InboundService struct {
+	userModel    UserRepository
+	offerService *OfferService
+	domain       string
+}
+
+func NewInboundService(userModel UserRepository, offerService *OfferService, domain string) *InboundService {
+	return &InboundService{
+		userModel:    userModel,
+		offerService: offerService,
+		domain:       strings.ToLower(domain),
+	}
+}
+
+// AddressFor returns the address a user forwards offers to, or "" if the user
+// has no inbound alias yet. See BackfillAliases.
+func (s *InboundService) AddressFor(user *models.User) string {
+	if user.InboundAlias == "" {
+		return ""
+	}
+	return user.InboundAlias + "@" + s.domain
+}
+
+// EnsureAlias gives a user registered before forwarding addresses existed an
+// inbound alias
+func (s *InboundService) EnsureAlias(ctx context.Context, user *models.User) error {
+	if user.InboundAlias != "" {
+		return nil
+	}
+
+	alias, err := utils.GenerateInboundAlias()
+	if err != nil {
+		return err
+	}
+	if err := s.userModel.SetInboundAlias(ctx, user.ID, alias); err != nil {
+		return err
+	}
+	user.InboundAlias = alias
+	return nil
+}
+
+// BackfillAliases gives every user registered before forwarding addresses
+// existed an inbound alias, returning how many were given one. It is run at
+// startup.
+func (s *InboundService) BackfillAliases(ctx context.Context) (int, error) {
+	count := 0
+	for {
+		users, err := s.userModel.FindWithoutInboundAlias(ctx, 100)
+		if err != nil {
+			return count, err
+		}
+		if len(users) == 0 {
+			return count, nil
+		}
+		for i := range users {
+			if err := s.EnsureAlias(ctx, &users[i]); err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+}
+
+// RotateAlias gives a user a new inbound alias. Mail to the old forwarding
+// address is rejected from then on.
+func (s *InboundService) RotateAlias(ctx context.Context, userID string) (*models.User, error) {
+	user, err := s.userModel.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+
+	alias, err := utils.GenerateInboundAlias()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.userModel.SetInboundAlias(ctx, user.ID, alias); err != nil {
+		return nil, err
+	}
+	user.InboundAlias = alias
+	return user, nil
+}
+
+// isForwardingAddress reports whether address is in the inbound domain
+func (s *InboundService) isForwardingAddress(address string) bool {
+	_, domain, ok := strings.Cut(strings.ToLower(strings.TrimSpace(address)), "@")
+	return ok && domain == s.domain
+}
+
+// ResolveRecipient returns the user a forwarding address belongs to
+func (s *InboundService) ResolveRecipient(ctx context.Context, address string) (*models.User, error) {
+	local, domain, ok := strings.Cut(strings.ToLower(strings.TrimSpace(address)), "@")
+	if !ok || domain != s.domain || local == "" {
+		return nil, ErrUnknownRecipient
+	}
+
+	user, err := s.userModel.FindByInboundAlias(ctx, local)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || user.Suspended {
+		return nil, ErrUnknownRecipient
+	}
+	return user, nil
+}
+
+// Receive stores an offer for the user owning the first forwarding address
+// among recipients. Offers not sent to a forwarding address are attributed
+// by SenderEmail, as CreateOffer does.
+func (s *InboundService) Receive(ctx context.Context, recipients []string, offer *models.Offer) error {
+	for _, address := range recipients {
+		if !s.isForwardingAddress(address) {
+			continue
+		}
+		user, err := s.ResolveRecipient(ctx, address)
+		if err != nil {
+			return err
+		}
+		return s.offerService.ReceiveForUser(ctx, user, offer)
+	}
+	return s.offerService.CreateOffer(ctx, offer)
+}
+
+// ValidateRecipient checks that mail to address can be delivered
+func (s *InboundService) ValidateRecipient(ctx context.Context, address string) error {
+	_, err := s.ResolveRecipient(ctx, address)
+	return err
+}
+
+// Deliver stores the offer in a raw message for each user it was sent to.
+// Every recipient is checked before anything is stored, so a rejected message
+// leaves nothing behind for the sending server's retry to duplicate. Once one
+// offer has been stored the message counts as delivered, and a failure to
+// store it for another recipient is only logged.
+func (s *InboundService) Deliver(ctx context.Context, from string, to []string, message []byte) error {
+	email, err := inbound.Parse(bytes.NewReader(message))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+	}
+
+	var users []*models.User
+	seen := map[string]bool{}
+	for _, address := range to {
+		user, err := s.ResolveRecipient(ctx, address)
+		if err != nil {
+			return err
+		}
+		if seen[user.ID] {
+			continue
+		}
+		seen[user.ID] = true
+		if err := s.offerService.checkReceiver(user); err != nil {
+			return err
+		}
+		users = append(users, user)
+	}
+
+	delivered := 0
+	for _, user := range users {
+		if err := s.offerService.ReceiveForUser(ctx, user, email.Offer()); err != nil {
+			if delivered == 0 {
+				return err
+			}
+			log.Printf("Failed to deliver offer to user %s: %v", user.ID, err)
+			continue
+		}
+		delivered++
+	}
+	return nil
+}
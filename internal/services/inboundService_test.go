@@ -0,0 +1,179 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/services"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// rawMessage builds a plain text email forwarded from an address
+func rawMessage(from string, subject string, body string) []byte {
+	return []byte(strings.Join([]string{
+		"From: " + from,
+		"To: someone@example.com",
+		"Subject: " + subject,
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		body,
+	}, "\r\n"))
+}
+
+// listOffers returns every stored offer
+func (env *testEnv) listOffers(t *testing.T) []models.Offer {
+	t.Helper()
+	offers, err := env.offers.List(context.Background(), models.OfferQuery{SortBy: models.OfferSortCreatedAt, Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return offers
+}
+
+func TestReceiveRoutesByForwardingAddress(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	user := env.createUser(t, "user@example.com", true)
+	ctx := context.Background()
+
+	// Forwarded from an address the user never registered
+	offer := &models.Offer{SenderEmail: "work@corp.example", Subject: "Team lunch deal", Body: "Ten percent off group orders."}
+	if err := env.inbound.Receive(ctx, []string{"other@example.com", strings.ToUpper(env.inbound.AddressFor(user))}, offer); err != nil {
+		t.Fatal(err)
+	}
+	if offer.UserID != user.ID || offer.SenderEmail != user.Email {
+		t.Fatalf("offer = %+v, want it stored for %s", offer, user.ID)
+	}
+
+	// Without a forwarding address it goes by the sender
+	unrouted := &models.Offer{SenderEmail: "stranger@example.com", Subject: "Hello", Body: "Nothing to see."}
+	if err := env.inbound.Receive(ctx, []string{"other@example.com"}, unrouted); err != nil {
+		t.Fatal(err)
+	}
+	if unrouted.Status != models.OfferStatusUnclaimed {
+		t.Fatalf("status = %q, want unclaimed", unrouted.Status)
+	}
+
+	unknown := &models.Offer{SenderEmail: "user@example.com", Subject: "Lost", Body: "Sent to a retired address."}
+	if err := env.inbound.Receive(ctx, []string{"nobody@" + testInboundDomain}, unknown); !errors.Is(err, services.ErrUnknownRecipient) {
+		t.Fatalf("error = %v, want ErrUnknownRecipient", err)
+	}
+}
+
+func TestResolveRecipientSuspended(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	user := env.createUser(t, "user@example.com", true)
+	ctx := context.Background()
+
+	if err := env.inbound.ValidateRecipient(ctx, env.inbound.AddressFor(user)); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.users.SetSuspended(ctx, user.ID, true, "abuse"); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.inbound.ValidateRecipient(ctx, env.inbound.AddressFor(user)); !errors.Is(err, services.ErrUnknownRecipient) {
+		t.Fatalf("error = %v, want ErrUnknownRecipient", err)
+	}
+}
+
+func TestDeliverChecksEveryRecipientFirst(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	alice := env.createUser(t, "alice@example.com", true)
+	bob := env.createUser(t, "bob@example.com", true)
+	ctx := context.Background()
+	message := rawMessage("alice@example.com", "Garden centre sale", "All plants are 30% off this weekend.")
+
+	err := env.inbound.Deliver(ctx, "alice@example.com", []string{env.inbound.AddressFor(alice), "nobody@" + testInboundDomain}, message)
+	if !errors.Is(err, services.ErrUnknownRecipient) {
+		t.Fatalf("error = %v, want ErrUnknownRecipient", err)
+	}
+	if offers := env.listOffers(t); len(offers) != 0 {
+		t.Fatalf("stored %d offers for a rejected message, want 0", len(offers))
+	}
+
+	to := []string{env.inbound.AddressFor(alice), env.inbound.AddressFor(bob), env.inbound.AddressFor(alice)}
+	if err := env.inbound.Deliver(ctx, "alice@example.com", to, message); err != nil {
+		t.Fatal(err)
+	}
+	owners := map[string]int{}
+	for _, offer := range env.listOffers(t) {
+		owners[offer.UserID]++
+	}
+	if owners[alice.ID] != 1 || owners[bob.ID] != 1 {
+		t.Fatalf("offers by owner = %v, want one each", owners)
+	}
+
+	if err := env.inbound.Deliver(ctx, "alice@example.com", to, []byte("not an email")); !errors.Is(err, services.ErrInvalidMessage) {
+		t.Fatalf("error = %v, want ErrInvalidMessage", err)
+	}
+}
+
+func TestDeliverRequiresVerifiedRecipients(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{RequireForOffers: true})
+	verified := env.createUser(t, "verified@example.com", true)
+	unverified := env.createUser(t, "unverified@example.com", false)
+	message := rawMessage("verified@example.com", "Sale", "Everything is 10% off.")
+
+	to := []string{env.inbound.AddressFor(verified), env.inbound.AddressFor(unverified)}
+	if err := env.inbound.Deliver(context.Background(), "verified@example.com", to, message); !errors.Is(err, services.ErrSenderNotVerified) {
+		t.Fatalf("error = %v, want ErrSenderNotVerified", err)
+	}
+	if offers := env.listOffers(t); len(offers) != 0 {
+		t.Fatalf("stored %d offers, want 0", len(offers))
+	}
+}
+
+func TestBackfillAliases(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	ctx := context.Background()
+	env.createUser(t, "new@example.com", true)
+	for _, email := range []string{"old1@example.com", "old2@example.com"} {
+		err := env.users.Create(ctx, &models.User{ID: bson.NewObjectID().Hex(), Email: email, Password: testPassword})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count, err := env.inbound.BackfillAliases(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("backfilled %d users, want 2", count)
+	}
+	old, err := env.users.FindByEmail(ctx, "old1@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.inbound.AddressFor(old) == "" {
+		t.Fatal("old user has no forwarding address")
+	}
+}
+
+func TestRotateAlias(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	user := env.createUser(t, "user@example.com", true)
+	ctx := context.Background()
+	oldAddress := env.inbound.AddressFor(user)
+
+	rotated, err := env.inbound.RotateAlias(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newAddress := env.inbound.AddressFor(rotated)
+	if newAddress == oldAddress {
+		t.Fatal("forwarding address not changed")
+	}
+	if err := env.inbound.ValidateRecipient(ctx, oldAddress); !errors.Is(err, services.ErrUnknownRecipient) {
+		t.Fatalf("old address: error = %v, want ErrUnknownRecipient", err)
+	}
+	if err := env.inbound.ValidateRecipient(ctx, newAddress); err != nil {
+		t.Fatalf("new address: %v", err)
+	}
+	if _, err := env.inbound.RotateAlias(ctx, "missing"); !errors.Is(err, services.ErrUserNotFound) {
+		t.Fatalf("error = %v, want ErrUserNotFound", err)
+	}
+}
@@ -0,0 +1,314 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"loyaltea-server/internal/mailer"
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/utils"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrInvalidUnlockToken = errors.New("invalid or expired unlock token")
+)
+
+// LoginThrottledError is returned when a login is refused without checking
+// the password because of too many recent failures
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+	Locked     bool // The account or IP is locked out rather than just delayed
+}
+
+func (e *LoginThrottledError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("too many failed logins, locked for %s", e.RetryAfter.Round(time.Second))
+	}
+	return fmt.Sprintf("too many failed logins, retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+// LockoutPolicy configures failed login throttling
+type LockoutPolicy struct {
+	DelayAfter      int           // Failures allowed before delays start
+	BaseDelay       time.Duration // Delay after the first throttled failure, doubled for each further one
+	MaxDelay        time.Duration
+	MaxFailures     int // Failures per account before it is locked
+	IPMaxFailures   int // Failures per client IP before it is locked
+	LockoutDuration time.Duration
+	Window          time.Duration // Failures older than this are forgotten
+}
+
+// DefaultLockoutPolicy returns the policy used unless configured otherwise
+func DefaultLockoutPolicy() LockoutPolicy {
+	return LockoutPolicy{
+		DelayAfter:      3,
+		BaseDelay:       time.Second,
+		MaxDelay:        30 * time.Second,
+		MaxFailures:     10,
+		IPMaxFailures:   50,
+		LockoutDuration: 30 * time.Minute,
+		Window:          time.Hour,
+	}
+}
+
+// LockoutService tracks failed logins per account and per client IP, applies
+// progressive delays and temporary lockouts, and records security events
+type LockoutService struct {
+	attemptModel LoginAttemptRepository
+	eventModel   SecurityEventRepository
+	userModel    UserRepository
+	tokenModel   OneTimeTokenRepository
+	mailer       mailer.Mailer
+	baseURL      string
+	policy       LockoutPolicy
+}
+
+// NewLockoutService creates a new LockoutService instance
+func NewLockoutService(attemptModel LoginAttemptRepository, eventModel SecurityEventRepository, userModel UserRepository, tokenModel OneTimeTokenRepository, m mailer.Mailer, baseURL string, policy LockoutPolicy) *LockoutService {
+	return &LockoutService{
+		attemptModel: attemptModel,
+		eventModel:   eventModel,
+		userModel:    userModel,
+		tokenModel:   tokenModel,
+		mailer:       m,
+		baseURL:      baseURL,
+		policy:       policy,
+	}
+}
+
+// CheckLogin returns a *LoginThrottledError if a login for email from ip must
+// be refused right now
+func (s *LockoutService) CheckLogin(ctx context.Context, email string, ip string) error {
+	now := time.Now()
+	for _, id := range []string{attemptID(models.AttemptKindAccount, normalizeEmail(email)), attemptID(models.AttemptKindIP, ip)} {
+		attempt, err := s.attemptModel.FindByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if attempt == nil {
+			continue
+		}
+
+		if attempt.LockedUntil != nil && now.Before(*attempt.LockedUntil) {
+			s.recordEvent(ctx, models.SecurityEventLoginThrottled, "", email, ip, "locked out")
+			return &LoginThrottledError{RetryAfter: attempt.LockedUntil.Sub(now), Locked: true}
+		}
+		if attempt.NextAttemptAt != nil && now.Before(*attempt.NextAttemptAt) {
+			s.recordEvent(ctx, models.SecurityEventLoginThrottled, "", email, ip, "delayed")
+			return &LoginThrottledError{RetryAfter: attempt.NextAttemptAt.Sub(now)}
+		}
+	}
+	return nil
+}
+
+// RecordFailure counts a failed login for the account and the client IP.
+// user is nil when the email is not registered.
+func (s *LockoutService) RecordFailure(ctx context.Context, user *models.User, email string, ip string) error {
+	userID := ""
+	if user != nil {
+		userID = user.ID
+	}
+	s.recordEvent(ctx, models.SecurityEventLoginFailed, userID, email, ip, "")
+
+	accountLocked, err := s.countFailure(ctx, models.AttemptKindAccount, normalizeEmail(email), s.policy.MaxFailures)
+	if err != nil {
+		return err
+	}
+	if accountLocked {
+		s.recordEvent(ctx, models.SecurityEventAccountLocked, userID, email, ip, fmt.Sprintf("locked for %s", s.policy.LockoutDuration))
+		if user != nil {
+			if err := s.sendUnlockEmail(ctx, user); err != nil {
+				log.Printf("Failed to send unlock email to %s: %v", user.Email, err)
+			}
+		}
+	}
+
+	ipLocked, err := s.countFailure(ctx, models.AttemptKindIP, ip, s.policy.IPMaxFailures)
+	if err != nil {
+		return err
+	}
+	if ipLocked {
+		s.recordEvent(ctx, models.SecurityEventIPBlocked, "", "", ip, fmt.Sprintf("blocked for %s", s.policy.LockoutDuration))
+	}
+
+	return nil
+}
+
+// RecordSuccess clears the failure count of an account after a good login.
+// The IP count is kept so one valid account cannot hide an attack on others.
+func (s *LockoutService) RecordSuccess(ctx context.Context, email string) error {
+	return s.attemptModel.Delete(ctx, attemptID(models.AttemptKindAccount, normalizeEmail(email)))
+}
+
+// RequestUnlock emails a fresh unlock link if the account is locked. Like
+// ForgotPassword it gives no hint whether the email is registered.
+func (s *LockoutService) RequestUnlock(ctx context.Context, email string) error {
+	attempt, err := s.attemptModel.FindByID(ctx, attemptID(models.AttemptKindAccount, normalizeEmail(email)))
+	if err != nil {
+		return err
+	}
+	if attempt == nil || attempt.LockedUntil == nil || time.Now().After(*attempt.LockedUntil) {
+		return nil
+	}
+
+	user, err := s.userModel.FindByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return nil
+	}
+	return s.sendUnlockEmail(ctx, user)
+}
+
+// UnlockAccount lifts a lockout using an emailed unlock token
+func (s *LockoutService) UnlockAccount(ctx context.Context, token string) error {
+	stored, err := s.tokenModel.Consume(ctx, models.TokenPurposeAccountUnlock, utils.HashToken(token))
+	if err != nil {
+		return err
+	}
+	if stored == nil {
+		return ErrInvalidUnlockToken
+	}
+
+	user, err := s.userModel.FindByID(ctx, stored.UserID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrInvalidUnlockToken
+	}
+
+	if err := s.attemptModel.Delete(ctx, attemptID(models.AttemptKindAccount, normalizeEmail(user.Email))); err != nil {
+		return err
+	}
+	s.recordEvent(ctx, models.SecurityEventAccountUnlocked, user.ID, user.Email, "", "unlocked by email")
+	return nil
+}
+
+// ListEvents returns a page of security events for admins
+func (s *LockoutService) ListEvents(ctx context.Context, filter models.SecurityEventFilter, page int64, limit int64) ([]models.SecurityEvent, int64, error) {
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 50
+	}
+	return s.eventModel.List(ctx, filter, (page-1)*limit, limit)
+}
+
+// countFailure adds a failure for a key and applies the resulting delay or
+// lockout. It reports whether this failure locked the key. Logins are refused
+// while a key is locked, so once a lockout expires the next failure locks it
+// again until the failures age out of the window.
+func (s *LockoutService) countFailure(ctx context.Context, kind string, key string, maxFailures int) (bool, error) {
+	if key == "" {
+		return false, nil
+	}
+	id := attemptID(kind, key)
+	now := time.Now()
+
+	// Start counting afresh once old failures have aged out
+	existing, err := s.attemptModel.FindByID(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	if existing != nil && now.Sub(existing.LastFailureAt) > s.policy.Window &&
+		(existing.LockedUntil == nil || now.After(*existing.LockedUntil)) {
+		if err := s.attemptModel.Delete(ctx, id); err != nil {
+			return false, err
+		}
+	}
+
+	attempt, err := s.attemptModel.IncrementFailures(ctx, kind, key, now)
+	if err != nil {
+		return false, err
+	}
+
+	if maxFailures > 0 && attempt.Failures >= maxFailures {
+		lockedUntil := now.Add(s.policy.LockoutDuration)
+		if err := s.attemptModel.SetRestrictions(ctx, id, nil, &lockedUntil); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	if attempt.Failures > s.policy.DelayAfter {
+		nextAttemptAt := now.Add(s.delay(attempt.Failures - s.policy.DelayAfter))
+		if err := s.attemptModel.SetRestrictions(ctx, id, &nextAttemptAt, nil); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// delay returns the wait before the next attempt after n throttled failures
+func (s *LockoutService) delay(n int) time.Duration {
+	delay := float64(s.policy.BaseDelay) * math.Pow(2, float64(n-1))
+	if delay > float64(s.policy.MaxDelay) {
+		return s.policy.MaxDelay
+	}
+	return time.Duration(delay)
+}
+
+func (s *LockoutService) sendUnlockEmail(ctx context.Context, user *models.User) error {
+	if err := s.tokenModel.InvalidateForUser(ctx, user.ID, models.TokenPurposeAccountUnlock); err != nil {
+		return err
+	}
+
+	token, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	err = s.tokenModel.Create(ctx, &models.OneTimeToken{
+		ID:        primitive.NewObjectID().Hex(),
+		UserID:    user.ID,
+		Purpose:   models.TokenPurposeAccountUnlock,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: time.Now().Add(s.policy.LockoutDuration),
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Your Loyaltea account has been locked",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nWe locked your account after several failed login attempts. If that was you, open the link below to unlock it now.\n\n%s/user/unlock?token=%s\n\nIf it was not you, consider resetting your password.\n",
+			user.Name, s.baseURL, token,
+		),
+	})
+}
+
+// recordEvent stores a security event. Failures are only logged since they
+// must never block a login decision.
+func (s *LockoutService) recordEvent(ctx context.Context, eventType string, userID string, email string, ip string, details string) {
+	err := s.eventModel.Create(ctx, &models.SecurityEvent{
+		ID:      primitive.NewObjectID().Hex(),
+		Type:    eventType,
+		UserID:  userID,
+		Email:   email,
+		IP:      ip,
+		Details: details,
+	})
+	if err != nil {
+		log.Printf("Failed to record security event %s: %v", eventType, err)
+	}
+}
+
+func attemptID(kind string, key string) string {
+	return kind + ":" + key
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,115 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/services"
+)
+
+func TestLoginDelaysAfterFailures(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	env.createUser(t, "user@example.com", true)
+
+	policy := services.DefaultLockoutPolicy()
+	for i := 0; i <= policy.DelayAfter; i++ {
+		if _, err := env.userService.LoginUser("user@example.com", "wrong password", "10.0.0.1"); !errors.Is(err, services.ErrInvalidCredentials) {
+			t.Fatalf("failure %d: error = %v, want ErrInvalidCredentials", i+1, err)
+		}
+	}
+
+	// Even the right password is refused until the delay is over
+	_, err := env.userService.LoginUser("user@example.com", testPassword, "10.0.0.1")
+	var throttled *services.LoginThrottledError
+	if !errors.As(err, &throttled) {
+		t.Fatalf("error = %v, want *LoginThrottledError", err)
+	}
+	if throttled.Locked || throttled.RetryAfter <= 0 || throttled.RetryAfter > policy.BaseDelay {
+		t.Fatalf("throttled = %+v, want a delay of at most %s", throttled, policy.BaseDelay)
+	}
+}
+
+func TestLockoutAndUnlock(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	user := env.createUser(t, "user@example.com", true)
+	ctx := context.Background()
+
+	for i := 0; i < services.DefaultLockoutPolicy().MaxFailures; i++ {
+		if err := env.lockout.RecordFailure(ctx, user, "user@example.com", "10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var throttled *services.LoginThrottledError
+	if err := env.lockout.CheckLogin(ctx, "User@Example.com", "10.0.0.2"); !errors.As(err, &throttled) || !throttled.Locked {
+		t.Fatalf("error = %v, want the account locked", err)
+	}
+	events, _, err := env.lockout.ListEvents(ctx, models.SecurityEventFilter{Type: models.SecurityEventAccountLocked}, 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].UserID != user.ID {
+		t.Fatalf("account_locked events = %+v, want one for the user", events)
+	}
+
+	// A fresh link replaces the one sent when the account was locked
+	first := env.mailedToken(t, "user@example.com")
+	if err := env.lockout.RequestUnlock(ctx, "user@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	token := env.mailedToken(t, "user@example.com")
+	if err := env.lockout.UnlockAccount(ctx, first); !errors.Is(err, services.ErrInvalidUnlockToken) {
+		t.Fatalf("replaced link: error = %v, want ErrInvalidUnlockToken", err)
+	}
+
+	if err := env.lockout.UnlockAccount(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.lockout.CheckLogin(ctx, "user@example.com", "10.0.0.2"); err != nil {
+		t.Fatalf("still refused after unlocking: %v", err)
+	}
+	if err := env.lockout.UnlockAccount(ctx, token); !errors.Is(err, services.ErrInvalidUnlockToken) {
+		t.Fatalf("reused link: error = %v, want ErrInvalidUnlockToken", err)
+	}
+}
+
+func TestRequestUnlockNotLocked(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	env.createUser(t, "user@example.com", true)
+
+	if err := env.lockout.RequestUnlock(context.Background(), "user@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(env.mail.Messages()); n != 0 {
+		t.Fatalf("sent %d emails for an account that is not locked, want 0", n)
+	}
+}
+
+func TestSuccessKeepsIPFailures(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	ctx := context.Background()
+
+	if err := env.lockout.RecordFailure(ctx, nil, "user@example.com", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.lockout.RecordSuccess(ctx, "user@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	account, err := env.attempts.FindByID(ctx, models.AttemptKindAccount+":user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account != nil {
+		t.Fatalf("account attempt = %+v, want it cleared", account)
+	}
+	ip, err := env.attempts.FindByID(ctx, models.AttemptKindIP+":10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip == nil || ip.Failures != 1 {
+		t.Fatalf("ip attempt = %+v, want the failure kept", ip)
+	}
+}
@@ -0,0 +1,239 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/utils"
+)
+
+// recoveryCodeCount is how many recovery codes a user gets at a time
+const recoveryCodeCount = 10
+
+// totpSkew is how many 30 second steps of clock drift are tolerated
+const totpSkew = 1
+
+var (
+	ErrMFAAlreadyEnabled   = errors.New("two-factor authentication already enabled")
+	ErrMFANotEnabled       = errors.New("two-factor authentication not enabled")
+	ErrMFANotPending       = errors.New("two-factor authentication setup not started")
+	ErrInvalidMFACode      = errors.New("invalid two-factor code")
+	ErrInvalidMFAChallenge = errors.New("invalid or expired two-factor challenge")
+)
+
+// MFAEnrollment is what a user needs to add the account to an authenticator app
+type MFAEnrollment struct {
+	Secret string
+	URI    string
+}
+
+// MFAService handles TOTP enrollment, recovery codes and the second step of login
+type MFAService struct {
+	userModel UserRepository
+	lockout   *LockoutService
+	issuer    string
+	now       func() time.Time
+}
+
+// NewMFAService creates a new MFAService instance. issuer is the name shown
+// in authenticator apps.
+func NewMFAService(userModel UserRepository, lockout *LockoutService, issuer string) *MFAService {
+	return &MFAService{
+		userModel: userModel,
+		lockout:   lockout,
+		issuer:    issuer,
+		now:       time.Now,
+	}
+}
+
+// SetClock replaces the clock TOTP codes are checked against
+func (s *MFAService) SetClock(now func() time.Time) {
+	s.now = now
+}
+
+// BeginEnrollment generates a new secret for the user. It only becomes
+// active once ConfirmEnrollment has seen a valid code for it.
+func (s *MFAService) BeginEnrollment(ctx context.Context, userID string) (*MFAEnrollment, error) {
+	user, err := s.findUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.MFAEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.userModel.SetPendingMFASecret(ctx, user.ID, secret); err != nil {
+		return nil, err
+	}
+
+	return &MFAEnrollment{
+		Secret: secret,
+		URI:    utils.TOTPURI(secret, s.issuer, user.Email),
+	}, nil
+}
+
+// ConfirmEnrollment enables two-factor authentication once the user proves
+// their app produces valid codes. It returns the recovery codes, which are
+// only ever shown this once.
+func (s *MFAService) ConfirmEnrollment(ctx context.Context, userID string, code string) ([]string, error) {
+	user, err := s.findUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.MFAEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if user.MFAPendingSecret == "" {
+		return nil, ErrMFANotPending
+	}
+
+	step, ok := utils.ValidateTOTP(user.MFAPendingSecret, code, s.now(), totpSkew)
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.userModel.EnableMFA(ctx, user.ID, user.MFAPendingSecret, step, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Disable turns two-factor authentication off after checking the password
+// and a current code or recovery code
+func (s *MFAService) Disable(ctx context.Context, userID string, password string, code string) error {
+	user, err := s.findUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !user.MFAEnabled {
+		return ErrMFANotEnabled
+	}
+	if !s.userModel.VerifyPassword(user, password) {
+		return ErrInvalidCredentials
+	}
+	if err := s.verifyCode(ctx, user, code); err != nil {
+		return err
+	}
+
+	return s.userModel.DisableMFA(ctx, user.ID)
+}
+
+// RegenerateRecoveryCodes replaces every recovery code of the user after
+// checking a current code
+func (s *MFAService) RegenerateRecoveryCodes(ctx context.Context, userID string, code string) ([]string, error) {
+	user, err := s.findUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.MFAEnabled {
+		return nil, ErrMFANotEnabled
+	}
+	if err := s.verifyCode(ctx, user, code); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.userModel.SetRecoveryCodes(ctx, user.ID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// CompleteLogin finishes a login that needed a second factor. Wrong codes
+// count towards the same lockout as wrong passwords.
+func (s *MFAService) CompleteLogin(ctx context.Context, challengeToken string, code string, ip string) (*models.User, error) {
+	claims, err := utils.ValidateMFAChallengeToken(challengeToken)
+	if err != nil {
+		return nil, ErrInvalidMFAChallenge
+	}
+
+	user, err := s.userModel.FindByID(ctx, claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || !user.MFAEnabled {
+		return nil, ErrInvalidMFAChallenge
+	}
+	if user.Suspended {
+		return nil, ErrAccountSuspended
+	}
+
+	if err := s.lockout.CheckLogin(ctx, user.Email, ip); err != nil {
+		return nil, err
+	}
+
+	if err := s.verifyCode(ctx, user, code); err != nil {
+		if err == ErrInvalidMFACode {
+			if err := s.lockout.RecordFailure(ctx, user, user.Email, ip); err != nil {
+				return nil, err
+			}
+		}
+		return nil, err
+	}
+
+	if err := s.lockout.RecordSuccess(ctx, user.Email); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// verifyCode accepts either a TOTP code, which can only be used once, or an
+// unused recovery code, which is consumed
+func (s *MFAService) verifyCode(ctx context.Context, user *models.User, code string) error {
+	if step, ok := utils.ValidateTOTP(user.MFASecret, code, s.now(), totpSkew); ok {
+		fresh, err := s.userModel.UseTOTPStep(ctx, user.ID, step)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			return ErrInvalidMFACode
+		}
+		return nil
+	}
+
+	consumed, err := s.userModel.ConsumeRecoveryCode(ctx, user.ID, utils.HashToken(utils.NormalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+	if !consumed {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+func (s *MFAService) findUser(ctx context.Context, id string) (*models.User, error) {
+	user, err := s.userModel.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// newRecoveryCodes returns fresh recovery codes and the hashes to store
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := utils.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = utils.HashToken(code)
+	}
+	return codes, hashes, nil
+}
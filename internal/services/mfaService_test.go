@@ -0,0 +1,211 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/services"
+	"loyaltea-server/internal/utils"
+)
+
+// mfaClock is a settable clock for the MFAService
+type mfaClock struct {
+	now time.Time
+}
+
+func (c *mfaClock) Now() time.Time {
+	return c.now
+}
+
+// totpCode returns the code an authenticator app shows for secret at t
+func totpCode(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+	code, err := utils.TOTPCode(secret, utils.TOTPStep(at))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// enrollMFA turns on two-factor authentication for a user at the clock's
+// time and returns the secret and recovery codes
+func (env *testEnv) enrollMFA(t *testing.T, clock *mfaClock, userID string) (string, []string) {
+	t.Helper()
+	ctx := context.Background()
+	enrollment, err := env.mfa.BeginEnrollment(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, err := env.mfa.ConfirmEnrollment(ctx, userID, totpCode(t, enrollment.Secret, clock.now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return enrollment.Secret, codes
+}
+
+// newMFAEnv returns a testEnv whose MFAService reads a fixed clock
+func newMFAEnv(t *testing.T) (*testEnv, *mfaClock) {
+	t.Helper()
+	env := newTestEnv(t, services.VerificationPolicy{})
+	clock := &mfaClock{now: time.Date(2026, 3, 14, 9, 26, 53, 0, time.UTC)}
+	env.mfa.SetClock(clock.Now)
+	return env, clock
+}
+
+func TestMFAEnrollment(t *testing.T) {
+	env, clock := newMFAEnv(t)
+	user := env.createUser(t, "user@example.com", true)
+	ctx := context.Background()
+
+	if _, err := env.mfa.ConfirmEnrollment(ctx, user.ID, "123456"); !errors.Is(err, services.ErrMFANotPending) {
+		t.Fatalf("confirm before setup: error = %v, want ErrMFANotPending", err)
+	}
+
+	enrollment, err := env.mfa.BeginEnrollment(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(enrollment.URI, "otpauth://totp/") || !strings.Contains(enrollment.URI, enrollment.Secret) {
+		t.Fatalf("URI = %q, want an otpauth URI with the secret", enrollment.URI)
+	}
+
+	// Codes from outside the allowed drift are refused
+	for _, offset := range []time.Duration{-2 * time.Minute, 2 * time.Minute} {
+		code := totpCode(t, enrollment.Secret, clock.now.Add(offset))
+		if _, err := env.mfa.ConfirmEnrollment(ctx, user.ID, code); !errors.Is(err, services.ErrInvalidMFACode) {
+			t.Fatalf("code from %v: error = %v, want ErrInvalidMFACode", offset, err)
+		}
+	}
+	if env.findUser(t, user.ID).MFAEnabled {
+		t.Fatal("enabled with a wrong code")
+	}
+
+	// One step of drift either way is tolerated
+	codes, err := env.mfa.ConfirmEnrollment(ctx, user.ID, totpCode(t, enrollment.Secret, clock.now.Add(-30*time.Second)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) == 0 {
+		t.Fatal("no recovery codes")
+	}
+	stored := env.findUser(t, user.ID)
+	if !stored.MFAEnabled || stored.MFASecret != enrollment.Secret || stored.MFAPendingSecret != "" {
+		t.Fatalf("user = %+v, want the pending secret enabled", stored)
+	}
+	if _, err := env.mfa.BeginEnrollment(ctx, user.ID); !errors.Is(err, services.ErrMFAAlreadyEnabled) {
+		t.Fatalf("setup again: error = %v, want ErrMFAAlreadyEnabled", err)
+	}
+}
+
+func TestMFACompleteLogin(t *testing.T) {
+	env, clock := newMFAEnv(t)
+	user := env.createUser(t, "user@example.com", true)
+	secret, _ := env.enrollMFA(t, clock, user.ID)
+	ctx := context.Background()
+
+	challenge, err := utils.GenerateMFAChallengeToken(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The code used to confirm enrollment cannot be used again
+	if _, err := env.mfa.CompleteLogin(ctx, challenge, totpCode(t, secret, clock.now), "10.0.0.1"); !errors.Is(err, services.ErrInvalidMFACode) {
+		t.Fatalf("enrollment code: error = %v, want ErrInvalidMFACode", err)
+	}
+
+	clock.now = clock.now.Add(30 * time.Second)
+	code := totpCode(t, secret, clock.now)
+	loggedIn, err := env.mfa.CompleteLogin(ctx, challenge, code, "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loggedIn.ID != user.ID {
+		t.Fatalf("logged in as %s, want %s", loggedIn.ID, user.ID)
+	}
+	if _, err := env.mfa.CompleteLogin(ctx, challenge, code, "10.0.0.1"); !errors.Is(err, services.ErrInvalidMFACode) {
+		t.Fatalf("replayed code: error = %v, want ErrInvalidMFACode", err)
+	}
+	// Nor can a code older than the last one used
+	if _, err := env.mfa.CompleteLogin(ctx, challenge, totpCode(t, secret, clock.now.Add(-30*time.Second)), "10.0.0.1"); !errors.Is(err, services.ErrInvalidMFACode) {
+		t.Fatalf("older code: error = %v, want ErrInvalidMFACode", err)
+	}
+
+	if _, err := env.mfa.CompleteLogin(ctx, "not-a-challenge", code, "10.0.0.1"); !errors.Is(err, services.ErrInvalidMFAChallenge) {
+		t.Fatalf("bad challenge: error = %v, want ErrInvalidMFAChallenge", err)
+	}
+	access, err := utils.GenerateToken(utils.Claims{UserID: user.ID, Email: user.Email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.mfa.CompleteLogin(ctx, access, code, "10.0.0.1"); !errors.Is(err, services.ErrInvalidMFAChallenge) {
+		t.Fatalf("access token as challenge: error = %v, want ErrInvalidMFAChallenge", err)
+	}
+}
+
+func TestMFAWrongCodeCountsAsFailure(t *testing.T) {
+	env, clock := newMFAEnv(t)
+	user := env.createUser(t, "user@example.com", true)
+	env.enrollMFA(t, clock, user.ID)
+	ctx := context.Background()
+
+	challenge, err := utils.GenerateMFAChallengeToken(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.mfa.CompleteLogin(ctx, challenge, "000000", "10.0.0.1"); !errors.Is(err, services.ErrInvalidMFACode) {
+		t.Fatalf("error = %v, want ErrInvalidMFACode", err)
+	}
+
+	for _, id := range []string{models.AttemptKindAccount + ":user@example.com", models.AttemptKindIP + ":10.0.0.1"} {
+		attempt, err := env.attempts.FindByID(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attempt == nil || attempt.Failures != 1 {
+			t.Fatalf("%s: attempt = %+v, want one failure", id, attempt)
+		}
+	}
+}
+
+func TestMFARecoveryCodes(t *testing.T) {
+	env, clock := newMFAEnv(t)
+	user := env.createUser(t, "user@example.com", true)
+	secret, codes := env.enrollMFA(t, clock, user.ID)
+	ctx := context.Background()
+
+	challenge, err := utils.GenerateMFAChallengeToken(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Recovery codes are accepted however they are typed, but only once
+	if _, err := env.mfa.CompleteLogin(ctx, challenge, " "+strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))+" ", "10.0.0.1"); err != nil {
+		t.Fatalf("recovery code: %v", err)
+	}
+	if _, err := env.mfa.CompleteLogin(ctx, challenge, codes[0], "10.0.0.1"); !errors.Is(err, services.ErrInvalidMFACode) {
+		t.Fatalf("used recovery code: error = %v, want ErrInvalidMFACode", err)
+	}
+
+	clock.now = clock.now.Add(time.Minute)
+	fresh, err := env.mfa.RegenerateRecoveryCodes(ctx, user.ID, totpCode(t, secret, clock.now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.mfa.CompleteLogin(ctx, challenge, codes[1], "10.0.0.1"); !errors.Is(err, services.ErrInvalidMFACode) {
+		t.Fatalf("replaced recovery code: error = %v, want ErrInvalidMFACode", err)
+	}
+
+	if err := env.mfa.Disable(ctx, user.ID, "wrong password", fresh[0]); !errors.Is(err, services.ErrInvalidCredentials) {
+		t.Fatalf("disable with a wrong password: error = %v, want ErrInvalidCredentials", err)
+	}
+	if err := env.mfa.Disable(ctx, user.ID, testPassword, fresh[0]); err != nil {
+		t.Fatal(err)
+	}
+	if stored := env.findUser(t, user.ID); stored.MFAEnabled || stored.MFASecret != "" || len(stored.RecoveryCodes) != 0 {
+		t.Fatalf("user = %+v, want two-factor authentication off", stored)
+	}
+}
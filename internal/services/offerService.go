@@ -2,19 +2,568 @@ package services
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"loyaltea-server/internal/brands"
+	"loyaltea-server/internal/classify"
+	"loyaltea-server/internal/fingerprint"
 	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/promo"
+	"loyaltea-server/internal/sanitize"
+	"loyaltea-server/internal/search"
+)
+
+const (
+	defaultOfferPageSize = 20
+	maxOfferPageSize     = 100
+	defaultSearchLimit   = 20
+	maxSearchLimit       = 100
+	reclassifyBatchSize  = 200
+)
+
+var (
+	ErrSenderNotVerified = errors.New("sender email not verified")
+	ErrOfferNotFound     = errors.New("offer not found")
+	ErrInvalidCursor     = errors.New("invalid cursor")
+	ErrInvalidSort       = errors.New("invalid sort")
+	ErrEmptySearch       = errors.New("empty search query")
 )
 
+// OfferViewer is the caller an offer listing is scoped to. Admins see every
+// offer, everyone else only the offers they forwarded.
+type OfferViewer struct {
+	UserID  string
+	Email   string
+	IsAdmin bool
+}
+
+// OfferListParams are the filters, order and page of an offer listing
+type OfferListParams struct {
+	SenderEmail    string
+	Brand          string
+	Tag            string
+	Source         string
+	CreatedAfter   *time.Time
+	CreatedBefore  *time.Time
+	HideDuplicates bool
+	Sort           string // Field name, prefixed with "-" for descending order
+	Cursor         string // NextCursor of the previous page
+	Limit          int64
+}
+
+// OfferPage is one page of an offer listing. NextCursor is empty on the last page.
+type OfferPage struct {
+	Offers     []models.Offer
+	NextCursor string
+}
+
+// offerCursor is the decoded form of the opaque cursor handed to clients
+type offerCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+// OfferSearchResult is an offer matching a search with its relevance score
+// and the highlighted snippets of the fields that matched
+type OfferSearchResult struct {
+	Offer      models.Offer        `json:"offer"`
+	Score      float64             `json:"score"`
+	Highlights map[string][]string `json:"highlights"`
+}
+
 type OfferService struct {
-	offerModel *models.OfferModel
+	offerModel OfferRepository
+	userModel  UserRepository
+	index      search.Index
+	brands     *brands.Detector
+	classifier *classify.Classifier
+	policy     VerificationPolicy
 }
 
-func NewOfferService(offerModel *models.OfferModel) *OfferService {
+func NewOfferService(offerModel OfferRepository, userModel UserRepository, index search.Index, detector *brands.Detector, classifier *classify.Classifier, policy VerificationPolicy) *OfferService {
 	return &OfferService{
 		offerModel: offerModel,
+		userModel:  userModel,
+		index:      index,
+		brands:     detector,
+		classifier: classifier,
+		policy:     policy,
 	}
 }
 
+// ReclassifyResult counts the offers a reclassification looked at and changed
+type ReclassifyResult struct {
+	Scanned int `json:"scanned"`
+	Updated int `json:"updated"`
+}
+
+// CreateOffer stores an offer, linking it to the account that owns the sender
+// address once that address is verified. Offers from unknown or unverified
+// addresses are kept as unclaimed until the address is verified, whatever the
+// policy, as anyone can register someone else's address.
+//
+// Forwards of an offer already stored, by any user, are counted on the
+// canonical offer. A first forward from another address is still stored, with
+// DuplicateOf set, while a repeated forward from the same forwarder is not
+// stored again and offer is replaced by the canonical offer as that forwarder
+// may see it, keeping its SenderEmail.
 func (s *OfferService) CreateOffer(ctx context.Context, offer *models.Offer) error {
-	return s.offerModel.Create(ctx, offer)
+	sender, err := s.userModel.FindByAddress(ctx, offer.SenderEmail)
+	if err != nil {
+		return err
+	}
+
+	verified := sender != nil && addressVerified(sender, offer.SenderEmail)
+	if sender != nil && s.policy.RequireForOffers && !verified {
+		return ErrSenderNotVerified
+	}
+	if verified {
+		offer.UserID = sender.ID
+		offer.Status = models.OfferStatusClaimed
+	} else {
+		offer.Status = models.OfferStatusUnclaimed
+	}
+	return s.store(ctx, offer)
+}
+
+// ReceiveForUser stores an offer sent to the forwarding address of user, who
+// owns it whatever address it was forwarded from
+func (s *OfferService) ReceiveForUser(ctx context.Context, user *models.User, offer *models.Offer) error {
+	if err := s.checkReceiver(user); err != nil {
+		return err
+	}
+	offer.SenderEmail = user.Email
+	offer.UserID = user.ID
+	offer.Status = models.OfferStatusClaimed
+	return s.store(ctx, offer)
+}
+
+// addressVerified reports whether user has proven they own email. Alias
+// addresses are only attached once verified.
+func addressVerified(user *models.User, email string) bool {
+	if strings.EqualFold(user.Email, email) {
+		return user.EmailVerified
+	}
+	return slices.ContainsFunc(user.AliasEmails, func(alias string) bool {
+		return strings.EqualFold(alias, email)
+	})
+}
+
+// checkReceiver reports whether a user may receive offers under the
+// verification policy
+func (s *OfferService) checkReceiver(user *models.User) error {
+	if s.policy.RequireForOffers && !user.EmailVerified {
+		return ErrSenderNotVerified
+	}
+	return nil
+}
+
+// store fills in what can be derived from an offer's email, merges it with
+// any offer it duplicates and indexes it. See CreateOffer.
+func (s *OfferService) store(ctx context.Context, offer *models.Offer) error {
+	if offer.OriginalHTML != "" {
+		offer.SafeHTML = sanitize.HTML(offer.OriginalHTML)
+		if strings.TrimSpace(offer.Body) == "" {
+			offer.Body = sanitize.Text(offer.OriginalHTML)
+		}
+	}
+
+	if offer.Brand == "" {
+		s.detectBrand(offer)
+	}
+	extractTerms(offer, time.Now())
+	offer.InferredTags = s.classifier.Classify(offer)
+
+	now := time.Now()
+	forwarder := models.Forwarder{
+		UserID:           offer.UserID,
+		SenderEmail:      offer.SenderEmail,
+		Count:            1,
+		FirstForwardedAt: now,
+		LastForwardedAt:  now,
+	}
+
+	fp := fingerprint.Of(offer.Subject, offer.Body)
+	offer.ContentHash = fp.Hash
+	offer.Simhash = int64(fp.Simhash)
+	offer.SimhashBands = fp.Bands
+
+	canonical, err := s.findCanonical(ctx, offer)
+	if err != nil {
+		return err
+	}
+	if canonical != nil {
+		if err := s.offerModel.RecordForward(ctx, canonical.ID, forwarder); err != nil {
+			return err
+		}
+		if forwardedBy(canonical, offer) {
+			// Reload so the caller sees the forward just counted
+			updated, err := s.offerModel.FindByID(ctx, canonical.ID)
+			if err != nil {
+				return err
+			}
+			if updated != nil {
+				canonical = updated
+			}
+			viewer := OfferViewer{UserID: offer.UserID, Email: offer.SenderEmail}
+			*offer = *canonical
+			viewer.redact(offer)
+			offer.SenderEmail = viewer.Email
+			return nil
+		}
+		offer.DuplicateOf = canonical.ID
+	} else {
+		offer.Forwarders = []models.Forwarder{forwarder}
+		offer.ForwardCount = 1
+	}
+
+	if err := s.offerModel.Create(ctx, offer); err != nil {
+		return err
+	}
+	// The offer is stored either way, a failed index only hides it from search
+	if err := s.index.Index(ctx, offer); err != nil {
+		log.Printf("Failed to index offer %s: %v", offer.ID, err)
+	}
+	return nil
+}
+
+// GetOffer returns an offer the viewer is allowed to see
+func (s *OfferService) GetOffer(ctx context.Context, viewer OfferViewer, id string) (*models.Offer, error) {
+	offer, err := s.offerModel.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	// Offers of other users are reported as missing rather than forbidden
+	if offer == nil || !viewer.canSee(offer) {
+		return nil, ErrOfferNotFound
+	}
+	viewer.redact(offer)
+	return offer, nil
+}
+
+// ListOffers returns a page of the offers visible to the viewer
+func (s *OfferService) ListOffers(ctx context.Context, viewer OfferViewer, params OfferListParams) (*OfferPage, error) {
+	sortBy, desc, err := parseOfferSort(params.Sort)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := params.Limit
+	if limit < 1 {
+		limit = defaultOfferPageSize
+	}
+	if limit > maxOfferPageSize {
+		limit = maxOfferPageSize
+	}
+
+	query := models.OfferQuery{
+		SenderEmail:       params.SenderEmail,
+		Brand:             params.Brand,
+		Tag:               params.Tag,
+		Source:            params.Source,
+		CreatedAfter:      params.CreatedAfter,
+		CreatedBefore:     params.CreatedBefore,
+		ExcludeDuplicates: params.HideDuplicates,
+		SortBy:            sortBy,
+		SortDesc:          desc,
+		Limit:             limit + 1, // One extra to know whether there is a next page
+	}
+	if !viewer.IsAdmin {
+		query.OwnerID = viewer.UserID
+	}
+	if params.Cursor != "" {
+		query.After, err = decodeOfferCursor(params.Cursor, params.Sort, sortBy)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	offers, err := s.offerModel.List(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range offers {
+		viewer.redact(&offers[i])
+	}
+
+	page := &OfferPage{Offers: offers}
+	if int64(len(offers)) > limit {
+		page.Offers = offers[:limit]
+		page.NextCursor = encodeOfferCursor(params.Sort, sortBy, &page.Offers[limit-1])
+	}
+	return page, nil
+}
+
+// duplicateCandidates is the most canonical offers compared with a new one
+const duplicateCandidates = 50
+
+// findCanonical returns the stored canonical offer that offer is a forward
+// of, or nil. An identical offer is preferred over the most similar one.
+// Offers of every user are compared, see OfferViewer.redact for what other
+// forwarders are shown.
+func (s *OfferService) findCanonical(ctx context.Context, offer *models.Offer) (*models.Offer, error) {
+	candidates, err := s.offerModel.FindDuplicateCandidates(ctx, offer.ContentHash, offer.SimhashBands, duplicateCandidates)
+	if err != nil {
+		return nil, err
+	}
+
+	words := fingerprint.Normalize(offer.Subject, offer.Body)
+	var best *models.Offer
+	bestSimilarity := fingerprint.MinSimilarity
+	for i := range candidates {
+		candidate := &candidates[i]
+		if candidate.ContentHash == offer.ContentHash {
+			return candidate, nil
+		}
+		similarity := fingerprint.Similarity(words, fingerprint.Normalize(candidate.Subject, candidate.Body))
+		if similarity >= bestSimilarity {
+			best, bestSimilarity = candidate, similarity
+		}
+	}
+	return best, nil
+}
+
+// forwardedBy reports whether the forwarder of offer has already forwarded canonical
+func forwardedBy(canonical *models.Offer, offer *models.Offer) bool {
+	for _, f := range canonical.Forwarders {
+		if strings.EqualFold(f.SenderEmail, offer.SenderEmail) || (offer.UserID != "" && f.UserID == offer.UserID) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReclassifyOffers runs the classifier over every stored offer again, so
+// changed rules apply to old offers too. Every offer is indexed again as well,
+// which also indexes offers stored before the search index existed.
+func (s *OfferService) ReclassifyOffers(ctx context.Context) (*ReclassifyResult, error) {
+	result := &ReclassifyResult{}
+	query := models.OfferQuery{
+		SortBy: models.OfferSortCreatedAt,
+		Limit:  reclassifyBatchSize,
+	}
+	for {
+		offers, err := s.offerModel.List(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range offers {
+			offer := &offers[i]
+			result.Scanned++
+			tags := s.classifier.Classify(offer)
+			if !slices.Equal(tags, offer.InferredTags) {
+				if err := s.offerModel.SetInferredTags(ctx, offer.ID, tags); err != nil {
+					return nil, err
+				}
+				offer.InferredTags = tags
+				result.Updated++
+			}
+			if err := s.index.Index(ctx, offer); err != nil {
+				log.Printf("Failed to index offer %s: %v", offer.ID, err)
+			}
+		}
+
+		if len(offers) < reclassifyBatchSize {
+			return result, nil
+		}
+		last := offers[len(offers)-1]
+		query.After = &models.OfferCursor{Value: last.CreatedAt, ID: last.ID}
+	}
+}
+
+// detectBrand fills in the brand of an offer from its email
+func (s *OfferService) detectBrand(offer *models.Offer) {
+	match := s.brands.Detect(brands.Input{
+		SenderEmail:     offer.SenderEmail,
+		From:            headerValue(offer.Headers, "From"),
+		ListUnsubscribe: headerValue(offer.Headers, "List-Unsubscribe"),
+		Subject:         offer.Subject,
+		Body:            offer.Body,
+	})
+	if match != nil {
+		offer.Brand = match.Brand
+		offer.BrandConfidence = match.Confidence
+	}
+}
+
+// extractTerms fills in the codes, discounts, minimum spend and validity of
+// an offer from its email, keeping any the caller already set
+func extractTerms(offer *models.Offer, received time.Time) {
+	terms := promo.Extract(offer.Subject+"\n"+offer.Body, received)
+	if len(offer.Codes) == 0 {
+		offer.Codes = terms.Codes
+	}
+	if len(offer.Discounts) == 0 {
+		offer.Discounts = terms.Discounts
+	}
+	if offer.MinimumSpend == nil {
+		offer.MinimumSpend = terms.MinimumSpend
+	}
+	if offer.ValidFrom == nil {
+		offer.ValidFrom = terms.ValidFrom
+	}
+	if offer.ValidUntil == nil {
+		offer.ValidUntil = terms.ValidUntil
+	}
+}
+
+// headerValue looks up an email header, ignoring the case of its name
+func headerValue(headers map[string]string, name string) string {
+	for key, value := range headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// SearchOffers returns the offers visible to the viewer that match text, most
+// relevant first
+func (s *OfferService) SearchOffers(ctx context.Context, viewer OfferViewer, text string, limit int) ([]OfferSearchResult, error) {
+	if search.ParseQuery(text).Empty() {
+		return nil, ErrEmptySearch
+	}
+	if limit < 1 {
+		limit = defaultSearchLimit
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+
+	query := search.Query{Text: text, Limit: limit}
+	if !viewer.IsAdmin {
+		query.OwnerID = viewer.UserID
+	}
+	hits, err := s.index.Search(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]OfferSearchResult, 0, len(hits))
+	for _, hit := range hits {
+		// The index may lag behind the store, so visibility is checked again
+		offer, err := s.offerModel.FindByID(ctx, hit.ID)
+		if err != nil {
+			return nil, err
+		}
+		if offer == nil || !viewer.canSee(offer) {
+			continue
+		}
+		viewer.redact(offer)
+		results = append(results, OfferSearchResult{
+			Offer:      *offer,
+			Score:      hit.Score,
+			Highlights: hit.Highlights,
+		})
+	}
+	return results, nil
+}
+
+// canSee reports whether the viewer may read the offer. Forwarders of a
+// canonical offer may read it as well as its owner.
+func (v OfferViewer) canSee(offer *models.Offer) bool {
+	if v.IsAdmin || v.owns(offer) {
+		return true
+	}
+	return v.forwarderOf(offer) >= 0
+}
+
+// owns reports whether the offer is linked to the viewer's account
+func (v OfferViewer) owns(offer *models.Offer) bool {
+	return offer.UserID != "" && offer.UserID == v.UserID
+}
+
+// forwarderOf returns the index of the viewer in the forwarders of offer, or -1
+func (v OfferViewer) forwarderOf(offer *models.Offer) int {
+	for i, f := range offer.Forwarders {
+		if (v.UserID != "" && f.UserID == v.UserID) || (v.Email != "" && strings.EqualFold(f.SenderEmail, v.Email)) {
+			return i
+		}
+	}
+	return -1
+}
+
+// redact hides who else forwarded an offer from anyone but admins. The
+// forward count stays visible. Forwarders reading another user's canonical
+// offer are not told whose it is either.
+func (v OfferViewer) redact(offer *models.Offer) {
+	if v.IsAdmin {
+		return
+	}
+	if !v.owns(offer) {
+		offer.SenderEmail = ""
+		offer.UserID = ""
+		offer.Status = ""
+	}
+	if offer.Forwarders == nil {
+		return
+	}
+	if i := v.forwarderOf(offer); i >= 0 {
+		offer.Forwarders = []models.Forwarder{offer.Forwarders[i]}
+	} else {
+		offer.Forwarders = nil
+	}
+}
+
+// parseOfferSort reads a sort parameter such as "-createdAt". Offers are
+// listed newest first by default.
+func parseOfferSort(sort string) (string, bool, error) {
+	if sort == "" {
+		return models.OfferSortCreatedAt, true, nil
+	}
+
+	desc := strings.HasPrefix(sort, "-")
+	field := strings.TrimPrefix(sort, "-")
+	switch field {
+	case models.OfferSortCreatedAt, models.OfferSortSubject, models.OfferSortBrand:
+		return field, desc, nil
+	}
+	return "", false, ErrInvalidSort
+}
+
+func encodeOfferCursor(sort string, sortBy string, last *models.Offer) string {
+	cursor := offerCursor{Sort: sort, ID: last.ID}
+	switch sortBy {
+	case models.OfferSortCreatedAt:
+		cursor.Value = last.CreatedAt.UTC().Format(time.RFC3339Nano)
+	case models.OfferSortSubject:
+		cursor.Value = last.Subject
+	case models.OfferSortBrand:
+		cursor.Value = last.Brand
+	}
+
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeOfferCursor parses a cursor, which is only valid with the sort it was
+// issued for
+func decodeOfferCursor(value string, sort string, sortBy string) (*models.OfferCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor offerCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID == "" || cursor.Sort != sort {
+		return nil, ErrInvalidCursor
+	}
+
+	if sortBy == models.OfferSortCreatedAt {
+		createdAt, err := time.Parse(time.RFC3339Nano, cursor.Value)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		return &models.OfferCursor{Value: createdAt, ID: cursor.ID}, nil
+	}
+	return &models.OfferCursor{Value: cursor.Value, ID: cursor.ID}, nil
 }
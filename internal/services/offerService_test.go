@@ -0,0 +1,299 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/services"
+)
+
+// createOffer stores an offer forwarded from an address
+func (env *testEnv) createOffer(t *testing.T, sender string, subject string, body string) *models.Offer {
+	t.Helper()
+	offer := &models.Offer{SenderEmail: sender, Subject: subject, Body: body}
+	if err := env.offerService.CreateOffer(context.Background(), offer); err != nil {
+		t.Fatal(err)
+	}
+	return offer
+}
+
+func TestCreateOfferExtractsTerms(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	user := env.createUser(t, "user@example.com", true)
+
+	offer := env.createOffer(t, "user@example.com", "Coffee lovers: 20% off", "Use code BREW20 on any latte or espresso.")
+	if offer.UserID != user.ID || offer.Status != models.OfferStatusClaimed {
+		t.Fatalf("offer = %+v, want it claimed by %s", offer, user.ID)
+	}
+	if !slices.Contains(offer.Codes, "BREW20") {
+		t.Fatalf("codes = %v, want BREW20", offer.Codes)
+	}
+	if len(offer.Discounts) == 0 || offer.Discounts[0].Kind != models.DiscountPercent || offer.Discounts[0].Value != 20 {
+		t.Fatalf("discounts = %+v, want 20%% off", offer.Discounts)
+	}
+	if !slices.Contains(offer.InferredTags, "food-drink") {
+		t.Fatalf("inferred tags = %v, want food-drink", offer.InferredTags)
+	}
+}
+
+func TestCreateOfferRequiresVerifiedSender(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{RequireForOffers: true})
+	env.createUser(t, "user@example.com", false)
+
+	offer := &models.Offer{SenderEmail: "user@example.com", Subject: "Sale", Body: "Everything must go."}
+	if err := env.offerService.CreateOffer(context.Background(), offer); !errors.Is(err, services.ErrSenderNotVerified) {
+		t.Fatalf("error = %v, want ErrSenderNotVerified", err)
+	}
+}
+
+func TestRepeatedForwardIsMerged(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	env.createUser(t, "user@example.com", true)
+	const subject, body = "Weekend flash sale", "Half price on every jacket and coat until Sunday night."
+
+	first := env.createOffer(t, "user@example.com", subject, body)
+	second := env.createOffer(t, "user@example.com", subject, body)
+	if second.ID != first.ID || second.ForwardCount != 2 {
+		t.Fatalf("second forward = %+v, want the canonical offer counted twice", second)
+	}
+
+	offers, err := env.offers.List(context.Background(), models.OfferQuery{SortBy: models.OfferSortCreatedAt, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(offers) != 1 {
+		t.Fatalf("stored %d offers, want 1", len(offers))
+	}
+}
+
+func TestDuplicatesAcrossUsersAreRedacted(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	alice := env.createUser(t, "alice@example.com", true)
+	bob := env.createUser(t, "bob@example.com", true)
+	const subject, body = "Spring sale starts now", "Take 15% off every pair of trainers with code SPRING15."
+	ctx := context.Background()
+
+	aliceOffer := env.createOffer(t, "alice@example.com", subject, body)
+	bobOffer := env.createOffer(t, "bob@example.com", subject, body)
+	if bobOffer.ID == aliceOffer.ID || bobOffer.DuplicateOf != aliceOffer.ID || bobOffer.UserID != bob.ID {
+		t.Fatalf("bob's offer = %+v, want his own forward of %s", bobOffer, aliceOffer.ID)
+	}
+
+	// Bob may read the canonical offer he forwarded, but not whose it is
+	bobViewer := services.OfferViewer{UserID: bob.ID, Email: bob.Email}
+	got, err := env.offerService.GetOffer(ctx, bobViewer, aliceOffer.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ForwardCount != 2 || got.Subject != subject {
+		t.Fatalf("offer = %+v, want the canonical offer forwarded twice", got)
+	}
+	if got.UserID != "" || got.SenderEmail != "" || got.Status != "" {
+		t.Fatalf("offer = %+v, want the owner hidden", got)
+	}
+	if len(got.Forwarders) != 1 || got.Forwarders[0].SenderEmail != bob.Email {
+		t.Fatalf("forwarders = %+v, want only bob", got.Forwarders)
+	}
+
+	// Bob forwarding it again is counted without telling him more
+	again := env.createOffer(t, "bob@example.com", subject, body)
+	if again.ID != aliceOffer.ID || again.ForwardCount != 3 || again.UserID != "" || again.SenderEmail != bob.Email {
+		t.Fatalf("repeated forward = %+v, want the redacted canonical offer", again)
+	}
+	if len(again.Forwarders) != 1 || again.Forwarders[0].UserID != bob.ID {
+		t.Fatalf("forwarders = %+v, want only bob", again.Forwarders)
+	}
+
+	// Alice keeps her own view, without bob's address
+	aliceViewer := services.OfferViewer{UserID: alice.ID, Email: alice.Email}
+	got, err = env.offerService.GetOffer(ctx, aliceViewer, aliceOffer.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.UserID != alice.ID || got.SenderEmail != alice.Email || len(got.Forwarders) != 1 || got.Forwarders[0].SenderEmail != alice.Email {
+		t.Fatalf("offer = %+v, want alice's view", got)
+	}
+
+	// A user who never forwarded it cannot read it
+	carol := env.createUser(t, "carol@example.com", true)
+	carolViewer := services.OfferViewer{UserID: carol.ID, Email: carol.Email}
+	if _, err := env.offerService.GetOffer(ctx, carolViewer, aliceOffer.ID); !errors.Is(err, services.ErrOfferNotFound) {
+		t.Fatalf("error = %v, want ErrOfferNotFound", err)
+	}
+}
+
+func TestOfferVisibility(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	owner := env.createUser(t, "owner@example.com", true)
+	other := env.createUser(t, "other@example.com", true)
+	unclaimed := env.createOffer(t, "stranger@example.com", "Stranger danger", "An offer nobody has claimed yet.")
+	owned := env.createOffer(t, "owner@example.com", "Members only", "Exclusive access to the spring collection.")
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		viewer services.OfferViewer
+		id     string
+		ok     bool
+	}{
+		{"owner", services.OfferViewer{UserID: owner.ID}, owned.ID, true},
+		{"other user", services.OfferViewer{UserID: other.ID}, owned.ID, false},
+		{"admin", services.OfferViewer{UserID: other.ID, IsAdmin: true}, owned.ID, true},
+		{"unclaimed", services.OfferViewer{UserID: other.ID}, unclaimed.ID, false},
+		{"no user", services.OfferViewer{}, unclaimed.ID, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := env.offerService.GetOffer(ctx, tt.viewer, tt.id)
+			if tt.ok && err != nil {
+				t.Fatalf("error = %v, want the offer", err)
+			}
+			if !tt.ok && !errors.Is(err, services.ErrOfferNotFound) {
+				t.Fatalf("error = %v, want ErrOfferNotFound", err)
+			}
+		})
+	}
+
+	page, err := env.offerService.ListOffers(ctx, services.OfferViewer{UserID: other.ID}, services.OfferListParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Offers) != 0 {
+		t.Fatalf("other user listed %d offers, want 0", len(page.Offers))
+	}
+}
+
+func TestListOffersByBrandPages(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	user := env.createUser(t, "user@example.com", true)
+	ctx := context.Background()
+
+	offers := []models.Offer{
+		{Brand: "Acme", Subject: "Anvils on sale", Body: "Sturdy anvils for every workshop."},
+		{Brand: "", Subject: "Quarterly newsletter", Body: "Read about our gardening tips."},
+		{Brand: "Zenith", Subject: "New watches", Body: "Precision timepieces arrive today."},
+		{Brand: "", Subject: "Survey invitation", Body: "Tell us about your last visit."},
+		{Brand: "Acme", Subject: "Rocket skates", Body: "Fast wheels with a warranty."},
+	}
+	want := map[string]bool{}
+	for i := range offers {
+		offers[i].SenderEmail = user.Email
+		if err := env.offerService.CreateOffer(ctx, &offers[i]); err != nil {
+			t.Fatal(err)
+		}
+		want[offers[i].ID] = true
+	}
+
+	for _, sort := range []string{"brand", "-brand"} {
+		seen := map[string]bool{}
+		var brands []string
+		params := services.OfferListParams{Sort: sort, Limit: 2}
+		for {
+			page, err := env.offerService.ListOffers(ctx, services.OfferViewer{UserID: user.ID}, params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, offer := range page.Offers {
+				if seen[offer.ID] {
+					t.Fatalf("%s: offer %s listed twice", sort, offer.ID)
+				}
+				seen[offer.ID] = true
+				brands = append(brands, offer.Brand)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			params.Cursor = page.NextCursor
+		}
+		if len(seen) != len(want) {
+			t.Fatalf("%s: listed %d offers, want %d", sort, len(seen), len(want))
+		}
+		sorted := slices.IsSorted(brands)
+		if sort == "-brand" {
+			slices.Reverse(brands)
+			sorted = slices.IsSorted(brands)
+		}
+		if !sorted {
+			t.Fatalf("%s: brands out of order: %q", sort, brands)
+		}
+	}
+
+	// A cursor only works with the sort it was issued for
+	page, err := env.offerService.ListOffers(ctx, services.OfferViewer{UserID: user.ID}, services.OfferListParams{Sort: "brand", Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.offerService.ListOffers(ctx, services.OfferViewer{UserID: user.ID}, services.OfferListParams{Sort: "subject", Cursor: page.NextCursor}); !errors.Is(err, services.ErrInvalidCursor) {
+		t.Fatalf("error = %v, want ErrInvalidCursor", err)
+	}
+	if _, err := env.offerService.ListOffers(ctx, services.OfferViewer{UserID: user.ID}, services.OfferListParams{Sort: "price"}); !errors.Is(err, services.ErrInvalidSort) {
+		t.Fatalf("error = %v, want ErrInvalidSort", err)
+	}
+}
+
+func TestSearchOffers(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	alice := env.createUser(t, "alice@example.com", true)
+	bob := env.createUser(t, "bob@example.com", true)
+	aliceOffer := env.createOffer(t, "alice@example.com", "Espresso week", "Every espresso is half price this week.")
+	bobOffer := env.createOffer(t, "bob@example.com", "Espresso machines", "Save on home espresso machines.")
+	ctx := context.Background()
+
+	results, err := env.offerService.SearchOffers(ctx, services.OfferViewer{UserID: alice.ID}, "espresso", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Offer.ID != aliceOffer.ID {
+		t.Fatalf("alice's results = %+v, want only her offer", results)
+	}
+	if len(results[0].Highlights["subject"]) == 0 {
+		t.Fatalf("highlights = %v, want the subject highlighted", results[0].Highlights)
+	}
+
+	results, err = env.offerService.SearchOffers(ctx, services.OfferViewer{UserID: bob.ID, IsAdmin: true}, "espresso", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for _, result := range results {
+		ids = append(ids, result.Offer.ID)
+	}
+	if len(ids) != 2 || !slices.Contains(ids, bobOffer.ID) {
+		t.Fatalf("admin results = %v, want both offers", ids)
+	}
+
+	if _, err := env.offerService.SearchOffers(ctx, services.OfferViewer{UserID: alice.ID}, ` "" `, 0); !errors.Is(err, services.ErrEmptySearch) {
+		t.Fatalf("error = %v, want ErrEmptySearch", err)
+	}
+}
+
+func TestReclassifyOffers(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	env.createUser(t, "user@example.com", true)
+	offer := env.createOffer(t, "user@example.com", "Pizza night", "Two pizzas for the price of one.")
+	env.createOffer(t, "user@example.com", "Account notice", "Your statement is ready.")
+	ctx := context.Background()
+
+	// Stored before the rules tagged food offers
+	if err := env.offers.SetInferredTags(ctx, offer.ID, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := env.offerService.ReclassifyOffers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Scanned != 2 || result.Updated != 1 {
+		t.Fatalf("result = %+v, want 2 scanned and 1 updated", result)
+	}
+	stored, err := env.offers.FindByID(ctx, offer.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(stored.InferredTags, "food-drink") {
+		t.Fatalf("inferred tags = %v, want food-drink", stored.InferredTags)
+	}
+}
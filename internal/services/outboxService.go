@@ -0,0 +1,184 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"loyaltea-server/internal/marketing"
+	"loyaltea-server/internal/models"
+)
+
+var ErrOutboxItemNotFound = errors.New("outbox item not found")
+
+// OutboxPolicy configures how outbox items are retried
+type OutboxPolicy struct {
+	MaxAttempts  int           // Attempts before an item is dead
+	BaseDelay    time.Duration // Delay after the first failure, doubled for each further one
+	MaxDelay     time.Duration
+	PollInterval time.Duration // How often the worker looks for due items
+	Lease        time.Duration // How long a worker may take to deliver an item before another may claim it
+}
+
+// DefaultOutboxPolicy returns the policy used unless configured otherwise
+func DefaultOutboxPolicy() OutboxPolicy {
+	return OutboxPolicy{
+		MaxAttempts:  10,
+		BaseDelay:    30 * time.Second,
+		MaxDelay:     6 * time.Hour,
+		PollInterval: 5 * time.Second,
+		Lease:        2 * time.Minute,
+	}
+}
+
+// OutboxService queues calls to the email marketing provider and delivers
+// them in the background, so offers are stored whether or not the provider
+// is up
+type OutboxService struct {
+	outboxModel   OutboxRepository
+	provider      marketing.Provider
+	subscriptions *SubscriptionService
+	policy        OutboxPolicy
+}
+
+func NewOutboxService(outboxModel OutboxRepository, provider marketing.Provider, subscriptions *SubscriptionService, policy OutboxPolicy) *OutboxService {
+	return &OutboxService{
+		outboxModel:   outboxModel,
+		provider:      provider,
+		subscriptions: subscriptions,
+		policy:        policy,
+	}
+}
+
+// EnqueueSubscribe queues adding an address to the mailing list. It reports
+// false, queueing nothing, if the owner of the address has left the list. An
+// address already waiting to be subscribed is not queued twice.
+func (s *OutboxService) EnqueueSubscribe(ctx context.Context, email string) (bool, error) {
+	allowed, err := s.subscriptions.CanSubscribe(ctx, email)
+	if err != nil || !allowed {
+		return false, err
+	}
+
+	item := &models.OutboxItem{
+		Kind:  models.OutboxKindSubscribe,
+		Email: email,
+	}
+	if _, err := s.outboxModel.Enqueue(ctx, item); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Run delivers due items every PollInterval until ctx is cancelled
+func (s *OutboxService) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.policy.PollInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := s.ProcessDue(ctx); err != nil {
+			log.Printf("Outbox: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessDue delivers every item that is due and returns how many it tried
+func (s *OutboxService) ProcessDue(ctx context.Context) (int, error) {
+	processed := 0
+	for ctx.Err() == nil {
+		item, err := s.outboxModel.ClaimDue(ctx, time.Now(), s.policy.Lease)
+		if err != nil {
+			return processed, err
+		}
+		if item == nil {
+			break
+		}
+		processed++
+
+		err = s.deliver(ctx, item)
+		if err == nil {
+			if err := s.outboxModel.MarkDelivered(ctx, item.ID); err != nil {
+				return processed, err
+			}
+			continue
+		}
+
+		attempts := item.Attempts + 1
+		dead := attempts >= s.policy.MaxAttempts || !temporary(err)
+		if dead {
+			log.Printf("Outbox: giving up on %s %s after %d attempts: %v", item.Kind, item.Email, attempts, err)
+		}
+		if err := s.outboxModel.MarkFailed(ctx, item.ID, err.Error(), time.Now().Add(s.backoff(attempts)), dead); err != nil {
+			return processed, err
+		}
+	}
+	return processed, nil
+}
+
+// deliver makes the call an item stands for
+func (s *OutboxService) deliver(ctx context.Context, item *models.OutboxItem) error {
+	switch item.Kind {
+	case models.OutboxKindSubscribe:
+		// the owner may have unsubscribed since the item was queued
+		allowed, err := s.subscriptions.CanSubscribe(ctx, item.Email)
+		if err != nil || !allowed {
+			return err
+		}
+		return s.provider.Subscribe(ctx, item.Email)
+	}
+	return permanentError{fmt.Errorf("unknown outbox item kind %q", item.Kind)}
+}
+
+// backoff returns the delay before the next attempt after attempts failures
+func (s *OutboxService) backoff(attempts int) time.Duration {
+	delay := s.policy.BaseDelay
+	for i := 1; i < attempts && delay < s.policy.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, s.policy.MaxDelay)
+}
+
+// permanentError is an error retrying cannot fix
+type permanentError struct {
+	error
+}
+
+// temporary reports whether a delivery error may go away on retry. Only the
+// provider's rejections of the request itself are permanent, anything else,
+// such as a network error, is retried.
+func temporary(err error) bool {
+	var apiErr *marketing.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Temporary()
+	}
+	var permanent permanentError
+	return !errors.As(err, &permanent)
+}
+
+// ListItems returns a page of outbox items. See models.OutboxFilter.
+func (s *OutboxService) ListItems(ctx context.Context, filter models.OutboxFilter, page int64, limit int64) ([]models.OutboxItem, int64, error) {
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 50
+	}
+	return s.outboxModel.List(ctx, filter, (page-1)*limit, limit)
+}
+
+// RetryItem queues a dead item again
+func (s *OutboxService) RetryItem(ctx context.Context, id string) error {
+	found, err := s.outboxModel.Retry(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrOutboxItemNotFound
+	}
+	return nil
+}
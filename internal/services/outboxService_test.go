@@ -0,0 +1,188 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"loyaltea-server/internal/marketing"
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/services"
+)
+
+// listOutbox returns the outbox items with a status
+func (env *testEnv) listOutbox(t *testing.T, status string) []models.OutboxItem {
+	t.Helper()
+	items, _, err := env.outbox.ListItems(context.Background(), models.OutboxFilter{Status: status}, 1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return items
+}
+
+func TestEnqueueSubscribe(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	user := env.createUser(t, "left@example.com", true)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		queued, err := env.outbox.EnqueueSubscribe(ctx, "new@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !queued {
+			t.Fatal("subscription not queued")
+		}
+	}
+	if items := env.listOutbox(t, models.OutboxPending); len(items) != 1 {
+		t.Fatalf("%d pending items, want 1", len(items))
+	}
+
+	if _, err := env.users.SetMarketingStatus(ctx, user.ID, models.MarketingUnsubscribed, "", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	queued, err := env.outbox.EnqueueSubscribe(ctx, "left@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queued {
+		t.Fatal("queued an address whose owner unsubscribed")
+	}
+}
+
+func TestProcessDueDelivers(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	ctx := context.Background()
+
+	if _, err := env.outbox.EnqueueSubscribe(ctx, "new@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	processed, err := env.outbox.ProcessDue(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if processed != 1 {
+		t.Fatalf("processed %d items, want 1", processed)
+	}
+
+	member, err := env.provider.Status(ctx, "new@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if member.Status != marketing.StatusSubscribed {
+		t.Fatalf("member status = %q, want subscribed", member.Status)
+	}
+	if items := env.listOutbox(t, models.OutboxDelivered); len(items) != 1 {
+		t.Fatalf("%d delivered items, want 1", len(items))
+	}
+
+	// A delivered item does not stop the address being queued again
+	queued, err := env.outbox.EnqueueSubscribe(ctx, "new@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !queued {
+		t.Fatal("subscription not queued after delivery")
+	}
+}
+
+func TestProcessDueSkipsUnsubscribed(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	user := env.createUser(t, "user@example.com", true)
+	ctx := context.Background()
+
+	if _, err := env.outbox.EnqueueSubscribe(ctx, "user@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	// Unsubscribed while the item was waiting
+	if _, err := env.subscriptions.Apply(ctx, services.SubscriptionEvent{Type: "unsubscribe", Email: user.Email}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.outbox.ProcessDue(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if calls := env.provider.Calls(); len(calls) != 0 {
+		t.Fatalf("provider calls = %+v, want none", calls)
+	}
+}
+
+func TestProcessDueFailures(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status string
+	}{
+		{"rate limited", &marketing.APIError{StatusCode: 429}, models.OutboxPending},
+		{"provider down", &marketing.APIError{StatusCode: 503}, models.OutboxPending},
+		{"network error", errors.New("connection refused"), models.OutboxPending},
+		{"rejected", &marketing.APIError{StatusCode: 400, Title: "Invalid Resource"}, models.OutboxDead},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, services.VerificationPolicy{})
+			env.provider.Err = tt.err
+			ctx := context.Background()
+
+			if _, err := env.outbox.EnqueueSubscribe(ctx, "new@example.com"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := env.outbox.ProcessDue(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			items := env.listOutbox(t, tt.status)
+			if len(items) != 1 {
+				t.Fatalf("%d %s items, want 1", len(items), tt.status)
+			}
+			item := items[0]
+			if item.Attempts != 1 || item.LastError != tt.err.Error() {
+				t.Fatalf("item = %+v, want one attempt failing with %q", item, tt.err)
+			}
+			if tt.status == models.OutboxPending && !item.NextAttemptAt.After(time.Now()) {
+				t.Fatal("retry not delayed")
+			}
+
+			// Nothing is due until the backoff is over
+			processed, err := env.outbox.ProcessDue(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if processed != 0 {
+				t.Fatalf("processed %d items during the backoff, want 0", processed)
+			}
+		})
+	}
+}
+
+func TestRetryItem(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	env.provider.Err = &marketing.APIError{StatusCode: 400}
+	ctx := context.Background()
+
+	if _, err := env.outbox.EnqueueSubscribe(ctx, "new@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.outbox.ProcessDue(ctx); err != nil {
+		t.Fatal(err)
+	}
+	dead := env.listOutbox(t, models.OutboxDead)
+	if len(dead) != 1 {
+		t.Fatalf("%d dead items, want 1", len(dead))
+	}
+
+	env.provider.Err = nil
+	if err := env.outbox.RetryItem(ctx, dead[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.outbox.ProcessDue(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if items := env.listOutbox(t, models.OutboxDelivered); len(items) != 1 {
+		t.Fatalf("%d delivered items, want 1", len(items))
+	}
+
+	if err := env.outbox.RetryItem(ctx, dead[0].ID); !errors.Is(err, services.ErrOutboxItemNotFound) {
+		t.Fatalf("retry of a delivered item: error = %v, want ErrOutboxItemNotFound", err)
+	}
+}
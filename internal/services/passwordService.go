@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"loyaltea-server/internal/mailer"
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/utils"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PasswordResetTTL is how long an emailed reset link stays valid
+const PasswordResetTTL = time.Hour
+
+var (
+	ErrInvalidResetToken = errors.New("invalid or expired reset token")
+)
+
+// PasswordService handles forgotten, reset and changed passwords
+type PasswordService struct {
+	userModel    UserRepository
+	tokenModel   OneTimeTokenRepository
+	tokenService *TokenService
+	mailer       mailer.Mailer
+	resetURL     string
+}
+
+// NewPasswordService creates a new PasswordService instance. resetURL is the
+// page of the web app that asks for a new password. Emailed links open it with
+// the reset token in a token query parameter, for the page to send to
+// POST /user/password/reset.
+func NewPasswordService(userModel UserRepository, tokenModel OneTimeTokenRepository, tokenService *TokenService, m mailer.Mailer, resetURL string) *PasswordService {
+	return &PasswordService{
+		userModel:    userModel,
+		tokenModel:   tokenModel,
+		tokenService: tokenService,
+		mailer:       m,
+		resetURL:     resetURL,
+	}
+}
+
+// ForgotPassword emails a reset link to the user. It does nothing for unknown
+// addresses and only logs failures, so callers get the same outcome whether or
+// not the address is registered and cannot use it to find out who is.
+func (s *PasswordService) ForgotPassword(ctx context.Context, email string) {
+	if err := s.sendResetLink(ctx, email); err != nil {
+		log.Printf("Failed to send password reset email: %v", err)
+	}
+}
+
+// sendResetLink emails a reset link to the owner of email, if there is one
+func (s *PasswordService) sendResetLink(ctx context.Context, email string) error {
+	user, err := s.userModel.FindByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return nil
+	}
+
+	// Only the most recent link should work
+	if err := s.tokenModel.InvalidateForUser(ctx, user.ID, models.TokenPurposePasswordReset); err != nil {
+		return err
+	}
+
+	token, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	err = s.tokenModel.Create(ctx, &models.OneTimeToken{
+		ID:        primitive.NewObjectID().Hex(),
+		UserID:    user.ID,
+		Purpose:   models.TokenPurposePasswordReset,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: time.Now().Add(PasswordResetTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	link, err := url.Parse(s.resetURL)
+	if err != nil {
+		return err
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	return s.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Loyaltea password",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nUse the link below to choose a new password. It expires in %d minutes.\n\n%s\n\nIf you did not ask for this you can ignore this email.\n",
+			user.Name, int(PasswordResetTTL.Minutes()), link,
+		),
+	})
+}
+
+// ResetPassword sets a new password using an emailed reset token. Every
+// session of the user is revoked afterwards.
+func (s *PasswordService) ResetPassword(ctx context.Context, token string, newPassword string) error {
+	if len(newPassword) < 8 {
+		return ErrInvalidPassword
+	}
+
+	stored, err := s.tokenModel.Consume(ctx, models.TokenPurposePasswordReset, utils.HashToken(token))
+	if err != nil {
+		return err
+	}
+	if stored == nil {
+		return ErrInvalidResetToken
+	}
+
+	if err := s.userModel.UpdatePassword(ctx, stored.UserID, newPassword); err != nil {
+		return err
+	}
+
+	return s.tokenService.RevokeAllSessions(ctx, stored.UserID)
+}
+
+// ChangePassword replaces the password of a logged in user after checking the
+// current one. Every session of the user is revoked afterwards, so a stolen
+// refresh token stops working once the owner changes their password.
+func (s *PasswordService) ChangePassword(ctx context.Context, userID string, oldPassword string, newPassword string) error {
+	user, err := s.userModel.FindByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+
+	if !s.userModel.VerifyPassword(user, oldPassword) {
+		return ErrInvalidCredentials
+	}
+
+	if len(newPassword) < 8 {
+		return ErrInvalidPassword
+	}
+
+	if err := s.userModel.UpdatePassword(ctx, user.ID, newPassword); err != nil {
+		return err
+	}
+
+	return s.tokenService.RevokeAllSessions(ctx, user.ID)
+}
@@ -0,0 +1,95 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"loyaltea-server/internal/services"
+)
+
+func TestForgotPasswordUnknownAddress(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+
+	env.password.ForgotPassword(context.Background(), "nobody@example.com")
+	if n := len(env.mail.Messages()); n != 0 {
+		t.Fatalf("sent %d emails for an unknown address, want 0", n)
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	user := env.createUser(t, "user@example.com", true)
+	ctx := context.Background()
+
+	session, err := env.tokenService.IssueTokens(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env.password.ForgotPassword(ctx, "user@example.com")
+	token := env.mailedToken(t, "user@example.com")
+
+	if err := env.password.ResetPassword(ctx, token, "short"); !errors.Is(err, services.ErrInvalidPassword) {
+		t.Fatalf("short password: error = %v, want ErrInvalidPassword", err)
+	}
+	if err := env.password.ResetPassword(ctx, token, "new password"); err != nil {
+		t.Fatal(err)
+	}
+	if !env.users.VerifyPassword(env.findUser(t, user.ID), "new password") {
+		t.Fatal("password not changed")
+	}
+
+	if err := env.password.ResetPassword(ctx, token, "another password"); !errors.Is(err, services.ErrInvalidResetToken) {
+		t.Fatalf("reused link: error = %v, want ErrInvalidResetToken", err)
+	}
+	if _, err := env.tokenService.Refresh(ctx, session.RefreshToken); !errors.Is(err, services.ErrInvalidRefreshToken) {
+		t.Fatalf("session after reset: error = %v, want ErrInvalidRefreshToken", err)
+	}
+}
+
+func TestResetPasswordOnlyLatestLinkWorks(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	env.createUser(t, "user@example.com", true)
+	ctx := context.Background()
+
+	env.password.ForgotPassword(ctx, "user@example.com")
+	first := env.mailedToken(t, "user@example.com")
+	env.password.ForgotPassword(ctx, "user@example.com")
+	second := env.mailedToken(t, "user@example.com")
+
+	if err := env.password.ResetPassword(ctx, first, "new password"); !errors.Is(err, services.ErrInvalidResetToken) {
+		t.Fatalf("old link: error = %v, want ErrInvalidResetToken", err)
+	}
+	if err := env.password.ResetPassword(ctx, second, "new password"); err != nil {
+		t.Fatalf("latest link: %v", err)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	user := env.createUser(t, "user@example.com", true)
+	ctx := context.Background()
+
+	session, err := env.tokenService.IssueTokens(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := env.password.ChangePassword(ctx, user.ID, "wrong password", "new password"); !errors.Is(err, services.ErrInvalidCredentials) {
+		t.Fatalf("wrong current password: error = %v, want ErrInvalidCredentials", err)
+	}
+	if err := env.password.ChangePassword(ctx, user.ID, testPassword, "short"); !errors.Is(err, services.ErrInvalidPassword) {
+		t.Fatalf("short password: error = %v, want ErrInvalidPassword", err)
+	}
+	if err := env.password.ChangePassword(ctx, user.ID, testPassword, "new password"); err != nil {
+		t.Fatal(err)
+	}
+
+	if !env.users.VerifyPassword(env.findUser(t, user.ID), "new password") {
+		t.Fatal("password not changed")
+	}
+	if _, err := env.tokenService.Refresh(ctx, session.RefreshToken); !errors.Is(err, services.ErrInvalidRefreshToken) {
+		t.Fatalf("session after change: error = %v, want ErrInvalidRefreshToken", err)
+	}
+}
@@ -0,0 +1,256 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"loyaltea-server/internal/models"
+)
+
+// maxAppendAttempts is how many times an entry is retried on a balance that
+// changed underneath it before giving up
+const maxAppendAttempts = 5
+
+var (
+	ErrInvalidPoints      = errors.New("points must be a positive whole number")
+	ErrInsufficientPoints = errors.New("insufficient points")
+	ErrNoteRequired       = errors.New("a note is required")
+	ErrPointsConflict     = errors.New("points balance changed concurrently")
+	ErrAlreadyEarned      = errors.New("points already earned for this purchase")
+	ErrReferenceRequired  = errors.New("a reference is required")
+	ErrAlreadyRedeemed    = errors.New("points already redeemed for this reference")
+
+	// errNothingEarned stops appending a purchase entry that earned no points
+	errNothingEarned = errors.New("nothing earned")
+)
+
+// uniqueReferences are the reasons whose entries are stored once per
+// reference, with the error appending another one fails with
+var uniqueReferences = map[string]error{
+	models.PointsReasonPurchase:   ErrAlreadyEarned,
+	models.PointsReasonRedemption: ErrAlreadyRedeemed,
+}
+
+// PointsService keeps each user's points ledger. Every change appends an
+// entry carrying the new balance; nothing is ever updated or deleted.
+type PointsService struct {
+	pointsModel PointsRepository
+	userModel   UserRepository
+}
+
+func NewPointsService(pointsModel PointsRepository, userModel UserRepository) *PointsService {
+	return &PointsService{
+		pointsModel: pointsModel,
+		userModel:   userModel,
+	}
+}
+
+// Balance returns a user's points balance
+func (s *PointsService) Balance(ctx context.Context, userID string) (int64, error) {
+	if err := s.requireUser(ctx, userID); err != nil {
+		return 0, err
+	}
+
+	latest, err := s.pointsModel.Latest(ctx, userID)
+	if err != nil || latest == nil {
+		return 0, err
+	}
+	return latest.Balance, nil
+}
+
+// History returns a page of a user's ledger, newest first
+func (s *PointsService) History(ctx context.Context, userID string, page int64, limit int64) ([]models.PointsEntry, int64, error) {
+	if err := s.requireUser(ctx, userID); err != nil {
+		return nil, 0, err
+	}
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 50
+	}
+	return s.pointsModel.History(ctx, userID, (page-1)*limit, limit)
+}
+
+// Credit adds points to a user's balance
+func (s *PointsService) Credit(ctx context.Context, userID string, points int64, reason string, reference string) (*models.PointsEntry, error) {
+	entry := &models.PointsEntry{
+		UserID:    userID,
+		Type:      models.PointsCredit,
+		Points:    points,
+		Reason:    reason,
+		Reference: reference,
+	}
+	if err := s.append(ctx, entry, nil); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// EarnFunc works out the points a purchase earns and the earn rules that
+// fired, from the user's ledger as it stands
+type EarnFunc func(ctx context.Context) (int64, []models.RuleApplication, error)
+
+// Earn credits the points a purchase earns. evaluate is run each time the
+// entry is appended, after the user's latest entry is read, so caps and
+// first purchase bonuses are worked out again if another entry gets in
+// first. It returns nil if the purchase earns nothing. Each purchase
+// reference is credited only once; crediting it again fails with
+// ErrAlreadyEarned.
+func (s *PointsService) Earn(ctx context.Context, userID string, reference string, occurredAt time.Time, evaluate EarnFunc) (*models.PointsEntry, error) {
+	entry := &models.PointsEntry{
+		UserID:     userID,
+		Type:       models.PointsCredit,
+		Reason:     models.PointsReasonPurchase,
+		Reference:  reference,
+		OccurredAt: occurredAt,
+	}
+	err := s.append(ctx, entry, func(ctx context.Context) error {
+		points, explanation, err := evaluate(ctx)
+		if err != nil {
+			return err
+		}
+		if points <= 0 {
+			return errNothingEarned
+		}
+		entry.Points = points
+		entry.Explanation = explanation
+		return nil
+	})
+	if err == errNothingEarned {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// Redeem spends points from a user's balance. It fails with
+// ErrInsufficientPoints rather than let the balance go below zero. The
+// reference identifies the redemption, so a retried request fails with
+// ErrAlreadyRedeemed instead of spending the points twice.
+func (s *PointsService) Redeem(ctx context.Context, userID string, points int64, reference string) (*models.PointsEntry, error) {
+	reference = strings.TrimSpace(reference)
+	if reference == "" {
+		return nil, ErrReferenceRequired
+	}
+
+	entry := &models.PointsEntry{
+		UserID:    userID,
+		Type:      models.PointsDebit,
+		Points:    points,
+		Reason:    models.PointsReasonRedemption,
+		Reference: reference,
+	}
+	if err := s.append(ctx, entry, nil); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// Adjust credits, or for negative points debits, a user's balance by hand.
+// Adjustments must say why in a note and cannot overdraw the balance either.
+func (s *PointsService) Adjust(ctx context.Context, adminID string, userID string, points int64, note string, reference string) (*models.PointsEntry, error) {
+	note = strings.TrimSpace(note)
+	if note == "" {
+		return nil, ErrNoteRequired
+	}
+
+	entry := &models.PointsEntry{
+		UserID:    userID,
+		Type:      models.PointsCredit,
+		Points:    points,
+		Reason:    models.PointsReasonAdjustment,
+		Reference: reference,
+		Note:      note,
+		CreatedBy: adminID,
+	}
+	if points < 0 {
+		entry.Type = models.PointsDebit
+		entry.Points = -points
+	}
+	if err := s.append(ctx, entry, nil); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// append numbers an entry after the user's latest one, works out the new
+// balance and stores it. If another entry took that number first, it starts
+// over from the new latest entry. A purchase already credited, or a
+// redemption already made, is not stored again. prepare, if not nil, fills in
+// the entry's points once the latest entry has been read.
+func (s *PointsService) append(ctx context.Context, entry *models.PointsEntry, prepare func(ctx context.Context) error) error {
+	if prepare == nil && entry.Points <= 0 {
+		return ErrInvalidPoints
+	}
+	if err := s.requireUser(ctx, entry.UserID); err != nil {
+		return err
+	}
+
+	for attempt := 0; attempt < maxAppendAttempts; attempt++ {
+		if errDuplicate, ok := uniqueReferences[entry.Reason]; ok && entry.Reference != "" {
+			existing, err := s.pointsModel.FindByReference(ctx, entry.UserID, entry.Reason, entry.Reference)
+			if err != nil {
+				return err
+			}
+			if existing != nil {
+				return errDuplicate
+			}
+		}
+
+		latest, err := s.pointsModel.Latest(ctx, entry.UserID)
+		if err != nil {
+			return err
+		}
+		if prepare != nil {
+			if err := prepare(ctx); err != nil {
+				return err
+			}
+			if entry.Points <= 0 {
+				return ErrInvalidPoints
+			}
+		}
+
+		var seq, balance int64
+		if latest != nil {
+			seq, balance = latest.Seq, latest.Balance
+		}
+		if entry.Type == models.PointsDebit {
+			if entry.Points > balance {
+				return ErrInsufficientPoints
+			}
+			balance -= entry.Points
+		} else {
+			balance += entry.Points
+		}
+
+		entry.ID = ""
+		entry.Seq = seq + 1
+		entry.Balance = balance
+		stored, err := s.pointsModel.Append(ctx, entry)
+		if err != nil {
+			return err
+		}
+		if stored {
+			return nil
+		}
+	}
+	return ErrPointsConflict
+}
+
+// requireUser returns ErrUserNotFound if the user does not exist
+func (s *PointsService) requireUser(ctx context.Context, userID string) error {
+	user, err := s.userModel.FindByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/services"
+)
+
+func TestPointsLedger(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	user := env.createUser(t, "user@example.com", true)
+	ctx := context.Background()
+
+	if _, err := env.pointsService.Credit(ctx, user.ID, 100, models.PointsReasonPurchase, "order-1"); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := env.pointsService.Redeem(ctx, user.ID, 30, "reward-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Seq != 2 || entry.Balance != 70 || entry.Type != models.PointsDebit {
+		t.Fatalf("redemption = %+v, want entry 2 leaving 70", entry)
+	}
+
+	if _, err := env.pointsService.Redeem(ctx, user.ID, 71, "reward-2"); !errors.Is(err, services.ErrInsufficientPoints) {
+		t.Fatalf("overdraw: error = %v, want ErrInsufficientPoints", err)
+	}
+	if _, err := env.pointsService.Credit(ctx, user.ID, 0, models.PointsReasonPurchase, "order-2"); !errors.Is(err, services.ErrInvalidPoints) {
+		t.Fatalf("zero points: error = %v, want ErrInvalidPoints", err)
+	}
+	if _, err := env.pointsService.Balance(ctx, "missing"); !errors.Is(err, services.ErrUserNotFound) {
+		t.Fatalf("unknown user: error = %v, want ErrUserNotFound", err)
+	}
+
+	balance, err := env.pointsService.Balance(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != 70 {
+		t.Fatalf("balance = %d, want 70", balance)
+	}
+	history, total, err := env.pointsService.History(ctx, user.ID, 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 || history[0].Seq != 2 {
+		t.Fatalf("history = %+v (total %d), want 2 entries newest first", history, total)
+	}
+}
+
+func TestRedeemReference(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	user := env.createUser(t, "user@example.com", true)
+	ctx := context.Background()
+
+	if _, err := env.pointsService.Credit(ctx, user.ID, 100, models.PointsReasonPurchase, "order-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.pointsService.Redeem(ctx, user.ID, 10, "  "); !errors.Is(err, services.ErrReferenceRequired) {
+		t.Fatalf("blank reference: error = %v, want ErrReferenceRequired", err)
+	}
+
+	// A retried request must not spend the points twice, however it races
+	var wg sync.WaitGroup
+	errs := make([]error, 5)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = env.pointsService.Redeem(ctx, user.ID, 10, "reward-1")
+		}(i)
+	}
+	wg.Wait()
+
+	redeemed := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			redeemed++
+		case !errors.Is(err, services.ErrAlreadyRedeemed):
+			t.Fatalf("error = %v, want ErrAlreadyRedeemed", err)
+		}
+	}
+	if redeemed != 1 {
+		t.Fatalf("redeemed %d times, want once", redeemed)
+	}
+	if balance, _ := env.pointsService.Balance(ctx, user.ID); balance != 90 {
+		t.Fatalf("balance = %d, want 90", balance)
+	}
+}
+
+func TestAdjustPoints(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	user := env.createUser(t, "user@example.com", true)
+	ctx := context.Background()
+
+	if _, err := env.pointsService.Adjust(ctx, "admin", user.ID, 50, " ", ""); !errors.Is(err, services.ErrNoteRequired) {
+		t.Fatalf("no note: error = %v, want ErrNoteRequired", err)
+	}
+	if _, err := env.pointsService.Adjust(ctx, "admin", user.ID, 50, "goodwill", ""); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := env.pointsService.Adjust(ctx, "admin", user.ID, -20, "correction", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Type != models.PointsDebit || entry.Points != 20 || entry.Balance != 30 || entry.CreatedBy != "admin" {
+		t.Fatalf("debit adjustment = %+v", entry)
+	}
+	if _, err := env.pointsService.Adjust(ctx, "admin", user.ID, -31, "correction", ""); !errors.Is(err, services.ErrInsufficientPoints) {
+		t.Fatalf("overdraw: error = %v, want ErrInsufficientPoints", err)
+	}
+}
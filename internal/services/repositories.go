@@ -0,0 +1,115 @@
+package services
+
+// Storage the services depend on. The MongoDB models in internal/db (and
+// models.OfferModel) implement these, as does the in-memory backend in
+// internal/db/memory used to run the services without a database.
+
+import (
+	"context"
+	"time"
+
+	"loyaltea-server/internal/models"
+)
+
+// UserRepository stores user accounts
+type UserRepository interface {
+	Create(ctx context.Context, user *models.User) error
+	FindByEmail(ctx context.Context, email string) (*models.User, error)
+	FindByAddress(ctx context.Context, email string) (*models.User, error)
+	FindByID(ctx context.Context, id string) (*models.User, error)
+	Update(ctx context.Context, user *models.User) error
+	Delete(ctx context.Context, id string) error
+	VerifyPassword(user *models.User, password string) bool
+	UpdatePassword(ctx context.Context, id string, password string) error
+	MarkEmailVerified(ctx context.Context, id string, email string) (bool, error)
+	AddAliasEmail(ctx context.Context, id string, email string) error
+	RemoveAliasEmail(ctx context.Context, id string, email string) error
+	SetRole(ctx context.Context, id string, role string) error
+	SetSuspended(ctx context.Context, id string, suspended bool, reason string) error
+	List(ctx context.Context, query string, skip int64, limit int64) ([]models.User, int64, error)
+	SetPendingMFASecret(ctx context.Context, id string, secret string) error
+	EnableMFA(ctx context.Context, id string, secret string, step int64, recoveryCodeHashes []string) error
+	DisableMFA(ctx context.Context, id string) error
+	UseTOTPStep(ctx context.Context, id string, step int64) (bool, error)
+	ConsumeRecoveryCode(ctx context.Context, id string, hash string) (bool, error)
+	SetRecoveryCodes(ctx context.Context, id string, hashes []string) error
+	FindByInboundAlias(ctx context.Context, alias string) (*models.User, error)
+	FindWithoutInboundAlias(ctx context.Context, limit int64) ([]models.User, error)
+	SetInboundAlias(ctx context.Context, id string, alias string) error
+	SetMarketingStatus(ctx context.Context, id string, status string, reason string, at time.Time) (bool, error)
+}
+
+// OfferRepository stores forwarded offers
+type OfferRepository interface {
+	Create(ctx context.Context, offer *models.Offer) error
+	FindByID(ctx context.Context, id string) (*models.Offer, error)
+	List(ctx context.Context, query models.OfferQuery) ([]models.Offer, error)
+	ClaimBySender(ctx context.Context, email string, userID string) (int64, error)
+	SetInferredTags(ctx context.Context, id string, tags []string) error
+	FindDuplicateCandidates(ctx context.Context, hash string, bands []string, limit int64) ([]models.Offer, error)
+	RecordForward(ctx context.Context, id string, forwarder models.Forwarder) error
+}
+
+// RefreshTokenRepository stores refresh tokens
+type RefreshTokenRepository interface {
+	Create(ctx context.Context, token *models.RefreshToken) error
+	FindByHash(ctx context.Context, hash string) (*models.RefreshToken, error)
+	MarkUsed(ctx context.Context, id string, replacedBy string) (bool, error)
+	RevokeFamily(ctx context.Context, familyID string) error
+	RevokeAllForUser(ctx context.Context, userID string) error
+}
+
+// OneTimeTokenRepository stores single-use emailed tokens
+type OneTimeTokenRepository interface {
+	Create(ctx context.Context, token *models.OneTimeToken) error
+	Consume(ctx context.Context, purpose string, hash string) (*models.OneTimeToken, error)
+	InvalidateForUser(ctx context.Context, userID string, purpose string) error
+}
+
+// LoginAttemptRepository stores failed login counters
+type LoginAttemptRepository interface {
+	FindByID(ctx context.Context, id string) (*models.LoginAttempt, error)
+	IncrementFailures(ctx context.Context, kind string, key string, at time.Time) (*models.LoginAttempt, error)
+	SetRestrictions(ctx context.Context, id string, nextAttemptAt *time.Time, lockedUntil *time.Time) error
+	Delete(ctx context.Context, id string) error
+}
+
+// SecurityEventRepository stores security events
+type SecurityEventRepository interface {
+	Create(ctx context.Context, event *models.SecurityEvent) error
+	List(ctx context.Context, filter models.SecurityEventFilter, skip int64, limit int64) ([]models.SecurityEvent, int64, error)
+}
+
+// WebhookNonceRepository remembers the tokens of signed webhook requests
+type WebhookNonceRepository interface {
+	Use(ctx context.Context, provider string, nonce string, expiresAt time.Time) (bool, error)
+	Release(ctx context.Context, provider string, nonce string) error
+}
+
+// OutboxRepository stores calls to external services made in the background
+type OutboxRepository interface {
+	Enqueue(ctx context.Context, item *models.OutboxItem) (bool, error)
+	ClaimDue(ctx context.Context, now time.Time, lease time.Duration) (*models.OutboxItem, error)
+	MarkDelivered(ctx context.Context, id string) error
+	MarkFailed(ctx context.Context, id string, lastError string, nextAttemptAt time.Time, dead bool) error
+	Retry(ctx context.Context, id string) (bool, error)
+	List(ctx context.Context, filter models.OutboxFilter, skip int64, limit int64) ([]models.OutboxItem, int64, error)
+}
+
+// PointsRepository stores the append-only points ledger
+type PointsRepository interface {
+	Latest(ctx context.Context, userID string) (*models.PointsEntry, error)
+	Append(ctx context.Context, entry *models.PointsEntry) (bool, error)
+	History(ctx context.Context, userID string, skip int64, limit int64) ([]models.PointsEntry, int64, error)
+	FindByReference(ctx context.Context, userID string, reason string, reference string) (*models.PointsEntry, error)
+	Earned(ctx context.Context, userID string, reason string, from time.Time, to time.Time) (int64, error)
+}
+
+// EarnRuleRepository stores the rules points are earned by
+type EarnRuleRepository interface {
+	Create(ctx context.Context, rule *models.EarnRule) error
+	Update(ctx context.Context, rule *models.EarnRule) (bool, error)
+	Delete(ctx context.Context, id string) (bool, error)
+	FindByID(ctx context.Context, id string) (*models.EarnRule, error)
+	List(ctx context.Context, activeOnly bool) ([]models.EarnRule, error)
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"loyaltea-server/internal/models"
+)
+
+// SubscriptionEvent is a change to a mailing list member reported by the
+// email marketing provider. Type is a Mailchimp list webhook type, such as
+// "unsubscribe", or a Mandrill message event, such as "hard_bounce".
+type SubscriptionEvent struct {
+	Type   string
+	Email  string
+	Reason string
+	At     time.Time
+}
+
+// SubscriptionService keeps the mailing list status of users in step with
+// the email marketing provider
+type SubscriptionService struct {
+	userModel UserRepository
+}
+
+func NewSubscriptionService(userModel UserRepository) *SubscriptionService {
+	return &SubscriptionService{
+		userModel: userModel,
+	}
+}
+
+// marketingStatus returns the status an event type implies, or "" for events
+// such as profile updates that do not change it
+func marketingStatus(eventType string) string {
+	switch eventType {
+	case "subscribe":
+		return models.MarketingSubscribed
+	case "unsubscribe", "unsub", "spam":
+		return models.MarketingUnsubscribed
+	case "cleaned", "hard_bounce", "reject":
+		return models.MarketingCleaned
+	}
+	return ""
+}
+
+// Apply records the status an event implies for the user owning its address
+// and reports whether it changed anything. Events about addresses no user
+// owns are ignored.
+func (s *SubscriptionService) Apply(ctx context.Context, event SubscriptionEvent) (bool, error) {
+	status := marketingStatus(event.Type)
+	if status == "" {
+		return false, nil
+	}
+
+	user, err := s.userModel.FindByAddress(ctx, strings.TrimSpace(event.Email))
+	if err != nil {
+		return false, err
+	}
+	if user == nil {
+		return false, nil
+	}
+
+	at := event.At
+	if at.IsZero() {
+		at = time.Now()
+	}
+	return s.userModel.SetMarketingStatus(ctx, user.ID, status, event.Reason, at)
+}
+
+// CanSubscribe reports whether an address may be added to the mailing list.
+// Addresses whose owner unsubscribed, or that bounced, must not be added back.
+func (s *SubscriptionService) CanSubscribe(ctx context.Context, email string) (bool, error) {
+	user, err := s.userModel.FindByAddress(ctx, email)
+	if err != nil {
+		return false, err
+	}
+	if user == nil {
+		return true, nil
+	}
+	return user.MarketingStatus != models.MarketingUnsubscribed && user.MarketingStatus != models.MarketingCleaned, nil
+}
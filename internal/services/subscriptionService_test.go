@@ -0,0 +1,69 @@
+package services_test
+
+import (
+	"context"
+	"testing"
+
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/services"
+)
+
+func TestApplySubscriptionEvent(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	user := env.createUser(t, "user@example.com", true)
+	if err := env.users.AddAliasEmail(context.Background(), user.ID, "work@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		event   services.SubscriptionEvent
+		changed bool
+		status  string
+	}{
+		{services.SubscriptionEvent{Type: "subscribe", Email: "user@example.com"}, true, models.MarketingSubscribed},
+		{services.SubscriptionEvent{Type: "profile", Email: "user@example.com"}, false, models.MarketingSubscribed},
+		{services.SubscriptionEvent{Type: "unsubscribe", Email: "nobody@example.com"}, false, models.MarketingSubscribed},
+		{services.SubscriptionEvent{Type: "hard_bounce", Email: " work@example.com "}, true, models.MarketingCleaned},
+		{services.SubscriptionEvent{Type: "unsubscribe", Email: "user@example.com", Reason: "manual"}, true, models.MarketingUnsubscribed},
+	}
+	for _, tt := range tests {
+		changed, err := env.subscriptions.Apply(ctx, tt.event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if changed != tt.changed {
+			t.Fatalf("%s %s: changed = %v, want %v", tt.event.Type, tt.event.Email, changed, tt.changed)
+		}
+		if status := env.findUser(t, user.ID).MarketingStatus; status != tt.status {
+			t.Fatalf("%s %s: status = %q, want %q", tt.event.Type, tt.event.Email, status, tt.status)
+		}
+	}
+}
+
+func TestCanSubscribe(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	user := env.createUser(t, "user@example.com", true)
+	ctx := context.Background()
+
+	for _, email := range []string{"user@example.com", "nobody@example.com"} {
+		allowed, err := env.subscriptions.CanSubscribe(ctx, email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !allowed {
+			t.Fatalf("%s may not subscribe", email)
+		}
+	}
+
+	if _, err := env.subscriptions.Apply(ctx, services.SubscriptionEvent{Type: "spam", Email: user.Email}); err != nil {
+		t.Fatal(err)
+	}
+	allowed, err := env.subscriptions.CanSubscribe(ctx, user.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed {
+		t.Fatal("address reported as spam may be subscribed again")
+	}
+}
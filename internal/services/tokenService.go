@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/utils"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RefreshTokenTTL is how long a refresh token can be used before the user has
+// to log in again
+const RefreshTokenTTL = 30 * 24 * time.Hour
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+	ErrAccountSuspended    = errors.New("account suspended")
+)
+
+// TokenPair is the access/refresh token pair handed out on login
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    time.Duration // Lifetime of the access token
+}
+
+// TokenService issues, rotates and revokes session tokens
+type TokenService struct {
+	tokenModel RefreshTokenRepository
+	userModel  UserRepository
+	policy     VerificationPolicy
+}
+
+// NewTokenService creates a new TokenService instance. Under a policy that
+// requires a verified address to log in, sessions of unverified users cannot
+// be refreshed.
+func NewTokenService(tokenModel RefreshTokenRepository, userModel UserRepository, policy VerificationPolicy) *TokenService {
+	return &TokenService{
+		tokenModel: tokenModel,
+		userModel:  userModel,
+		policy:     policy,
+	}
+}
+
+// IssueTokens starts a new session for the user and returns its first token pair
+func (s *TokenService) IssueTokens(ctx context.Context, user *models.User) (*TokenPair, error) {
+	return s.issueWithID(ctx, user, primitive.NewObjectID().Hex(), primitive.NewObjectID().Hex())
+}
+
+// Refresh exchanges a refresh token for a new token pair. Each refresh token
+// can be used once; presenting one that was already rotated means it has
+// leaked, so the whole session is revoked.
+func (s *TokenService) Refresh(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	stored, err := s.tokenModel.FindByHash(ctx, utils.HashToken(refreshToken))
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil || stored.RevokedAt != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	if stored.UsedAt != nil {
+		if err := s.tokenModel.RevokeFamily(ctx, stored.FamilyID); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	user, err := s.userModel.FindByID(ctx, stored.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || user.Suspended {
+		if err := s.tokenModel.RevokeFamily(ctx, stored.FamilyID); err != nil {
+			return nil, err
+		}
+		if user != nil {
+			return nil, ErrAccountSuspended
+		}
+		return nil, ErrInvalidRefreshToken
+	}
+	if s.policy.RequireForLogin && !user.EmailVerified {
+		return nil, ErrEmailNotVerified
+	}
+
+	// Claim the old token before issuing a new one so a concurrent refresh
+	// with the same token is treated as reuse
+	replacementID := primitive.NewObjectID().Hex()
+	ok, err := s.tokenModel.MarkUsed(ctx, stored.ID, replacementID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if err := s.tokenModel.RevokeFamily(ctx, stored.FamilyID); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	return s.issueWithID(ctx, user, stored.FamilyID, replacementID)
+}
+
+// RevokeSession revokes every refresh token issued for a session
+func (s *TokenService) RevokeSession(ctx context.Context, sessionID string) error {
+	return s.tokenModel.RevokeFamily(ctx, sessionID)
+}
+
+// RevokeAllSessions revokes every refresh token belonging to a user
+func (s *TokenService) RevokeAllSessions(ctx context.Context, userID string) error {
+	return s.tokenModel.RevokeAllForUser(ctx, userID)
+}
+
+// issueWithID stores a new refresh token with the given ID in the session and
+// signs a matching access token
+func (s *TokenService) issueWithID(ctx context.Context, user *models.User, familyID, tokenID string) (*TokenPair, error) {
+	refreshToken, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.tokenModel.Create(ctx, &models.RefreshToken{
+		ID:        tokenID,
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: utils.HashToken(refreshToken),
+		ExpiresAt: time.Now().Add(RefreshTokenTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := utils.GenerateToken(utils.Claims{
+		UserID:    user.ID,
+		Email:     user.Email,
+		Role:      user.GetRole(),
+		SessionID: familyID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    utils.AccessTokenTTL,
+	}, nil
+}
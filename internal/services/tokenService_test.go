@@ -0,0 +1,123 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"loyaltea-server/internal/services"
+	"loyaltea-server/internal/utils"
+)
+
+func TestRefreshRotatesTokens(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	user := env.createUser(t, "user@example.com", true)
+	ctx := context.Background()
+
+	pair, err := env.tokenService.IssueTokens(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := utils.ValidateToken(pair.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.UserID != user.ID || claims.Role != user.Role {
+		t.Fatalf("access token claims = %+v", claims)
+	}
+
+	next, err := env.tokenService.Refresh(ctx, pair.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.RefreshToken == pair.RefreshToken {
+		t.Fatal("refresh token not rotated")
+	}
+	nextClaims, err := utils.ValidateToken(next.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nextClaims.SessionID != claims.SessionID {
+		t.Fatal("refresh started a new session")
+	}
+}
+
+func TestRefreshReuseRevokesSession(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	user := env.createUser(t, "user@example.com", true)
+	ctx := context.Background()
+
+	pair, err := env.tokenService.IssueTokens(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := env.tokenService.Refresh(ctx, pair.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := env.tokenService.Refresh(ctx, pair.RefreshToken); !errors.Is(err, services.ErrRefreshTokenReused) {
+		t.Fatalf("reused token: error = %v, want ErrRefreshTokenReused", err)
+	}
+	// The thief's replay also ends the owner's session
+	if _, err := env.tokenService.Refresh(ctx, next.RefreshToken); !errors.Is(err, services.ErrInvalidRefreshToken) {
+		t.Fatalf("rotated token after reuse: error = %v, want ErrInvalidRefreshToken", err)
+	}
+}
+
+func TestRefreshRejects(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("unknown token", func(t *testing.T) {
+		env := newTestEnv(t, services.VerificationPolicy{})
+		if _, err := env.tokenService.Refresh(ctx, "not-a-token"); !errors.Is(err, services.ErrInvalidRefreshToken) {
+			t.Fatalf("error = %v, want ErrInvalidRefreshToken", err)
+		}
+	})
+
+	t.Run("revoked session", func(t *testing.T) {
+		env := newTestEnv(t, services.VerificationPolicy{})
+		user := env.createUser(t, "user@example.com", true)
+		pair, err := env.tokenService.IssueTokens(ctx, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		claims, err := utils.ValidateToken(pair.AccessToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := env.tokenService.RevokeSession(ctx, claims.SessionID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := env.tokenService.Refresh(ctx, pair.RefreshToken); !errors.Is(err, services.ErrInvalidRefreshToken) {
+			t.Fatalf("error = %v, want ErrInvalidRefreshToken", err)
+		}
+	})
+
+	t.Run("suspended user", func(t *testing.T) {
+		env := newTestEnv(t, services.VerificationPolicy{})
+		user := env.createUser(t, "user@example.com", true)
+		pair, err := env.tokenService.IssueTokens(ctx, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := env.users.SetSuspended(ctx, user.ID, true, "abuse"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := env.tokenService.Refresh(ctx, pair.RefreshToken); !errors.Is(err, services.ErrAccountSuspended) {
+			t.Fatalf("error = %v, want ErrAccountSuspended", err)
+		}
+	})
+
+	t.Run("unverified user when login requires it", func(t *testing.T) {
+		env := newTestEnv(t, services.VerificationPolicy{RequireForLogin: true})
+		user := env.createUser(t, "user@example.com", false)
+		pair, err := env.tokenService.IssueTokens(ctx, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := env.tokenService.Refresh(ctx, pair.RefreshToken); !errors.Is(err, services.ErrEmailNotVerified) {
+			t.Fatalf("error = %v, want ErrEmailNotVerified", err)
+		}
+	})
+}
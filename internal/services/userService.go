@@ -3,11 +3,13 @@ package services
 import (
 	"context"
 	"errors"
+	"log"
 	"regexp"
+	"strings"
 	"time"
 
-	"loyaltea-server/internal/db"
 	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/utils"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -22,13 +24,17 @@ var (
 
 // UserService handles business logic for user operations
 type UserService struct {
-	userModel *db.UserModel
+	userModel    UserRepository
+	verification *VerificationService
+	lockout      *LockoutService
 }
 
 // NewUserService creates a new UserService instance
-func NewUserService(userModel *db.UserModel) *UserService {
+func NewUserService(userModel UserRepository, verification *VerificationService, lockout *LockoutService) *UserService {
 	return &UserService{
-		userModel: userModel,
+		userModel:    userModel,
+		verification: verification,
+		lockout:      lockout,
 	}
 }
 
@@ -44,8 +50,8 @@ func (s *UserService) RegisterUser(email, password, name string) (*models.User,
 		return nil, ErrInvalidPassword
 	}
 
-	// Check if user already exists
-	existingUser, err := s.userModel.FindByEmail(context.TODO(), email)
+	// Check if user already exists, including as another user's alias
+	existingUser, err := s.userModel.FindByAddress(context.TODO(), email)
 	if err != nil {
 		return nil, err
 	}
@@ -53,12 +59,19 @@ func (s *UserService) RegisterUser(email, password, name string) (*models.User,
 		return nil, ErrEmailExists
 	}
 
+	inboundAlias, err := utils.GenerateInboundAlias()
+	if err != nil {
+		return nil, err
+	}
+
 	// Create new user
 	user := &models.User{
-		ID:       primitive.NewObjectID().Hex(),
-		Email:    email,
-		Password: password,
-		Name:     name,
+		ID:           primitive.NewObjectID().Hex(),
+		Email:        email,
+		Password:     password,
+		Name:         name,
+		Role:         models.RoleCustomer,
+		InboundAlias: inboundAlias,
 	}
 
 	err = s.userModel.Create(context.TODO(), user)
@@ -66,25 +79,53 @@ func (s *UserService) RegisterUser(email, password, name string) (*models.User,
 		return nil, err
 	}
 
+	// The account exists at this point, the user can ask for a new link.
+	// Offers already forwarded from the address are linked once it is verified.
+	if err := s.verification.SendVerification(context.TODO(), user); err != nil {
+		log.Printf("Failed to send verification email to %s: %v", user.Email, err)
+	}
+
 	return user, nil
 }
 
-// LoginUser handles user login
-func (s *UserService) LoginUser(email, password string) (*models.User, error) {
+// LoginUser handles user login from the client at ip
+func (s *UserService) LoginUser(email, password, ip string) (*models.User, error) {
+	// Refuse early while the account or IP is throttled
+	if err := s.lockout.CheckLogin(context.TODO(), email, ip); err != nil {
+		return nil, err
+	}
+
 	// Find user by email
 	user, err := s.userModel.FindByEmail(context.TODO(), email)
 	if err != nil {
 		return nil, err
 	}
-	if user == nil {
-		return nil, ErrInvalidCredentials
-	}
 
 	// Verify password
-	if !s.userModel.VerifyPassword(user, password) {
+	if user == nil || !s.userModel.VerifyPassword(user, password) {
+		if err := s.lockout.RecordFailure(context.TODO(), user, email, ip); err != nil {
+			return nil, err
+		}
 		return nil, ErrInvalidCredentials
 	}
 
+	if user.Suspended {
+		return nil, ErrAccountSuspended
+	}
+
+	if s.verification.Policy().RequireForLogin && !user.EmailVerified {
+		return nil, ErrEmailNotVerified
+	}
+
+	// With two factors the login is only complete, and the failure count
+	// cleared, once MFAService.CompleteLogin accepts the code. Clearing it
+	// here would let the password reset the count of wrong codes.
+	if !user.MFAEnabled {
+		if err := s.lockout.RecordSuccess(context.TODO(), email); err != nil {
+			return nil, err
+		}
+	}
+
 	return user, nil
 }
 
@@ -113,17 +154,18 @@ func (s *UserService) UpdateUser(id string, email, name string) (*models.User, e
 	}
 
 	// Validate email if it's being changed
-	if email != user.Email {
+	emailChanged := email != user.Email
+	if emailChanged {
 		if !isValidEmail(email) {
 			return nil, ErrInvalidEmail
 		}
 
 		// Check if new email already exists
-		existingUser, err := s.userModel.FindByEmail(context.TODO(), email)
+		existingUser, err := s.userModel.FindByAddress(context.TODO(), email)
 		if err != nil {
 			return nil, err
 		}
-		if existingUser != nil {
+		if existingUser != nil && existingUser.ID != user.ID {
 			return nil, ErrEmailExists
 		}
 	}
@@ -133,11 +175,23 @@ func (s *UserService) UpdateUser(id string, email, name string) (*models.User, e
 	user.Name = name
 	user.UpdatedAt = time.Now()
 
+	// A new address has to be verified again
+	if emailChanged {
+		user.EmailVerified = false
+		user.EmailVerifiedAt = nil
+	}
+
 	err = s.userModel.Update(context.TODO(), user)
 	if err != nil {
 		return nil, err
 	}
 
+	if emailChanged {
+		if err := s.verification.SendVerification(context.TODO(), user); err != nil {
+			log.Printf("Failed to send verification email to %s: %v", user.Email, err)
+		}
+	}
+
 	return user, nil
 }
 
@@ -155,6 +209,33 @@ func (s *UserService) DeleteUser(id string) error {
 	return s.userModel.Delete(context.TODO(), id)
 }
 
+// PromoteAdmins gives the admin role to the accounts with the given emails.
+// It is used at startup to bootstrap the first administrators.
+func (s *UserService) PromoteAdmins(emails []string) error {
+	for _, email := range emails {
+		email = strings.TrimSpace(email)
+		if email == "" {
+			continue
+		}
+		user, err := s.userModel.FindByEmail(context.TODO(), email)
+		if err != nil {
+			return err
+		}
+		if user == nil {
+			log.Printf("Bootstrap admin %s is not registered, skipping", email)
+			continue
+		}
+		if user.Role == models.RoleAdmin {
+			continue
+		}
+		if err := s.userModel.SetRole(context.TODO(), user.ID, models.RoleAdmin); err != nil {
+			return err
+		}
+		log.Printf("Promoted %s to admin", email)
+	}
+	return nil
+}
+
 // isValidEmail validates email format
 func isValidEmail(email string) bool {
 	emailRegex := `^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`
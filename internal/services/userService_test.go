@@ -0,0 +1,190 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/services"
+)
+
+func TestRegisterUserValidates(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	env.createUser(t, "taken@example.com", true)
+	owner := env.createUser(t, "owner@example.com", true)
+	if err := env.users.AddAliasEmail(context.Background(), owner.ID, "alias@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		email    string
+		password string
+		want     error
+	}{
+		{"invalid email", "not-an-email", testPassword, services.ErrInvalidEmail},
+		{"short password", "new@example.com", "short", services.ErrInvalidPassword},
+		{"taken email", "taken@example.com", testPassword, services.ErrEmailExists},
+		{"another user's alias", "alias@example.com", testPassword, services.ErrEmailExists},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := env.userService.RegisterUser(tt.email, tt.password, "Name"); !errors.Is(err, tt.want) {
+				t.Fatalf("RegisterUser() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegisterUserSendsVerification(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+
+	user, err := env.userService.RegisterUser("new@example.com", testPassword, "New")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Role != models.RoleCustomer || user.InboundAlias == "" || user.EmailVerified {
+		t.Fatalf("registered user = %+v, want an unverified customer with a forwarding address", user)
+	}
+	if env.mailCount("new@example.com") != 1 {
+		t.Fatalf("sent %d verification emails, want 1", env.mailCount("new@example.com"))
+	}
+	if !env.users.VerifyPassword(env.findUser(t, user.ID), testPassword) {
+		t.Fatal("stored password does not match")
+	}
+}
+
+func TestLoginUser(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	user := env.createUser(t, "user@example.com", true)
+
+	if _, err := env.userService.LoginUser("user@example.com", "wrong password", "10.0.0.1"); !errors.Is(err, services.ErrInvalidCredentials) {
+		t.Fatalf("wrong password: error = %v, want ErrInvalidCredentials", err)
+	}
+	if _, err := env.userService.LoginUser("nobody@example.com", testPassword, "10.0.0.1"); !errors.Is(err, services.ErrInvalidCredentials) {
+		t.Fatalf("unknown email: error = %v, want ErrInvalidCredentials", err)
+	}
+
+	got, err := env.userService.LoginUser("user@example.com", testPassword, "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != user.ID {
+		t.Fatalf("logged in as %s, want %s", got.ID, user.ID)
+	}
+	attempt, err := env.attempts.FindByID(context.Background(), models.AttemptKindAccount+":user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempt != nil {
+		t.Fatalf("failures not cleared after a good login: %+v", attempt)
+	}
+}
+
+func TestLoginUserSuspended(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	user := env.createUser(t, "user@example.com", true)
+	if err := env.users.SetSuspended(context.Background(), user.ID, true, "abuse"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := env.userService.LoginUser("user@example.com", testPassword, "10.0.0.1"); !errors.Is(err, services.ErrAccountSuspended) {
+		t.Fatalf("error = %v, want ErrAccountSuspended", err)
+	}
+}
+
+func TestLoginUserRequiresVerification(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{RequireForLogin: true})
+	env.createUser(t, "unverified@example.com", false)
+	env.createUser(t, "verified@example.com", true)
+
+	if _, err := env.userService.LoginUser("unverified@example.com", testPassword, "10.0.0.1"); !errors.Is(err, services.ErrEmailNotVerified) {
+		t.Fatalf("error = %v, want ErrEmailNotVerified", err)
+	}
+	if _, err := env.userService.LoginUser("verified@example.com", testPassword, "10.0.0.1"); err != nil {
+		t.Fatalf("verified login: %v", err)
+	}
+}
+
+func TestLoginUserWithMFAKeepsFailures(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	user := env.createUser(t, "user@example.com", true)
+	if err := env.users.EnableMFA(context.Background(), user.ID, "JBSWY3DPEHPK3PXP", 0, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := env.userService.LoginUser("user@example.com", "wrong password", "10.0.0.1"); err == nil {
+		t.Fatal("wrong password accepted")
+	}
+	if _, err := env.userService.LoginUser("user@example.com", testPassword, "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only a good second factor may clear the count
+	attempt, err := env.attempts.FindByID(context.Background(), models.AttemptKindAccount+":user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempt == nil || attempt.Failures != 1 {
+		t.Fatalf("attempt = %+v, want the failure kept until the second factor", attempt)
+	}
+}
+
+func TestUpdateUserEmailResetsVerification(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	user := env.createUser(t, "old@example.com", true)
+	env.createUser(t, "other@example.com", true)
+
+	if _, err := env.userService.UpdateUser(user.ID, "other@example.com", "Name"); !errors.Is(err, services.ErrEmailExists) {
+		t.Fatalf("taken email: error = %v, want ErrEmailExists", err)
+	}
+
+	updated, err := env.userService.UpdateUser(user.ID, "new@example.com", "New Name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.EmailVerified || updated.EmailVerifiedAt != nil {
+		t.Fatal("changed email still verified")
+	}
+	if env.mailCount("new@example.com") != 1 {
+		t.Fatal("no verification email sent to the new address")
+	}
+
+	// Renaming alone keeps the address verified
+	kept := env.createUser(t, "kept@example.com", true)
+	renamed, err := env.userService.UpdateUser(kept.ID, "kept@example.com", "Renamed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !renamed.EmailVerified {
+		t.Fatal("rename cleared verification")
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	user := env.createUser(t, "user@example.com", true)
+
+	if err := env.userService.DeleteUser(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.userService.GetUserByID(user.ID); !errors.Is(err, services.ErrUserNotFound) {
+		t.Fatalf("error = %v, want ErrUserNotFound", err)
+	}
+	if err := env.userService.DeleteUser(user.ID); !errors.Is(err, services.ErrUserNotFound) {
+		t.Fatalf("second delete: error = %v, want ErrUserNotFound", err)
+	}
+}
+
+func TestPromoteAdmins(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	user := env.createUser(t, "boss@example.com", true)
+
+	if err := env.userService.PromoteAdmins([]string{" boss@example.com ", "", "missing@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if role := env.findUser(t, user.ID).Role; role != models.RoleAdmin {
+		t.Fatalf("role = %q, want admin", role)
+	}
+}
@@ -0,0 +1,212 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"loyaltea-server/internal/mailer"
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/utils"
+)
+
+var (
+	ErrInvalidVerificationToken = errors.New("invalid or expired verification token")
+	ErrEmailAlreadyVerified     = errors.New("email already verified")
+	ErrEmailNotVerified         = errors.New("email not verified")
+	ErrAliasNotFound            = errors.New("alias email not found")
+)
+
+// VerificationPolicy decides what an account with an unverified email
+// address is blocked from doing
+type VerificationPolicy struct {
+	RequireForLogin  bool
+	RequireForOffers bool
+}
+
+// ParseVerificationPolicy reads a policy from a comma separated list such as
+// "login,offers". Unknown entries are ignored and an empty string blocks nothing.
+func ParseVerificationPolicy(value string) VerificationPolicy {
+	var policy VerificationPolicy
+	for _, part := range strings.Split(value, ",") {
+		switch strings.ToLower(strings.TrimSpace(part)) {
+		case "login":
+			policy.RequireForLogin = true
+		case "offers":
+			policy.RequireForOffers = true
+		}
+	}
+	return policy
+}
+
+// VerificationService sends and checks email verification links, for the
+// primary address as well as alias addresses, and links unclaimed offers to
+// an account once it has proven it owns the address they came from
+type VerificationService struct {
+	userModel  UserRepository
+	offerModel OfferRepository
+	mailer     mailer.Mailer
+	baseURL    string
+	policy     VerificationPolicy
+}
+
+// NewVerificationService creates a new VerificationService instance. baseURL
+// is the public URL of the API the emailed links point to.
+func NewVerificationService(userModel UserRepository, offerModel OfferRepository, m mailer.Mailer, baseURL string, policy VerificationPolicy) *VerificationService {
+	return &VerificationService{
+		userModel:  userModel,
+		offerModel: offerModel,
+		mailer:     m,
+		baseURL:    baseURL,
+		policy:     policy,
+	}
+}
+
+// Policy returns the configured verification policy
+func (s *VerificationService) Policy() VerificationPolicy {
+	return s.policy
+}
+
+// SendVerification emails a signed verification link for the user's current address
+func (s *VerificationService) SendVerification(ctx context.Context, user *models.User) error {
+	return s.sendLink(ctx, user, user.Email)
+}
+
+// AddAliasEmail starts adding a secondary address to a user. The address is
+// only attached once the link sent to it is opened.
+func (s *VerificationService) AddAliasEmail(ctx context.Context, userID string, email string) error {
+	if !isValidEmail(email) {
+		return ErrInvalidEmail
+	}
+
+	user, err := s.userModel.FindByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+
+	owner, err := s.userModel.FindByAddress(ctx, email)
+	if err != nil {
+		return err
+	}
+	if owner != nil {
+		return ErrEmailExists
+	}
+
+	return s.sendLink(ctx, user, email)
+}
+
+// RemoveAliasEmail detaches a secondary address from a user. Offers already
+// linked through it stay with the user.
+func (s *VerificationService) RemoveAliasEmail(ctx context.Context, userID string, email string) error {
+	user, err := s.userModel.FindByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+	if !slices.Contains(user.AliasEmails, email) {
+		return ErrAliasNotFound
+	}
+
+	return s.userModel.RemoveAliasEmail(ctx, user.ID, email)
+}
+
+// ResendVerification sends a fresh link to a user who has not verified yet
+func (s *VerificationService) ResendVerification(ctx context.Context, userID string) error {
+	user, err := s.userModel.FindByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+	if user.EmailVerified {
+		return ErrEmailAlreadyVerified
+	}
+
+	return s.SendVerification(ctx, user)
+}
+
+// VerifyEmail confirms the address in a verification token. For the user's
+// primary address it marks the account verified, any other address is added
+// as an alias. Offers forwarded from the address are then linked to the user.
+func (s *VerificationService) VerifyEmail(ctx context.Context, token string) (*models.User, error) {
+	claims, err := utils.ValidateEmailVerificationToken(token)
+	if err != nil {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	user, err := s.userModel.FindByID(ctx, claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	if claims.Email == user.Email {
+		if !user.EmailVerified {
+			ok, err := s.userModel.MarkEmailVerified(ctx, user.ID, claims.Email)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				return nil, ErrInvalidVerificationToken
+			}
+		}
+	} else if !slices.Contains(user.AliasEmails, claims.Email) {
+		// Someone else may have claimed the address since the link was sent
+		owner, err := s.userModel.FindByAddress(ctx, claims.Email)
+		if err != nil {
+			return nil, err
+		}
+		if owner != nil {
+			return nil, ErrEmailExists
+		}
+		if err := s.userModel.AddAliasEmail(ctx, user.ID, claims.Email); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.claimOffers(ctx, user.ID, claims.Email); err != nil {
+		return nil, err
+	}
+
+	return s.userModel.FindByID(ctx, user.ID)
+}
+
+// sendLink emails a signed link proving user owns email to that address
+func (s *VerificationService) sendLink(ctx context.Context, user *models.User, email string) error {
+	token, err := utils.GenerateEmailVerificationToken(user.ID, email)
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Confirm your Loyaltea email address",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nPlease confirm your email address by opening the link below. It expires in %d hours.\n\n%s/user/verify?token=%s\n",
+			user.Name, int(utils.EmailVerificationTTL.Hours()), s.baseURL, token,
+		),
+	})
+}
+
+// claimOffers links the unclaimed offers forwarded from email to the user
+func (s *VerificationService) claimOffers(ctx context.Context, userID string, email string) error {
+	claimed, err := s.offerModel.ClaimBySender(ctx, email, userID)
+	if err != nil {
+		return err
+	}
+	if claimed > 0 {
+		log.Printf("Linked %d unclaimed offers from %s to user %s", claimed, email, userID)
+	}
+	return nil
+}
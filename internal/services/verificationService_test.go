@@ -0,0 +1,196 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/services"
+)
+
+func TestVerifyEmailClaimsOffers(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	ctx := context.Background()
+
+	// Forwarded before the address was registered
+	offer := &models.Offer{SenderEmail: "new@example.com", Subject: "20% off everything", Body: "Use code SAVE20 at checkout."}
+	if err := env.offerService.CreateOffer(ctx, offer); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := env.userService.RegisterUser("new@example.com", testPassword, "New")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := env.offers.FindByID(ctx, offer.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.UserID != "" || stored.Status != models.OfferStatusUnclaimed {
+		t.Fatal("offer claimed before the address was verified")
+	}
+
+	verified, err := env.verification.VerifyEmail(ctx, env.mailedToken(t, "new@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verified.EmailVerified {
+		t.Fatal("email not marked verified")
+	}
+	stored, err = env.offers.FindByID(ctx, offer.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.UserID != user.ID || stored.Status != models.OfferStatusClaimed {
+		t.Fatalf("offer = %+v, want it claimed by %s", stored, user.ID)
+	}
+}
+
+func TestUnverifiedSenderOffersWaitForVerification(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	ctx := context.Background()
+
+	// Registering someone else's address does not link their forwards
+	user, err := env.userService.RegisterUser("new@example.com", testPassword, "New")
+	if err != nil {
+		t.Fatal(err)
+	}
+	offer := env.createOffer(t, "new@example.com", "20% off everything", "Use code SAVE20 at checkout.")
+	if offer.UserID != "" || offer.Status != models.OfferStatusUnclaimed {
+		t.Fatalf("offer = %+v, want it unclaimed until the address is verified", offer)
+	}
+
+	if _, err := env.verification.VerifyEmail(ctx, env.mailedToken(t, "new@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := env.offers.FindByID(ctx, offer.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.UserID != user.ID || stored.Status != models.OfferStatusClaimed {
+		t.Fatalf("offer = %+v, want it claimed by %s", stored, user.ID)
+	}
+
+	// Later forwards are linked straight away
+	later := env.createOffer(t, "new@example.com", "Free delivery weekend", "No minimum order this weekend only.")
+	if later.UserID != user.ID || later.Status != models.OfferStatusClaimed {
+		t.Fatalf("offer = %+v, want it claimed by %s", later, user.ID)
+	}
+}
+
+func TestVerifyEmailInvalidToken(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+
+	if _, err := env.verification.VerifyEmail(context.Background(), "not-a-token"); !errors.Is(err, services.ErrInvalidVerificationToken) {
+		t.Fatalf("error = %v, want ErrInvalidVerificationToken", err)
+	}
+}
+
+func TestVerifyEmailAfterAddressChanged(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	user := env.createUser(t, "old@example.com", false)
+	ctx := context.Background()
+
+	if err := env.verification.SendVerification(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	token := env.mailedToken(t, "old@example.com")
+	if _, err := env.userService.UpdateUser(user.ID, "new@example.com", user.Name); err != nil {
+		t.Fatal(err)
+	}
+
+	// The old link now proves an address the user no longer has as primary,
+	// so it is added as an alias rather than verifying the new address
+	verified, err := env.verification.VerifyEmail(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified.EmailVerified {
+		t.Fatal("old link verified the new address")
+	}
+}
+
+func TestAliasEmailFlow(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	user := env.createUser(t, "user@example.com", true)
+	ctx := context.Background()
+
+	offer := &models.Offer{SenderEmail: "work@example.com", Subject: "Free shipping this week", Body: "Free shipping on all orders."}
+	if err := env.offerService.CreateOffer(ctx, offer); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := env.verification.AddAliasEmail(ctx, user.ID, "not-an-email"); !errors.Is(err, services.ErrInvalidEmail) {
+		t.Fatalf("invalid alias: error = %v, want ErrInvalidEmail", err)
+	}
+	if err := env.verification.AddAliasEmail(ctx, user.ID, "work@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if slices.Contains(env.findUser(t, user.ID).AliasEmails, "work@example.com") {
+		t.Fatal("alias added before it was verified")
+	}
+
+	verified, err := env.verification.VerifyEmail(ctx, env.mailedToken(t, "work@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(verified.AliasEmails, "work@example.com") {
+		t.Fatalf("aliases = %v, want work@example.com", verified.AliasEmails)
+	}
+	stored, err := env.offers.FindByID(ctx, offer.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.UserID != user.ID {
+		t.Fatal("offer from the alias not claimed")
+	}
+
+	if err := env.verification.RemoveAliasEmail(ctx, user.ID, "work@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.verification.RemoveAliasEmail(ctx, user.ID, "work@example.com"); !errors.Is(err, services.ErrAliasNotFound) {
+		t.Fatalf("second removal: error = %v, want ErrAliasNotFound", err)
+	}
+}
+
+func TestAliasEmailTaken(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	user := env.createUser(t, "user@example.com", true)
+	other := env.createUser(t, "other@example.com", true)
+	ctx := context.Background()
+
+	if err := env.verification.AddAliasEmail(ctx, user.ID, "other@example.com"); !errors.Is(err, services.ErrEmailExists) {
+		t.Fatalf("another user's email: error = %v, want ErrEmailExists", err)
+	}
+
+	// Claimed by someone else between sending and opening the link
+	if err := env.verification.AddAliasEmail(ctx, user.ID, "shared@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	token := env.mailedToken(t, "shared@example.com")
+	if err := env.users.AddAliasEmail(ctx, other.ID, "shared@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.verification.VerifyEmail(ctx, token); !errors.Is(err, services.ErrEmailExists) {
+		t.Fatalf("error = %v, want ErrEmailExists", err)
+	}
+}
+
+func TestResendVerification(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	unverified := env.createUser(t, "unverified@example.com", false)
+	verified := env.createUser(t, "verified@example.com", true)
+	ctx := context.Background()
+
+	if err := env.verification.ResendVerification(ctx, unverified.ID); err != nil {
+		t.Fatal(err)
+	}
+	if env.mailCount("unverified@example.com") != 1 {
+		t.Fatal("no verification email resent")
+	}
+	if err := env.verification.ResendVerification(ctx, verified.ID); !errors.Is(err, services.ErrEmailAlreadyVerified) {
+		t.Fatalf("error = %v, want ErrEmailAlreadyVerified", err)
+	}
+}
@@ -0,0 +1,180 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Providers whose webhook nonces are recorded
+const (
+	WebhookProviderMailgun = "mailgun"
+	WebhookProviderRaw     = "raw"
+)
+
+// webhookTolerance is how far a signed webhook's timestamp may be from now
+const webhookTolerance = 5 * time.Minute
+
+var (
+	ErrWebhookNotConfigured = errors.New("webhook signing key not configured")
+	ErrInvalidSignature     = errors.New("invalid webhook signature")
+	ErrStaleWebhook         = errors.New("webhook timestamp outside the accepted window")
+	ErrReplayedWebhook      = errors.New("webhook already received")
+)
+
+// WebhookConfig holds the secrets webhook requests are checked with. A
+// provider whose secret is empty is refused.
+type WebhookConfig struct {
+	MailgunSigningKey string // Mailgun HTTP webhook signing key
+	MailchimpSecret   string // Secret in the key query parameter of the Mailchimp list webhook URL
+	MandrillKey       string // Mandrill webhook authentication key
+	MandrillURL       string // URL the Mandrill webhook is registered with, which is part of the signature
+	RawSigningKey     string // Key raw emails posted by a mail server are signed with
+}
+
+// WebhookService authenticates webhook requests from email providers
+type WebhookService struct {
+	nonces WebhookNonceRepository
+	config WebhookConfig
+}
+
+func NewWebhookService(nonces WebhookNonceRepository, config WebhookConfig) *WebhookService {
+	return &WebhookService{
+		nonces: nonces,
+		config: config,
+	}
+}
+
+// VerifyMailgun checks the signature Mailgun sends with every webhook, an
+// HMAC-SHA256 of the timestamp and token keyed with the webhook signing key.
+// The timestamp must be recent and the token unused, so a captured request
+// cannot be replayed.
+func (s *WebhookService) VerifyMailgun(ctx context.Context, timestamp string, token string, signature string) error {
+	if s.config.MailgunSigningKey == "" {
+		return ErrWebhookNotConfigured
+	}
+
+	mac := hmac.New(sha256.New, []byte(s.config.MailgunSigningKey))
+	mac.Write([]byte(timestamp + token))
+	expected := hex.EncodeToString(mac.Sum(nil))
+	if token == "" || !hmac.Equal([]byte(expected), []byte(strings.ToLower(signature))) {
+		return ErrInvalidSignature
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	signedAt := time.Unix(seconds, 0)
+	if age := time.Since(signedAt); age > webhookTolerance || age < -webhookTolerance {
+		return ErrStaleWebhook
+	}
+
+	fresh, err := s.nonces.Use(ctx, WebhookProviderMailgun, token, signedAt.Add(webhookTolerance))
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return ErrReplayedWebhook
+	}
+	return nil
+}
+
+// VerifyRaw checks the signature of a raw email posted by a mail server: a
+// hex HMAC-SHA256 of the timestamp, a dot and the body, keyed with the raw
+// signing key. Like Mailgun's, the timestamp must be recent and a signature
+// is only accepted once.
+func (s *WebhookService) VerifyRaw(ctx context.Context, timestamp string, signature string, body []byte) error {
+	if s.config.RawSigningKey == "" {
+		return ErrWebhookNotConfigured
+	}
+
+	mac := hmac.New(sha256.New, []byte(s.config.RawSigningKey))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	signature = strings.ToLower(signature)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	signedAt := time.Unix(seconds, 0)
+	if age := time.Since(signedAt); age > webhookTolerance || age < -webhookTolerance {
+		return ErrStaleWebhook
+	}
+
+	fresh, err := s.nonces.Use(ctx, WebhookProviderRaw, signature, signedAt.Add(webhookTolerance))
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return ErrReplayedWebhook
+	}
+	return nil
+}
+
+// ReleaseMailgun forgets the token of a verified Mailgun webhook that could
+// not be handled, so that Mailgun's retry of it is accepted
+func (s *WebhookService) ReleaseMailgun(ctx context.Context, token string) error {
+	return s.nonces.Release(ctx, WebhookProviderMailgun, token)
+}
+
+// ReleaseRaw forgets the signature of a verified raw email that could not be
+// handled, so that the mail server's retry of it is accepted
+func (s *WebhookService) ReleaseRaw(ctx context.Context, signature string) error {
+	return s.nonces.Release(ctx, WebhookProviderRaw, strings.ToLower(signature))
+}
+
+// VerifyMailchimp checks the secret of a Mailchimp list webhook. Mailchimp
+// does not sign these requests, so the secret is part of the registered URL.
+func (s *WebhookService) VerifyMailchimp(secret string) error {
+	if s.config.MailchimpSecret == "" {
+		return ErrWebhookNotConfigured
+	}
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(s.config.MailchimpSecret)) != 1 {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// VerifyMandrill checks the X-Mandrill-Signature of a Mandrill webhook: a
+// base64 HMAC-SHA1 of the webhook URL followed by every POST parameter's key
+// and value, sorted by key
+func (s *WebhookService) VerifyMandrill(params url.Values, signature string) error {
+	if s.config.MandrillKey == "" {
+		return ErrWebhookNotConfigured
+	}
+
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	mac := hmac.New(sha1.New, []byte(s.config.MandrillKey))
+	mac.Write([]byte(s.config.MandrillURL))
+	for _, key := range keys {
+		for _, value := range params[key] {
+			mac.Write([]byte(key + value))
+		}
+	}
+	expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package services_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"loyaltea-server/internal/db/memory"
+	"loyaltea-server/internal/services"
+)
+
+// hmacHex returns the hex HMAC-SHA256 of message keyed with key
+func hmacHex(key string, message string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(message))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func unixNow() string {
+	return strconv.FormatInt(time.Now().Unix(), 10)
+}
+
+func TestVerifyMailgun(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	ctx := context.Background()
+	timestamp, token := unixNow(), "mailgun-token-1"
+	signature := hmacHex(testMailgunKey, timestamp+token)
+
+	if err := env.webhooks.VerifyMailgun(ctx, timestamp, token, hmacHex("wrong key", timestamp+token)); !errors.Is(err, services.ErrInvalidSignature) {
+		t.Fatalf("bad signature: error = %v, want ErrInvalidSignature", err)
+	}
+	if err := env.webhooks.VerifyMailgun(ctx, timestamp, token, signature); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.webhooks.VerifyMailgun(ctx, timestamp, token, signature); !errors.Is(err, services.ErrReplayedWebhook) {
+		t.Fatalf("replay: error = %v, want ErrReplayedWebhook", err)
+	}
+
+	// A request that could not be handled may be retried
+	if err := env.webhooks.ReleaseMailgun(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.webhooks.VerifyMailgun(ctx, timestamp, token, strings.ToUpper(signature)); err != nil {
+		t.Fatalf("retry after release: %v", err)
+	}
+
+	stale := strconv.FormatInt(time.Now().Add(-10*time.Minute).Unix(), 10)
+	if err := env.webhooks.VerifyMailgun(ctx, stale, "mailgun-token-2", hmacHex(testMailgunKey, stale+"mailgun-token-2")); !errors.Is(err, services.ErrStaleWebhook) {
+		t.Fatalf("stale: error = %v, want ErrStaleWebhook", err)
+	}
+}
+
+func TestVerifyRaw(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	ctx := context.Background()
+	body := rawMessage("user@example.com", "Sale", "Everything is 10% off.")
+	timestamp := unixNow()
+	signature := hmacHex(testRawKey, timestamp+"."+string(body))
+
+	if err := env.webhooks.VerifyRaw(ctx, timestamp, signature, append(body, '!')); !errors.Is(err, services.ErrInvalidSignature) {
+		t.Fatalf("changed body: error = %v, want ErrInvalidSignature", err)
+	}
+	if err := env.webhooks.VerifyRaw(ctx, timestamp, strings.ToUpper(signature), body); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.webhooks.VerifyRaw(ctx, timestamp, signature, body); !errors.Is(err, services.ErrReplayedWebhook) {
+		t.Fatalf("replay: error = %v, want ErrReplayedWebhook", err)
+	}
+	if err := env.webhooks.ReleaseRaw(ctx, strings.ToUpper(signature)); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.webhooks.VerifyRaw(ctx, timestamp, signature, body); err != nil {
+		t.Fatalf("retry after release: %v", err)
+	}
+
+	future := strconv.FormatInt(time.Now().Add(10*time.Minute).Unix(), 10)
+	if err := env.webhooks.VerifyRaw(ctx, future, hmacHex(testRawKey, future+"."+string(body)), body); !errors.Is(err, services.ErrStaleWebhook) {
+		t.Fatalf("future timestamp: error = %v, want ErrStaleWebhook", err)
+	}
+}
+
+func TestVerifyMailchimp(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+
+	if err := env.webhooks.VerifyMailchimp(testMailchimpKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.webhooks.VerifyMailchimp("guess"); !errors.Is(err, services.ErrInvalidSignature) {
+		t.Fatalf("error = %v, want ErrInvalidSignature", err)
+	}
+}
+
+func TestVerifyMandrill(t *testing.T) {
+	env := newTestEnv(t, services.VerificationPolicy{})
+	params := url.Values{"mandrill_events": {`[{"event":"hard_bounce"}]`}, "a": {"1"}}
+
+	mac := hmac.New(sha1.New, []byte(testMandrillKey))
+	mac.Write([]byte(testMandrillURL + "a1" + "mandrill_events" + params.Get("mandrill_events")))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	if err := env.webhooks.VerifyMandrill(params, signature); err != nil {
+		t.Fatal(err)
+	}
+	params.Set("a", "2")
+	if err := env.webhooks.VerifyMandrill(params, signature); !errors.Is(err, services.ErrInvalidSignature) {
+		t.Fatalf("changed params: error = %v, want ErrInvalidSignature", err)
+	}
+}
+
+func TestWebhooksNotConfigured(t *testing.T) {
+	webhooks := services.NewWebhookService(memory.NewWebhookNonceRepository(), services.WebhookConfig{})
+	ctx := context.Background()
+
+	// An empty key must not make an empty signature valid
+	if err := webhooks.VerifyMailgun(ctx, unixNow(), "token", hmacHex("", unixNow()+"token")); !errors.Is(err, services.ErrWebhookNotConfigured) {
+		t.Fatalf("mailgun: error = %v, want ErrWebhookNotConfigured", err)
+	}
+	if err := webhooks.VerifyRaw(ctx, unixNow(), "", nil); !errors.Is(err, services.ErrWebhookNotConfigured) {
+		t.Fatalf("raw: error = %v, want ErrWebhookNotConfigured", err)
+	}
+	if err := webhooks.VerifyMailchimp(""); !errors.Is(err, services.ErrWebhookNotConfigured) {
+		t.Fatalf("mailchimp: error = %v, want ErrWebhookNotConfigured", err)
+	}
+	if err := webhooks.VerifyMandrill(url.Values{}, ""); !errors.Is(err, services.ErrWebhookNotConfigured) {
+		t.Fatalf("mandrill: error = %v, want ErrWebhookNotConfigured", err)
+	}
+}
@@ -0,0 +1,477 @@
+// Package smtpd is a small inbound SMTP server. It accepts mail for
+// recipients its Backend validates and hands each message to the Backend; it
+// does not relay, authenticate or offer TLS.
+package smtpd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for the limits of a Server left at zero
+const (
+	DefaultMaxMessageBytes = 10 << 20
+	DefaultMaxRecipients   = 50
+	DefaultMaxConnections  = 100
+	DefaultTimeout         = 5 * time.Minute
+	maxLineLength          = 4096
+)
+
+// ErrServerClosed is returned by Serve after Close
+var ErrServerClosed = errors.New("smtpd: server closed")
+
+// Backend validates recipients and receives messages
+type Backend interface {
+	// ValidateRecipient is called for each RCPT TO. An error rejects the recipient.
+	ValidateRecipient(ctx context.Context, address string) error
+	// Deliver receives a message for the accepted recipients
+	Deliver(ctx context.Context, from string, to []string, message []byte) error
+}
+
+// Error is an SMTP reply a Backend can return to control the response code.
+// Other errors reject recipients with 550 and messages with 451.
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s", e.Code, e.Message)
+}
+
+// Server accepts SMTP connections
+type Server struct {
+	Addr            string // Address to listen on, such as ":2525"
+	Hostname        string // Name announced in the greeting
+	Backend         Backend
+	MaxMessageBytes int64
+	MaxRecipients   int
+	MaxConnections  int           // Connections served at once; more are turned away with 421
+	Timeout         time.Duration // Idle time allowed for each command
+
+	mu        sync.Mutex
+	listener  net.Listener
+	conns     map[net.Conn]bool
+	closed    bool
+	waitGroup sync.WaitGroup
+}
+
+// ListenAndServe listens on s.Addr and serves connections until Close
+func (s *Server) ListenAndServe() error {
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve accepts connections on listener until Close
+func (s *Server) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		listener.Close()
+		return ErrServerClosed
+	}
+	s.listener = listener
+	s.conns = make(map[net.Conn]bool)
+	s.mu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if len(s.conns) >= s.maxConnections() {
+			s.mu.Unlock()
+			go refuse(conn)
+			continue
+		}
+		s.conns[conn] = true
+		s.waitGroup.Add(1)
+		s.mu.Unlock()
+		go func() {
+			defer s.waitGroup.Done()
+			s.handle(conn)
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// Close stops the listener, closes open connections and waits for them to finish
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.waitGroup.Wait()
+	return err
+}
+
+func (s *Server) maxMessageBytes() int64 {
+	if s.MaxMessageBytes > 0 {
+		return s.MaxMessageBytes
+	}
+	return DefaultMaxMessageBytes
+}
+
+func (s *Server) maxRecipients() int {
+	if s.MaxRecipients > 0 {
+		return s.MaxRecipients
+	}
+	return DefaultMaxRecipients
+}
+
+func (s *Server) maxConnections() int {
+	if s.MaxConnections > 0 {
+		return s.MaxConnections
+	}
+	return DefaultMaxConnections
+}
+
+func (s *Server) timeout() time.Duration {
+	if s.Timeout > 0 {
+		return s.Timeout
+	}
+	return DefaultTimeout
+}
+
+func (s *Server) hostname() string {
+	if s.Hostname != "" {
+		return s.Hostname
+	}
+	return "localhost"
+}
+
+// refuse turns away a connection over the limit, as RFC 5321 allows with a
+// 421 greeting
+func refuse(conn net.Conn) {
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	io.WriteString(conn, "421 4.3.2 Too many connections, try again later\r\n")
+}
+
+// session is the state of one connection
+type session struct {
+	server  *Server
+	conn    net.Conn
+	reader  *bufio.Reader
+	writer  *bufio.Writer
+	helo    string
+	from    string
+	hasFrom bool
+	to      []string
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	sess := &session{
+		server: s,
+		conn:   conn,
+		reader: bufio.NewReaderSize(conn, maxLineLength),
+		writer: bufio.NewWriter(conn),
+	}
+
+	sess.reply(220, s.hostname()+" ESMTP ready")
+	for {
+		line, err := sess.readLine()
+		if err != nil {
+			if err == errLineTooLong {
+				sess.reply(500, "5.5.2 Line too long")
+				continue
+			}
+			return
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+		if !sess.command(strings.ToUpper(verb), strings.TrimSpace(arg)) {
+			return
+		}
+	}
+}
+
+// command runs one SMTP command and reports whether the session continues
+func (sess *session) command(verb string, arg string) bool {
+	switch verb {
+	case "HELO", "EHLO":
+		if arg == "" {
+			sess.reply(501, "5.5.4 Domain required")
+			return true
+		}
+		sess.helo = arg
+		sess.reset()
+		if verb == "HELO" {
+			sess.reply(250, sess.server.hostname())
+			return true
+		}
+		sess.replyLines(250,
+			sess.server.hostname(),
+			"8BITMIME",
+			"PIPELINING",
+			"SMTPUTF8",
+			"SIZE "+strconv.FormatInt(sess.server.maxMessageBytes(), 10),
+		)
+
+	case "MAIL":
+		sess.mail(arg)
+
+	case "RCPT":
+		sess.rcpt(arg)
+
+	case "DATA":
+		sess.data()
+
+	case "RSET":
+		sess.reset()
+		sess.reply(250, "2.0.0 OK")
+
+	case "NOOP":
+		sess.reply(250, "2.0.0 OK")
+
+	case "VRFY":
+		sess.reply(252, "2.5.0 Cannot verify user")
+
+	case "QUIT":
+		sess.reply(221, "2.0.0 Bye")
+		return false
+
+	case "STARTTLS", "AUTH":
+		sess.reply(502, "5.5.1 Not supported")
+
+	default:
+		sess.reply(500, "5.5.2 Unknown command")
+	}
+	return true
+}
+
+func (sess *session) reset() {
+	sess.from = ""
+	sess.hasFrom = false
+	sess.to = nil
+}
+
+func (sess *session) mail(arg string) {
+	if sess.helo == "" {
+		sess.reply(503, "5.5.1 Send HELO or EHLO first")
+		return
+	}
+	if sess.hasFrom {
+		sess.reply(503, "5.5.1 Sender already given")
+		return
+	}
+	address, params, ok := parsePath(arg, "FROM:")
+	if !ok {
+		sess.reply(501, "5.5.4 Syntax: MAIL FROM:<address>")
+		return
+	}
+	for _, param := range params {
+		key, value, _ := strings.Cut(param, "=")
+		if strings.EqualFold(key, "SIZE") {
+			if size, err := strconv.ParseInt(value, 10, 64); err == nil && size > sess.server.maxMessageBytes() {
+				sess.reply(552, "5.3.4 Message too large")
+				return
+			}
+		}
+	}
+	sess.from = address
+	sess.hasFrom = true
+	sess.reply(250, "2.1.0 OK")
+}
+
+func (sess *session) rcpt(arg string) {
+	if !sess.hasFrom {
+		sess.reply(503, "5.5.1 Send MAIL first")
+		return
+	}
+	address, _, ok := parsePath(arg, "TO:")
+	if !ok || address == "" {
+		sess.reply(501, "5.5.4 Syntax: RCPT TO:<address>")
+		return
+	}
+	if len(sess.to) >= sess.server.maxRecipients() {
+		sess.reply(452, "4.5.3 Too many recipients")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sess.server.timeout())
+	defer cancel()
+	if err := sess.server.Backend.ValidateRecipient(ctx, address); err != nil {
+		sess.replyError(err, 550, "5.1.1 Recipient rejected")
+		return
+	}
+	sess.to = append(sess.to, address)
+	sess.reply(250, "2.1.5 OK")
+}
+
+func (sess *session) data() {
+	if !sess.hasFrom || len(sess.to) == 0 {
+		sess.reply(503, "5.5.1 Send MAIL and RCPT first")
+		return
+	}
+	sess.reply(354, "End data with <CR><LF>.<CR><LF>")
+
+	message, tooLarge, err := sess.readData()
+	if err != nil {
+		return
+	}
+	if tooLarge {
+		sess.reset()
+		sess.reply(552, "5.3.4 Message too large")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sess.server.timeout())
+	defer cancel()
+	err = sess.server.Backend.Deliver(ctx, sess.from, sess.to, message)
+	sess.reset()
+	if err != nil {
+		log.Printf("smtpd: delivery failed: %v", err)
+		sess.replyError(err, 451, "4.3.0 Message not accepted, try again later")
+		return
+	}
+	sess.reply(250, "2.0.0 Message accepted")
+}
+
+// readData reads a message up to the terminating dot, undoing dot stuffing.
+// A message over the size limit is read to the end and reported as too large.
+func (sess *session) readData() ([]byte, bool, error) {
+	var message bytes.Buffer
+	limit := sess.server.maxMessageBytes()
+	tooLarge := false
+	lineStart := true
+	for {
+		sess.conn.SetReadDeadline(time.Now().Add(sess.server.timeout()))
+		line, err := sess.reader.ReadSlice('\n')
+		if err != nil && err != bufio.ErrBufferFull {
+			return nil, false, err
+		}
+		// Long lines arrive in pieces; only the first piece starts a line
+		if lineStart {
+			if string(bytes.TrimRight(line, "\r\n")) == "." && err == nil {
+				return message.Bytes(), tooLarge, nil
+			}
+			if len(line) > 0 && line[0] == '.' {
+				line = line[1:]
+			}
+		}
+		lineStart = err == nil
+		if tooLarge || int64(message.Len()+len(line)) > limit {
+			tooLarge = true
+			message.Reset()
+			continue
+		}
+		message.Write(line)
+	}
+}
+
+var errLineTooLong = errors.New("line too long")
+
+// readLine reads a command line without its line ending
+func (sess *session) readLine() (string, error) {
+	sess.conn.SetReadDeadline(time.Now().Add(sess.server.timeout()))
+	line, err := sess.reader.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		// Discard the rest of the line
+		for err == bufio.ErrBufferFull {
+			_, err = sess.reader.ReadSlice('\n')
+		}
+		if err != nil {
+			return "", err
+		}
+		return "", errLineTooLong
+	}
+	if err != nil {
+		if err == io.EOF && len(line) > 0 {
+			return strings.TrimRight(string(line), "\r\n"), nil
+		}
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+func (sess *session) reply(code int, message string) {
+	sess.conn.SetWriteDeadline(time.Now().Add(sess.server.timeout()))
+	fmt.Fprintf(sess.writer, "%d %s\r\n", code, message)
+	sess.writer.Flush()
+}
+
+func (sess *session) replyLines(code int, lines ...string) {
+	sess.conn.SetWriteDeadline(time.Now().Add(sess.server.timeout()))
+	for i, line := range lines {
+		separator := "-"
+		if i == len(lines)-1 {
+			separator = " "
+		}
+		fmt.Fprintf(sess.writer, "%d%s%s\r\n", code, separator, line)
+	}
+	sess.writer.Flush()
+}
+
+// replyError replies with the code of an *Error, or the given default
+func (sess *session) replyError(err error, code int, message string) {
+	var smtpErr *Error
+	if errors.As(err, &smtpErr) {
+		sess.reply(smtpErr.Code, smtpErr.Message)
+		return
+	}
+	sess.reply(code, message)
+}
+
+// parsePath parses the argument of MAIL or RCPT, such as
+// "FROM:<a@b.c> SIZE=100", into the address and its parameters
+func parsePath(arg string, prefix string) (string, []string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", nil, false
+	}
+	rest := strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(rest, "<") {
+		// Some clients leave out the brackets
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			return "", nil, false
+		}
+		return fields[0], fields[1:], true
+	}
+	end := strings.IndexByte(rest, '>')
+	if end < 0 {
+		return "", nil, false
+	}
+	address := rest[1:end]
+	// Drop source routes such as <@relay:user@host>
+	if i := strings.LastIndexByte(address, ':'); strings.HasPrefix(address, "@") && i >= 0 {
+		address = address[i+1:]
+	}
+	return address, strings.Fields(rest[end+1:]), true
+}
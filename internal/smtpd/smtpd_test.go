@@ -0,0 +1,327 @@
+package smtpd_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"loyaltea-server/internal/smtpd"
+)
+
+// delivery is a message handed to the backend
+type delivery struct {
+	from    string
+	to      []string
+	message string
+}
+
+// backend accepts recipients at in.test, except unknown@in.test, and records
+// what is delivered. Deliveries fail with err if it is set.
+type backend struct {
+	mu         sync.Mutex
+	deliveries []delivery
+	err        error
+}
+
+func (b *backend) ValidateRecipient(ctx context.Context, address string) error {
+	if address == "unknown@in.test" {
+		return &smtpd.Error{Code: 550, Message: "5.1.1 No such forwarding address"}
+	}
+	if !strings.HasSuffix(address, "@in.test") {
+		return errors.New("not our domain")
+	}
+	return nil
+}
+
+func (b *backend) Deliver(ctx context.Context, from string, to []string, message []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return b.err
+	}
+	b.deliveries = append(b.deliveries, delivery{from, to, string(message)})
+	return nil
+}
+
+func (b *backend) delivered() []delivery {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]delivery(nil), b.deliveries...)
+}
+
+// startServer serves srv on a free local port until the test ends and
+// returns its address
+func startServer(t *testing.T, srv *smtpd.Server) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.Serve(listener)
+	}()
+	t.Cleanup(func() {
+		srv.Close()
+		if err := <-served; !errors.Is(err, smtpd.ErrServerClosed) {
+			t.Errorf("Serve returned %v, want ErrServerClosed", err)
+		}
+	})
+	return listener.Addr().String()
+}
+
+// dial connects a client that has said hello
+func dial(t *testing.T, addr string) *smtp.Client {
+	t.Helper()
+	client, err := smtp.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	if err := client.Hello("client.test"); err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// sendData sends message as the DATA of the current transaction
+func sendData(client *smtp.Client, message string) error {
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(message)); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// expectCode fails the test unless err is an SMTP reply with code
+func expectCode(t *testing.T, err error, code int) {
+	t.Helper()
+	var reply *textproto.Error
+	if !errors.As(err, &reply) || reply.Code != code {
+		t.Fatalf("error = %v, want a %d reply", err, code)
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	b := &backend{}
+	addr := startServer(t, &smtpd.Server{Hostname: "in.test", Backend: b})
+	client := dial(t, addr)
+
+	if ok, size := client.Extension("SIZE"); !ok || size != "10485760" {
+		t.Fatalf("SIZE extension = %v %q, want the default limit", ok, size)
+	}
+
+	message := "Subject: Sale\r\n\r\nHello\r\n.hidden line\r\n..\r\n"
+	if err := client.Mail("sender@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	for _, to := range []string{"alice@in.test", "bob@in.test"} {
+		if err := client.Rcpt(to); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sendData(client, message); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Quit(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := b.delivered()
+	if len(got) != 1 {
+		t.Fatalf("%d deliveries, want 1", len(got))
+	}
+	want := delivery{"sender@example.com", []string{"alice@in.test", "bob@in.test"}, message}
+	if got[0].from != want.from || strings.Join(got[0].to, ",") != strings.Join(want.to, ",") || got[0].message != want.message {
+		t.Fatalf("delivery = %+v, want %+v", got[0], want)
+	}
+}
+
+func TestRejectedRecipients(t *testing.T) {
+	b := &backend{}
+	addr := startServer(t, &smtpd.Server{Backend: b})
+	client := dial(t, addr)
+
+	if err := client.Mail("sender@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	// The backend's reply is used when it gives one
+	expectCode(t, client.Rcpt("unknown@in.test"), 550)
+	expectCode(t, client.Rcpt("someone@elsewhere.test"), 550)
+
+	// Nothing to deliver to yet
+	_, err := client.Data()
+	expectCode(t, err, 503)
+
+	if err := client.Rcpt("alice@in.test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sendData(client, "Subject: Hi\r\n\r\nHello\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.delivered(); len(got) != 1 || len(got[0].to) != 1 || got[0].to[0] != "alice@in.test" {
+		t.Fatalf("deliveries = %+v, want one to alice@in.test", got)
+	}
+}
+
+func TestDeliveryErrors(t *testing.T) {
+	tests := []struct {
+		err  error
+		code int
+	}{
+		{&smtpd.Error{Code: 554, Message: "5.6.0 Message could not be read"}, 554},
+		{errors.New("database down"), 451},
+	}
+	for _, tt := range tests {
+		b := &backend{err: tt.err}
+		addr := startServer(t, &smtpd.Server{Backend: b})
+		client := dial(t, addr)
+
+		if err := client.Mail("sender@example.com"); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.Rcpt("alice@in.test"); err != nil {
+			t.Fatal(err)
+		}
+		expectCode(t, sendData(client, "Subject: Hi\r\n\r\nHello\r\n"), tt.code)
+
+		// The transaction is over, the session is not
+		if err := client.Noop(); err != nil {
+			t.Fatal(err)
+		}
+		_, err := client.Data()
+		expectCode(t, err, 503)
+	}
+}
+
+func TestMessageSizeLimit(t *testing.T) {
+	b := &backend{}
+	addr := startServer(t, &smtpd.Server{Backend: b, MaxMessageBytes: 100})
+	client := dial(t, addr)
+
+	// A declared size over the limit is refused up front
+	id, err := client.Text.Cmd("MAIL FROM:<sender@example.com> SIZE=101")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Text.StartResponse(id)
+	_, _, err = client.Text.ReadResponse(250)
+	client.Text.EndResponse(id)
+	expectCode(t, err, 552)
+
+	if err := client.Mail("sender@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Rcpt("alice@in.test"); err != nil {
+		t.Fatal(err)
+	}
+	expectCode(t, sendData(client, "Subject: Big\r\n\r\n"+strings.Repeat("x", 200)+"\r\n"), 552)
+	if got := b.delivered(); len(got) != 0 {
+		t.Fatalf("delivered %d oversized messages", len(got))
+	}
+
+	// The session can carry on with a message that fits
+	if err := client.Mail("sender@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Rcpt("alice@in.test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sendData(client, "Subject: Small\r\n\r\nHello\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.delivered(); len(got) != 1 {
+		t.Fatalf("%d deliveries, want 1", len(got))
+	}
+}
+
+func TestRecipientLimit(t *testing.T) {
+	addr := startServer(t, &smtpd.Server{Backend: &backend{}, MaxRecipients: 2})
+	client := dial(t, addr)
+
+	if err := client.Mail("sender@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	for _, to := range []string{"alice@in.test", "bob@in.test"} {
+		if err := client.Rcpt(to); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectCode(t, client.Rcpt("carol@in.test"), 452)
+}
+
+func TestCommandOrder(t *testing.T) {
+	addr := startServer(t, &smtpd.Server{Backend: &backend{}})
+	client, err := smtp.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	tests := []struct {
+		command string
+		code    int
+	}{
+		{"MAIL FROM:<sender@example.com>", 503},
+		{"EHLO", 501},
+		{"HELO client.test", 250},
+		{"RCPT TO:<alice@in.test>", 503},
+		{"MAIL TO:<sender@example.com>", 501},
+		{"MAIL FROM:<sender@example.com>", 250},
+		{"MAIL FROM:<sender@example.com>", 503},
+		{"RCPT TO:<>", 501},
+		{"STARTTLS", 502},
+		{"FROB", 500},
+		{"RSET", 250},
+		{"DATA", 503},
+	}
+	for _, tt := range tests {
+		id, err := client.Text.Cmd("%s", tt.command)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.Text.StartResponse(id)
+		code, message, err := client.Text.ReadResponse(0)
+		client.Text.EndResponse(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tt.code {
+			t.Fatalf("%s: reply = %d %s, want %d", tt.command, code, message, tt.code)
+		}
+	}
+}
+
+func TestMaxConnections(t *testing.T) {
+	addr := startServer(t, &smtpd.Server{Backend: &backend{}, MaxConnections: 1})
+	first := dial(t, addr)
+
+	_, err := smtp.Dial(addr)
+	expectCode(t, err, 421)
+
+	// The slot is freed once the first client leaves
+	if err := first.Quit(); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		client, err := smtp.Dial(addr)
+		if err == nil {
+			client.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("connection still refused after the first client left: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"os"
 	"time"
 
+	"loyaltea-server/internal/models"
+
 	"github.com/golang-jwt/jwt/v5"
 )
 
@@ -12,59 +14,153 @@ var (
 	ErrInvalidToken = errors.New("invalid token")
 )
 
+// AccessTokenTTL is how long an access token stays valid. Access tokens are
+// kept short-lived and renewed with a refresh token.
+const AccessTokenTTL = 15 * time.Minute
+
+// EmailVerificationTTL is how long an emailed verification link stays valid
+const EmailVerificationTTL = 48 * time.Hour
+
+// MFAChallengeTTL is how long a user has to enter their second factor after
+// giving the right password
+const MFAChallengeTTL = 5 * time.Minute
+
+// Audiences keep the different kinds of signed tokens from being accepted in
+// place of each other
+const (
+	audienceAccess            = "loyaltea-access"
+	audienceEmailVerification = "loyaltea-email-verification"
+	audienceMFAChallenge      = "loyaltea-mfa-challenge"
+)
+
 type Claims struct {
+	UserID    string `json:"user_id"`
+	Email     string `json:"email"`
+	Role      string `json:"role,omitempty"`
+	SessionID string `json:"sid,omitempty"` // Refresh token family the access token was issued for
+	jwt.RegisteredClaims
+}
+
+// HasRole reports whether the token was issued to a user with one of roles
+func (c *Claims) HasRole(roles ...string) bool {
+	for _, role := range roles {
+		if c.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAdmin reports whether the token was issued to an admin
+func (c *Claims) IsAdmin() bool {
+	return c.Role == models.RoleAdmin
+}
+
+// EmailVerificationClaims are carried by the signed link sent to confirm an
+// email address
+type EmailVerificationClaims struct {
 	UserID string `json:"user_id"`
 	Email  string `json:"email"`
 	jwt.RegisteredClaims
 }
 
-func GenerateToken(userID string, email string) (string, error) {
-	// Get JWT secret from environment variable
+// MFAChallengeClaims are carried by the token handed out in place of an
+// access token when the password was right but a second factor is required
+type MFAChallengeClaims struct {
+	UserID string `json:"user_id"`
+	jwt.RegisteredClaims
+}
+
+// jwtSecret returns the HMAC secret used to sign tokens
+func jwtSecret() []byte {
 	secret := os.Getenv("JWT_SECRET")
 	if secret == "" {
 		secret = "your-secret-key" // fallback secret, should be replaced with proper secret
 	}
+	return []byte(secret)
+}
 
-	// Create claims with multiple fields
-	claims := Claims{
-		UserID: userID,
-		Email:  email,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)), // Token expires in 24 hours
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
-		},
+// registeredClaims returns the standard claims for a token of the given
+// audience and lifetime
+func registeredClaims(audience string, ttl time.Duration) jwt.RegisteredClaims {
+	now := time.Now()
+	return jwt.RegisteredClaims{
+		Audience:  jwt.ClaimStrings{audience},
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
 	}
+}
 
-	// Create token with claims
+// signToken signs claims with the shared HS256 secret
+func signToken(claims jwt.Claims) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(jwtSecret())
+}
 
-	// Generate encoded token
-	tokenString, err := token.SignedString([]byte(secret))
+// parseToken verifies the signature, expiry and audience of a token and
+// decodes it into claims
+func parseToken(tokenString string, claims jwt.Claims, audience string) error {
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return jwtSecret(), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithAudience(audience))
 	if err != nil {
-		return "", err
+		return err
 	}
+	if !token.Valid {
+		return ErrInvalidToken
+	}
+	return nil
+}
 
-	return tokenString, nil
+// GenerateToken signs an access token for the given claims. The registered
+// claims (audience, expiry, issued at, not before) are filled in here.
+func GenerateToken(claims Claims) (string, error) {
+	claims.RegisteredClaims = registeredClaims(audienceAccess, AccessTokenTTL)
+	return signToken(claims)
 }
 
+// ValidateToken parses and verifies an access token
 func ValidateToken(tokenString string) (*Claims, error) {
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		secret = "your-secret-key" // fallback secret, should be replaced with proper secret
+	claims := &Claims{}
+	if err := parseToken(tokenString, claims, audienceAccess); err != nil {
+		return nil, err
 	}
+	return claims, nil
+}
 
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(secret), nil
+// GenerateEmailVerificationToken signs a token proving ownership of email
+func GenerateEmailVerificationToken(userID string, email string) (string, error) {
+	return signToken(EmailVerificationClaims{
+		UserID:           userID,
+		Email:            email,
+		RegisteredClaims: registeredClaims(audienceEmailVerification, EmailVerificationTTL),
 	})
+}
 
-	if err != nil {
+// ValidateEmailVerificationToken parses and verifies an email verification token
+func ValidateEmailVerificationToken(tokenString string) (*EmailVerificationClaims, error) {
+	claims := &EmailVerificationClaims{}
+	if err := parseToken(tokenString, claims, audienceEmailVerification); err != nil {
 		return nil, err
 	}
+	return claims, nil
+}
 
-	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
-		return claims, nil
-	}
+// GenerateMFAChallengeToken signs a token proving the user passed the
+// password step of a login
+func GenerateMFAChallengeToken(userID string) (string, error) {
+	return signToken(MFAChallengeClaims{
+		UserID:           userID,
+		RegisteredClaims: registeredClaims(audienceMFAChallenge, MFAChallengeTTL),
+	})
+}
 
-	return nil, ErrInvalidToken
+// ValidateMFAChallengeToken parses and verifies an MFA challenge token
+func ValidateMFAChallengeToken(tokenString string) (*MFAChallengeClaims, error) {
+	claims := &MFAChallengeClaims{}
+	if err := parseToken(tokenString, claims, audienceMFAChallenge); err != nil {
+		return nil, err
+	}
+	return claims, nil
 }
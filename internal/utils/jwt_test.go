@@ -0,0 +1,122 @@
+package utils_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/utils"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testSecret = "test-secret"
+
+// accessClaims returns claims for an access token issued to a customer that
+// expires after ttl
+func accessClaims(ttl time.Duration) utils.Claims {
+	now := time.Now()
+	return utils.Claims{
+		UserID: "user-1",
+		Email:  "user@example.com",
+		Role:   models.RoleCustomer,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{"loyaltea-access"},
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(now.Add(-time.Minute)),
+		},
+	}
+}
+
+// sign signs claims with method and key
+func sign(t *testing.T, method jwt.SigningMethod, claims jwt.Claims, key any) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestValidateToken(t *testing.T) {
+	t.Setenv("JWT_SECRET", testSecret)
+
+	token, err := utils.GenerateToken(utils.Claims{UserID: "user-1", Email: "user@example.com", Role: models.RoleAdmin, SessionID: "session-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := utils.ValidateToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.UserID != "user-1" || claims.SessionID != "session-1" || !claims.IsAdmin() {
+		t.Fatalf("claims = %+v", claims)
+	}
+	if !claims.ExpiresAt.After(time.Now()) || claims.ExpiresAt.After(time.Now().Add(utils.AccessTokenTTL)) {
+		t.Fatalf("expires at %v, want within %v", claims.ExpiresAt, utils.AccessTokenTTL)
+	}
+
+	// The secret is read when tokens are checked, not when they are signed
+	t.Setenv("JWT_SECRET", "rotated-secret")
+	if _, err := utils.ValidateToken(token); !errors.Is(err, jwt.ErrTokenSignatureInvalid) {
+		t.Fatalf("after changing the secret: error = %v, want ErrTokenSignatureInvalid", err)
+	}
+}
+
+func TestValidateTokenRejects(t *testing.T) {
+	t.Setenv("JWT_SECRET", testSecret)
+
+	verification, err := utils.GenerateEmailVerificationToken("user-1", "user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge, err := utils.GenerateMFAChallengeToken("user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	noAudience := accessClaims(time.Hour)
+	noAudience.Audience = nil
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"expired", sign(t, jwt.SigningMethodHS256, accessClaims(-time.Minute), []byte(testSecret)), jwt.ErrTokenExpired},
+		{"wrong secret", sign(t, jwt.SigningMethodHS256, accessClaims(time.Hour), []byte("guess")), jwt.ErrTokenSignatureInvalid},
+		{"other HMAC algorithm", sign(t, jwt.SigningMethodHS512, accessClaims(time.Hour), []byte(testSecret)), jwt.ErrTokenSignatureInvalid},
+		{"unsigned", sign(t, jwt.SigningMethodNone, accessClaims(time.Hour), jwt.UnsafeAllowNoneSignatureType), jwt.ErrTokenSignatureInvalid},
+		{"email verification token", verification, jwt.ErrTokenInvalidAudience},
+		{"MFA challenge token", challenge, jwt.ErrTokenInvalidAudience},
+		{"no audience", sign(t, jwt.SigningMethodHS256, noAudience, []byte(testSecret)), jwt.ErrTokenRequiredClaimMissing},
+		{"malformed", "not.a.token", jwt.ErrTokenMalformed},
+		{"empty", "", jwt.ErrTokenMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := utils.ValidateToken(tt.token)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+			if claims != nil {
+				t.Fatalf("claims = %+v, want none", claims)
+			}
+		})
+	}
+}
+
+func TestAccessTokenIsNotAVerificationToken(t *testing.T) {
+	t.Setenv("JWT_SECRET", testSecret)
+
+	token, err := utils.GenerateToken(utils.Claims{UserID: "user-1", Email: "user@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := utils.ValidateEmailVerificationToken(token); !errors.Is(err, jwt.ErrTokenInvalidAudience) {
+		t.Fatalf("verification: error = %v, want ErrTokenInvalidAudience", err)
+	}
+	if _, err := utils.ValidateMFAChallengeToken(token); !errors.Is(err, jwt.ErrTokenInvalidAudience) {
+		t.Fatalf("MFA challenge: error = %v, want ErrTokenInvalidAudience", err)
+	}
+}
@@ -1,12 +1,24 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"loyaltea-server/internal/brands"
+	"loyaltea-server/internal/classify"
 	"loyaltea-server/internal/db"
 	"loyaltea-server/internal/handlers"
+	"loyaltea-server/internal/mailer"
+	"loyaltea-server/internal/marketing"
+	"loyaltea-server/internal/middleware"
 	"loyaltea-server/internal/models"
+	"loyaltea-server/internal/search"
 	"loyaltea-server/internal/services"
+	"loyaltea-server/internal/smtpd"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -23,6 +35,23 @@ func main() {
 
 	DBNAME := os.Getenv("DBNAME")
 	DBURI := os.Getenv("DATABASE_URL")
+	BASEURL := os.Getenv("APP_BASE_URL")
+	if BASEURL == "" {
+		BASEURL = "http://localhost:8080"
+	}
+	// Password reset links open this page of the web app, which posts the
+	// token from its ?token= parameter and the new password to
+	// POST /user/password/reset
+	RESETURL := os.Getenv("PASSWORD_RESET_URL")
+	if RESETURL == "" {
+		RESETURL = "http://localhost:3000/reset-password"
+	}
+
+	// Only believe X-Forwarded-For from the listed proxies, so clients cannot
+	// pick the IP login throttling is counted against
+	if err := router.SetTrustedProxies(trustedProxiesFromEnv()); err != nil {
+		log.Fatal("Error configuring trusted proxies: ", err)
+	}
 
 	err = db.ConnectDB(DBURI, DBNAME)
 	if err != nil {
@@ -37,26 +66,319 @@ func main() {
 	})
 
 	userModel := db.NewUserModel(db.Database)
-	userService := services.NewUserService(userModel)
-	userHandler := handlers.NewUserHandler(userService)
+	if err := userModel.EnsureIndexes(context.Background()); err != nil {
+		log.Fatal("Error creating user indexes: ", err)
+	}
+	offerModel := models.NewOfferModel(db.Database)
+	mail := mailer.NewFromEnv()
+	verificationPolicy := services.ParseVerificationPolicy(os.Getenv("EMAIL_VERIFICATION_REQUIRED"))
+	verificationService := services.NewVerificationService(userModel, offerModel, mail, BASEURL, verificationPolicy)
+
+	oneTimeTokenModel := db.NewOneTimeTokenModel(db.Database)
+	loginAttemptModel := db.NewLoginAttemptModel(db.Database)
+	securityEventModel := db.NewSecurityEventModel(db.Database)
+	lockoutService := services.NewLockoutService(loginAttemptModel, securityEventModel, userModel, oneTimeTokenModel, mail, BASEURL, lockoutPolicyFromEnv())
+	lockoutHandler := handlers.NewLockoutHandler(lockoutService)
+
+	offerIndex, err := search.NewMongoIndex(context.Background(), db.Database)
+	if err != nil {
+		log.Fatal("Error creating offer search index")
+	}
+	brandDetector, err := brandDetectorFromEnv()
+	if err != nil {
+		log.Fatal("Error loading brand catalog: ", err)
+	}
+	offerClassifier, err := classifierFromEnv()
+	if err != nil {
+		log.Fatal("Error loading tag rules: ", err)
+	}
+	offerService := services.NewOfferService(offerModel, userModel, offerIndex, brandDetector, offerClassifier, verificationPolicy)
+
+	inboundDomain := os.Getenv("INBOUND_DOMAIN")
+	if inboundDomain == "" {
+		inboundDomain = "in.loyaltea.example"
+	}
+	inboundService := services.NewInboundService(userModel, offerService, inboundDomain)
+	if count, err := inboundService.BackfillAliases(context.Background()); err != nil {
+		log.Printf("Failed to backfill forwarding addresses: %v", err)
+	} else if count > 0 {
+		log.Printf("Gave %d users a forwarding address", count)
+	}
+
+	refreshTokenModel := db.NewRefreshTokenModel(db.Database)
+	userService := services.NewUserService(userModel, verificationService, lockoutService)
+	tokenService := services.NewTokenService(refreshTokenModel, userModel, verificationPolicy)
+	userHandler := handlers.NewUserHandler(userService, tokenService, verificationService, inboundService)
+
+	mfaService := services.NewMFAService(userModel, lockoutService, "Loyaltea")
+	mfaHandler := handlers.NewMFAHandler(mfaService, tokenService)
+
+	pointsModel := db.NewPointsModel(db.Database)
+	if err := pointsModel.EnsureIndexes(context.Background()); err != nil {
+		log.Fatal("Error creating points ledger index: ", err)
+	}
+	pointsService := services.NewPointsService(pointsModel, userModel)
+	pointsHandler := handlers.NewPointsHandler(pointsService)
+	earnRuleModel := db.NewEarnRuleModel(db.Database)
+	earnService := services.NewEarnService(earnRuleModel, pointsModel, pointsService, userModel)
+	earnHandler := handlers.NewEarnHandler(earnService)
+
+	passwordService := services.NewPasswordService(userModel, oneTimeTokenModel, tokenService, mail, RESETURL)
+	passwordHandler := handlers.NewPasswordHandler(passwordService)
 
 	// user routes
 	userRoutes := router.Group("/user")
 	{
 		userRoutes.POST("/register", userHandler.Register)
 		userRoutes.POST("/login", userHandler.Login)
-		userRoutes.GET("/:id", userHandler.GetUser)
-		userRoutes.PUT("/:id", userHandler.UpdateUser)
-		userRoutes.DELETE("/:id", userHandler.DeleteUser)
+		userRoutes.POST("/login/mfa", mfaHandler.Login)
+		userRoutes.POST("/token/refresh", userHandler.RefreshToken)
+		userRoutes.POST("/password/forgot", passwordHandler.ForgotPassword)
+		userRoutes.POST("/password/reset", passwordHandler.ResetPassword)
+		userRoutes.GET("/verify", userHandler.VerifyEmail)
+		userRoutes.POST("/unlock/request", lockoutHandler.RequestUnlock)
+		userRoutes.GET("/unlock", lockoutHandler.UnlockAccount)
+
+		// routes below require a valid Bearer token
+		authRoutes := userRoutes.Group("", middleware.RequireAuth())
+		authRoutes.POST("/logout", userHandler.Logout)
+		authRoutes.POST("/password/change", passwordHandler.ChangePassword)
+		authRoutes.POST("/verify/resend", userHandler.ResendVerification)
+		authRoutes.POST("/2fa/setup", mfaHandler.Setup)
+		authRoutes.POST("/2fa/confirm", mfaHandler.Confirm)
+		authRoutes.POST("/2fa/disable", mfaHandler.Disable)
+		authRoutes.POST("/2fa/recovery-codes", mfaHandler.RegenerateRecoveryCodes)
+		authRoutes.GET("/:id", userHandler.GetUser)
+		authRoutes.PUT("/:id", userHandler.UpdateUser)
+		authRoutes.DELETE("/:id", userHandler.DeleteUser)
+		authRoutes.POST("/:id/emails", userHandler.AddAliasEmail)
+		authRoutes.DELETE("/:id/emails/:email", userHandler.RemoveAliasEmail)
+		authRoutes.POST("/:id/forwarding-address/rotate", userHandler.RotateForwardingAddress)
+		authRoutes.GET("/:id/points", pointsHandler.GetBalance)
+		authRoutes.GET("/:id/points/history", pointsHandler.GetHistory)
+		authRoutes.POST("/:id/points/redeem", pointsHandler.Redeem)
 	}
 
-	offerModel := models.NewOfferModel(db.Database)
-	offerService := services.NewOfferService(offerModel)
-	offerHandler := handlers.NewOfferHandler(offerService)
+	// promote the configured bootstrap admins
+	if admins := os.Getenv("ADMIN_EMAILS"); admins != "" {
+		if err := userService.PromoteAdmins(strings.Split(admins, ",")); err != nil {
+			log.Printf("Failed to promote bootstrap admins: %v", err)
+		}
+	}
+
+	adminService := services.NewAdminService(userModel, tokenService)
+	adminHandler := handlers.NewAdminHandler(adminService)
+
+	// admin routes
+	adminRoutes := router.Group("/admin", middleware.RequireAuth(), middleware.RequireRoles(models.RoleAdmin))
+	{
+		adminRoutes.GET("/users", adminHandler.ListUsers)
+		adminRoutes.GET("/users/search", adminHandler.SearchUsers)
+		adminRoutes.PUT("/users/:id/role", adminHandler.SetRole)
+		adminRoutes.POST("/users/:id/suspend", adminHandler.SuspendUser)
+		adminRoutes.POST("/users/:id/unsuspend", adminHandler.UnsuspendUser)
+		adminRoutes.POST("/users/:id/points/adjust", pointsHandler.AdjustPoints)
+		adminRoutes.GET("/security-events", lockoutHandler.ListSecurityEvents)
+	}
+
+	// merchant routes
+	merchantRoutes := router.Group("/merchant", middleware.RequireAuth(), middleware.RequireRoles(models.RoleMerchantStaff, models.RoleAdmin))
+	{
+		merchantRoutes.GET("/earn-rules", earnHandler.ListRules)
+		merchantRoutes.POST("/earn-rules", earnHandler.CreateRule)
+		merchantRoutes.POST("/earn-rules/dry-run", earnHandler.DryRun)
+		merchantRoutes.GET("/earn-rules/:id", earnHandler.GetRule)
+		merchantRoutes.PUT("/earn-rules/:id", earnHandler.UpdateRule)
+		merchantRoutes.DELETE("/earn-rules/:id", earnHandler.DeleteRule)
+		merchantRoutes.POST("/purchases", earnHandler.RecordPurchase)
+	}
+
+	webhookNonceModel := db.NewWebhookNonceModel(db.Database)
+	if err := webhookNonceModel.EnsureIndexes(context.Background()); err != nil {
+		log.Printf("Failed to create webhook nonce index: %v", err)
+	}
+	webhookService := services.NewWebhookService(webhookNonceModel, webhookConfigFromEnv(BASEURL))
+	subscriptionService := services.NewSubscriptionService(userModel)
+	marketingProvider, err := marketing.NewFromEnv()
+	if err != nil {
+		log.Fatal("Error configuring email marketing provider: ", err)
+	}
+	outboxModel := db.NewOutboxModel(db.Database)
+	if err := outboxModel.EnsureIndexes(context.Background()); err != nil {
+		log.Printf("Failed to create outbox index: %v", err)
+	}
+	outboxService := services.NewOutboxService(outboxModel, marketingProvider, subscriptionService, outboxPolicyFromEnv())
+	outboxHandler := handlers.NewOutboxHandler(outboxService)
+	go outboxService.Run(context.Background())
+
+	offerHandler := handlers.NewOfferHandler(offerService, inboundService, webhookService, subscriptionService, outboxService)
 
 	// offer routes
 	router.POST("/offer/mailchimp", offerHandler.ReceiveOffer)
 	router.GET("/offer/mailchimp", offerHandler.VerifyWebhook)
+	router.HEAD("/offer/mailchimp", offerHandler.VerifyWebhook)
+	router.POST("/offer/raw", offerHandler.ReceiveRawOffer)
+	router.POST("/offer/mailgun", offerHandler.ReceiveMailgunOffer)
+
+	offerRoutes := router.Group("/offers", middleware.RequireAuth())
+	{
+		offerRoutes.GET("", offerHandler.ListOffers)
+		offerRoutes.GET("/search", offerHandler.SearchOffers)
+		offerRoutes.GET("/:id", offerHandler.GetOffer)
+	}
+	adminRoutes.POST("/offers/reclassify", offerHandler.ReclassifyOffers)
+	adminRoutes.GET("/outbox", outboxHandler.ListOutbox)
+	adminRoutes.POST("/outbox/:id/retry", outboxHandler.RetryOutboxItem)
+
+	// Optional SMTP listener for offers forwarded to <alias>@INBOUND_DOMAIN
+	if addr := os.Getenv("INBOUND_SMTP_ADDR"); addr != "" {
+		smtpServer := &smtpd.Server{
+			Addr:     addr,
+			Hostname: inboundDomain,
+			Backend:  inboundBackend{inboundService},
+		}
+		if n, err := strconv.ParseInt(os.Getenv("INBOUND_SMTP_MAX_BYTES"), 10, 64); err == nil && n > 0 {
+			smtpServer.MaxMessageBytes = n
+		}
+		if n, err := strconv.Atoi(os.Getenv("INBOUND_SMTP_MAX_CONNECTIONS")); err == nil && n > 0 {
+			smtpServer.MaxConnections = n
+		}
+		go func() {
+			log.Printf("Inbound SMTP listening on %s", addr)
+			if err := smtpServer.ListenAndServe(); err != nil {
+				log.Printf("Inbound SMTP stopped: %v", err)
+			}
+		}()
+	}
 
 	log.Fatal(router.Run(":8080"))
 }
+
+// trustedProxiesFromEnv reads the comma separated addresses or CIDR ranges of
+// the reverse proxies in front of the server from TRUSTED_PROXIES. None are
+// trusted if it is not set.
+func trustedProxiesFromEnv() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
+// lockoutPolicyFromEnv reads the failed login thresholds, keeping the
+// defaults for anything not set
+func lockoutPolicyFromEnv() services.LockoutPolicy {
+	policy := services.DefaultLockoutPolicy()
+	if n, err := strconv.Atoi(os.Getenv("LOGIN_MAX_FAILURES")); err == nil && n > 0 {
+		policy.MaxFailures = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("LOGIN_IP_MAX_FAILURES")); err == nil && n > 0 {
+		policy.IPMaxFailures = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("LOGIN_LOCKOUT_MINUTES")); err == nil && n > 0 {
+		policy.LockoutDuration = time.Duration(n) * time.Minute
+	}
+	return policy
+}
+
+// outboxPolicyFromEnv reads the outbox retry settings, keeping the defaults
+// for anything not set
+func outboxPolicyFromEnv() services.OutboxPolicy {
+	policy := services.DefaultOutboxPolicy()
+	if n, err := strconv.Atoi(os.Getenv("OUTBOX_MAX_ATTEMPTS")); err == nil && n > 0 {
+		policy.MaxAttempts = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("OUTBOX_POLL_SECONDS")); err == nil && n > 0 {
+		policy.PollInterval = time.Duration(n) * time.Second
+	}
+	return policy
+}
+
+// webhookConfigFromEnv reads the webhook secrets. The Mandrill signature
+// covers the URL the webhook was registered with, which defaults to the
+// Mailchimp webhook route under baseURL.
+func webhookConfigFromEnv(baseURL string) services.WebhookConfig {
+	config := services.WebhookConfig{
+		MailgunSigningKey: os.Getenv("MAILGUN_WEBHOOK_SIGNING_KEY"),
+		MailchimpSecret:   os.Getenv("MAILCHIMP_WEBHOOK_SECRET"),
+		MandrillKey:       os.Getenv("MANDRILL_WEBHOOK_KEY"),
+		MandrillURL:       os.Getenv("MANDRILL_WEBHOOK_URL"),
+		RawSigningKey:     os.Getenv("INBOUND_RAW_SIGNING_KEY"),
+	}
+	if config.MandrillURL == "" {
+		config.MandrillURL = strings.TrimRight(baseURL, "/") + "/offer/mailchimp"
+	}
+	return config
+}
+
+// brandDetectorFromEnv uses the brand catalog file named by BRAND_CATALOG, or
+// the built-in catalog if it is not set
+func brandDetectorFromEnv() (*brands.Detector, error) {
+	path := os.Getenv("BRAND_CATALOG")
+	if path == "" {
+		return brands.NewDetector(brands.DefaultCatalog()), nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	catalog, err := brands.LoadCatalog(file)
+	if err != nil {
+		return nil, err
+	}
+	return brands.NewDetector(catalog), nil
+}
+
+// classifierFromEnv uses the tag rules file named by TAG_RULES, or the
+// built-in rules if it is not set
+func classifierFromEnv() (*classify.Classifier, error) {
+	path := os.Getenv("TAG_RULES")
+	if path == "" {
+		return classify.New(classify.DefaultRules()), nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	rules, err := classify.LoadRules(file)
+	if err != nil {
+		return nil, err
+	}
+	return classify.New(rules), nil
+}
+
+// inboundBackend feeds mail from the SMTP listener to the InboundService,
+// turning its errors into SMTP replies
+type inboundBackend struct {
+	inbound *services.InboundService
+}
+
+func (b inboundBackend) ValidateRecipient(ctx context.Context, address string) error {
+	return smtpError(b.inbound.ValidateRecipient(ctx, address))
+}
+
+func (b inboundBackend) Deliver(ctx context.Context, from string, to []string, message []byte) error {
+	return smtpError(b.inbound.Deliver(ctx, from, to, message))
+}
+
+func smtpError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, services.ErrUnknownRecipient):
+		return &smtpd.Error{Code: 550, Message: "5.1.1 No such forwarding address"}
+	case errors.Is(err, services.ErrInvalidMessage):
+		return &smtpd.Error{Code: 554, Message: "5.6.0 Message could not be read"}
+	case errors.Is(err, services.ErrSenderNotVerified):
+		return &smtpd.Error{Code: 550, Message: "5.7.1 Account email address not verified"}
+	}
+	return err
+}